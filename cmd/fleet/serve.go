@@ -118,6 +118,12 @@ the way that the Fleet server works.
 			if !allowedHostIdentifiers[config.Osquery.HostIdentifier] {
 				initFatal(errors.Errorf("%s is not a valid value for osquery_host_identifier", config.Osquery.HostIdentifier), "set host identifier")
 			}
+			if !fleet.EnrollStrategy(config.Osquery.EnrollStrategy).Valid() {
+				initFatal(errors.Errorf("%s is not a valid value for osquery_enroll_strategy", config.Osquery.EnrollStrategy), "set enroll strategy")
+			}
+			if !fleet.EnrollTeamChange(config.Osquery.EnrollTeamChange).Valid() {
+				initFatal(errors.Errorf("%s is not a valid value for osquery_enroll_team_change", config.Osquery.EnrollTeamChange), "set enroll team change")
+			}
 
 			if len(config.Server.URLPrefix) > 0 {
 				// Massage provided prefix to match expected format
@@ -138,7 +144,21 @@ the way that the Fleet server works.
 			var carveStore fleet.CarveStore
 			mailService := mail.NewService()
 
-			ds, err = mysql.New(config.Mysql, clock.C, mysql.Logger(logger))
+			dsOpts := []mysql.DBOption{mysql.Logger(logger)}
+			if config.Osquery.TrackedHostFields != "" {
+				var fields []string
+				for _, field := range strings.Split(config.Osquery.TrackedHostFields, ",") {
+					fields = append(fields, strings.TrimSpace(field))
+				}
+				dsOpts = append(dsOpts, mysql.TrackHostFields(fields...))
+			}
+			if config.Osquery.EnrollDedupWindow > 0 {
+				dsOpts = append(dsOpts, mysql.EnrollDedup(config.Osquery.EnrollDedupKey, config.Osquery.EnrollDedupWindow))
+			}
+			if config.Osquery.ResourceSampleInterval > 0 {
+				dsOpts = append(dsOpts, mysql.HostResourceSampleInterval(config.Osquery.ResourceSampleInterval))
+			}
+			ds, err = mysql.New(config.Mysql, clock.C, dsOpts...)
 			if err != nil {
 				initFatal(err, "initializing datastore")
 			}
@@ -398,6 +418,10 @@ const (
 	LockKeyLeader = "leader"
 )
 
+// staleRefetchRequestAge is how long a host has to respond to a refetch
+// request before the request is cleared.
+const staleRefetchRequestAge = 7 * 24 * time.Hour
+
 func trySendStatistics(ds fleet.Datastore, frequency time.Duration, url string) error {
 	ac, err := ds.AppConfig()
 	if err != nil {
@@ -468,6 +492,22 @@ func runCrons(ds fleet.Datastore, logger kitlog.Logger) context.CancelFunc {
 			if err != nil {
 				level.Error(logger).Log("err", "cleaning carves", "details", err)
 			}
+			_, err = ds.ClearStaleRefetchRequests(time.Now().Add(-staleRefetchRequestAge))
+			if err != nil {
+				level.Error(logger).Log("err", "clearing stale refetch requests", "details", err)
+			}
+			_, err = ds.ProcessHostRetirements(time.Now())
+			if err != nil {
+				level.Error(logger).Log("err", "processing host retirements", "details", err)
+			}
+			err = ds.SnapshotHostSummary(time.Now())
+			if err != nil {
+				level.Error(logger).Log("err", "snapshotting host summary", "details", err)
+			}
+			err = ds.SnapshotLabelCounts(time.Now())
+			if err != nil {
+				level.Error(logger).Log("err", "snapshotting label counts", "details", err)
+			}
 
 			err = trySendStatistics(ds, fleet.StatisticsFrequency, "https://fleetdm.com/api/v1/webhooks/receive-usage-analytics")
 			if err != nil {
@@ -194,7 +194,13 @@ func TestGetHosts(t *testing.T) {
 apiVersion: v1
 kind: host
 spec:
+  assigned_owner: ""
+  battery_cycle_count: null
+  battery_health: null
   build: ""
+  checkin_latency: 0
+  cloud_instance_id: ""
+  cloud_provider: ""
   code_name: ""
   computer_name: test_host
   config_tls_refresh: 0
@@ -204,19 +210,26 @@ spec:
   cpu_subtype: ""
   cpu_type: ""
   created_at: "0001-01-01T00:00:00Z"
+  days_since_last_seen: null
   detail_updated_at: "0001-01-01T00:00:00Z"
+  disk_encryption_enabled: null
   display_text: test_host
   distributed_interval: 0
+  enrolled_from_ip: ""
   hardware_model: ""
   hardware_serial: ""
   hardware_vendor: ""
   hardware_version: ""
   hostname: test_host
   id: 0
+  kernel_version: ""
   label_updated_at: "0001-01-01T00:00:00Z"
   last_enrolled_at: "0001-01-01T00:00:00Z"
   logger_tls_period: 0
+  mdm_enrolled: null
+  mdm_server_url: ""
   memory: 0
+  orbit_version: ""
   os_version: ""
   osquery_version: ""
   pack_stats: null
@@ -226,14 +239,16 @@ spec:
   primary_mac: ""
   refetch_requested: false
   seen_time: "0001-01-01T00:00:00Z"
+  software_updated_at: "0001-01-01T00:00:00Z"
   status: mia
   team_id: null
   team_name: null
+  timezone: ""
   updated_at: "0001-01-01T00:00:00Z"
   uptime: 0
   uuid: ""
 `
-	expectedJson := "{\"kind\":\"host\",\"apiVersion\":\"v1\",\"spec\":{\"created_at\":\"0001-01-01T00:00:00Z\",\"updated_at\":\"0001-01-01T00:00:00Z\",\"id\":0,\"detail_updated_at\":\"0001-01-01T00:00:00Z\",\"label_updated_at\":\"0001-01-01T00:00:00Z\",\"last_enrolled_at\":\"0001-01-01T00:00:00Z\",\"seen_time\":\"0001-01-01T00:00:00Z\",\"refetch_requested\":false,\"hostname\":\"test_host\",\"uuid\":\"\",\"platform\":\"\",\"osquery_version\":\"\",\"os_version\":\"\",\"build\":\"\",\"platform_like\":\"\",\"code_name\":\"\",\"uptime\":0,\"memory\":0,\"cpu_type\":\"\",\"cpu_subtype\":\"\",\"cpu_brand\":\"\",\"cpu_physical_cores\":0,\"cpu_logical_cores\":0,\"hardware_vendor\":\"\",\"hardware_model\":\"\",\"hardware_version\":\"\",\"hardware_serial\":\"\",\"computer_name\":\"test_host\",\"primary_ip\":\"\",\"primary_mac\":\"\",\"distributed_interval\":0,\"config_tls_refresh\":0,\"logger_tls_period\":0,\"team_id\":null,\"pack_stats\":null,\"team_name\":null,\"status\":\"mia\",\"display_text\":\"test_host\"}}\n"
+	expectedJson := "{\"kind\":\"host\",\"apiVersion\":\"v1\",\"spec\":{\"created_at\":\"0001-01-01T00:00:00Z\",\"updated_at\":\"0001-01-01T00:00:00Z\",\"software_updated_at\":\"0001-01-01T00:00:00Z\",\"id\":0,\"detail_updated_at\":\"0001-01-01T00:00:00Z\",\"label_updated_at\":\"0001-01-01T00:00:00Z\",\"last_enrolled_at\":\"0001-01-01T00:00:00Z\",\"seen_time\":\"0001-01-01T00:00:00Z\",\"refetch_requested\":false,\"hostname\":\"test_host\",\"uuid\":\"\",\"platform\":\"\",\"osquery_version\":\"\",\"orbit_version\":\"\",\"os_version\":\"\",\"build\":\"\",\"kernel_version\":\"\",\"platform_like\":\"\",\"code_name\":\"\",\"uptime\":0,\"memory\":0,\"cpu_type\":\"\",\"cpu_subtype\":\"\",\"cpu_brand\":\"\",\"cpu_physical_cores\":0,\"cpu_logical_cores\":0,\"hardware_vendor\":\"\",\"hardware_model\":\"\",\"hardware_version\":\"\",\"hardware_serial\":\"\",\"computer_name\":\"test_host\",\"cloud_provider\":\"\",\"cloud_instance_id\":\"\",\"primary_ip\":\"\",\"primary_mac\":\"\",\"distributed_interval\":0,\"config_tls_refresh\":0,\"logger_tls_period\":0,\"team_id\":null,\"enrolled_from_ip\":\"\",\"assigned_owner\":\"\",\"checkin_latency\":0,\"timezone\":\"\",\"disk_encryption_enabled\":null,\"mdm_enrolled\":null,\"mdm_server_url\":\"\",\"battery_cycle_count\":null,\"battery_health\":null,\"pack_stats\":null,\"team_name\":null,\"status\":\"mia\",\"display_text\":\"test_host\",\"days_since_last_seen\":null}}\n"
 
 	assert.Equal(t, expectedText, runAppForTest(t, []string{"get", "hosts"}))
 	assert.Equal(t, expectedYaml, runAppForTest(t, []string{"get", "hosts", "--yaml"}))
@@ -36,11 +36,11 @@ func TestHostsTransferByHosts(t *testing.T) {
 		return &fleet.Team{ID: 99, Name: "team1"}, nil
 	}
 
-	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint) error {
+	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint, refetchLabels bool) (fleet.HostTeamAssignment, error) {
 		require.NotNil(t, teamID)
 		require.Equal(t, uint(99), *teamID)
 		require.Equal(t, []uint{42}, hostIDs)
-		return nil
+		return fleet.HostTeamAssignment{}, nil
 	}
 
 	assert.Equal(t, "", runAppForTest(t, []string{"hosts", "transfer", "--team", "team1", "--hosts", "host1"}))
@@ -71,11 +71,11 @@ func TestHostsTransferByLabel(t *testing.T) {
 		return []*fleet.Host{{ID: 32}, {ID: 12}}, nil
 	}
 
-	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint) error {
+	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint, refetchLabels bool) (fleet.HostTeamAssignment, error) {
 		require.NotNil(t, teamID)
 		require.Equal(t, uint(99), *teamID)
 		require.Equal(t, []uint{32, 12}, hostIDs)
-		return nil
+		return fleet.HostTeamAssignment{}, nil
 	}
 
 	assert.Equal(t, "", runAppForTest(t, []string{"hosts", "transfer", "--team", "team1", "--label", "label1"}))
@@ -105,11 +105,11 @@ func TestHostsTransferByStatus(t *testing.T) {
 		return []*fleet.Host{{ID: 32}, {ID: 12}}, nil
 	}
 
-	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint) error {
+	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint, refetchLabels bool) (fleet.HostTeamAssignment, error) {
 		require.NotNil(t, teamID)
 		require.Equal(t, uint(99), *teamID)
 		require.Equal(t, []uint{32, 12}, hostIDs)
-		return nil
+		return fleet.HostTeamAssignment{}, nil
 	}
 
 	assert.Equal(t, "", runAppForTest(t,
@@ -141,11 +141,11 @@ func TestHostsTransferByStatusAndSearchQuery(t *testing.T) {
 		return []*fleet.Host{{ID: 32}, {ID: 12}}, nil
 	}
 
-	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint) error {
+	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint, refetchLabels bool) (fleet.HostTeamAssignment, error) {
 		require.NotNil(t, teamID)
 		require.Equal(t, uint(99), *teamID)
 		require.Equal(t, []uint{32, 12}, hostIDs)
-		return nil
+		return fleet.HostTeamAssignment{}, nil
 	}
 
 	assert.Equal(t, "", runAppForTest(t,
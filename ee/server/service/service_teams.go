@@ -87,6 +87,20 @@ func (svc *Service) ModifyTeam(ctx context.Context, teamID uint, payload fleet.T
 	if payload.Secrets != nil {
 		team.Secrets = payload.Secrets
 	}
+	if payload.CarveRetentionHours != nil {
+		if *payload.CarveRetentionHours == 0 {
+			team.CarveRetentionHours = nil
+		} else {
+			team.CarveRetentionHours = payload.CarveRetentionHours
+		}
+	}
+	if payload.NewHostHours != nil {
+		if *payload.NewHostHours == 0 {
+			team.NewHostHours = nil
+		} else {
+			team.NewHostHours = payload.NewHostHours
+		}
+	}
 
 	return svc.ds.SaveTeam(team)
 }
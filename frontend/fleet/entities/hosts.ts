@@ -32,7 +32,9 @@ export default (client: any) => {
     },
     load: (hostID: number) => {
       const { HOSTS } = endpoints;
-      const endpoint = client._endpoint(`${HOSTS}/${hostID}`);
+      const endpoint = client._endpoint(
+        `${HOSTS}/${hostID}?include_users=true`
+      );
 
       return client
         .authenticatedGet(endpoint)
@@ -319,13 +319,50 @@ allow {
   action == [read, write][_]
 }
 
+##
+# Policies
+##
+
+# All users can read policies
+allow {
+  not is_null(subject)
+  object.type == "policy"
+  action == read
+}
+
+# Only global admins and maintainers can write policies
+allow {
+  object.type == "policy"
+  subject.global_role == admin
+  action == write
+}
+allow {
+  object.type == "policy"
+  subject.global_role == maintainer
+  action == write
+}
+
 ##
 # File Carves
 ##
 
-# Only global admins can read/write carves
+# Global admins can read/write all carves
 allow {
   object.type == "carve"
   subject.global_role == admin
-  action == [read, write][_]
+  action == [list, read, write][_]
+}
+
+# Team admins can list carves (must be filtered appropriately by the service)
+allow {
+  object.type == "carve"
+  subject.teams[_].role == admin
+  action == list
+}
+
+# Team admins can read carves for their teams
+allow {
+  object.type == "carve"
+  team_role(subject, object.team_id) == admin
+  action == read
 }
@@ -438,23 +438,72 @@ func TestAuthorizePacks(t *testing.T) {
 	})
 }
 
+func TestAuthorizePolicies(t *testing.T) {
+	t.Parallel()
+
+	policy := &fleet.Policy{}
+	runTestCases(t, []authTestCase{
+		{user: nil, object: policy, action: read, allow: false},
+		{user: nil, object: policy, action: write, allow: false},
+
+		{user: test.UserNoRoles, object: policy, action: read, allow: true},
+		{user: test.UserNoRoles, object: policy, action: write, allow: false},
+
+		{user: test.UserAdmin, object: policy, action: read, allow: true},
+		{user: test.UserAdmin, object: policy, action: write, allow: true},
+
+		{user: test.UserMaintainer, object: policy, action: read, allow: true},
+		{user: test.UserMaintainer, object: policy, action: write, allow: true},
+
+		{user: test.UserObserver, object: policy, action: read, allow: true},
+		{user: test.UserObserver, object: policy, action: write, allow: false},
+	})
+}
+
 func TestAuthorizeCarves(t *testing.T) {
 	t.Parallel()
 
+	teamAdmin := &fleet.User{
+		Teams: []fleet.UserTeam{
+			{Team: fleet.Team{ID: 1}, Role: fleet.RoleAdmin},
+		},
+	}
+	teamMaintainer := &fleet.User{
+		Teams: []fleet.UserTeam{
+			{Team: fleet.Team{ID: 1}, Role: fleet.RoleMaintainer},
+		},
+	}
 	carve := &fleet.CarveMetadata{}
+	teamCarve := &fleet.CarveMetadata{TeamID: ptr.Uint(1)}
+	otherTeamCarve := &fleet.CarveMetadata{TeamID: ptr.Uint(2)}
 	runTestCases(t, []authTestCase{
 		{user: nil, object: carve, action: read, allow: false},
 		{user: nil, object: carve, action: write, allow: false},
+		{user: nil, object: carve, action: list, allow: false},
 		{user: test.UserNoRoles, object: carve, action: read, allow: false},
 		{user: test.UserNoRoles, object: carve, action: write, allow: false},
+		{user: test.UserNoRoles, object: carve, action: list, allow: false},
 		{user: test.UserMaintainer, object: carve, action: read, allow: false},
 		{user: test.UserMaintainer, object: carve, action: write, allow: false},
+		{user: test.UserMaintainer, object: carve, action: list, allow: false},
 		{user: test.UserObserver, object: carve, action: read, allow: false},
 		{user: test.UserObserver, object: carve, action: write, allow: false},
+		{user: test.UserObserver, object: carve, action: list, allow: false},
 
-		// Only admins allowed
+		// Global admins allowed
 		{user: test.UserAdmin, object: carve, action: read, allow: true},
 		{user: test.UserAdmin, object: carve, action: write, allow: true},
+		{user: test.UserAdmin, object: carve, action: list, allow: true},
+		{user: test.UserAdmin, object: teamCarve, action: read, allow: true},
+
+		// Team admins can only read carves of their team
+		{user: teamAdmin, object: carve, action: list, allow: true},
+		{user: teamAdmin, object: carve, action: read, allow: false},
+		{user: teamAdmin, object: teamCarve, action: read, allow: true},
+		{user: teamAdmin, object: teamCarve, action: write, allow: false},
+		{user: teamAdmin, object: otherTeamCarve, action: read, allow: false},
+		{user: teamMaintainer, object: carve, action: list, allow: false},
+		{user: teamMaintainer, object: teamCarve, action: read, allow: false},
 	})
 }
 
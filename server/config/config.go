@@ -81,16 +81,24 @@ type SessionConfig struct {
 
 // OsqueryConfig defines configs related to osquery
 type OsqueryConfig struct {
-	NodeKeySize          int           `yaml:"node_key_size"`
-	HostIdentifier       string        `yaml:"host_identifier"`
-	EnrollCooldown       time.Duration `yaml:"enroll_cooldown"`
-	StatusLogPlugin      string        `yaml:"status_log_plugin"`
-	ResultLogPlugin      string        `yaml:"result_log_plugin"`
-	LabelUpdateInterval  time.Duration `yaml:"label_update_interval"`
-	DetailUpdateInterval time.Duration `yaml:"detail_update_interval"`
-	StatusLogFile        string        `yaml:"status_log_file"`
-	ResultLogFile        string        `yaml:"result_log_file"`
-	EnableLogRotation    bool          `yaml:"enable_log_rotation"`
+	NodeKeySize            int           `yaml:"node_key_size"`
+	HostIdentifier         string        `yaml:"host_identifier"`
+	EnrollCooldown         time.Duration `yaml:"enroll_cooldown"`
+	EnrollStrategy         string        `yaml:"enroll_strategy"`
+	EnrollTeamChange       string        `yaml:"enroll_team_change"`
+	EnrollTeamRules        string        `yaml:"enroll_team_rules"`
+	EnrollDedupWindow      time.Duration `yaml:"enroll_dedup_window"`
+	EnrollDedupKey         string        `yaml:"enroll_dedup_key"`
+	StatusLogPlugin        string        `yaml:"status_log_plugin"`
+	ResultLogPlugin        string        `yaml:"result_log_plugin"`
+	LabelUpdateInterval    time.Duration `yaml:"label_update_interval"`
+	DetailUpdateInterval   time.Duration `yaml:"detail_update_interval"`
+	StatusLogFile          string        `yaml:"status_log_file"`
+	ResultLogFile          string        `yaml:"result_log_file"`
+	EnableLogRotation      bool          `yaml:"enable_log_rotation"`
+	MaxActiveCarvesPerHost int           `yaml:"max_active_carves_per_host"`
+	TrackedHostFields      string        `yaml:"tracked_host_fields"`
+	ResourceSampleInterval time.Duration `yaml:"resource_sample_interval"`
 }
 
 // LoggingConfig defines configs related to logging
@@ -266,6 +274,22 @@ func (man Manager) addConfigs() {
 		"Identifier used to uniquely determine osquery clients")
 	man.addConfigDuration("osquery.enroll_cooldown", 0,
 		"Cooldown period for duplicate host enrollment (default off)")
+	man.addConfigString("osquery.enroll_strategy", "reuse",
+		"Strategy for hosts enrolling with the identifier of an existing host (reuse, reset)")
+	man.addConfigString("osquery.enroll_team_change", "apply",
+		"Handling of existing hosts enrolling with a different team (apply, confirm)")
+	man.addConfigString("osquery.enroll_team_rules", "",
+		"Rules assigning hosts enrolling without a team to a team (i.e. platform=darwin:Workstations;platform_family=linux:Servers)")
+	man.addConfigDuration("osquery.enroll_dedup_window", 0,
+		"Window in which repeated enrollments of a host are collapsed onto the same host (default off)")
+	man.addConfigString("osquery.enroll_dedup_key", "uuid",
+		"Host field matched against the identifier of hosts enrolling within the dedup window (osquery_host_id, uuid, hardware_serial)")
+	man.addConfigInt("osquery.max_active_carves_per_host", 10,
+		"Maximum number of carves in progress for a single host (0 for no limit)")
+	man.addConfigString("osquery.tracked_host_fields", "",
+		"Comma separated host fields whose changes are recorded (i.e. hostname,hardware_serial)")
+	man.addConfigDuration("osquery.resource_sample_interval", time.Hour,
+		"Minimum interval between the samples of the memory and disk space of a host (0 to disable, at least 1m)")
 	man.addConfigString("osquery.status_log_plugin", "filesystem",
 		"Log plugin to use for status logs")
 	man.addConfigString("osquery.result_log_plugin", "filesystem",
@@ -400,16 +424,24 @@ func (man Manager) LoadConfig() FleetConfig {
 			Duration: man.getConfigDuration("session.duration"),
 		},
 		Osquery: OsqueryConfig{
-			NodeKeySize:          man.getConfigInt("osquery.node_key_size"),
-			HostIdentifier:       man.getConfigString("osquery.host_identifier"),
-			EnrollCooldown:       man.getConfigDuration("osquery.enroll_cooldown"),
-			StatusLogPlugin:      man.getConfigString("osquery.status_log_plugin"),
-			ResultLogPlugin:      man.getConfigString("osquery.result_log_plugin"),
-			StatusLogFile:        man.getConfigString("osquery.status_log_file"),
-			ResultLogFile:        man.getConfigString("osquery.result_log_file"),
-			LabelUpdateInterval:  man.getConfigDuration("osquery.label_update_interval"),
-			DetailUpdateInterval: man.getConfigDuration("osquery.detail_update_interval"),
-			EnableLogRotation:    man.getConfigBool("osquery.enable_log_rotation"),
+			NodeKeySize:            man.getConfigInt("osquery.node_key_size"),
+			HostIdentifier:         man.getConfigString("osquery.host_identifier"),
+			EnrollCooldown:         man.getConfigDuration("osquery.enroll_cooldown"),
+			EnrollStrategy:         man.getConfigString("osquery.enroll_strategy"),
+			EnrollTeamChange:       man.getConfigString("osquery.enroll_team_change"),
+			EnrollTeamRules:        man.getConfigString("osquery.enroll_team_rules"),
+			EnrollDedupWindow:      man.getConfigDuration("osquery.enroll_dedup_window"),
+			EnrollDedupKey:         man.getConfigString("osquery.enroll_dedup_key"),
+			StatusLogPlugin:        man.getConfigString("osquery.status_log_plugin"),
+			ResultLogPlugin:        man.getConfigString("osquery.result_log_plugin"),
+			StatusLogFile:          man.getConfigString("osquery.status_log_file"),
+			ResultLogFile:          man.getConfigString("osquery.result_log_file"),
+			LabelUpdateInterval:    man.getConfigDuration("osquery.label_update_interval"),
+			DetailUpdateInterval:   man.getConfigDuration("osquery.detail_update_interval"),
+			EnableLogRotation:      man.getConfigBool("osquery.enable_log_rotation"),
+			MaxActiveCarvesPerHost: man.getConfigInt("osquery.max_active_carves_per_host"),
+			TrackedHostFields:      man.getConfigString("osquery.tracked_host_fields"),
+			ResourceSampleInterval: man.getConfigDuration("osquery.resource_sample_interval"),
 		},
 		Logging: LoggingConfig{
 			Debug:         man.getConfigBool("logging.debug"),
@@ -48,6 +48,10 @@ func (d *Datastore) DeleteHost(hid uint) error {
 	return nil
 }
 
+func (d *Datastore) DeleteHostWithReason(hid uint, reason string) error {
+	return d.DeleteHost(hid)
+}
+
 func (d *Datastore) Host(id uint) (*fleet.Host, error) {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
@@ -132,30 +136,33 @@ func (d *Datastore) ListHosts(filter fleet.TeamFilter, opt fleet.HostListOptions
 	return hosts, nil
 }
 
-func (d *Datastore) GenerateHostStatusStatistics(filter fleet.TeamFilter, now time.Time) (online, offline, mia, new uint, err error) {
+func (d *Datastore) GenerateHostStatusStatistics(filter fleet.TeamFilter, now time.Time) (*fleet.HostSummary, error) {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
 
+	summary := &fleet.HostSummary{PlatformCounts: map[string]uint{}}
 	for _, host := range d.hosts {
+		summary.TotalCount++
+		summary.PlatformCounts[fleet.PlatformFamily(host.Platform)]++
 		if host.IsNew(now) {
-			new++
+			summary.NewCount++
 		}
 
 		status := host.Status(now)
 		switch status {
 		case fleet.StatusMIA:
-			mia++
+			summary.MIACount++
 		case fleet.StatusOffline:
-			offline++
+			summary.OfflineCount++
 		default:
-			online++
+			summary.OnlineCount++
 		}
 	}
 
-	return online, offline, mia, new, nil
+	return summary, nil
 }
 
-func (d *Datastore) EnrollHost(osQueryHostID, nodeKey string, teamID *uint, cooldown time.Duration) (*fleet.Host, error) {
+func (d *Datastore) EnrollHost(osQueryHostID, nodeKey string, teamID *uint, cooldown time.Duration, opt fleet.EnrollHostOptions) (*fleet.Host, error) {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
 
@@ -174,10 +181,15 @@ func (d *Datastore) EnrollHost(osQueryHostID, nodeKey string, teamID *uint, cool
 
 	for _, h := range d.hosts {
 		if h.OsqueryHostID == osQueryHostID {
+			if opt.Strategy == fleet.EnrollStrategyReset {
+				delete(d.hosts, h.ID)
+				break
+			}
 			host = *h
 			break
 		}
 	}
+	host.EnrolledFromIP = opt.EnrolledFromIP
 
 	if host.ID == 0 {
 		host.ID = d.nextID(host)
@@ -214,7 +226,7 @@ func (d *Datastore) MarkHostSeen(host *fleet.Host, t time.Time) error {
 	return nil
 }
 
-func (d *Datastore) SearchHosts(filter fleet.TeamFilter, query string, omit ...uint) ([]*fleet.Host, error) {
+func (d *Datastore) SearchHosts(filter fleet.TeamFilter, query string, limit int, omit ...uint) ([]*fleet.Host, error) {
 	omitLookup := map[uint]bool{}
 	for _, o := range omit {
 		omitLookup[o] = true
@@ -225,8 +237,12 @@ func (d *Datastore) SearchHosts(filter fleet.TeamFilter, query string, omit ...u
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
 
+	if limit <= 0 {
+		limit = 10
+	}
+
 	for _, h := range d.hosts {
-		if len(results) == 10 {
+		if len(results) == limit {
 			break
 		}
 
@@ -262,3 +262,37 @@ func (d *Datastore) GetEnrollSecrets(teamID *uint) ([]*fleet.EnrollSecret, error
 	}
 	return secrets, nil
 }
+
+func (d *Datastore) RecordHostEnrollSecret(hostID uint, secret string) error {
+	sql := `
+		INSERT INTO host_enroll_secrets (host_id, secret)
+		VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE secret = VALUES(secret)
+	`
+	if _, err := d.db.Exec(sql, hostID, secret); err != nil {
+		return errors.Wrap(err, "record host enroll secret")
+	}
+	return nil
+}
+
+func (d *Datastore) EnrollmentSecretUsage() (map[string]uint, error) {
+	sql := `
+		SELECT es.secret, COUNT(h.id) AS count
+		FROM enroll_secrets es
+		LEFT JOIN host_enroll_secrets hes ON (hes.secret = es.secret)
+		LEFT JOIN hosts h ON (h.id = hes.host_id AND h.decommissioned_at IS NULL)
+		GROUP BY es.secret
+	`
+	var rows []struct {
+		Secret string `db:"secret"`
+		Count  uint   `db:"count"`
+	}
+	if err := d.db.Select(&rows, sql); err != nil {
+		return nil, errors.Wrap(err, "enrollment secret usage")
+	}
+	usage := make(map[string]uint, len(rows))
+	for _, row := range rows {
+		usage[row.Secret] = row.Count
+	}
+	return usage, nil
+}
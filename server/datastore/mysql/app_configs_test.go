@@ -2,10 +2,13 @@ package mysql
 
 import (
 	"encoding/json"
+	"fmt"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/fleetdm/fleet/v4/server/test"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/stretchr/testify/assert"
@@ -239,3 +242,39 @@ func TestEnrollSecretUniqueness(t *testing.T) {
 	err = ds.ApplyEnrollSecrets(nil, expectedSecrets)
 	require.Error(t, err)
 }
+
+func TestEnrollmentSecretUsage(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	team1, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	require.NoError(t, ds.ApplyEnrollSecrets(nil, []*fleet.EnrollSecret{{Secret: "global"}}))
+	require.NoError(t, ds.ApplyEnrollSecrets(&team1.ID, []*fleet.EnrollSecret{{Secret: "team"}, {Secret: "stale"}}))
+
+	usage, err := ds.EnrollmentSecretUsage()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint{"global": 0, "team": 0, "stale": 0}, usage)
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		hosts = append(hosts, test.NewHost(t, ds, fmt.Sprintf("host%d", i), "", fmt.Sprintf("key%d", i), fmt.Sprintf("uuid%d", i), time.Now()))
+	}
+	require.NoError(t, ds.RecordHostEnrollSecret(hosts[0].ID, "global"))
+	require.NoError(t, ds.RecordHostEnrollSecret(hosts[1].ID, "team"))
+	require.NoError(t, ds.RecordHostEnrollSecret(hosts[2].ID, "team"))
+
+	usage, err = ds.EnrollmentSecretUsage()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint{"global": 1, "team": 2, "stale": 0}, usage)
+
+	// Re-enrolling with another secret moves the host to that secret
+	require.NoError(t, ds.RecordHostEnrollSecret(hosts[2].ID, "global"))
+	// Deleted and decommissioned hosts are no longer counted
+	require.NoError(t, ds.DeleteHost(hosts[0].ID))
+	require.NoError(t, ds.DecommissionHost(hosts[1].ID))
+
+	usage, err = ds.EnrollmentSecretUsage()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint{"global": 1, "team": 0, "stale": 0}, usage)
+}
@@ -1,8 +1,11 @@
 package mysql
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
@@ -10,7 +13,11 @@ import (
 	"github.com/pkg/errors"
 )
 
-func (d *Datastore) NewCarve(metadata *fleet.CarveMetadata) (*fleet.CarveMetadata, error) {
+// carveNameUniqueKey is the name of the unique index on carve_metadata.name,
+// created in the CreateCarveTables migration.
+const carveNameUniqueKey = "idx_name"
+
+func (d *Datastore) NewCarve(metadata *fleet.CarveMetadata, maxActivePerHost int) (*fleet.CarveMetadata, error) {
 	stmt := `INSERT INTO carve_metadata (
 		host_id,
 		created_at,
@@ -33,25 +40,57 @@ func (d *Datastore) NewCarve(metadata *fleet.CarveMetadata) (*fleet.CarveMetadat
 		?
 	)`
 
-	result, err := d.db.Exec(
-		stmt,
-		metadata.HostId,
-		metadata.CreatedAt.Format(mySQLTimestampFormat),
-		metadata.Name,
-		metadata.BlockCount,
-		metadata.BlockSize,
-		metadata.CarveSize,
-		metadata.CarveId,
-		metadata.RequestId,
-		metadata.SessionId,
-	)
+	err := d.withRetryTxx(func(tx *sqlx.Tx) error {
+		if maxActivePerHost > 0 {
+			// Lock the host row so that concurrent carves from the same host
+			// can't both pass the quota check.
+			var hostID uint
+			if err := tx.Get(&hostID, `SELECT id FROM hosts WHERE id = ? FOR UPDATE`, metadata.HostId); err != nil && err != sql.ErrNoRows {
+				return errors.Wrap(err, "lock host for carve quota")
+			}
+
+			var active int
+			err := tx.Get(&active, `
+				SELECT COUNT(*) FROM carve_metadata
+				WHERE host_id = ? AND NOT expired AND max_block < block_count - 1
+			`, metadata.HostId)
+			if err != nil {
+				return errors.Wrap(err, "count active carves")
+			}
+			if active >= maxActivePerHost {
+				return fleet.ErrCarveQuotaExceeded
+			}
+		}
+
+		result, err := tx.Exec(
+			stmt,
+			metadata.HostId,
+			metadata.CreatedAt.Format(mySQLTimestampFormat),
+			metadata.Name,
+			metadata.BlockCount,
+			metadata.BlockSize,
+			metadata.CarveSize,
+			metadata.CarveId,
+			metadata.RequestId,
+			metadata.SessionId,
+		)
+		if err != nil {
+			// session_id is also unique, so check which key was violated. This
+			// relies on MySQL including the key name in the error message.
+			if isDuplicate(err) && strings.Contains(err.Error(), carveNameUniqueKey) {
+				return fleet.ErrDuplicateCarveName
+			}
+			return errors.Wrap(err, "insert carve metadata")
+		}
+
+		id, _ := result.LastInsertId()
+		metadata.ID = id
+		return nil
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "insert carve metadata")
+		return nil, err
 	}
 
-	id, _ := result.LastInsertId()
-	metadata.ID = id
-
 	return metadata, nil
 }
 
@@ -76,29 +115,80 @@ func (d *Datastore) UpdateCarve(metadata *fleet.CarveMetadata) error {
 	return nil
 }
 
-func (d *Datastore) CleanupCarves(now time.Time) (int, error) {
-	var countExpired int
+// Sizes of the case_id and notes columns, created in the AddCarveCase
+// migration.
+const (
+	maxCarveCaseIDLen = 255
+	maxCarveNotesLen  = 1024
+)
+
+func (d *Datastore) UpdateCarveCase(carveId int64, caseID string, notes string) error {
+	if len(caseID) > maxCarveCaseIDLen {
+		return fleet.NewInvalidArgumentError("case_id", fmt.Sprintf("must be at most %d characters", maxCarveCaseIDLen))
+	}
+	if len(notes) > maxCarveNotesLen {
+		return fleet.NewInvalidArgumentError("notes", fmt.Sprintf("must be at most %d characters", maxCarveNotesLen))
+	}
+
+	result, err := d.db.Exec(`UPDATE carve_metadata SET case_id = ?, notes = ? WHERE id = ?`, caseID, notes, carveId)
+	if err != nil {
+		return errors.Wrap(err, "update carve case")
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		// RowsAffected is also 0 if nothing changed, so check that the carve
+		// exists.
+		var count int
+		if err := d.db.Get(&count, `SELECT COUNT(*) FROM carve_metadata WHERE id = ?`, carveId); err != nil {
+			return errors.Wrap(err, "check carve exists")
+		}
+		if count == 0 {
+			return notFound("Carve").WithID(uint(carveId))
+		}
+	}
+	return nil
+}
+
+func (d *Datastore) CleanupCarves(now time.Time) (map[uint]int, error) {
+	countExpired := make(map[uint]int)
 	err := d.withRetryTxx(func(tx *sqlx.Tx) error {
-		// Get IDs of carves to expire
-		stmt := `
-			SELECT id
-			FROM carve_metadata
-			WHERE expired = 0 AND created_at < (? - INTERVAL 24 HOUR)
+		// Get IDs of carves to expire, using the retention of the team of
+		// the host.
+		stmt := fmt.Sprintf(`
+			SELECT c.id, COALESCE(h.team_id, 0) AS team_id
+			FROM carve_metadata c
+			LEFT JOIN hosts h ON (h.id = c.host_id)
+			LEFT JOIN teams t ON (t.id = h.team_id)
+			WHERE c.expired = 0 AND c.created_at < (? - INTERVAL COALESCE(t.carve_retention_hours, %d) HOUR)
 			LIMIT 50000
-		`
-		var expiredCarves []int64
-		if err := tx.Select(&expiredCarves, stmt, now); err != nil {
+		`, int(fleet.DefaultCarveRetention.Hours()))
+		var rows []struct {
+			ID     int64 `db:"id"`
+			TeamID uint  `db:"team_id"`
+		}
+		if err := tx.Select(&rows, stmt, now); err != nil {
 			return errors.Wrap(err, "get expired carves")
 		}
 
-		countExpired = len(expiredCarves)
+		for k := range countExpired {
+			delete(countExpired, k)
+		}
+		var expiredCarves []int64
+		for _, row := range rows {
+			expiredCarves = append(expiredCarves, row.ID)
+			countExpired[row.TeamID]++
+		}
 
 		if len(expiredCarves) == 0 {
 			// Nothing to do
 			return nil
 		}
 
-		// Delete carve block data
+		// Release the block data referenced by the carves, then delete the
+		// carve blocks
+		if err := releaseCarveBlockData(tx, expiredCarves); err != nil {
+			return err
+		}
+
 		stmt = `
 			DELETE FROM carve_blocks
 			WHERE metadata_id IN (?)
@@ -108,7 +198,7 @@ func (d *Datastore) CleanupCarves(now time.Time) (int, error) {
 			return errors.Wrap(err, "IN for DELETE FROM carve_blocks")
 		}
 		stmt = tx.Rebind(stmt)
-		if _, err := d.db.Exec(stmt, args...); err != nil {
+		if _, err := tx.Exec(stmt, args...); err != nil {
 			return errors.Wrap(err, "delete carve blocks")
 		}
 
@@ -123,35 +213,105 @@ func (d *Datastore) CleanupCarves(now time.Time) (int, error) {
 			return errors.Wrap(err, "IN for UPDATE carve_metadata")
 		}
 		stmt = tx.Rebind(stmt)
-		if _, err := d.db.Exec(stmt, args...); err != nil {
+		if _, err := tx.Exec(stmt, args...); err != nil {
 			return errors.Wrap(err, "update carve_metadtata")
 		}
 
 		return nil
 	})
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	if err := d.deleteUnreferencedCarveBlockData(); err != nil {
+		return nil, err
 	}
 
 	return countExpired, nil
 
 }
 
+// releaseCarveBlockData decrements the refcount of the block data referenced
+// by the blocks of the carves. The data is deleted by
+// deleteUnreferencedCarveBlockData once the transaction is committed.
+func releaseCarveBlockData(tx *sqlx.Tx, carveIDs []int64) error {
+	stmt := `
+		UPDATE carve_block_data d
+		JOIN (
+			SELECT sha256, COUNT(*) AS refs
+			FROM carve_blocks
+			WHERE metadata_id IN (?)
+			GROUP BY sha256
+		) b ON (b.sha256 = d.sha256)
+		SET d.refcount = d.refcount - b.refs
+	`
+	stmt, args, err := sqlx.In(stmt, carveIDs)
+	if err != nil {
+		return errors.Wrap(err, "IN for UPDATE carve_block_data")
+	}
+	stmt = tx.Rebind(stmt)
+	if _, err := tx.Exec(stmt, args...); err != nil {
+		return errors.Wrap(err, "release carve block data")
+	}
+	return nil
+}
+
+func (d *Datastore) DeleteCarves(ids []int64) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var deleted int
+	err := d.withRetryTxx(func(tx *sqlx.Tx) error {
+		if err := releaseCarveBlockData(tx, ids); err != nil {
+			return err
+		}
+
+		// The carve blocks are deleted by the foreign key cascade
+		stmt, args, err := sqlx.In(`DELETE FROM carve_metadata WHERE id IN (?)`, ids)
+		if err != nil {
+			return errors.Wrap(err, "IN for DELETE FROM carve_metadata")
+		}
+		res, err := tx.Exec(tx.Rebind(stmt), args...)
+		if err != nil {
+			return errors.Wrap(err, "delete carve metadata")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "rows affected by delete carve metadata")
+		}
+		deleted = int(n)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := d.deleteUnreferencedCarveBlockData(); err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
 // Selecting max_block should be very efficient because MySQL is able to use
 // the index metadata and optimizes away the SELECT.
 const carveSelectFields = `
-			id,
-			host_id,
-			created_at,
-			name,
-			block_count,
-			block_size,
-			carve_size,
-			carve_id,
-			request_id,
-			session_id,
-			expired,
-			max_block
+			carve_metadata.id,
+			carve_metadata.host_id,
+			carve_metadata.created_at,
+			carve_metadata.name,
+			carve_metadata.block_count,
+			carve_metadata.block_size,
+			carve_metadata.carve_size,
+			carve_metadata.carve_id,
+			carve_metadata.request_id,
+			carve_metadata.session_id,
+			carve_metadata.expired,
+			carve_metadata.max_block,
+			carve_metadata.case_id,
+			carve_metadata.notes,
+			(SELECT team_id FROM hosts WHERE hosts.id = carve_metadata.host_id) AS team_id
 `
 
 func (d *Datastore) Carve(carveId int64) (*fleet.CarveMetadata, error) {
@@ -186,12 +346,13 @@ func (d *Datastore) CarveBySessionId(sessionId string) (*fleet.CarveMetadata, er
 	return &metadata, nil
 }
 
-func (d *Datastore) CarveByName(name string) (*fleet.CarveMetadata, error) {
+func (d *Datastore) CarveByName(filter fleet.TeamFilter, name string) (*fleet.CarveMetadata, error) {
 	stmt := fmt.Sprintf(`
 		SELECT %s
 		FROM carve_metadata
-		WHERE name = ?`,
-		carveSelectFields,
+		LEFT JOIN hosts h ON h.id = carve_metadata.host_id
+		WHERE carve_metadata.name = ? AND %s`,
+		carveSelectFields, d.whereFilterHostsByTeams(filter, "h"),
 	)
 
 	var metadata fleet.CarveMetadata
@@ -202,37 +363,145 @@ func (d *Datastore) CarveByName(name string) (*fleet.CarveMetadata, error) {
 	return &metadata, nil
 }
 
-func (d *Datastore) ListCarves(opt fleet.CarveListOptions) ([]*fleet.CarveMetadata, error) {
+func (d *Datastore) CarveByRequestId(requestId string) (*fleet.CarveMetadata, error) {
 	stmt := fmt.Sprintf(`
 		SELECT %s
-		FROM carve_metadata`,
+		FROM carve_metadata
+		WHERE request_id = ?
+		ORDER BY id DESC
+		LIMIT 1`,
 		carveSelectFields,
 	)
+
+	var metadata fleet.CarveMetadata
+	if err := d.db.Get(&metadata, stmt, requestId); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFound("Carve").WithMessage(fmt.Sprintf("with request ID %s", requestId))
+		}
+		return nil, errors.Wrap(err, "get carve by request ID")
+	}
+
+	return &metadata, nil
+}
+
+func (d *Datastore) ListCarves(filter fleet.TeamFilter, opt fleet.CarveListOptions) ([]*fleet.CarveMetadata, error) {
+	stmt := fmt.Sprintf(`
+		SELECT %s
+		FROM carve_metadata
+		LEFT JOIN hosts h ON h.id = carve_metadata.host_id
+		WHERE %s`,
+		carveSelectFields, d.whereFilterHostsByTeams(filter, "h"),
+	)
+	var args []interface{}
 	if !opt.Expired {
-		stmt += ` WHERE NOT expired `
+		stmt += ` AND NOT carve_metadata.expired`
+	}
+	if opt.NamePrefix != "" {
+		stmt += ` AND carve_metadata.name LIKE ?`
+		args = append(args, escapeLike(opt.NamePrefix)+"%")
+	}
+	if opt.CaseID != "" {
+		stmt += ` AND carve_metadata.case_id = ?`
+		args = append(args, opt.CaseID)
+	}
+	// Order by id by default so that the carves are listed in a stable order
+	// across pages and MySQL versions.
+	if opt.OrderKey == "" {
+		opt.OrderKey = "id"
 	}
 	stmt = appendListOptionsToSQL(stmt, opt.ListOptions)
 	carves := []*fleet.CarveMetadata{}
-	if err := d.db.Select(&carves, stmt); err != nil && err != sql.ErrNoRows {
+	if err := d.db.Select(&carves, stmt, args...); err != nil && err != sql.ErrNoRows {
 		return nil, errors.Wrap(err, "list carves")
 	}
 
 	return carves, nil
 }
 
-func (d *Datastore) NewBlock(metadata *fleet.CarveMetadata, blockId int64, data []byte) error {
+func (d *Datastore) ListCarvesExpiringBefore(t time.Time) ([]*fleet.CarveMetadata, error) {
+	// Keep the retention consistent with CleanupCarves
+	stmt := fmt.Sprintf(`
+		SELECT %s
+		FROM carve_metadata
+		LEFT JOIN hosts h ON (h.id = carve_metadata.host_id)
+		LEFT JOIN teams t ON (t.id = h.team_id)
+		WHERE NOT carve_metadata.expired AND carve_metadata.created_at < (? - INTERVAL COALESCE(t.carve_retention_hours, %d) HOUR)
+		ORDER BY carve_metadata.created_at, carve_metadata.id`,
+		carveSelectFields, int(fleet.DefaultCarveRetention.Hours()),
+	)
+	carves := []*fleet.CarveMetadata{}
+	if err := d.db.Select(&carves, stmt, t); err != nil {
+		return nil, errors.Wrap(err, "list carves expiring before")
+	}
+	return carves, nil
+}
+
+func (d *Datastore) HostCarveStorage(hostID uint) (int64, int, error) {
+	var storage struct {
+		TotalBytes int64 `db:"total_bytes"`
+		CarveCount int   `db:"carve_count"`
+	}
 	stmt := `
-		INSERT INTO carve_blocks (
-			metadata_id,
-			block_id,
-			data
-		) VALUES (
-			?,
-			?,
-			?
-		)`
-	if _, err := d.db.Exec(stmt, metadata.ID, blockId, data); err != nil {
-		return errors.Wrap(err, "insert carve block")
+		SELECT COALESCE(SUM(carve_size), 0) AS total_bytes, COUNT(*) AS carve_count
+		FROM carve_metadata
+		WHERE host_id = ? AND NOT expired
+	`
+	if err := d.db.Get(&storage, stmt, hostID); err != nil {
+		return 0, 0, errors.Wrap(err, "get host carve storage")
+	}
+	return storage.TotalBytes, storage.CarveCount, nil
+}
+
+// deleteUnreferencedCarveBlockData deletes the block data that is no longer
+// referenced by carve blocks. Carve blocks deleted through the foreign key
+// cascade when hosts are deleted don't release their data, so data that is
+// still counted but unreferenced is deleted too.
+func (d *Datastore) deleteUnreferencedCarveBlockData() error {
+	stmt := `
+		DELETE d FROM carve_block_data d
+		LEFT JOIN carve_blocks b ON (b.sha256 = d.sha256)
+		WHERE d.refcount = 0 OR b.sha256 IS NULL
+	`
+	if _, err := d.db.Exec(stmt); err != nil {
+		return errors.Wrap(err, "delete unreferenced carve block data")
+	}
+	return nil
+}
+
+func (d *Datastore) NewBlock(metadata *fleet.CarveMetadata, blockId int64, data []byte) error {
+	if err := metadata.ValidateBlockSize(blockId, int64(len(data))); err != nil {
+		return err
+	}
+
+	// Block data is stored once for identical blocks, across all carves.
+	sum := sha256.Sum256(data)
+	err := d.withRetryTxx(func(tx *sqlx.Tx) error {
+		stmt := `
+			INSERT INTO carve_block_data (sha256, data, refcount)
+			VALUES (?, ?, 1)
+			ON DUPLICATE KEY UPDATE refcount = refcount + 1
+		`
+		if _, err := tx.Exec(stmt, sum[:], data); err != nil {
+			return errors.Wrap(err, "insert carve block data")
+		}
+
+		stmt = `
+			INSERT INTO carve_blocks (
+				metadata_id,
+				block_id,
+				sha256
+			) VALUES (
+				?,
+				?,
+				?
+			)`
+		if _, err := tx.Exec(stmt, metadata.ID, blockId, sum[:]); err != nil {
+			return errors.Wrap(err, "insert carve block")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	if metadata.MaxBlock < blockId {
@@ -246,11 +515,53 @@ func (d *Datastore) NewBlock(metadata *fleet.CarveMetadata, blockId int64, data
 	return nil
 }
 
+func (d *Datastore) CarveReaderAt(carve *fleet.CarveMetadata) (io.ReaderAt, int64, error) {
+	return fleet.NewCarveReaderAt(d.GetBlock, carve)
+}
+
+func (d *Datastore) WriteCarve(carve *fleet.CarveMetadata, w io.Writer) (int64, error) {
+	if carve.Expired {
+		return 0, fleet.ErrCarveExpired
+	}
+	status, err := d.CarveBlockStatus(carve.ID)
+	if err != nil {
+		return 0, err
+	}
+	return fleet.WriteCarveBlocks(d.GetBlock, status, carve, w)
+}
+
+func (d *Datastore) CarveBlockStatus(carveId int64) ([]bool, error) {
+	metadata, err := d.Carve(carveId)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]bool, metadata.BlockCount)
+	if metadata.Expired {
+		return status, nil
+	}
+
+	// Only the block IDs are loaded, the data is not read.
+	var blockIds []int64
+	stmt := `SELECT block_id FROM carve_blocks WHERE metadata_id = ?`
+	if err := d.db.Select(&blockIds, stmt, carveId); err != nil {
+		return nil, errors.Wrap(err, "select carve block ids")
+	}
+	for _, id := range blockIds {
+		if id >= 0 && id < metadata.BlockCount {
+			status[id] = true
+		}
+	}
+
+	return status, nil
+}
+
 func (d *Datastore) GetBlock(metadata *fleet.CarveMetadata, blockId int64) ([]byte, error) {
 	stmt := `
-		SELECT data
-		FROM carve_blocks
-		WHERE metadata_id = ? AND block_id = ?
+		SELECT d.data
+		FROM carve_blocks b
+		JOIN carve_block_data d ON (d.sha256 = b.sha256)
+		WHERE b.metadata_id = ? AND b.block_id = ?
 	`
 	var data []byte
 	if err := d.db.Get(&data, stmt, metadata.ID, blockId); err != nil {
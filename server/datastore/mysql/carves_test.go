@@ -1,11 +1,18 @@
 package mysql
 
 import (
+	"bytes"
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -31,7 +38,7 @@ func TestCarveMetadata(t *testing.T) {
 		CreatedAt:  mockCreatedAt,
 	}
 
-	expectedCarve, err := ds.NewCarve(expectedCarve)
+	expectedCarve, err := ds.NewCarve(expectedCarve, 0)
 	require.NoError(t, err)
 	assert.NotEqual(t, 0, expectedCarve.ID)
 	expectedCarve.MaxBlock = -1
@@ -46,7 +53,7 @@ func TestCarveMetadata(t *testing.T) {
 
 	// Check for increment of max block
 
-	err = ds.NewBlock(carve, 0, nil)
+	err = ds.NewBlock(carve, 0, make([]byte, 12))
 	require.NoError(t, err)
 	expectedCarve.MaxBlock = 0
 
@@ -60,7 +67,7 @@ func TestCarveMetadata(t *testing.T) {
 
 	// Check for increment of max block
 
-	err = ds.NewBlock(carve, 1, nil)
+	err = ds.NewBlock(carve, 1, make([]byte, 12))
 	require.NoError(t, err)
 	expectedCarve.MaxBlock = 1
 
@@ -69,7 +76,7 @@ func TestCarveMetadata(t *testing.T) {
 	assert.Equal(t, expectedCarve, carve)
 
 	// Get by name also
-	carve, err = ds.CarveByName(expectedCarve.Name)
+	carve, err = ds.CarveByName(fleet.TeamFilter{User: test.UserAdmin}, expectedCarve.Name)
 	require.NoError(t, err)
 	assert.Equal(t, expectedCarve, carve)
 }
@@ -94,7 +101,7 @@ func TestCarveBlocks(t *testing.T) {
 		CreatedAt:  mockCreatedAt,
 	}
 
-	carve, err := ds.NewCarve(carve)
+	carve, err := ds.NewCarve(carve, 0)
 	require.NoError(t, err)
 
 	// Randomly generate and insert blocks
@@ -118,6 +125,346 @@ func TestCarveBlocks(t *testing.T) {
 
 }
 
+func TestCarveBlockSizeValidation(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	carve, err := ds.NewCarve(&fleet.CarveMetadata{
+		HostId:     h.ID,
+		Name:       "foobar",
+		BlockCount: 3,
+		BlockSize:  8,
+		CarveSize:  20,
+		CarveId:    "carve_id",
+		RequestId:  "request_id",
+		SessionId:  "session_id",
+		CreatedAt:  mockCreatedAt,
+	}, 0)
+	require.NoError(t, err)
+
+	// Non-final blocks must be exactly the block size
+	err = ds.NewBlock(carve, 0, make([]byte, 7))
+	require.IsType(t, &fleet.CarveBlockSizeError{}, err)
+	assert.False(t, err.(*fleet.CarveBlockSizeError).Final)
+	err = ds.NewBlock(carve, 0, make([]byte, 9))
+	require.IsType(t, &fleet.CarveBlockSizeError{}, err)
+
+	require.NoError(t, ds.NewBlock(carve, 0, make([]byte, 8)))
+	require.NoError(t, ds.NewBlock(carve, 1, make([]byte, 8)))
+
+	// The final block may be smaller, but not larger
+	err = ds.NewBlock(carve, 2, make([]byte, 9))
+	require.IsType(t, &fleet.CarveBlockSizeError{}, err)
+	assert.True(t, err.(*fleet.CarveBlockSizeError).Final)
+	require.NoError(t, ds.NewBlock(carve, 2, make([]byte, 4)))
+
+	carve, err = ds.Carve(carve.ID)
+	require.NoError(t, err)
+	assert.True(t, carve.BlocksComplete())
+}
+
+func TestCarveReaderAt(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	carve, err := ds.NewCarve(&fleet.CarveMetadata{
+		HostId:     h.ID,
+		Name:       "foobar",
+		BlockCount: 3,
+		BlockSize:  8,
+		CarveSize:  20,
+		CarveId:    "carve_id",
+		RequestId:  "request_id",
+		SessionId:  "session_id",
+		CreatedAt:  mockCreatedAt,
+	}, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, ds.NewBlock(carve, 0, []byte("aaaaaaaa")))
+	require.NoError(t, ds.NewBlock(carve, 1, []byte("bbbbbbbb")))
+	require.NoError(t, ds.NewBlock(carve, 2, []byte("cccc")))
+
+	r, size, err := ds.CarveReaderAt(carve)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), size)
+
+	data, err := ioutil.ReadAll(io.NewSectionReader(r, 0, size))
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaaaaabbbbbbbbcccc", string(data))
+
+	// Reads spanning blocks
+	buf := make([]byte, 4)
+	n, err := r.ReadAt(buf, 6)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, "aabb", string(buf))
+
+	// Reads past the end
+	n, err = r.ReadAt(buf, 18)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "cc", string(buf[:n]))
+
+	n, err = r.ReadAt(buf, 20)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+
+	// Only the blocks covering the read are fetched
+	var fetched []int64
+	r, _, err = fleet.NewCarveReaderAt(func(metadata *fleet.CarveMetadata, blockId int64) ([]byte, error) {
+		fetched = append(fetched, blockId)
+		return ds.GetBlock(metadata, blockId)
+	}, carve)
+	require.NoError(t, err)
+	_, err = r.ReadAt(buf, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "bbbb", string(buf))
+	assert.Equal(t, []int64{1}, fetched)
+
+	carve.Expired = true
+	_, _, err = ds.CarveReaderAt(carve)
+	assert.Error(t, err)
+}
+
+func TestWriteCarve(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	carve, err := ds.NewCarve(&fleet.CarveMetadata{
+		HostId:     h.ID,
+		Name:       "foobar",
+		BlockCount: 3,
+		BlockSize:  8,
+		CarveSize:  20,
+		CarveId:    "carve_id",
+		RequestId:  "request_id",
+		SessionId:  "session_id",
+		CreatedAt:  mockCreatedAt,
+	}, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, ds.NewBlock(carve, 0, []byte("aaaaaaaa")))
+	require.NoError(t, ds.NewBlock(carve, 2, []byte("cccc")))
+
+	// Nothing is written while a block is missing
+	var buf bytes.Buffer
+	n, err := ds.WriteCarve(carve, &buf)
+	var missing *fleet.CarveBlockMissingError
+	require.True(t, errors.As(err, &missing))
+	assert.Equal(t, int64(1), missing.BlockID)
+	assert.Zero(t, n)
+	assert.Zero(t, buf.Len())
+
+	require.NoError(t, ds.NewBlock(carve, 1, []byte("bbbbbbbb")))
+	n, err = ds.WriteCarve(carve, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), n)
+	assert.Equal(t, "aaaaaaaabbbbbbbbcccc", buf.String())
+
+	// Blocks are fetched in order
+	var fetched []int64
+	buf.Reset()
+	n, err = fleet.WriteCarveBlocks(func(metadata *fleet.CarveMetadata, blockId int64) ([]byte, error) {
+		fetched = append(fetched, blockId)
+		return ds.GetBlock(metadata, blockId)
+	}, []bool{true, true, true}, carve, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), n)
+	assert.Equal(t, []int64{0, 1, 2}, fetched)
+
+	carve.Expired = true
+	require.NoError(t, ds.UpdateCarve(carve))
+	buf.Reset()
+	n, err = ds.WriteCarve(carve, &buf)
+	assert.Equal(t, fleet.ErrCarveExpired, err)
+	assert.Zero(t, n)
+	assert.Zero(t, buf.Len())
+}
+
+func TestCarveBlockStatus(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	carve, err := ds.NewCarve(&fleet.CarveMetadata{
+		HostId:     h.ID,
+		Name:       "foobar",
+		BlockCount: 5,
+		BlockSize:  10,
+		CarveSize:  50,
+		CarveId:    "carve_id",
+		RequestId:  "request_id",
+		SessionId:  "session_id",
+		CreatedAt:  mockCreatedAt,
+	}, 0)
+	require.NoError(t, err)
+
+	status, err := ds.CarveBlockStatus(carve.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, false, false, false, false}, status)
+
+	for _, id := range []int64{0, 1, 3} {
+		require.NoError(t, ds.NewBlock(carve, id, make([]byte, 10)))
+	}
+	status, err = ds.CarveBlockStatus(carve.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true, false, true, false}, status)
+
+	carve.Expired = true
+	require.NoError(t, ds.UpdateCarve(carve))
+	status, err = ds.CarveBlockStatus(carve.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, false, false, false, false}, status)
+
+	_, err = ds.CarveBlockStatus(carve.ID + 1)
+	assert.Error(t, err)
+}
+
+func TestCarveBlocksDedup(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	newCarve := func(name string, createdAt time.Time) *fleet.CarveMetadata {
+		h := test.NewHost(t, ds, name, "", name, name, now)
+		carve, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     h.ID,
+			Name:       name,
+			BlockCount: 2,
+			BlockSize:  4,
+			CarveSize:  8,
+			CarveId:    name,
+			RequestId:  name,
+			SessionId:  name,
+			CreatedAt:  createdAt,
+		}, 0)
+		require.NoError(t, err)
+		return carve
+	}
+	countData := func() (rows, refs int) {
+		require.NoError(t, ds.db.Get(&rows, `SELECT COUNT(*) FROM carve_block_data`))
+		require.NoError(t, ds.db.Get(&refs, `SELECT COALESCE(SUM(refcount), 0) FROM carve_block_data`))
+		return rows, refs
+	}
+
+	shared, unique := []byte("same"), []byte("diff")
+	oldCarve := newCarve("old", now.Add(-48*time.Hour))
+	newerCarve := newCarve("new", now)
+	require.NoError(t, ds.NewBlock(oldCarve, 0, shared))
+	require.NoError(t, ds.NewBlock(oldCarve, 1, shared))
+	require.NoError(t, ds.NewBlock(newerCarve, 0, shared))
+	require.NoError(t, ds.NewBlock(newerCarve, 1, unique))
+
+	// A failed block insert doesn't add a reference
+	require.Error(t, ds.NewBlock(newerCarve, 1, shared))
+
+	rows, refs := countData()
+	assert.Equal(t, 2, rows)
+	assert.Equal(t, 4, refs)
+
+	for _, c := range []*fleet.CarveMetadata{oldCarve, newerCarve} {
+		data, err := ds.GetBlock(c, 0)
+		require.NoError(t, err)
+		assert.Equal(t, shared, data)
+	}
+	data, err := ds.GetBlock(newerCarve, 1)
+	require.NoError(t, err)
+	assert.Equal(t, unique, data)
+
+	// Expiring the old carve keeps the data still referenced by the new carve
+	expired, err := ds.CleanupCarves(now)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint]int{0: 1}, expired)
+
+	rows, refs = countData()
+	assert.Equal(t, 2, rows)
+	assert.Equal(t, 2, refs)
+	_, err = ds.GetBlock(oldCarve, 0)
+	require.Error(t, err)
+	data, err = ds.GetBlock(newerCarve, 0)
+	require.NoError(t, err)
+	assert.Equal(t, shared, data)
+
+	// Data of carves deleted with their host is deleted on cleanup
+	require.NoError(t, ds.DeleteHost(newerCarve.HostId))
+	_, err = ds.CleanupCarves(now)
+	require.NoError(t, err)
+	rows, _ = countData()
+	assert.Zero(t, rows)
+}
+
+func TestDeleteCarves(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+	newCarve := func(name string) *fleet.CarveMetadata {
+		carve, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     h.ID,
+			Name:       name,
+			BlockCount: 2,
+			BlockSize:  4,
+			CarveSize:  8,
+			CarveId:    name,
+			RequestId:  name,
+			SessionId:  name,
+			CreatedAt:  mockCreatedAt,
+		}, 0)
+		require.NoError(t, err)
+		return carve
+	}
+	countData := func() (rows, refs int) {
+		require.NoError(t, ds.db.Get(&rows, `SELECT COUNT(*) FROM carve_block_data`))
+		require.NoError(t, ds.db.Get(&refs, `SELECT COALESCE(SUM(refcount), 0) FROM carve_block_data`))
+		return rows, refs
+	}
+
+	shared := []byte("same")
+	carve1 := newCarve("carve1")
+	carve2 := newCarve("carve2")
+	carve3 := newCarve("carve3")
+	require.NoError(t, ds.NewBlock(carve1, 0, shared))
+	require.NoError(t, ds.NewBlock(carve1, 1, []byte("one1")))
+	require.NoError(t, ds.NewBlock(carve2, 0, shared))
+	require.NoError(t, ds.NewBlock(carve2, 1, []byte("two2")))
+	require.NoError(t, ds.NewBlock(carve3, 0, shared))
+
+	deleted, err := ds.DeleteCarves(nil)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	// Missing IDs are skipped
+	deleted, err = ds.DeleteCarves([]int64{carve1.ID, carve2.ID, carve3.ID + 100})
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	_, err = ds.Carve(carve1.ID)
+	require.Error(t, err)
+	_, err = ds.Carve(carve2.ID)
+	require.Error(t, err)
+
+	// Only the data still referenced by carve3 remains
+	rows, refs := countData()
+	assert.Equal(t, 1, rows)
+	assert.Equal(t, 1, refs)
+	data, err := ds.GetBlock(carve3, 0)
+	require.NoError(t, err)
+	assert.Equal(t, shared, data)
+
+	deleted, err = ds.DeleteCarves([]int64{carve1.ID, carve3.ID})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	rows, _ = countData()
+	assert.Zero(t, rows)
+}
+
 func TestCarveCleanupCarves(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
@@ -138,7 +485,7 @@ func TestCarveCleanupCarves(t *testing.T) {
 		CreatedAt:  mockCreatedAt,
 	}
 
-	carve, err := ds.NewCarve(carve)
+	carve, err := ds.NewCarve(carve, 0)
 	require.NoError(t, err)
 
 	// Randomly generate and insert blocks
@@ -155,14 +502,14 @@ func TestCarveCleanupCarves(t *testing.T) {
 
 	expired, err := ds.CleanupCarves(time.Now())
 	require.NoError(t, err)
-	assert.Equal(t, 0, expired)
+	assert.Empty(t, expired)
 
 	_, err = ds.GetBlock(carve, 0)
 	require.NoError(t, err)
 
 	expired, err = ds.CleanupCarves(time.Now().Add(24 * time.Hour))
 	require.NoError(t, err)
-	assert.Equal(t, 1, expired)
+	assert.Equal(t, map[uint]int{0: 1}, expired)
 
 	// Should no longer be able to get data
 	_, err = ds.GetBlock(carve, 0)
@@ -173,9 +520,213 @@ func TestCarveCleanupCarves(t *testing.T) {
 	assert.True(t, carve.Expired)
 }
 
+func TestCarveCleanupCarvesTeamRetention(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	shortTeam, err := ds.NewTeam(&fleet.Team{Name: "short", CarveRetentionHours: ptr.Uint(1)})
+	require.NoError(t, err)
+	longTeam, err := ds.NewTeam(&fleet.Team{Name: "long", CarveRetentionHours: ptr.Uint(48)})
+	require.NoError(t, err)
+	defaultTeam, err := ds.NewTeam(&fleet.Team{Name: "default"})
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	newCarve := func(name string, teamID *uint) *fleet.CarveMetadata {
+		h := test.NewHost(t, ds, name, "", name, name, now)
+		if teamID != nil {
+			_, err = ds.AddHostsToTeam(teamID, []uint{h.ID}, false)
+			require.NoError(t, err)
+		}
+		carve, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     h.ID,
+			Name:       name,
+			BlockCount: 1,
+			BlockSize:  1,
+			CarveSize:  1,
+			CarveId:    name,
+			RequestId:  name,
+			SessionId:  name,
+			CreatedAt:  now,
+		}, 0)
+		require.NoError(t, err)
+		return carve
+	}
+	shortCarve := newCarve("short", &shortTeam.ID)
+	longCarve := newCarve("long", &longTeam.ID)
+	defaultCarve := newCarve("default", &defaultTeam.ID)
+	noTeamCarve := newCarve("noteam", nil)
+
+	carve, err := ds.Carve(shortCarve.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &shortTeam.ID, carve.TeamID)
+
+	// Only the team with a 1 hour retention expires
+	expired, err := ds.CleanupCarves(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, map[uint]int{shortTeam.ID: 1}, expired)
+
+	// Global retention applies to hosts without a team and teams without a
+	// retention
+	expired, err = ds.CleanupCarves(now.Add(25 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, map[uint]int{defaultTeam.ID: 1, 0: 1}, expired)
+
+	expired, err = ds.CleanupCarves(now.Add(49 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, map[uint]int{longTeam.ID: 1}, expired)
+
+	for _, c := range []*fleet.CarveMetadata{shortCarve, longCarve, defaultCarve, noTeamCarve} {
+		carve, err := ds.Carve(c.ID)
+		require.NoError(t, err)
+		assert.True(t, carve.Expired, c.Name)
+	}
+}
+
+func TestListCarvesExpiringBefore(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	shortTeam, err := ds.NewTeam(&fleet.Team{Name: "short", CarveRetentionHours: ptr.Uint(1)})
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	newCarve := func(name string, teamID *uint, createdAt time.Time) *fleet.CarveMetadata {
+		h := test.NewHost(t, ds, name, "", name, name, now)
+		if teamID != nil {
+			_, err = ds.AddHostsToTeam(teamID, []uint{h.ID}, false)
+			require.NoError(t, err)
+		}
+		carve, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     h.ID,
+			Name:       name,
+			BlockCount: 1,
+			BlockSize:  1,
+			CarveSize:  1,
+			CarveId:    name,
+			RequestId:  name,
+			SessionId:  name,
+			CreatedAt:  createdAt,
+		}, 0)
+		require.NoError(t, err)
+		return carve
+	}
+	shortCarve := newCarve("short", &shortTeam.ID, now)
+	oldCarve := newCarve("old", nil, now.Add(-12*time.Hour))
+	newerCarve := newCarve("newer", nil, now)
+	expiredCarve := newCarve("expired", nil, now.Add(-48*time.Hour))
+	expiredCarve.Expired = true
+	require.NoError(t, ds.UpdateCarve(expiredCarve))
+
+	listNames := func(before time.Time) []string {
+		carves, err := ds.ListCarvesExpiringBefore(before)
+		require.NoError(t, err)
+		var names []string
+		for _, c := range carves {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	assert.Empty(t, listNames(now))
+	assert.Equal(t, []string{shortCarve.Name}, listNames(now.Add(2*time.Hour)))
+	assert.Equal(t, []string{oldCarve.Name, shortCarve.Name}, listNames(now.Add(13*time.Hour)))
+	assert.Equal(t, []string{oldCarve.Name, shortCarve.Name, newerCarve.Name}, listNames(now.Add(25*time.Hour)))
+
+	// The listed carves are the ones expired by cleanup
+	expired, err := ds.CleanupCarves(now.Add(13 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, map[uint]int{shortTeam.ID: 1, 0: 1}, expired)
+	assert.Equal(t, []string{newerCarve.Name}, listNames(now.Add(25*time.Hour)))
+}
+func TestHostCarveStorage(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h1 := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+	h2 := test.NewHost(t, ds, "bar.local", "192.168.1.11", "2", "2", time.Now())
+
+	newCarve := func(name string, hostID uint, size int64) *fleet.CarveMetadata {
+		carve, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     hostID,
+			Name:       name,
+			BlockCount: 1,
+			BlockSize:  size,
+			CarveSize:  size,
+			CarveId:    name,
+			RequestId:  name,
+			SessionId:  name,
+			CreatedAt:  mockCreatedAt,
+		}, 0)
+		require.NoError(t, err)
+		return carve
+	}
+	newCarve("carve1", h1.ID, 100)
+	newCarve("carve2", h1.ID, 250)
+	expiredCarve := newCarve("carve3", h1.ID, 1000)
+	newCarve("carve4", h2.ID, 7)
+
+	expiredCarve.Expired = true
+	require.NoError(t, ds.UpdateCarve(expiredCarve))
+
+	total, count, err := ds.HostCarveStorage(h1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(350), total)
+	assert.Equal(t, 2, count)
+
+	total, count, err = ds.HostCarveStorage(h2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), total)
+	assert.Equal(t, 1, count)
+
+	total, count, err = ds.HostCarveStorage(999)
+	require.NoError(t, err)
+	assert.Zero(t, total)
+	assert.Zero(t, count)
+}
+
+func TestCarveByRequestId(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	newCarve := func(name, requestId string) *fleet.CarveMetadata {
+		carve, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     h.ID,
+			Name:       name,
+			BlockCount: 1,
+			BlockSize:  10,
+			CarveSize:  10,
+			CarveId:    name,
+			RequestId:  requestId,
+			SessionId:  name,
+			CreatedAt:  mockCreatedAt,
+		}, 0)
+		require.NoError(t, err)
+		return carve
+	}
+	newCarve("carve1", "request1")
+	latest := newCarve("carve2", "request1")
+	other := newCarve("carve3", "request2")
+
+	carve, err := ds.CarveByRequestId("request1")
+	require.NoError(t, err)
+	assert.Equal(t, latest.ID, carve.ID)
+	assert.Equal(t, "carve2", carve.Name)
+
+	carve, err = ds.CarveByRequestId("request2")
+	require.NoError(t, err)
+	assert.Equal(t, other.ID, carve.ID)
+
+	_, err = ds.CarveByRequestId("request3")
+	assert.True(t, fleet.IsNotFound(err))
+}
+
 func TestCarveListCarves(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
+	filter := fleet.TeamFilter{User: test.UserAdmin}
 
 	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
 
@@ -192,11 +743,11 @@ func TestCarveListCarves(t *testing.T) {
 		MaxBlock:   -1,
 	}
 
-	expectedCarve, err := ds.NewCarve(expectedCarve)
+	expectedCarve, err := ds.NewCarve(expectedCarve, 0)
 	require.NoError(t, err)
 	assert.NotEqual(t, 0, expectedCarve.ID)
 	// Add a block to this carve
-	err = ds.NewBlock(expectedCarve, 0, nil)
+	err = ds.NewBlock(expectedCarve, 0, make([]byte, 12))
 	require.NoError(t, err)
 	expectedCarve.MaxBlock = 0
 
@@ -212,12 +763,12 @@ func TestCarveListCarves(t *testing.T) {
 		CreatedAt:  mockCreatedAt,
 	}
 
-	expectedCarve2, err = ds.NewCarve(expectedCarve2)
+	expectedCarve2, err = ds.NewCarve(expectedCarve2, 0)
 	require.NoError(t, err)
 	assert.NotEqual(t, 0, expectedCarve2.ID)
 	expectedCarve2.MaxBlock = -1
 
-	carves, err := ds.ListCarves(fleet.CarveListOptions{Expired: true})
+	carves, err := ds.ListCarves(filter, fleet.CarveListOptions{Expired: true})
 	require.NoError(t, err)
 	assert.Equal(t, []*fleet.CarveMetadata{expectedCarve, expectedCarve2}, carves)
 
@@ -225,15 +776,185 @@ func TestCarveListCarves(t *testing.T) {
 	_, err = ds.CleanupCarves(time.Now().Add(24 * time.Hour))
 	require.NoError(t, err)
 
-	carves, err = ds.ListCarves(fleet.CarveListOptions{Expired: false})
+	carves, err = ds.ListCarves(filter, fleet.CarveListOptions{Expired: false})
 	require.NoError(t, err)
 	assert.Empty(t, carves)
 
-	carves, err = ds.ListCarves(fleet.CarveListOptions{Expired: true})
+	carves, err = ds.ListCarves(filter, fleet.CarveListOptions{Expired: true})
 	require.NoError(t, err)
 	assert.Len(t, carves, 2)
 }
 
+func TestCarveListCarvesTeamFilter(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	team1, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	team2, err := ds.NewTeam(&fleet.Team{Name: "team2"})
+	require.NoError(t, err)
+
+	newCarve := func(name string, teamID *uint) *fleet.CarveMetadata {
+		h := test.NewHost(t, ds, name, "", name, name, time.Now())
+		if teamID != nil {
+			_, err = ds.AddHostsToTeam(teamID, []uint{h.ID}, false)
+			require.NoError(t, err)
+		}
+		carve, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     h.ID,
+			Name:       name,
+			BlockCount: 1,
+			BlockSize:  1,
+			CarveSize:  1,
+			CarveId:    name,
+			RequestId:  name,
+			SessionId:  name,
+			CreatedAt:  mockCreatedAt,
+		}, 0)
+		require.NoError(t, err)
+		return carve
+	}
+	newCarve("carve1", &team1.ID)
+	newCarve("carve2", &team2.ID)
+	newCarve("noteam", nil)
+
+	listNames := func(filter fleet.TeamFilter) []string {
+		carves, err := ds.ListCarves(filter, fleet.CarveListOptions{ListOptions: fleet.ListOptions{OrderKey: "name"}})
+		require.NoError(t, err)
+		var names []string
+		for _, c := range carves {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	globalFilter := fleet.TeamFilter{User: test.UserAdmin}
+	team1Filter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Team: *team1, Role: fleet.RoleAdmin}},
+	}}
+	team2Filter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Team: *team2, Role: fleet.RoleAdmin}},
+	}}
+
+	assert.Equal(t, []string{"carve1", "carve2", "noteam"}, listNames(globalFilter))
+	assert.Equal(t, []string{"carve1"}, listNames(team1Filter))
+	assert.Equal(t, []string{"carve2"}, listNames(team2Filter))
+	assert.Empty(t, listNames(fleet.TeamFilter{User: test.UserNoRoles}))
+
+	carve, err := ds.CarveByName(team1Filter, "carve1")
+	require.NoError(t, err)
+	assert.Equal(t, &team1.ID, carve.TeamID)
+
+	_, err = ds.CarveByName(team1Filter, "carve2")
+	require.Error(t, err)
+	_, err = ds.CarveByName(team2Filter, "noteam")
+	require.Error(t, err)
+
+	carve, err = ds.CarveByName(globalFilter, "carve2")
+	require.NoError(t, err)
+	assert.Equal(t, &team2.ID, carve.TeamID)
+}
+
+func TestCarveListCarvesNamePrefix(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	carves := map[string]*fleet.CarveMetadata{}
+	for i, name := range []string{"incident-1234-a", "incident-1234-b", "incident-12345-a", "incident_1234-c", "other"} {
+		carve, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     h.ID,
+			Name:       name,
+			BlockCount: 1,
+			BlockSize:  8,
+			CarveSize:  8,
+			CarveId:    fmt.Sprintf("carve_id%d", i),
+			RequestId:  fmt.Sprintf("request_id%d", i),
+			SessionId:  fmt.Sprintf("session_id%d", i),
+			CreatedAt:  mockCreatedAt,
+		}, 0)
+		require.NoError(t, err)
+		carves[name] = carve
+	}
+	carves["incident-1234-b"].Expired = true
+	require.NoError(t, ds.UpdateCarve(carves["incident-1234-b"]))
+
+	listNames := func(opt fleet.CarveListOptions) []string {
+		listed, err := ds.ListCarves(filter, opt)
+		require.NoError(t, err)
+		var names []string
+		for _, c := range listed {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"incident-1234-a", "incident-12345-a"}, listNames(fleet.CarveListOptions{NamePrefix: "incident-1234"}))
+	assert.Equal(t, []string{"incident-1234-a"}, listNames(fleet.CarveListOptions{NamePrefix: "incident-1234-"}))
+	assert.Equal(t, []string{"incident-1234-a", "incident-1234-b"}, listNames(fleet.CarveListOptions{NamePrefix: "incident-1234-", Expired: true}))
+	// Wildcards in the prefix match literally
+	assert.Equal(t, []string{"incident_1234-c"}, listNames(fleet.CarveListOptions{NamePrefix: "incident_"}))
+	assert.Empty(t, listNames(fleet.CarveListOptions{NamePrefix: "%"}))
+
+	// Pagination
+	opt := fleet.CarveListOptions{NamePrefix: "incident", Expired: true, ListOptions: fleet.ListOptions{OrderKey: "id", PerPage: 2, Page: 1}}
+	assert.Equal(t, []string{"incident-12345-a", "incident_1234-c"}, listNames(opt))
+}
+
+func TestCarveListCarvesOrder(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	// Names are created out of order, with the same creation time
+	var ids []int64
+	for i := 0; i < 50; i++ {
+		carve, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     h.ID,
+			Name:       fmt.Sprintf("carve%02d", (i*7)%50),
+			BlockCount: 1,
+			BlockSize:  8,
+			CarveSize:  8,
+			CarveId:    fmt.Sprintf("carve_id%d", i),
+			RequestId:  fmt.Sprintf("request_id%d", i),
+			SessionId:  fmt.Sprintf("session_id%d", i),
+			CreatedAt:  mockCreatedAt,
+		}, 0)
+		require.NoError(t, err)
+		ids = append(ids, carve.ID)
+	}
+
+	listIDs := func(opt fleet.CarveListOptions) []int64 {
+		listed, err := ds.ListCarves(filter, opt)
+		require.NoError(t, err)
+		var listedIDs []int64
+		for _, c := range listed {
+			listedIDs = append(listedIDs, c.ID)
+		}
+		return listedIDs
+	}
+
+	assert.Equal(t, ids, listIDs(fleet.CarveListOptions{}))
+	// Pages follow the same order
+	var paged []int64
+	for page := uint(0); page < 5; page++ {
+		paged = append(paged, listIDs(fleet.CarveListOptions{ListOptions: fleet.ListOptions{PerPage: 10, Page: page}})...)
+	}
+	assert.Equal(t, ids, paged)
+
+	// The order can be overridden
+	listed, err := ds.ListCarves(filter, fleet.CarveListOptions{ListOptions: fleet.ListOptions{OrderKey: "name", OrderDirection: fleet.OrderDescending}})
+	require.NoError(t, err)
+	require.Len(t, listed, 50)
+	for i, c := range listed {
+		assert.Equal(t, fmt.Sprintf("carve%02d", 49-i), c.Name)
+	}
+}
+
 func TestCarveUpdateCarve(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
@@ -253,7 +974,7 @@ func TestCarveUpdateCarve(t *testing.T) {
 		CreatedAt:  mockCreatedAt,
 	}
 
-	carve, err := ds.NewCarve(carve)
+	carve, err := ds.NewCarve(carve, 0)
 	require.NoError(t, err)
 
 	carve.Expired = true
@@ -267,3 +988,193 @@ func TestCarveUpdateCarve(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, carve, dbCarve)
 }
+
+func TestCarveUpdateCarveCase(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+
+	h1 := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+	h2 := test.NewHost(t, ds, "bar.local", "192.168.1.11", "2", "2", time.Now())
+
+	var carves []*fleet.CarveMetadata
+	for i, h := range []*fleet.Host{h1, h2, h1} {
+		carve, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     h.ID,
+			Name:       fmt.Sprintf("carve%d", i),
+			BlockCount: 1,
+			BlockSize:  8,
+			CarveSize:  8,
+			CarveId:    fmt.Sprintf("carve_id%d", i),
+			RequestId:  fmt.Sprintf("request_id%d", i),
+			SessionId:  fmt.Sprintf("session_id%d", i),
+			CreatedAt:  mockCreatedAt,
+		}, 0)
+		require.NoError(t, err)
+		carves = append(carves, carve)
+	}
+
+	require.NoError(t, ds.UpdateCarveCase(carves[0].ID, "IR-1234", "Suspicious binary"))
+	require.NoError(t, ds.UpdateCarveCase(carves[1].ID, "IR-1234", ""))
+	// Setting the same values again is not an error
+	require.NoError(t, ds.UpdateCarveCase(carves[1].ID, "IR-1234", ""))
+
+	carve, err := ds.Carve(carves[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "IR-1234", carve.CaseID)
+	assert.Equal(t, "Suspicious binary", carve.Notes)
+
+	// The case is kept by UpdateCarve
+	carve.MaxBlock = 0
+	require.NoError(t, ds.UpdateCarve(carve))
+	carve, err = ds.Carve(carves[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "IR-1234", carve.CaseID)
+
+	listed, err := ds.ListCarves(filter, fleet.CarveListOptions{CaseID: "IR-1234"})
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, carves[0].ID, listed[0].ID)
+	assert.Equal(t, carves[1].ID, listed[1].ID)
+	listed, err = ds.ListCarves(filter, fleet.CarveListOptions{CaseID: "IR-0000"})
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+	listed, err = ds.ListCarves(filter, fleet.CarveListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, listed, 3)
+
+	// Clearing the case
+	require.NoError(t, ds.UpdateCarveCase(carves[0].ID, "", ""))
+	listed, err = ds.ListCarves(filter, fleet.CarveListOptions{CaseID: "IR-1234"})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, carves[1].ID, listed[0].ID)
+
+	err = ds.UpdateCarveCase(999, "IR-1234", "")
+	require.Error(t, err)
+	assert.True(t, fleet.IsNotFound(err))
+	require.Error(t, ds.UpdateCarveCase(carves[0].ID, strings.Repeat("a", 256), ""))
+}
+
+func TestCarveDuplicateName(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	carve := &fleet.CarveMetadata{
+		HostId:     h.ID,
+		Name:       "foobar",
+		BlockCount: 10,
+		BlockSize:  12,
+		CarveSize:  113,
+		CarveId:    "carve_id",
+		RequestId:  "request_id",
+		SessionId:  "session_id",
+		CreatedAt:  mockCreatedAt,
+	}
+	_, err := ds.NewCarve(carve, 0)
+	require.NoError(t, err)
+
+	duplicate := &fleet.CarveMetadata{
+		HostId:     h.ID,
+		Name:       "foobar",
+		BlockCount: 10,
+		BlockSize:  12,
+		CarveSize:  113,
+		CarveId:    "carve_id2",
+		RequestId:  "request_id2",
+		SessionId:  "session_id2",
+		CreatedAt:  mockCreatedAt,
+	}
+	_, err = ds.NewCarve(duplicate, 0)
+	assert.Equal(t, fleet.ErrDuplicateCarveName, err)
+
+	// A duplicate session ID is not reported as a duplicate name
+	duplicate.Name = "foobar2"
+	duplicate.SessionId = "session_id"
+	_, err = ds.NewCarve(duplicate, 0)
+	require.Error(t, err)
+	assert.NotEqual(t, fleet.ErrDuplicateCarveName, err)
+}
+
+func TestCarveNameUniqueKey(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	// NewCarve detects duplicate names by the key name in the MySQL error, so
+	// the unique index on name must keep the expected name.
+	var columns []string
+	err := ds.db.Select(&columns, `
+		SELECT column_name
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = 'carve_metadata' AND index_name = ? AND non_unique = 0
+	`, carveNameUniqueKey)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name"}, columns)
+}
+
+func TestCarveActiveQuota(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+	h2 := test.NewHost(t, ds, "bar.local", "192.168.1.11", "2", "2", time.Now())
+
+	newCarve := func(hostID uint, i int) (*fleet.CarveMetadata, error) {
+		return ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     hostID,
+			Name:       fmt.Sprintf("carve%d-%d", hostID, i),
+			BlockCount: 2,
+			BlockSize:  12,
+			CarveSize:  20,
+			CarveId:    fmt.Sprintf("carve_id%d-%d", hostID, i),
+			RequestId:  "request_id",
+			SessionId:  fmt.Sprintf("session_id%d-%d", hostID, i),
+			CreatedAt:  mockCreatedAt,
+		}, 2)
+	}
+
+	c1, err := newCarve(h.ID, 1)
+	require.NoError(t, err)
+	c2, err := newCarve(h.ID, 2)
+	require.NoError(t, err)
+
+	_, err = newCarve(h.ID, 3)
+	assert.Equal(t, fleet.ErrCarveQuotaExceeded, err)
+
+	// Other hosts are not affected
+	_, err = newCarve(h2.ID, 1)
+	require.NoError(t, err)
+
+	// No limit
+	_, err = ds.NewCarve(&fleet.CarveMetadata{
+		HostId:     h.ID,
+		Name:       "unlimited",
+		BlockCount: 2,
+		BlockSize:  12,
+		CarveSize:  20,
+		CarveId:    "unlimited",
+		RequestId:  "request_id",
+		SessionId:  "unlimited",
+		CreatedAt:  mockCreatedAt,
+	}, 0)
+	require.NoError(t, err)
+
+	// Completed and expired carves don't count
+	require.NoError(t, ds.NewBlock(c1, 0, []byte("block0block0")))
+	require.NoError(t, ds.NewBlock(c1, 1, []byte("block1")))
+	c2.Expired = true
+	require.NoError(t, ds.UpdateCarve(c2))
+	c3, err := ds.CarveByName(fleet.TeamFilter{User: test.UserAdmin}, "unlimited")
+	require.NoError(t, err)
+	c3.Expired = true
+	require.NoError(t, ds.UpdateCarve(c3))
+
+	_, err = newCarve(h.ID, 3)
+	require.NoError(t, err)
+	_, err = newCarve(h.ID, 4)
+	require.NoError(t, err)
+	_, err = newCarve(h.ID, 5)
+	assert.Equal(t, fleet.ErrCarveQuotaExceeded, err)
+}
@@ -1,6 +1,11 @@
 package mysql
 
-import "github.com/go-kit/kit/log"
+import (
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
 
 const defaultMaxAttempts int = 15
 
@@ -11,6 +16,18 @@ type dbOptions struct {
 	// maxAttempts configures the number of retries to connect to the DB
 	maxAttempts int
 	logger      log.Logger
+	// trackedHostFields are the host columns whose changes are recorded
+	trackedHostFields []string
+	// hostIdentifierResolvers are tried by HostByIdentifier after the
+	// default resolvers
+	hostIdentifierResolvers []HostIdentifierResolver
+	// enrollDedupColumn and enrollDedupWindow collapse the repeated
+	// enrollments of a host, see EnrollDedup
+	enrollDedupColumn string
+	enrollDedupWindow time.Duration
+	// hostResourceSampleInterval is the minimum interval between the
+	// resource samples of a host, see HostResourceSampleInterval
+	hostResourceSampleInterval time.Duration
 }
 
 // Logger adds a logger to the datastore
@@ -30,3 +47,71 @@ func LimitAttempts(attempts int) DBOption {
 		return nil
 	}
 }
+
+// TrackHostFields records the changes of the host columns saved by SaveHost
+// and SaveHostFields, see ListHostFieldChanges. The columns must be columns
+// saved by SaveHostFields.
+func TrackHostFields(fields ...string) DBOption {
+	return func(o *dbOptions) error {
+		for _, field := range fields {
+			if _, ok := hostSaveFields[field]; !ok {
+				return errors.Errorf("invalid tracked host field %q", field)
+			}
+		}
+		o.trackedHostFields = fields
+		return nil
+	}
+}
+
+// HostIdentifierResolvers adds resolvers tried in order by HostByIdentifier
+// when no host matches the osquery host ID, node key, UUID, hostname or cloud
+// instance ID, eg. HostColumnResolver("hardware_serial").
+func HostIdentifierResolvers(resolvers ...HostIdentifierResolver) DBOption {
+	return func(o *dbOptions) error {
+		o.hostIdentifierResolvers = append(o.hostIdentifierResolvers, resolvers...)
+		return nil
+	}
+}
+
+// enrollDedupColumns are the host columns that EnrollDedup can match
+// enrolling hosts against.
+var enrollDedupColumns = map[string]bool{
+	"osquery_host_id": true,
+	"uuid":            true,
+	"hardware_serial": true,
+}
+
+// EnrollDedup collapses the enrollments of a host within the window onto a
+// single host row, for agents that keep enrolling again. A host enrolling
+// with the value of the column of a host enrolled within the window is
+// enrolled as that host, updating its osquery host identifier, and isn't
+// reset by the reset enroll strategy. The column is one of osquery_host_id,
+// uuid or hardware_serial, the latter two are matched against the
+// EnrollHostOptions values of the enrollment.
+func EnrollDedup(column string, window time.Duration) DBOption {
+	return func(o *dbOptions) error {
+		if !enrollDedupColumns[column] {
+			return errors.Errorf("invalid enroll dedup column %q", column)
+		}
+		o.enrollDedupColumn = column
+		o.enrollDedupWindow = window
+		return nil
+	}
+}
+
+// minHostResourceSampleInterval bounds the number of resource samples kept
+// for each host.
+const minHostResourceSampleInterval = time.Minute
+
+// HostResourceSampleInterval records a sample of the memory and disk space of
+// a host when SaveHost saves it, unless the host was sampled within the
+// interval, see HostResourceHistory. The interval is at least a minute.
+func HostResourceSampleInterval(interval time.Duration) DBOption {
+	return func(o *dbOptions) error {
+		if interval < minHostResourceSampleInterval {
+			return errors.Errorf("host resource sample interval %s is less than %s", interval, minHostResourceSampleInterval)
+		}
+		o.hostResourceSampleInterval = interval
+		return nil
+	}
+}
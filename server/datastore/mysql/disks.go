@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+func (d *Datastore) SaveHostDisks(host *fleet.Host) error {
+	if !host.HostDisks.Modified {
+		return nil
+	}
+
+	// Disks are identified by name, the last disk reported with a name wins.
+	incoming := make(map[string]fleet.HostDisk, len(host.Disks))
+	for _, disk := range host.Disks {
+		incoming[disk.Name] = disk
+	}
+
+	if err := d.withRetryTxx(func(tx *sqlx.Tx) error {
+		var current []fleet.HostDisk
+		if err := tx.Select(&current, `SELECT name, size, type FROM host_disks WHERE host_id = ?`, host.ID); err != nil {
+			return errors.Wrap(err, "load current host disks")
+		}
+
+		// Only removed disks are deleted, and only new or changed disks are
+		// written.
+		changed := make(map[string]fleet.HostDisk, len(incoming))
+		for name, disk := range incoming {
+			changed[name] = disk
+		}
+		deletes := []interface{}{host.ID}
+		for _, disk := range current {
+			in, ok := incoming[disk.Name]
+			switch {
+			case !ok:
+				deletes = append(deletes, disk.Name)
+			case in == disk:
+				delete(changed, disk.Name)
+			}
+		}
+
+		if len(deletes) > 1 {
+			sql := fmt.Sprintf(
+				`DELETE FROM host_disks WHERE host_id = ? AND name IN (%s)`,
+				strings.TrimSuffix(strings.Repeat("?,", len(deletes)-1), ","),
+			)
+			if _, err := tx.Exec(sql, deletes...); err != nil {
+				return errors.Wrap(err, "delete host disks")
+			}
+		}
+
+		if len(changed) > 0 {
+			var args []interface{}
+			for _, disk := range changed {
+				args = append(args, host.ID, disk.Name, disk.Size, disk.Type)
+			}
+			sql := fmt.Sprintf(`
+				INSERT INTO host_disks (host_id, name, size, type) VALUES %s
+				ON DUPLICATE KEY UPDATE size = VALUES(size), type = VALUES(type)`,
+				strings.TrimSuffix(strings.Repeat("(?,?,?,?),", len(changed)), ","),
+			)
+			if _, err := tx.Exec(sql, args...); err != nil {
+				return errors.Wrap(err, "insert host disks")
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "save host disks")
+	}
+
+	host.HostDisks.Modified = false
+	return nil
+}
+
+func (d *Datastore) LoadHostDisks(host *fleet.Host) error {
+	host.HostDisks = fleet.HostDisks{Modified: false}
+	var disks []fleet.HostDisk
+	sql := `SELECT name, size, type FROM host_disks WHERE host_id = ? ORDER BY name`
+	if err := d.db.Select(&disks, sql, host.ID); err != nil {
+		return errors.Wrap(err, "load host disks")
+	}
+	host.Disks = disks
+	return nil
+}
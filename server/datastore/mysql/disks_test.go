@@ -0,0 +1,80 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveHostDisks(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host1 := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host2 := test.NewHost(t, ds, "host2", "", "host2key", "host2uuid", time.Now())
+
+	host1.HostDisks = fleet.HostDisks{
+		Modified: true,
+		Disks: []fleet.HostDisk{
+			{Name: "/dev/sdb", Size: 1000, Type: "disk"},
+			{Name: "/dev/sda", Size: 2000, Type: "disk"},
+			// Duplicate names are deduplicated, the last one wins
+			{Name: "/dev/sdb", Size: 4000, Type: "disk"},
+		},
+	}
+	require.NoError(t, ds.SaveHost(host1))
+	assert.False(t, host1.HostDisks.Modified)
+
+	host2.HostDisks = fleet.HostDisks{
+		Modified: true,
+		Disks:    []fleet.HostDisk{{Name: `\\.\PHYSICALDRIVE0`, Size: 3000, Type: "SCSI"}},
+	}
+	require.NoError(t, ds.SaveHostDisks(host2))
+
+	require.NoError(t, ds.LoadHostDisks(host1))
+	assert.Equal(t, []fleet.HostDisk{
+		{Name: "/dev/sda", Size: 2000, Type: "disk"},
+		{Name: "/dev/sdb", Size: 4000, Type: "disk"},
+	}, host1.Disks)
+	require.NoError(t, ds.LoadHostDisks(host2))
+	assert.Equal(t, []fleet.HostDisk{{Name: `\\.\PHYSICALDRIVE0`, Size: 3000, Type: "SCSI"}}, host2.Disks)
+
+	// Unmodified disks are not saved
+	host1.Disks = nil
+	require.NoError(t, ds.SaveHostDisks(host1))
+	require.NoError(t, ds.LoadHostDisks(host1))
+	assert.Len(t, host1.Disks, 2)
+
+	// Removed disks are deleted, changed disks are updated
+	host1.HostDisks = fleet.HostDisks{
+		Modified: true,
+		Disks: []fleet.HostDisk{
+			{Name: "/dev/sda", Size: 2500, Type: "disk"},
+			{Name: "/dev/sdc", Size: 100, Type: "disk"},
+		},
+	}
+	require.NoError(t, ds.SaveHostDisks(host1))
+	require.NoError(t, ds.LoadHostDisks(host1))
+	assert.Equal(t, []fleet.HostDisk{
+		{Name: "/dev/sda", Size: 2500, Type: "disk"},
+		{Name: "/dev/sdc", Size: 100, Type: "disk"},
+	}, host1.Disks)
+
+	host1.HostDisks = fleet.HostDisks{Modified: true}
+	require.NoError(t, ds.SaveHostDisks(host1))
+	require.NoError(t, ds.LoadHostDisks(host1))
+	assert.Empty(t, host1.Disks)
+
+	// Other hosts are unaffected
+	require.NoError(t, ds.LoadHostDisks(host2))
+	assert.Len(t, host2.Disks, 1)
+
+	require.NoError(t, ds.DeleteHost(host2.ID))
+	counts, err := ds.HostOrphanCheck(host2.ID)
+	require.NoError(t, err)
+	assert.Zero(t, counts["host_disks"])
+}
@@ -42,3 +42,26 @@ func TestQueryMinLength(t *testing.T) {
 		})
 	}
 }
+
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		a, b string
+		out  int
+	}{
+		{"", "", 0},
+		{"web01", "web01", 0},
+		{"web01", "", 5},
+		{"web01", "web02", 1},
+		{"webserver", "websrever", 2},
+		{"webserver", "webservr", 1},
+		{"kitten", "sitting", 3},
+		{"héllo", "hello", 1},
+	}
+
+	for _, tt := range testCases {
+		t.Run("", func(t *testing.T) {
+			assert.Equal(t, tt.out, editDistance(tt.a, tt.b))
+			assert.Equal(t, tt.out, editDistance(tt.b, tt.a))
+		})
+	}
+}
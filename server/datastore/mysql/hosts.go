@@ -2,17 +2,27 @@ package mysql
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/cenkalti/backoff/v4"
+	"github.com/fleetdm/fleet/v4/server"
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/go-kit/kit/log/level"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 )
 
-var hostSearchColumns = []string{"hostname", "uuid", "hardware_serial", "primary_ip"}
+var hostSearchColumns = []string{"hostname", "uuid", "hardware_serial", "primary_ip", "cloud_instance_id"}
+
+// hostLabelsNeverUpdated is the label_updated_at value used for hosts that
+// should have all of their label queries run on the next check in.
+var hostLabelsNeverUpdated = time.Unix(0, 0).Add(24 * time.Hour)
 
 func (d *Datastore) NewHost(host *fleet.Host) (*fleet.Host, error) {
 	sqlStatement := `
@@ -57,7 +67,28 @@ func (d *Datastore) NewHost(host *fleet.Host) (*fleet.Host, error) {
 	return host, nil
 }
 
+// setHostPrimaryInterface sets the primary IP and MAC of the host to the
+// interface picked by fleet.SelectPrimaryNetworkInterface from the network
+// interfaces reported with the host details. Hosts saved without interfaces
+// keep their primary IP and MAC.
+func setHostPrimaryInterface(host *fleet.Host) {
+	primary := fleet.SelectPrimaryNetworkInterface(host.NetworkInterfaces)
+	if primary == nil {
+		return
+	}
+
+	host.PrimaryIP = primary.IPAddress
+	host.PrimaryMac = primary.MAC
+}
+
 func (d *Datastore) SaveHost(host *fleet.Host) error {
+	setHostPrimaryInterface(host)
+
+	before, err := d.trackedHostFieldValues(host.ID, d.trackedHostFields)
+	if err != nil {
+		return err
+	}
+
 	sqlStatement := `
 		UPDATE hosts SET
 			detail_updated_at = ?,
@@ -90,10 +121,22 @@ func (d *Datastore) SaveHost(host *fleet.Host) error {
 			team_id = ?,
 			primary_ip = ?,
 			primary_mac = ?,
-			refetch_requested = ?
+			refetch_detail_updated_at = IF(? AND refetch_requested_at IS NULL, detail_updated_at, refetch_detail_updated_at),
+			refetch_requested_at = IF(?, COALESCE(refetch_requested_at, NOW()), NULL),
+			refetch_requested = ?,
+			timezone = ?,
+			kernel_version = ?,
+			disk_encryption_enabled = ?,
+			orbit_version = ?,
+			cloud_provider = ?,
+			cloud_instance_id = ?,
+			mdm_enrolled = ?,
+			mdm_server_url = ?,
+			battery_cycle_count = ?,
+			battery_health = ?
 		WHERE id = ?
 	`
-	_, err := d.db.Exec(sqlStatement,
+	_, err = d.db.Exec(sqlStatement,
 		host.DetailUpdatedAt,
 		host.LabelUpdatedAt,
 		host.NodeKey,
@@ -125,12 +168,28 @@ func (d *Datastore) SaveHost(host *fleet.Host) error {
 		host.PrimaryIP,
 		host.PrimaryMac,
 		host.RefetchRequested,
+		host.RefetchRequested,
+		host.RefetchRequested,
+		host.Timezone,
+		host.KernelVersion,
+		host.DiskEncryptionEnabled,
+		host.OrbitVersion,
+		host.CloudProvider,
+		host.CloudInstanceID,
+		host.MDMEnrolled,
+		host.MDMServerURL,
+		host.BatteryCycleCount,
+		host.BatteryHealth,
 		host.ID,
 	)
 	if err != nil {
 		return errors.Wrapf(err, "save host with id %d", host.ID)
 	}
 
+	if err := d.recordHostFieldChanges(before, host, d.trackedHostFields); err != nil {
+		return err
+	}
+
 	// Save host pack stats only if it is non-nil. Empty stats should be
 	// represented by an empty slice.
 	if host.PackStats != nil {
@@ -139,12 +198,30 @@ func (d *Datastore) SaveHost(host *fleet.Host) error {
 		}
 	}
 
+	if len(host.DetailQueriesSucceeded) > 0 {
+		if err := d.saveHostDetailQueryStatus(host); err != nil {
+			return err
+		}
+	}
+
 	if host.HostSoftware.Modified {
 		if err := d.SaveHostSoftware(host); err != nil {
 			return errors.Wrap(err, "failed to save host software")
 		}
 	}
 
+	if host.HostDisks.Modified {
+		if err := d.SaveHostDisks(host); err != nil {
+			return errors.Wrap(err, "failed to save host disks")
+		}
+	}
+
+	if d.hostResourceSampleInterval > 0 && host.Memory > 0 {
+		if err := d.sampleHostResources(host); err != nil {
+			return errors.Wrap(err, "failed to sample host resources")
+		}
+	}
+
 	if host.Modified {
 		if err := d.SaveHostAdditional(host); err != nil {
 			return errors.Wrap(err, "failed to save host additional")
@@ -160,6 +237,216 @@ func (d *Datastore) SaveHost(host *fleet.Host) error {
 	return nil
 }
 
+// hostSaveFields are the columns that can be updated with SaveHostFields,
+// with the value of each column for the host.
+var hostSaveFields = map[string]func(h *fleet.Host) interface{}{
+	"detail_updated_at":       func(h *fleet.Host) interface{} { return h.DetailUpdatedAt },
+	"label_updated_at":        func(h *fleet.Host) interface{} { return h.LabelUpdatedAt },
+	"node_key":                func(h *fleet.Host) interface{} { return h.NodeKey },
+	"hostname":                func(h *fleet.Host) interface{} { return h.Hostname },
+	"uuid":                    func(h *fleet.Host) interface{} { return h.UUID },
+	"platform":                func(h *fleet.Host) interface{} { return h.Platform },
+	"osquery_version":         func(h *fleet.Host) interface{} { return h.OsqueryVersion },
+	"os_version":              func(h *fleet.Host) interface{} { return h.OSVersion },
+	"uptime":                  func(h *fleet.Host) interface{} { return h.Uptime },
+	"memory":                  func(h *fleet.Host) interface{} { return h.Memory },
+	"cpu_type":                func(h *fleet.Host) interface{} { return h.CPUType },
+	"cpu_subtype":             func(h *fleet.Host) interface{} { return h.CPUSubtype },
+	"cpu_brand":               func(h *fleet.Host) interface{} { return h.CPUBrand },
+	"cpu_physical_cores":      func(h *fleet.Host) interface{} { return h.CPUPhysicalCores },
+	"hardware_vendor":         func(h *fleet.Host) interface{} { return h.HardwareVendor },
+	"hardware_model":          func(h *fleet.Host) interface{} { return h.HardwareModel },
+	"hardware_version":        func(h *fleet.Host) interface{} { return h.HardwareVersion },
+	"hardware_serial":         func(h *fleet.Host) interface{} { return h.HardwareSerial },
+	"computer_name":           func(h *fleet.Host) interface{} { return h.ComputerName },
+	"build":                   func(h *fleet.Host) interface{} { return h.Build },
+	"kernel_version":          func(h *fleet.Host) interface{} { return h.KernelVersion },
+	"platform_like":           func(h *fleet.Host) interface{} { return h.PlatformLike },
+	"code_name":               func(h *fleet.Host) interface{} { return h.CodeName },
+	"cpu_logical_cores":       func(h *fleet.Host) interface{} { return h.CPULogicalCores },
+	"seen_time":               func(h *fleet.Host) interface{} { return h.SeenTime },
+	"distributed_interval":    func(h *fleet.Host) interface{} { return h.DistributedInterval },
+	"config_tls_refresh":      func(h *fleet.Host) interface{} { return h.ConfigTLSRefresh },
+	"logger_tls_period":       func(h *fleet.Host) interface{} { return h.LoggerTLSPeriod },
+	"team_id":                 func(h *fleet.Host) interface{} { return h.TeamID },
+	"primary_ip":              func(h *fleet.Host) interface{} { return h.PrimaryIP },
+	"primary_mac":             func(h *fleet.Host) interface{} { return h.PrimaryMac },
+	"timezone":                func(h *fleet.Host) interface{} { return h.Timezone },
+	"disk_encryption_enabled": func(h *fleet.Host) interface{} { return h.DiskEncryptionEnabled },
+	"orbit_version":           func(h *fleet.Host) interface{} { return h.OrbitVersion },
+	"cloud_provider":          func(h *fleet.Host) interface{} { return h.CloudProvider },
+	"cloud_instance_id":       func(h *fleet.Host) interface{} { return h.CloudInstanceID },
+	"mdm_enrolled":            func(h *fleet.Host) interface{} { return h.MDMEnrolled },
+	"mdm_server_url":          func(h *fleet.Host) interface{} { return h.MDMServerURL },
+	"battery_cycle_count":     func(h *fleet.Host) interface{} { return h.BatteryCycleCount },
+	"battery_health":          func(h *fleet.Host) interface{} { return h.BatteryHealth },
+}
+
+func (d *Datastore) SaveHostFields(host *fleet.Host, fields []string) error {
+	if len(fields) == 0 {
+		return errors.New("no host fields to save")
+	}
+
+	var sets []string
+	var args []interface{}
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		value, ok := hostSaveFields[field]
+		if !ok {
+			return errors.Errorf("invalid host field %q", field)
+		}
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+		sets = append(sets, field+" = ?")
+		args = append(args, value(host))
+	}
+	args = append(args, host.ID)
+
+	var tracked []string
+	for _, field := range d.trackedHostFields {
+		if seen[field] {
+			tracked = append(tracked, field)
+		}
+	}
+	before, err := d.trackedHostFieldValues(host.ID, tracked)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement := fmt.Sprintf(`UPDATE hosts SET %s WHERE id = ?`, strings.Join(sets, ", "))
+	if _, err := d.db.Exec(sqlStatement, args...); err != nil {
+		return errors.Wrapf(err, "save host fields with id %d", host.ID)
+	}
+	return d.recordHostFieldChanges(before, host, tracked)
+}
+
+// trackedHostFieldValues returns the host as stored before saving, with only
+// the tracked fields loaded. Nil is returned if no fields are tracked or the
+// host doesn't exist.
+func (d *Datastore) trackedHostFieldValues(hostID uint, fields []string) (*fleet.Host, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	// The fields are validated by TrackHostFields
+	stmt := fmt.Sprintf(`SELECT id, %s FROM hosts WHERE id = ?`, strings.Join(fields, ", "))
+	var host fleet.Host
+	if err := d.db.Get(&host, stmt, hostID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get tracked host fields")
+	}
+	return &host, nil
+}
+
+// recordHostFieldChanges records the tracked fields that differ between the
+// host before saving and the saved host.
+func (d *Datastore) recordHostFieldChanges(before, after *fleet.Host, fields []string) error {
+	if before == nil {
+		return nil
+	}
+	now := d.clock.Now().UTC().Truncate(time.Second)
+	var placeholders []string
+	var args []interface{}
+	for _, field := range fields {
+		value := hostSaveFields[field]
+		oldValue, newValue := formatHostFieldValue(value(before)), formatHostFieldValue(value(after))
+		if oldValue == newValue {
+			continue
+		}
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?)")
+		args = append(args, after.ID, field, oldValue, newValue, now)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	stmt := `INSERT INTO host_field_changes (host_id, field, old_value, new_value, created_at) VALUES ` + strings.Join(placeholders, ", ")
+	if _, err := d.db.Exec(stmt, args...); err != nil {
+		return errors.Wrap(err, "record host field changes")
+	}
+	return nil
+}
+
+// formatHostFieldValue formats the value of a host field for
+// HostFieldChange, with nil pointers formatted as an empty string.
+func formatHostFieldValue(value interface{}) string {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		value = v.Elem().Interface()
+	}
+	if t, ok := value.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+func (d *Datastore) ListHostFieldChanges(hostID uint, fields []string) ([]*fleet.HostFieldChange, error) {
+	stmt := `
+		SELECT id, host_id, field, old_value, new_value, created_at
+		FROM host_field_changes
+		WHERE host_id = ?`
+	args := []interface{}{hostID}
+	if len(fields) > 0 {
+		stmt += ` AND field IN (?)`
+		args = append(args, fields)
+	}
+	stmt += ` ORDER BY created_at, id`
+	stmt, args, err := sqlx.In(stmt, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "build list host field changes query")
+	}
+
+	changes := []*fleet.HostFieldChange{}
+	if err := d.db.Select(&changes, stmt, args...); err != nil {
+		return nil, errors.Wrap(err, "list host field changes")
+	}
+	return changes, nil
+}
+
+// saveHostDetailQueryStatus records the last success of the detail queries
+// that succeeded in the latest detail update of the host.
+func (d *Datastore) saveHostDetailQueryStatus(host *fleet.Host) error {
+	var args []interface{}
+	for _, name := range host.DetailQueriesSucceeded {
+		args = append(args, host.ID, name, host.DetailUpdatedAt)
+	}
+	values := strings.TrimSuffix(strings.Repeat("(?,?,?),", len(host.DetailQueriesSucceeded)), ",")
+	sql := fmt.Sprintf(`
+		INSERT INTO host_detail_query_status (host_id, name, last_success_at)
+		VALUES %s
+		ON DUPLICATE KEY UPDATE last_success_at = VALUES(last_success_at)
+	`, values)
+	if _, err := d.db.Exec(sql, args...); err != nil {
+		return errors.Wrap(err, "save host detail query status")
+	}
+	return nil
+}
+
+func (d *Datastore) HostDetailQueryStatus(hostID uint) (map[string]time.Time, error) {
+	var rows []struct {
+		Name          string    `db:"name"`
+		LastSuccessAt time.Time `db:"last_success_at"`
+	}
+	sql := `
+		SELECT name, last_success_at FROM host_detail_query_status
+		WHERE host_id = ?
+	`
+	if err := d.db.Select(&rows, sql, hostID); err != nil {
+		return nil, errors.Wrap(err, "get host detail query status")
+	}
+	status := make(map[string]time.Time, len(rows))
+	for _, r := range rows {
+		status[r.Name] = r.LastSuccessAt
+	}
+	return status, nil
+}
+
 func (d *Datastore) saveHostPackStats(host *fleet.Host) error {
 	if err := d.withRetryTxx(func(tx *sqlx.Tx) error {
 		sql := `
@@ -226,8 +513,9 @@ func (d *Datastore) saveHostPackStats(host *fleet.Host) error {
 	return nil
 }
 
-func (d *Datastore) loadHostPackStats(host *fleet.Host) error {
-	sql := `
+// hostScheduledQueryStatsSQL selects the scheduled query stats reported by
+// hosts, with the details of the scheduled query and its pack.
+const hostScheduledQueryStatsSQL = `
 SELECT
 	sqs.scheduled_query_id,
 	sqs.average_memory,
@@ -249,8 +537,10 @@ FROM scheduled_query_stats sqs
 	JOIN scheduled_queries sq ON (sqs.scheduled_query_id = sq.id)
 	JOIN packs p ON (sq.pack_id = p.id)
 	JOIN queries q ON (sq.query_name = q.name)
-WHERE host_id = ?
 `
+
+func (d *Datastore) loadHostPackStats(host *fleet.Host) error {
+	sql := hostScheduledQueryStatsSQL + `WHERE host_id = ?`
 	var stats []fleet.ScheduledQueryStats
 	if err := d.db.Select(&stats, sql, host.ID); err != nil {
 		return errors.Wrap(err, "load pack stats")
@@ -272,25 +562,259 @@ WHERE host_id = ?
 	return nil
 }
 
-func (d *Datastore) loadHostUsers(host *fleet.Host) error {
+func (d *Datastore) HostScheduledQueryStats(hostID uint, queryName string) (fleet.ScheduledQueryStats, error) {
+	// The query may be scheduled in several packs, the latest execution is
+	// the most relevant.
+	stmt := hostScheduledQueryStatsSQL + `
+		WHERE host_id = ? AND sq.name = ?
+		ORDER BY sqs.last_executed DESC, sq.id
+		LIMIT 1
+	`
+	var stats fleet.ScheduledQueryStats
+	if err := d.db.Get(&stats, stmt, hostID, queryName); err != nil {
+		if err == sql.ErrNoRows {
+			return fleet.ScheduledQueryStats{ScheduledQueryName: queryName}, nil
+		}
+		return fleet.ScheduledQueryStats{}, errors.Wrap(err, "get host scheduled query stats")
+	}
+	stats.Found = true
+	return stats, nil
+}
+
+func (d *Datastore) LoadHostUsers(host *fleet.Host) error {
 	sql := `SELECT id, username, groupname, uid, user_type FROM host_users WHERE host_id = ? and removed_at IS NULL`
 	if err := d.db.Select(&host.Users, sql, host.ID); err != nil {
-		return errors.Wrap(err, "load pack stats")
+		return errors.Wrap(err, "load host users")
 	}
 	return nil
 }
 
+func (d *Datastore) ListHostUsers(hostID uint, opt fleet.ListOptions) ([]fleet.HostUser, uint, error) {
+	var count uint
+	if err := d.db.Get(
+		&count,
+		`SELECT COUNT(*) FROM host_users WHERE host_id = ? AND removed_at IS NULL`,
+		hostID,
+	); err != nil {
+		return nil, 0, errors.Wrap(err, "count host users")
+	}
+
+	// Order by uid unless requested otherwise so that pages are stable.
+	if opt.OrderKey == "" {
+		opt.OrderKey = "uid"
+	}
+	sql := `SELECT id, username, groupname, uid, user_type FROM host_users WHERE host_id = ? AND removed_at IS NULL`
+	sql = appendListOptionsToSQL(sql, opt)
+	users := []fleet.HostUser{}
+	if err := d.db.Select(&users, sql, hostID); err != nil {
+		return nil, 0, errors.Wrap(err, "list host users")
+	}
+	return users, count, nil
+}
+
 func (d *Datastore) DeleteHost(hid uint) error {
-	err := d.deleteEntity("hosts", hid)
+	err := d.withRetryTxx(func(tx *sqlx.Tx) error {
+		return deleteHostDB(tx, hid)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "deleting host with id %d", hid)
+	}
+	return nil
+}
+
+func deleteHostDB(tx *sqlx.Tx, hid uint) error {
+	// host_software has no foreign key to hosts, so it is not cleared by the
+	// cascade.
+	if _, err := tx.Exec(`DELETE FROM host_software WHERE host_id = ?`, hid); err != nil {
+		return errors.Wrap(err, "delete host software")
+	}
+	result, err := tx.Exec(`DELETE FROM hosts WHERE id = ?`, hid)
+	if err != nil {
+		return errors.Wrap(err, "delete hosts")
+	}
+	if rows, _ := result.RowsAffected(); rows != 1 {
+		return notFound("hosts").WithID(hid)
+	}
+	return nil
+}
+
+func (d *Datastore) DeleteHostWithReason(hid uint, reason string) error {
+	err := d.withRetryTxx(func(tx *sqlx.Tx) error {
+		var deletion fleet.HostDeletion
+		err := tx.Get(&deletion, `
+			SELECT id AS host_id, hostname, uuid, osquery_host_id, hardware_serial
+			FROM hosts WHERE id = ? FOR UPDATE`, hid)
+		if err == sql.ErrNoRows {
+			return notFound("hosts").WithID(hid)
+		} else if err != nil {
+			return errors.Wrap(err, "select host")
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO host_deletions (host_id, hostname, uuid, osquery_host_id, hardware_serial, reason)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			deletion.HostID, deletion.Hostname, deletion.UUID, deletion.OsqueryHostID, deletion.HardwareSerial, reason,
+		)
+		if err != nil {
+			return errors.Wrap(err, "insert host deletion")
+		}
+
+		return deleteHostDB(tx, hid)
+	})
 	if err != nil {
 		return errors.Wrapf(err, "deleting host with id %d", hid)
 	}
 	return nil
 }
 
+func (d *Datastore) ListHostDeletions(since time.Time) ([]*fleet.HostDeletion, error) {
+	deletions := []*fleet.HostDeletion{}
+	err := d.db.Select(&deletions, `
+		SELECT id, host_id, hostname, uuid, osquery_host_id, hardware_serial, reason, deleted_at
+		FROM host_deletions
+		WHERE deleted_at >= ?
+		ORDER BY deleted_at, id`, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "list host deletions")
+	}
+	return deletions, nil
+}
+
+// decommissionedNodeKeyPrefix is prepended to the node key of a host when it
+// is decommissioned. The original key no longer authenticates the host, but
+// AuthenticateHost can still tell that it belonged to a decommissioned host.
+const decommissionedNodeKeyPrefix = "decommissioned:"
+
+func (d *Datastore) DecommissionHost(hid uint) error {
+	res, err := d.db.Exec(
+		`UPDATE hosts SET decommissioned_at = ?, node_key = CONCAT(?, node_key) WHERE id = ? AND decommissioned_at IS NULL`,
+		d.clock.Now().UTC().Truncate(time.Second), decommissionedNodeKeyPrefix, hid,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "decommission host %d", hid)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		// The host is either missing or already decommissioned
+		var count int
+		if err := d.db.Get(&count, `SELECT COUNT(*) FROM hosts WHERE id = ?`, hid); err != nil {
+			return errors.Wrapf(err, "check host %d", hid)
+		}
+		if count == 0 {
+			return notFound("Host").WithID(hid)
+		}
+	}
+	return nil
+}
+
+func (d *Datastore) ScheduleHostRetirement(hostID uint, at time.Time) error {
+	res, err := d.db.Exec(`UPDATE hosts SET retire_at = ? WHERE id = ?`, at, hostID)
+	if err != nil {
+		return errors.Wrapf(err, "schedule retirement of host %d", hostID)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return notFound("Host").WithID(hostID)
+	}
+
+	if now := d.clock.Now(); !at.After(now) {
+		if _, err := d.ProcessHostRetirements(now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Datastore) ProcessHostRetirements(now time.Time) (int, error) {
+	res, err := d.db.Exec(`
+		UPDATE hosts SET decommissioned_at = ?, node_key = CONCAT(?, node_key)
+		WHERE retire_at <= ? AND decommissioned_at IS NULL`,
+		now.UTC().Truncate(time.Second), decommissionedNodeKeyPrefix, now,
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "process host retirements")
+	}
+	retired, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "rows affected processing host retirements")
+	}
+	return int(retired), nil
+}
+
+// rotatedNodeKeySize is the number of random bytes of rotated node keys, the
+// default of the osquery.node_key_size configuration.
+const rotatedNodeKeySize = 24
+
+func (d *Datastore) RotateHostNodeKey(hostID uint) (string, error) {
+	nodeKey, err := server.GenerateRandomText(rotatedNodeKeySize)
+	if err != nil {
+		return "", errors.Wrap(err, "generate node key")
+	}
+
+	res, err := d.db.Exec(`UPDATE hosts SET node_key = ? WHERE id = ?`, nodeKey, hostID)
+	if err != nil {
+		return "", errors.Wrapf(err, "rotate node key of host %d", hostID)
+	}
+	// The new key always differs, so no rows are affected only if the host
+	// doesn't exist.
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return "", notFound("Host").WithID(hostID)
+	}
+	return nodeKey, nil
+}
+
+// hostRelatedTables are the tables holding rows that reference a host by a
+// host_id column.
+var hostRelatedTables = []string{
+	"carve_metadata",
+	"host_additional",
+	"host_detail_query_status",
+	"host_disks",
+	"host_enroll_secrets",
+	"host_field_changes",
+	"host_resource_samples",
+	"host_software",
+	"host_software_updates",
+	"host_tags",
+	"host_team_changes",
+	"host_users",
+	"label_membership",
+	"network_interfaces",
+	"policy_membership",
+	"scheduled_query_stats",
+}
+
+func (d *Datastore) HostOrphanCheck(hostID uint) (map[string]int, error) {
+	counts := make(map[string]int, len(hostRelatedTables))
+	for _, table := range hostRelatedTables {
+		var count int
+		sql := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE host_id = ?`, table)
+		if err := d.db.Get(&count, sql, hostID); err != nil {
+			return nil, errors.Wrapf(err, "count orphaned rows in %s", table)
+		}
+		counts[table] = count
+	}
+	return counts, nil
+}
+
+func (d *Datastore) HostExists(id uint) (bool, error) {
+	var exists bool
+	err := d.db.Get(&exists, `SELECT EXISTS (SELECT 1 FROM hosts WHERE id = ? AND decommissioned_at IS NULL)`, id)
+	if err != nil {
+		return false, errors.Wrap(err, "check host exists")
+	}
+	return exists, nil
+}
+
+// hostPolicyCountsColumns selects the counts of policies passed and failed by
+// the hosts h.
+const hostPolicyCountsColumns = `
+		(SELECT COUNT(*) FROM policy_membership pm WHERE pm.host_id = h.id AND pm.passes = 1) AS policy_pass_count,
+		(SELECT COUNT(*) FROM policy_membership pm WHERE pm.host_id = h.id AND pm.passes = 0) AS policy_fail_count
+`
+
 func (d *Datastore) Host(id uint) (*fleet.Host, error) {
 	sqlStatement := `
-		SELECT h.*, t.name AS team_name, (SELECT additional FROM host_additional WHERE host_id = h.id) AS additional
+		SELECT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours, (SELECT additional FROM host_additional WHERE host_id = h.id) AS additional,
+		` + hostPolicyCountsColumns + `
 		FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
 		WHERE h.id = ?
 		LIMIT 1
@@ -303,13 +827,29 @@ func (d *Datastore) Host(id uint) (*fleet.Host, error) {
 	if err := d.loadHostPackStats(host); err != nil {
 		return nil, err
 	}
-	if err := d.loadHostUsers(host); err != nil {
-		return nil, err
-	}
 
 	return host, nil
 }
 
+func (d *Datastore) HostBySerial(serial string) (*fleet.Host, error) {
+	if serial == "" {
+		return nil, notFound("Host").WithMessage("with empty hardware serial")
+	}
+
+	var ids []uint
+	if err := d.db.Select(&ids, `SELECT id FROM hosts WHERE hardware_serial = ? ORDER BY id`, serial); err != nil {
+		return nil, errors.Wrap(err, "get host ids by serial")
+	}
+	switch len(ids) {
+	case 0:
+		return nil, notFound("Host").WithMessage(fmt.Sprintf("with hardware serial %s", serial))
+	case 1:
+		return d.Host(ids[0])
+	default:
+		return nil, &fleet.HostSerialAmbiguousError{Serial: serial, HostIDs: ids}
+	}
+}
+
 func (d *Datastore) amountEnrolledHosts() (int, error) {
 	var amount int
 	err := d.db.Get(&amount, `SELECT count(*) FROM hosts`)
@@ -319,55 +859,66 @@ func (d *Datastore) amountEnrolledHosts() (int, error) {
 	return amount, nil
 }
 
-func (d *Datastore) ListHosts(filter fleet.TeamFilter, opt fleet.HostListOptions) ([]*fleet.Host, error) {
-	sql := `SELECT
-		h.*,
-		t.name AS team_name
-		`
-
-	var params []interface{}
+// filterHostsByLabels adds the condition selecting the hosts that are members
+// of any or all of opt.LabelIDs.
+func filterHostsByLabels(sql string, opt fleet.HostListOptions, params []interface{}) (string, []interface{}, error) {
+	if len(opt.LabelIDs) == 0 {
+		return sql, params, nil
+	}
+	if !opt.LabelMatchMode.Valid() {
+		return "", nil, errors.Errorf("invalid label match mode %q", opt.LabelMatchMode)
+	}
 
-	// Only include "additional" if filter provided.
-	if len(opt.AdditionalFilters) == 1 && opt.AdditionalFilters[0] == "*" {
-		// All info requested.
-		sql += `
-		, (SELECT additional FROM host_additional WHERE host_id = h.id) AS additional
-		`
-	} else if len(opt.AdditionalFilters) > 0 {
-		// Filter specific columns.
-		sql += `, (SELECT JSON_OBJECT(
-			`
-		for _, field := range opt.AdditionalFilters {
-			sql += `?, JSON_EXTRACT(additional, ?), `
-			params = append(params, field, fmt.Sprintf(`$."%s"`, field))
+	unique := make(map[uint]bool, len(opt.LabelIDs))
+	var labelIDs []interface{}
+	for _, id := range opt.LabelIDs {
+		if !unique[id] {
+			unique[id] = true
+			labelIDs = append(labelIDs, id)
 		}
-		sql = sql[:len(sql)-2]
-		sql += `
-		    ) FROM host_additional WHERE host_id = h.id) AS additional
-		    `
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(labelIDs)), ",")
+
+	if opt.LabelMatchMode == fleet.LabelMatchAll {
+		sql += fmt.Sprintf(` AND h.id IN (
+			SELECT host_id FROM label_membership WHERE label_id IN (%s)
+			GROUP BY host_id HAVING COUNT(*) = ?
+		)`, placeholders)
+		params = append(params, labelIDs...)
+		return sql, append(params, len(labelIDs)), nil
 	}
 
-	sql += fmt.Sprintf(`FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
-		WHERE TRUE AND %s
-    `, d.whereFilterHostsByTeams(filter, "h"),
-	)
-	switch opt.StatusFilter {
-	case "new":
-		sql += "AND DATE_ADD(h.created_at, INTERVAL 1 DAY) >= ?"
-		params = append(params, time.Now())
-	case "online":
-		sql += fmt.Sprintf("AND DATE_ADD(h.seen_time, INTERVAL LEAST(h.distributed_interval, h.config_tls_refresh) + %d SECOND) > ?", fleet.OnlineIntervalBuffer)
-		params = append(params, time.Now())
-	case "offline":
-		sql += fmt.Sprintf("AND DATE_ADD(h.seen_time, INTERVAL LEAST(h.distributed_interval, h.config_tls_refresh) + %d SECOND) <= ? AND DATE_ADD(h.seen_time, INTERVAL 30 DAY) >= ?", fleet.OnlineIntervalBuffer)
-		params = append(params, time.Now(), time.Now())
-	case "mia":
-		sql += "AND DATE_ADD(h.seen_time, INTERVAL 30 DAY) <= ?"
-		params = append(params, time.Now())
+	sql += fmt.Sprintf(` AND h.id IN (SELECT host_id FROM label_membership WHERE label_id IN (%s))`, placeholders)
+	return sql, append(params, labelIDs...), nil
+}
+
+// hostModifiedAt is the latest time a host's details, labels or check-ins
+// were updated.
+const hostModifiedAt = "GREATEST(h.detail_updated_at, h.label_updated_at, h.seen_time)"
+
+// hostListColumns returns the host columns to select for the list options.
+func hostListColumns(opt fleet.HostListOptions) (string, error) {
+	if len(opt.Fields) == 0 {
+		return "h.*", nil
+	}
+	if err := opt.ValidateFields(); err != nil {
+		return "", err
 	}
 
-	sql, params = searchLike(sql, params, opt.MatchQuery, hostSearchColumns...)
+	columns := []string{"h.id"}
+	for _, field := range opt.Fields {
+		if field != "id" {
+			columns = append(columns, "h."+field)
+		}
+	}
+	return strings.Join(columns, ", "), nil
+}
 
+func (d *Datastore) ListHosts(filter fleet.TeamFilter, opt fleet.HostListOptions) ([]*fleet.Host, error) {
+	sql, params, err := d.listHostsSQL(filter, opt)
+	if err != nil {
+		return nil, err
+	}
 	sql = appendListOptionsToSQL(sql, opt.ListOptions)
 
 	hosts := []*fleet.Host{}
@@ -378,71 +929,848 @@ func (d *Datastore) ListHosts(filter fleet.TeamFilter, opt fleet.HostListOptions
 	return hosts, nil
 }
 
-func (d *Datastore) CleanupIncomingHosts(now time.Time) error {
-	sqlStatement := `
-		DELETE FROM hosts
-		WHERE hostname = '' AND osquery_version = ''
-		AND created_at < (? - INTERVAL 5 MINUTE)
-	`
-	if _, err := d.db.Exec(sqlStatement, now); err != nil {
-		return errors.Wrap(err, "cleanup incoming hosts")
+func (d *Datastore) IterHosts(filter fleet.TeamFilter, opt fleet.HostListOptions, fn func(*fleet.Host) error) error {
+	sql, params, err := d.listHostsSQL(filter, opt)
+	if err != nil {
+		return err
+	}
+	// Unlike ListHosts, there is no default page size.
+	if opt.PerPage > 0 {
+		sql = appendListOptionsToSQL(sql, opt.ListOptions)
+	} else {
+		sql = appendOrderToSQL(sql, opt.ListOptions)
 	}
 
-	return nil
-}
-
-func (d *Datastore) GenerateHostStatusStatistics(filter fleet.TeamFilter, now time.Time) (online, offline, mia, new uint, e error) {
-	// The logic in this function should remain synchronized with
-	// host.Status and CountHostsInTargets
+	// The rows are streamed from the server as they are scanned.
+	rows, err := d.db.Queryx(sql, params...)
+	if err != nil {
+		return errors.Wrap(err, "iterate hosts")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var host fleet.Host
+		if err := rows.StructScan(&host); err != nil {
+			return errors.Wrap(err, "scan host")
+		}
+		if err := fn(&host); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "iterate hosts")
+	}
+	return nil
+}
+
+func (d *Datastore) ListHostsWithPoorBatteryHealth(filter fleet.TeamFilter) ([]*fleet.Host, error) {
+	hosts := []*fleet.Host{}
+	opt := fleet.HostListOptions{
+		ListOptions:       fleet.ListOptions{OrderKey: "id"},
+		PoorBatteryHealth: true,
+	}
+	err := d.IterHosts(filter, opt, func(h *fleet.Host) error {
+		hosts = append(hosts, h)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list hosts with poor battery health")
+	}
+	return hosts, nil
+}
+
+func (d *Datastore) ListEnrolledNeverSeenHosts(filter fleet.TeamFilter) ([]*fleet.Host, error) {
+	// Enrolling sets both seen_time and last_enrolled_at to the current
+	// time, which may be rounded to different seconds.
+	sql := fmt.Sprintf(`
+		SELECT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
+		FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
+		WHERE h.node_key IS NOT NULL AND h.node_key != ''
+		AND NOT (h.hostname = '' AND h.osquery_version = '')
+		AND h.seen_time <= DATE_ADD(h.last_enrolled_at, INTERVAL 1 SECOND)
+		AND %s
+		ORDER BY h.id
+	`, d.whereFilterHostsByTeams(filter, "h"),
+	)
+	hosts := []*fleet.Host{}
+	if err := d.db.Select(&hosts, sql); err != nil {
+		return nil, errors.Wrap(err, "list enrolled never seen hosts")
+	}
+	return hosts, nil
+}
+
+func (d *Datastore) ListHostsWithPendingActions(filter fleet.TeamFilter) ([]*fleet.Host, error) {
+	// Keep the conditions consistent with Host.PendingActions
+	sql := fmt.Sprintf(`
+		SELECT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
+		FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
+		WHERE h.decommissioned_at IS NULL
+		AND (h.refetch_requested OR h.retire_at IS NOT NULL)
+		AND %s
+		ORDER BY h.id
+	`, d.whereFilterHostsByTeams(filter, "h"),
+	)
+	hosts := []*fleet.Host{}
+	if err := d.db.Select(&hosts, sql); err != nil {
+		return nil, errors.Wrap(err, "list hosts with pending actions")
+	}
+	return hosts, nil
+}
+
+// listHostsSQL returns the unpaginated query selecting the hosts of
+// ListHosts, with its parameters.
+func (d *Datastore) listHostsSQL(filter fleet.TeamFilter, opt fleet.HostListOptions) (string, []interface{}, error) {
+	if err := opt.ValidateOrderKey(); err != nil {
+		return "", nil, err
+	}
+	columns, err := hostListColumns(opt)
+	if err != nil {
+		return "", nil, err
+	}
+	sql := fmt.Sprintf(`SELECT
+		%s,
+		t.name AS team_name,
+		t.new_host_hours AS team_new_host_hours,
+		%s
+		`, columns, hostPolicyCountsColumns)
+
+	var params []interface{}
+
+	// Only include "additional" if filter provided.
+	if len(opt.AdditionalFilters) == 1 && opt.AdditionalFilters[0] == "*" {
+		// All info requested.
+		sql += `
+		, (SELECT additional FROM host_additional WHERE host_id = h.id) AS additional
+		`
+	} else if len(opt.AdditionalFilters) > 0 {
+		// Filter specific columns.
+		sql += `, (SELECT JSON_OBJECT(
+			`
+		for _, field := range opt.AdditionalFilters {
+			sql += `?, JSON_EXTRACT(additional, ?), `
+			params = append(params, field, fmt.Sprintf(`$."%s"`, field))
+		}
+		sql = sql[:len(sql)-2]
+		sql += `
+		    ) FROM host_additional WHERE host_id = h.id) AS additional
+		    `
+	}
+
+	sql += fmt.Sprintf(`FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
+		WHERE TRUE AND %s
+    `, d.whereFilterHostsByTeams(filter, "h"),
+	)
+	if !opt.IncludeDecommissioned {
+		sql += " AND h.decommissioned_at IS NULL"
+	}
+	switch opt.StatusFilter {
+	case "new":
+		sql += fmt.Sprintf("AND DATE_ADD(h.created_at, INTERVAL COALESCE(t.new_host_hours, %d) HOUR) >= ?", int(fleet.NewDuration.Hours()))
+		params = append(params, time.Now())
+	case "online":
+		sql += fmt.Sprintf("AND DATE_ADD(h.seen_time, INTERVAL LEAST(h.distributed_interval, h.config_tls_refresh) + %d SECOND) > ?", fleet.OnlineIntervalBuffer)
+		params = append(params, time.Now())
+	case "offline":
+		sql += fmt.Sprintf("AND DATE_ADD(h.seen_time, INTERVAL LEAST(h.distributed_interval, h.config_tls_refresh) + %d SECOND) <= ? AND DATE_ADD(h.seen_time, INTERVAL 30 DAY) >= ?", fleet.OnlineIntervalBuffer)
+		params = append(params, time.Now(), time.Now())
+	case "mia":
+		sql += "AND DATE_ADD(h.seen_time, INTERVAL 30 DAY) <= ?"
+		params = append(params, time.Now())
+	}
+
+	sql, params = filterHostsByUptime(sql, opt, params)
+
+	if opt.NoTeam {
+		sql += " AND h.team_id IS NULL"
+	}
+
+	sql, params = filterHostsByOsqueryVersion(sql, opt, params)
+
+	if opt.EnrolledFromIP != "" {
+		sql += " AND h.enrolled_from_ip = ?"
+		params = append(params, opt.EnrolledFromIP)
+	}
+
+	if opt.OwnerFilter != "" {
+		sql += " AND h.assigned_owner = ?"
+		params = append(params, opt.OwnerFilter)
+	}
+
+	if opt.HasUser != "" {
+		sql += ` AND EXISTS (
+			SELECT 1 FROM host_users hu
+			WHERE hu.host_id = h.id AND hu.removed_at IS NULL AND LOWER(hu.username) = LOWER(?)
+		)`
+		params = append(params, opt.HasUser)
+	}
+
+	if opt.HasActiveCarves {
+		// Same condition as the active carves limited by NewCarve
+		sql += ` AND EXISTS (
+			SELECT 1 FROM carve_metadata cm
+			WHERE cm.host_id = h.id AND NOT cm.expired AND cm.max_block < cm.block_count - 1
+		)`
+	}
+
+	if opt.HasFailingPolicies {
+		sql += ` AND EXISTS (
+			SELECT 1 FROM policy_membership pm
+			WHERE pm.host_id = h.id AND pm.passes = 0
+		)`
+	}
+
+	if opt.TimezoneFilter != "" {
+		sql += " AND h.timezone = ?"
+		params = append(params, opt.TimezoneFilter)
+	}
+
+	if opt.KernelVersionFilter != "" {
+		sql += " AND h.kernel_version = ?"
+		params = append(params, opt.KernelVersionFilter)
+	}
+
+	if opt.DiskEncryptionFilter != nil {
+		sql += " AND h.disk_encryption_enabled = ?"
+		params = append(params, *opt.DiskEncryptionFilter)
+	}
+
+	if opt.OrbitVersionFilter != "" {
+		sql += " AND h.orbit_version = ?"
+		params = append(params, opt.OrbitVersionFilter)
+	}
+
+	if opt.CloudProviderFilter != "" {
+		sql += " AND h.cloud_provider = ?"
+		params = append(params, opt.CloudProviderFilter)
+	}
+
+	if opt.CloudInstanceIDFilter != "" {
+		sql += " AND h.cloud_instance_id = ?"
+		params = append(params, opt.CloudInstanceIDFilter)
+	}
+
+	if opt.MDMEnrolledFilter != nil {
+		sql += " AND h.mdm_enrolled = ?"
+		params = append(params, *opt.MDMEnrolledFilter)
+	}
+
+	if opt.MDMServerURLFilter != "" {
+		sql += " AND h.mdm_server_url = ?"
+		params = append(params, opt.MDMServerURLFilter)
+	}
+
+	if opt.BatteryHealthFilter != "" {
+		sql += " AND h.battery_health = ?"
+		params = append(params, opt.BatteryHealthFilter)
+	}
+
+	if opt.PoorBatteryHealth {
+		sql += " AND (h.battery_health != ? OR h.battery_cycle_count >= ?)"
+		params = append(params, fleet.BatteryHealthGood, fleet.BatteryCycleCountLimit)
+	}
+
+	if opt.RefetchRequestedFilter != nil {
+		sql += " AND h.refetch_requested = ?"
+		params = append(params, *opt.RefetchRequestedFilter)
+	}
+
+	sql, params = filterHostsByAdditionalKey(sql, opt, params)
+
+	if opt.LabelUpdatedBefore != nil {
+		sql += " AND h.label_updated_at < ?"
+		params = append(params, *opt.LabelUpdatedBefore)
+	}
+
+	if opt.ModifiedSince != nil {
+		sql += " AND " + hostModifiedAt + " >= ?"
+		params = append(params, *opt.ModifiedSince)
+	}
+
+	sql, params, err = filterHostsByLabels(sql, opt, params)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sql, params = searchLike(sql, params, opt.MatchQuery, hostSearchColumns...)
+
+	if opt.OrderKey == "" {
+		if opt.ModifiedSince != nil {
+			// Stable order for paginating through the changes
+			sql += " ORDER BY " + hostModifiedAt + ", h.id"
+		} else {
+			sql += " ORDER BY h.hostname, h.id"
+		}
+	}
+	return sql, params, nil
+}
+
+// filterHostsByUptime adds the conditions for the MinUptime and MaxUptime
+// options. Hosts that report zero uptime have not provided the value, so they
+// are never matched by an uptime range.
+func filterHostsByUptime(sql string, opt fleet.HostListOptions, params []interface{}) (string, []interface{}) {
+	if opt.MinUptime == 0 && opt.MaxUptime == 0 {
+		return sql, params
+	}
+
+	sql += " AND h.uptime > 0"
+	if opt.MinUptime > 0 {
+		sql += " AND h.uptime >= ?"
+		params = append(params, opt.MinUptime)
+	}
+	if opt.MaxUptime > 0 {
+		sql += " AND h.uptime <= ?"
+		params = append(params, opt.MaxUptime)
+	}
+	return sql, params
+}
+
+// versionTuple returns an expression that compares as (major, minor, patch)
+// of the version in column, following the fallback rules of
+// fleet.OsqueryVersionConstraint: missing components are padded with zeros and
+// CAST takes the leading number of each component.
+func versionTuple(column string) string {
+	return fmt.Sprintf(`(
+	CAST(SUBSTRING_INDEX(CONCAT(%[1]s, '.0.0'), '.', 1) AS UNSIGNED),
+	CAST(SUBSTRING_INDEX(SUBSTRING_INDEX(CONCAT(%[1]s, '.0.0'), '.', 2), '.', -1) AS UNSIGNED),
+	CAST(SUBSTRING_INDEX(SUBSTRING_INDEX(CONCAT(%[1]s, '.0.0'), '.', 3), '.', -1) AS UNSIGNED)
+)`, column)
+}
+
+func filterHostsByOsqueryVersion(sql string, opt fleet.HostListOptions, params []interface{}) (string, []interface{}) {
+	if len(opt.OsqueryVersionConstraints) == 0 && !opt.OsqueryVersionEmpty {
+		return sql, params
+	}
+
+	var conds []string
+	if len(opt.OsqueryVersionConstraints) > 0 {
+		cond := "(h.osquery_version != ''"
+		for _, c := range opt.OsqueryVersionConstraints {
+			// Only known operators are interpolated into the query
+			op := "="
+			switch c.Op {
+			case "<", "<=", ">", ">=", "!=":
+				op = c.Op
+			}
+			cond += fmt.Sprintf(" AND %s %s (?, ?, ?)", versionTuple("h.osquery_version"), op)
+			params = append(params, c.Major, c.Minor, c.Patch)
+		}
+		conds = append(conds, cond+")")
+	}
+	if opt.OsqueryVersionEmpty {
+		conds = append(conds, "h.osquery_version = ''")
+	}
+
+	sql += " AND (" + strings.Join(conds, " OR ") + ")"
+	return sql, params
+}
+
+// filterHostsByAdditionalKey adds the condition for the AdditionalKey and
+// AdditionalKeyMissing options. The key is quoted with JSON_QUOTE so that any
+// key name forms a valid path.
+func filterHostsByAdditionalKey(sql string, opt fleet.HostListOptions, params []interface{}) (string, []interface{}) {
+	if opt.AdditionalKey == "" {
+		return sql, params
+	}
+
+	cond := `EXISTS (
+		SELECT 1 FROM host_additional
+		WHERE host_id = h.id AND JSON_CONTAINS_PATH(additional, 'one', CONCAT('$.', JSON_QUOTE(?)))
+	)`
+	if opt.AdditionalKeyMissing {
+		cond = "NOT " + cond
+	}
+	sql += " AND " + cond
+	params = append(params, opt.AdditionalKey)
+	return sql, params
+}
+
+func (d *Datastore) CleanupIncomingHosts(now time.Time) error {
+	sqlStatement := `
+		DELETE FROM hosts
+		WHERE hostname = '' AND osquery_version = ''
+		AND created_at < (? - INTERVAL 5 MINUTE)
+	`
+	if _, err := d.db.Exec(sqlStatement, now); err != nil {
+		return errors.Wrap(err, "cleanup incoming hosts")
+	}
+
+	return nil
+}
+
+func (d *Datastore) ClearStaleRefetchRequests(olderThan time.Time) (int, error) {
+	sqlStatement := `
+		UPDATE hosts
+		SET refetch_requested = FALSE, refetch_requested_at = NULL
+		WHERE refetch_requested AND refetch_requested_at < ? AND detail_updated_at < ?
+	`
+	result, err := d.db.Exec(sqlStatement, olderThan, olderThan)
+	if err != nil {
+		return 0, errors.Wrap(err, "clear stale refetch requests")
+	}
+
+	cleared, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "rows affected clearing stale refetch requests")
+	}
+	return int(cleared), nil
+}
+
+func (d *Datastore) RefetchStatus(hostID uint) (bool, bool, error) {
+	var status struct {
+		RefetchRequested       bool       `db:"refetch_requested"`
+		DetailUpdatedAt        time.Time  `db:"detail_updated_at"`
+		RefetchDetailUpdatedAt *time.Time `db:"refetch_detail_updated_at"`
+	}
+	err := d.db.Get(&status, `
+		SELECT refetch_requested, detail_updated_at, refetch_detail_updated_at
+		FROM hosts
+		WHERE id = ?`, hostID,
+	)
+	if err == sql.ErrNoRows {
+		return false, false, notFound("Host").WithID(hostID)
+	}
+	if err != nil {
+		return false, false, errors.Wrap(err, "get refetch status")
+	}
+	if status.RefetchDetailUpdatedAt == nil {
+		return false, false, nil
+	}
+
+	completed := status.DetailUpdatedAt.After(*status.RefetchDetailUpdatedAt)
+	if completed && status.RefetchRequested {
+		_, err := d.db.Exec(
+			`UPDATE hosts SET refetch_requested = FALSE, refetch_requested_at = NULL WHERE id = ?`,
+			hostID,
+		)
+		if err != nil {
+			return false, false, errors.Wrap(err, "clear completed refetch request")
+		}
+	}
+	return true, completed, nil
+}
+
+// hostStatusStatistics are the host status counts of a team and platform, and
+// of a label when grouped by label.
+type hostStatusStatistics struct {
+	fleet.HostSummary
+	TeamID   *uint  `db:"team_id"`
+	Platform string `db:"platform"`
+	LabelID  *uint  `db:"label_id"`
+}
+
+// add adds the counts of the statistics to the summary.
+func (s hostStatusStatistics) add(summary *fleet.HostSummary) {
+	summary.OnlineCount += s.OnlineCount
+	summary.OfflineCount += s.OfflineCount
+	summary.MIACount += s.MIACount
+	summary.NewCount += s.NewCount
+	summary.TotalCount += s.TotalCount
+	if summary.PlatformCounts == nil {
+		summary.PlatformCounts = map[string]uint{}
+	}
+	summary.PlatformCounts[fleet.PlatformFamily(s.Platform)] += s.TotalCount
+}
+
+// hostStatusStatistics returns the host status counts of the hosts matching
+// the where condition, grouped by team and platform, so that the summaries by
+// team and by platform family are all computed with the same query. If
+// labelIDs are given, only the members of the labels are counted, and the
+// counts are also grouped by label. Decommissioned hosts are not counted.
+func (d *Datastore) hostStatusStatistics(where string, labelIDs []uint, now time.Time) ([]hostStatusStatistics, error) {
+	// The logic in this function should remain synchronized with
+	// host.Status and CountHostsInTargets
+
+	args := []interface{}{now, now, now, now, now}
+	labelColumn, labelJoin, labelGroup := "NULL", "", ""
+	if len(labelIDs) > 0 {
+		labelColumn = "lm.label_id"
+		labelJoin = fmt.Sprintf(
+			"JOIN label_membership lm ON (lm.host_id = hosts.id AND lm.label_id IN (%s))",
+			strings.TrimSuffix(strings.Repeat("?,", len(labelIDs)), ","),
+		)
+		labelGroup = ", lm.label_id"
+		for _, id := range labelIDs {
+			args = append(args, id)
+		}
+	}
 
 	sqlStatement := fmt.Sprintf(`
 			SELECT
-				COALESCE(SUM(CASE WHEN DATE_ADD(seen_time, INTERVAL 30 DAY) <= ? THEN 1 ELSE 0 END), 0) mia,
-				COALESCE(SUM(CASE WHEN DATE_ADD(seen_time, INTERVAL LEAST(distributed_interval, config_tls_refresh) + %d SECOND) <= ? AND DATE_ADD(seen_time, INTERVAL 30 DAY) >= ? THEN 1 ELSE 0 END), 0) offline,
-				COALESCE(SUM(CASE WHEN DATE_ADD(seen_time, INTERVAL LEAST(distributed_interval, config_tls_refresh) + %d SECOND) > ? THEN 1 ELSE 0 END), 0) online,
-				COALESCE(SUM(CASE WHEN DATE_ADD(created_at, INTERVAL 1 DAY) >= ? THEN 1 ELSE 0 END), 0) new
-			FROM hosts WHERE %s
-			LIMIT 1;
-		`, fleet.OnlineIntervalBuffer, fleet.OnlineIntervalBuffer,
-		d.whereFilterHostsByTeams(filter, "hosts"),
+				COALESCE(SUM(CASE WHEN DATE_ADD(hosts.seen_time, INTERVAL 30 DAY) <= ? THEN 1 ELSE 0 END), 0) mia,
+				COALESCE(SUM(CASE WHEN DATE_ADD(hosts.seen_time, INTERVAL LEAST(hosts.distributed_interval, hosts.config_tls_refresh) + %d SECOND) <= ? AND DATE_ADD(hosts.seen_time, INTERVAL 30 DAY) >= ? THEN 1 ELSE 0 END), 0) offline,
+				COALESCE(SUM(CASE WHEN DATE_ADD(hosts.seen_time, INTERVAL LEAST(hosts.distributed_interval, hosts.config_tls_refresh) + %d SECOND) > ? THEN 1 ELSE 0 END), 0) online,
+				COALESCE(SUM(CASE WHEN DATE_ADD(hosts.created_at, INTERVAL COALESCE(t.new_host_hours, %d) HOUR) >= ? THEN 1 ELSE 0 END), 0) new,
+				COUNT(*) total,
+				hosts.team_id,
+				hosts.platform,
+				%s AS label_id
+			FROM hosts LEFT JOIN teams t ON (hosts.team_id = t.id) %s
+			WHERE hosts.decommissioned_at IS NULL AND (%s)
+			GROUP BY hosts.team_id, hosts.platform%s
+		`, fleet.OnlineIntervalBuffer, fleet.OnlineIntervalBuffer, int(fleet.NewDuration.Hours()),
+		labelColumn, labelJoin, where, labelGroup,
 	)
 
-	counts := struct {
-		MIA     uint `db:"mia"`
-		Offline uint `db:"offline"`
-		Online  uint `db:"online"`
-		New     uint `db:"new"`
-	}{}
-	err := d.db.Get(&counts, sqlStatement, now, now, now, now, now)
-	if err != nil && err != sql.ErrNoRows {
-		e = errors.Wrap(err, "generating host statistics")
-		return
+	var stats []hostStatusStatistics
+	err := d.db.Select(&stats, sqlStatement, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating host statistics")
+	}
+	return stats, nil
+}
+
+func (d *Datastore) GenerateHostStatusStatistics(filter fleet.TeamFilter, now time.Time) (*fleet.HostSummary, error) {
+	stats, err := d.hostStatusStatistics(d.whereFilterHostsByTeams(filter, "hosts"), nil, now)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &fleet.HostSummary{PlatformCounts: map[string]uint{}}
+	for _, s := range stats {
+		s.add(summary)
+	}
+	return summary, nil
+}
+
+func (d *Datastore) TeamHostStatusStatistics(filter fleet.TeamFilter, now time.Time) (map[uint]fleet.HostSummary, error) {
+	stats, err := d.hostStatusStatistics(d.whereFilterHostsByTeams(filter, "hosts"), nil, now)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make(map[uint]fleet.HostSummary)
+	for _, s := range stats {
+		var teamID uint
+		if s.TeamID != nil {
+			teamID = *s.TeamID
+		}
+		summary := summaries[teamID]
+		s.add(&summary)
+		summaries[teamID] = summary
+	}
+	return summaries, nil
+}
+
+// MultiHostStatusStatistics computes the summaries of all the filters with at
+// most two queries, one for the filters without a label and one for the
+// filters with a label. The hosts allowed by any of the filters are counted by
+// team and platform, and by label for the second query, and each summary adds
+// up the counts of the teams, and label, its filter allows.
+func (d *Datastore) MultiHostStatusStatistics(filters []fleet.NamedTeamFilter, now time.Time) (map[string]fleet.HostSummary, error) {
+	summaries := make(map[string]fleet.HostSummary, len(filters))
+	if len(filters) == 0 {
+		return summaries, nil
+	}
+
+	var conds, labelConds []string
+	var labelIDs []uint
+	seenLabels := make(map[uint]bool)
+	for _, f := range filters {
+		if _, ok := summaries[f.Name]; ok {
+			return nil, errors.Errorf("duplicate host status filter name %q", f.Name)
+		}
+		summaries[f.Name] = fleet.HostSummary{PlatformCounts: map[string]uint{}}
+		cond := "(" + d.whereFilterHostsByTeams(f.Filter, "hosts") + ")"
+		if f.LabelID == 0 {
+			conds = append(conds, cond)
+			continue
+		}
+		labelConds = append(labelConds, cond)
+		if !seenLabels[f.LabelID] {
+			seenLabels[f.LabelID] = true
+			labelIDs = append(labelIDs, f.LabelID)
+		}
+	}
+
+	var stats, labelStats []hostStatusStatistics
+	if len(conds) > 0 {
+		var err error
+		stats, err = d.hostStatusStatistics(strings.Join(conds, " OR "), nil, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(labelConds) > 0 {
+		var err error
+		labelStats, err = d.hostStatusStatistics(strings.Join(labelConds, " OR "), labelIDs, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range filters {
+		summary := summaries[f.Name]
+		filterStats := stats
+		if f.LabelID != 0 {
+			filterStats = labelStats
+		}
+		for _, s := range filterStats {
+			if f.LabelID != 0 && *s.LabelID != f.LabelID {
+				continue
+			}
+			if f.Platform != "" && fleet.PlatformFamily(s.Platform) != f.Platform {
+				continue
+			}
+			if filterAllowsHostTeam(f.Filter, s.TeamID) {
+				s.add(&summary)
+			}
+		}
+		summaries[f.Name] = summary
+	}
+	return summaries, nil
+}
+
+func (d *Datastore) CountHostsBySubnet(filter fleet.TeamFilter, maskBits int) (map[string]uint, error) {
+	if maskBits < 0 || maskBits > 32 {
+		return nil, errors.Errorf("invalid subnet mask bits %d", maskBits)
+	}
+
+	// Grouping by subnet is done here, as MySQL has no CIDR functions.
+	var ips []string
+	sql := fmt.Sprintf(`SELECT h.primary_ip FROM hosts h WHERE %s`, d.whereFilterHostsByTeams(filter, "h"))
+	if err := d.db.Select(&ips, sql); err != nil {
+		return nil, errors.Wrap(err, "get host primary ips")
+	}
+
+	counts := map[string]uint{}
+	for _, ip := range ips {
+		counts[fleet.HostSubnet(ip, maskBits)]++
+	}
+	return counts, nil
+}
+
+func (d *Datastore) SnapshotHostSummary(now time.Time) error {
+	// The snapshot covers all hosts
+	filter := fleet.TeamFilter{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}}
+	summary, err := d.GenerateHostStatusStatistics(filter, now)
+	if err != nil {
+		return err
+	}
+	platformCounts, err := json.Marshal(summary.PlatformCounts)
+	if err != nil {
+		return errors.Wrap(err, "marshal platform counts")
+	}
+
+	_, err = d.db.Exec(`
+		INSERT INTO host_summary_snapshots (created_at, online, offline, mia, new, total, platform_counts)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		now, summary.OnlineCount, summary.OfflineCount, summary.MIACount, summary.NewCount, summary.TotalCount, platformCounts,
+	)
+	if err != nil {
+		return errors.Wrap(err, "insert host summary snapshot")
+	}
+	return nil
+}
+
+// hostSummarySnapshot returns the latest snapshot taken at or before t.
+func (d *Datastore) hostSummarySnapshot(t time.Time) (*fleet.HostSummary, error) {
+	var snapshot struct {
+		fleet.HostSummary
+		PlatformCountsJSON []byte `db:"platform_counts"`
+	}
+	err := d.db.Get(&snapshot, `
+		SELECT online, offline, mia, new, total, platform_counts
+		FROM host_summary_snapshots
+		WHERE created_at <= ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1`, t)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFound("HostSummarySnapshot")
+		}
+		return nil, errors.Wrap(err, "get host summary snapshot")
+	}
+	if err := json.Unmarshal(snapshot.PlatformCountsJSON, &snapshot.PlatformCounts); err != nil {
+		return nil, errors.Wrap(err, "unmarshal platform counts")
+	}
+	return &snapshot.HostSummary, nil
+}
+
+func (d *Datastore) HostSummaryDelta(from, to time.Time) (fleet.HostSummaryDelta, error) {
+	earlier, err := d.hostSummarySnapshot(from)
+	if err != nil {
+		return fleet.HostSummaryDelta{}, err
+	}
+	later, err := d.hostSummarySnapshot(to)
+	if err != nil {
+		return fleet.HostSummaryDelta{}, err
+	}
+	return later.Sub(*earlier), nil
+}
+
+func (d *Datastore) PlatformCountHistory(from, to time.Time) (map[string][]fleet.PlatformCountPoint, error) {
+	var snapshots []struct {
+		CreatedAt          time.Time `db:"created_at"`
+		PlatformCountsJSON []byte    `db:"platform_counts"`
+	}
+	err := d.db.Select(&snapshots, `
+		SELECT created_at, platform_counts
+		FROM host_summary_snapshots
+		WHERE created_at BETWEEN ? AND ?
+		ORDER BY created_at, id`,
+		from, to,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "get platform count history")
+	}
+
+	counts := make([]map[string]uint, len(snapshots))
+	platforms := map[string]bool{}
+	for i, snapshot := range snapshots {
+		if err := json.Unmarshal(snapshot.PlatformCountsJSON, &counts[i]); err != nil {
+			return nil, errors.Wrap(err, "unmarshal platform counts")
+		}
+		for platform := range counts[i] {
+			platforms[platform] = true
+		}
+	}
+
+	history := map[string][]fleet.PlatformCountPoint{}
+	for platform := range platforms {
+		points := make([]fleet.PlatformCountPoint, 0, len(snapshots))
+		for i, snapshot := range snapshots {
+			points = append(points, fleet.PlatformCountPoint{T: snapshot.CreatedAt, Count: counts[i][platform]})
+		}
+		history[platform] = points
+	}
+	return history, nil
+}
+
+// sampleHostResources records the memory and the total size of the disks of
+// the host, unless the host was sampled within the sampling interval.
+func (d *Datastore) sampleHostResources(host *fleet.Host) error {
+	sql := `
+		INSERT IGNORE INTO host_resource_samples (host_id, memory, disk_space)
+		SELECT ?, ?, COALESCE((SELECT SUM(size) FROM host_disks WHERE host_id = ?), 0)
+		FROM DUAL
+		WHERE NOT EXISTS (
+			SELECT 1 FROM host_resource_samples
+			WHERE host_id = ? AND created_at > DATE_SUB(NOW(), INTERVAL ? SECOND)
+		)
+	`
+	interval := int64(d.hostResourceSampleInterval / time.Second)
+	if _, err := d.db.Exec(sql, host.ID, host.Memory, host.ID, host.ID, interval); err != nil {
+		return errors.Wrapf(err, "sample resources of host %d", host.ID)
 	}
+	return nil
+}
 
-	mia = counts.MIA
-	offline = counts.Offline
-	online = counts.Online
-	new = counts.New
-	return online, offline, mia, new, nil
+func (d *Datastore) HostResourceHistory(hostID uint, from, to time.Time) ([]fleet.HostResourceSample, error) {
+	var samples []fleet.HostResourceSample
+	err := d.db.Select(&samples, `
+		SELECT host_id, created_at, memory, disk_space
+		FROM host_resource_samples
+		WHERE host_id = ? AND created_at BETWEEN ? AND ?
+		ORDER BY created_at`,
+		hostID, from, to,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "get host resource history")
+	}
+	return samples, nil
 }
 
 // EnrollHost enrolls a host
-func (d *Datastore) EnrollHost(osqueryHostID, nodeKey string, teamID *uint, cooldown time.Duration) (*fleet.Host, error) {
+func (d *Datastore) EnrollHost(osqueryHostID, nodeKey string, teamID *uint, cooldown time.Duration, opt fleet.EnrollHostOptions) (*fleet.Host, error) {
+	return d.enrollHost(osqueryHostID, "", nodeKey, teamID, cooldown, opt)
+}
+
+func (d *Datastore) EnrollHostByHardware(osqueryHostID, fingerprint, nodeKey string, teamID *uint, cooldown time.Duration) (*fleet.Host, error) {
+	return d.enrollHost(osqueryHostID, fingerprint, nodeKey, teamID, cooldown, fleet.EnrollHostOptions{})
+}
+
+// enrollHost enrolls the host identified by osqueryHostID. If fingerprint is
+// not empty, an existing host with the same hardware fingerprint is enrolled
+// again even if its osquery identifier changed.
+func (d *Datastore) enrollHost(osqueryHostID, fingerprint, nodeKey string, teamID *uint, cooldown time.Duration, opt fleet.EnrollHostOptions) (*fleet.Host, error) {
 	if osqueryHostID == "" {
-		return nil, fmt.Errorf("missing osquery host identifier")
+		return nil, d.recordEnrollmentRejection(osqueryHostID, opt.EnrolledFromIP, fmt.Errorf("missing osquery host identifier"))
+	}
+	if !opt.Strategy.Valid() {
+		return nil, d.recordEnrollmentRejection(osqueryHostID, opt.EnrolledFromIP, fmt.Errorf("invalid enroll strategy %q", opt.Strategy))
+	}
+	if !opt.TeamChange.Valid() {
+		return nil, d.recordEnrollmentRejection(osqueryHostID, opt.EnrolledFromIP, fmt.Errorf("invalid enroll team change %q", opt.TeamChange))
 	}
 
 	var host fleet.Host
+	var change *fleet.HostTeamChange
+	var collapsed bool
 	err := d.withRetryTxx(func(tx *sqlx.Tx) error {
 		zeroTime := time.Unix(0, 0).Add(24 * time.Hour)
 
 		var id int64
-		err := tx.Get(&host, `SELECT id, last_enrolled_at FROM hosts WHERE osquery_host_id = ?`, osqueryHostID)
-		switch {
-		case err != nil && !errors.Is(err, sql.ErrNoRows):
-			return errors.Wrap(err, "check existing")
+		enrollTeamID := teamID
+		change = nil
+		err := sql.ErrNoRows
+		if fingerprint != "" {
+			err = tx.Get(&host, `SELECT id, team_id, last_enrolled_at, decommissioned_at FROM hosts WHERE hardware_fingerprint = ? ORDER BY id LIMIT 1`, fingerprint)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return errors.Wrap(err, "check existing hardware")
+			}
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			err = tx.Get(&host, `SELECT id, team_id, last_enrolled_at, decommissioned_at FROM hosts WHERE osquery_host_id = ?`, osqueryHostID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return errors.Wrap(err, "check existing")
+			}
+		}
+		collapsed = false
+		dedupValue := ""
+		switch d.enrollDedupColumn {
+		case "uuid":
+			dedupValue = opt.UUID
+		case "hardware_serial":
+			dedupValue = opt.HardwareSerial
+		}
+		if errors.Is(err, sql.ErrNoRows) && d.enrollDedupWindow > 0 && dedupValue != "" {
+			// The column is validated by EnrollDedup
+			err = tx.Get(&host, fmt.Sprintf(
+				`SELECT id, team_id, last_enrolled_at, decommissioned_at FROM hosts WHERE %s = ? AND last_enrolled_at >= ? ORDER BY last_enrolled_at DESC, id DESC LIMIT 1`,
+				d.enrollDedupColumn,
+			), dedupValue, time.Now().Add(-d.enrollDedupWindow))
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return errors.Wrap(err, "check recently enrolled")
+			}
+			collapsed = err == nil
+		}
+		exists := err == nil
+		if exists {
+			if host.DecommissionedAt != nil {
+				// Not wrapped with backoff.Permanent, withRetryTxx already
+				// does so and unwraps it once, keeping the rejection intact.
+				return &enrollRejectedError{fleet.ErrHostDecommissioned}
+			}
+			// Prevent hosts from enrolling too often with the same identifier.
+			// Prior to adding this we saw many hosts (probably VMs) with the
+			// same identifier competing for enrollment and causing perf issues.
+			if cooldown > 0 && time.Since(host.LastEnrolledAt) < cooldown {
+				return &enrollRejectedError{fmt.Errorf("host identified by %s enrolling too often", osqueryHostID)}
+			}
+			if !fleet.SameTeam(host.TeamID, teamID) {
+				change = &fleet.HostTeamChange{
+					FromTeamID: host.TeamID,
+					ToTeamID:   teamID,
+					Applied:    opt.TeamChange != fleet.EnrollTeamChangeConfirm,
+				}
+				if !change.Applied {
+					enrollTeamID = host.TeamID
+				}
+			}
+			// Hosts enrolling again within the dedup window are flapping
+			// rather than re-imaged, they keep their host.
+			recent := d.enrollDedupWindow > 0 && time.Since(host.LastEnrolledAt) < d.enrollDedupWindow
+			if opt.Strategy == fleet.EnrollStrategyReset && !recent {
+				// The host is enrolled as a new host, without the details
+				// of the existing one.
+				if err := deleteHostDB(tx, host.ID); err != nil {
+					return errors.Wrap(err, "reset existing host")
+				}
+				exists = false
+			}
+		}
 
-		case errors.Is(err, sql.ErrNoRows):
+		if !exists {
 			// Create new host record
 			sqlInsert := `
 				INSERT INTO hosts (
@@ -451,42 +1779,58 @@ func (d *Datastore) EnrollHost(osqueryHostID, nodeKey string, teamID *uint, cool
 					osquery_host_id,
 					seen_time,
 					node_key,
-					team_id
-				) VALUES (?, ?, ?, ?, ?, ?)
+					team_id,
+					enrolled_from_ip,
+					hardware_fingerprint,
+					uuid,
+					hardware_serial
+				) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 			`
-			result, err := tx.Exec(sqlInsert, zeroTime, zeroTime, osqueryHostID, time.Now().UTC(), nodeKey, teamID)
+			result, err := tx.Exec(sqlInsert, zeroTime, zeroTime, osqueryHostID, time.Now().UTC(), nodeKey, enrollTeamID, opt.EnrolledFromIP, fingerprint, opt.UUID, opt.HardwareSerial)
 
 			if err != nil {
 				return errors.Wrap(err, "insert host")
 			}
 
 			id, _ = result.LastInsertId()
-
-		default:
-			// Prevent hosts from enrolling too often with the same identifier.
-			// Prior to adding this we saw many hosts (probably VMs) with the
-			// same identifier competing for enrollment and causing perf issues.
-			if cooldown > 0 && time.Since(host.LastEnrolledAt) < cooldown {
-				return backoff.Permanent(fmt.Errorf("host identified by %s enrolling too often", osqueryHostID))
-			}
+		} else {
 			id = int64(host.ID)
-			// Update existing host record
+			// Update existing host record. The osquery identifier changes
+			// when a host matched by its hardware fingerprint was reimaged.
 			sqlUpdate := `
 				UPDATE hosts
 				SET node_key = ?,
 				team_id = ?,
+				enrolled_from_ip = ?,
+				osquery_host_id = ?,
+				hardware_fingerprint = IF(? = '', hardware_fingerprint, ?),
+				uuid = IF(? = '', uuid, ?),
+				hardware_serial = IF(? = '', hardware_serial, ?),
 				last_enrolled_at = NOW()
-				WHERE osquery_host_id = ?
+				WHERE id = ?
 			`
-			_, err := tx.Exec(sqlUpdate, nodeKey, teamID, osqueryHostID)
+			_, err := tx.Exec(sqlUpdate, nodeKey, enrollTeamID, opt.EnrolledFromIP, osqueryHostID, fingerprint, fingerprint, opt.UUID, opt.UUID, opt.HardwareSerial, opt.HardwareSerial, id)
 
 			if err != nil {
 				return errors.Wrap(err, "update host")
 			}
 		}
 
+		if change != nil {
+			change.HostID = uint(id)
+			_, err := tx.Exec(
+				`INSERT INTO host_team_changes (host_id, from_team_id, to_team_id, applied, created_at) VALUES (?, ?, ?, ?, ?)`,
+				change.HostID, change.FromTeamID, change.ToTeamID, change.Applied, d.clock.Now().UTC().Truncate(time.Second),
+			)
+			if err != nil {
+				return errors.Wrap(err, "record host team change")
+			}
+		}
+
 		sqlSelect := `
-			SELECT * FROM hosts WHERE id = ? LIMIT 1
+			SELECT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
+			FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
+			WHERE h.id = ? LIMIT 1
 		`
 		err = tx.Get(&host, sqlSelect, id)
 		if err != nil {
@@ -498,57 +1842,195 @@ func (d *Datastore) EnrollHost(osqueryHostID, nodeKey string, teamID *uint, cool
 			return errors.Wrap(err, "insert new host into all hosts label")
 		}
 
+		if err := addHostToInitialLabels(tx, uint(id), opt.InitialLabelIDs); err != nil {
+			return err
+		}
+
 		return nil
 	})
 
+	if rejected, ok := err.(*enrollRejectedError); ok {
+		return nil, d.recordEnrollmentRejection(osqueryHostID, opt.EnrolledFromIP, rejected.err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if collapsed {
+		level.Info(d.logger).Log(
+			"msg", "host enrollment collapsed onto recently enrolled host",
+			"host", host.ID,
+			"osquery_host_id", osqueryHostID,
+			"column", d.enrollDedupColumn,
+		)
+	}
+	if change != nil {
+		level.Info(d.logger).Log(
+			"msg", "host enrolled with a different team",
+			"host", host.ID,
+			"from_team", fmtTeamID(change.FromTeamID),
+			"to_team", fmtTeamID(change.ToTeamID),
+			"applied", change.Applied,
+		)
+	}
 	return &host, nil
 }
 
+func fmtTeamID(teamID *uint) string {
+	if teamID == nil {
+		return "none"
+	}
+	return strconv.FormatUint(uint64(*teamID), 10)
+}
+
+// enrollRejectedError wraps the errors of enrollments rejected by validation,
+// as opposed to datastore failures, so that they are recorded after the
+// enrollment transaction is rolled back.
+type enrollRejectedError struct {
+	err error
+}
+
+func (e *enrollRejectedError) Error() string {
+	return e.err.Error()
+}
+
+// recordEnrollmentRejection records the rejected enrollment and returns the
+// rejection error. Failing to record it is logged, so that the caller still
+// gets the reason of the rejection.
+func (d *Datastore) recordEnrollmentRejection(osqueryHostID, enrolledFromIP string, rejection error) error {
+	_, err := d.db.Exec(
+		`INSERT INTO enrollment_attempts (osquery_host_id, reason, enrolled_from_ip, created_at) VALUES (?, ?, ?, ?)`,
+		osqueryHostID, rejection.Error(), enrolledFromIP, d.clock.Now().UTC().Truncate(time.Second),
+	)
+	if err != nil {
+		d.logger.Log("err", err, "msg", "record rejected enrollment")
+	}
+	return rejection
+}
+
+func (d *Datastore) ListFailedEnrollments(since time.Time) ([]*fleet.EnrollmentAttempt, error) {
+	attempts := []*fleet.EnrollmentAttempt{}
+	err := d.db.Select(&attempts, `
+		SELECT id, osquery_host_id, reason, enrolled_from_ip, created_at
+		FROM enrollment_attempts
+		WHERE created_at >= ?
+		ORDER BY created_at, id`, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "list failed enrollments")
+	}
+	return attempts, nil
+}
+
+func (d *Datastore) ListHostTeamChanges(since time.Time) ([]*fleet.HostTeamChange, error) {
+	changes := []*fleet.HostTeamChange{}
+	err := d.db.Select(&changes, `
+		SELECT id, host_id, from_team_id, to_team_id, applied, created_at
+		FROM host_team_changes
+		WHERE created_at >= ?
+		ORDER BY created_at, id`, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "list host team changes")
+	}
+	return changes, nil
+}
+
+// addHostToInitialLabels adds the enrolling host to the provided manual labels.
+// Dynamic labels are rejected because their membership would be replaced by
+// the label query results.
+func addHostToInitialLabels(tx *sqlx.Tx, hostID uint, labelIDs []uint) error {
+	if len(labelIDs) == 0 {
+		return nil
+	}
+
+	unique := make(map[uint]bool, len(labelIDs))
+	for _, id := range labelIDs {
+		unique[id] = true
+	}
+
+	sql, args, err := sqlx.In(
+		`SELECT COUNT(*) FROM labels WHERE id IN (?) AND label_membership_type = ?`,
+		labelIDs, fleet.LabelMembershipTypeManual,
+	)
+	if err != nil {
+		return errors.Wrap(err, "sqlx.In initial labels")
+	}
+	var count int
+	if err := tx.Get(&count, sql, args...); err != nil {
+		return errors.Wrap(err, "count initial labels")
+	}
+	if count != len(unique) {
+		return &enrollRejectedError{fmt.Errorf("initial labels %v must all be existing manual labels", labelIDs)}
+	}
+
+	var values []interface{}
+	for id := range unique {
+		values = append(values, hostID, id)
+	}
+	sql = fmt.Sprintf(
+		`INSERT IGNORE INTO label_membership (host_id, label_id) VALUES %s`,
+		strings.TrimSuffix(strings.Repeat("(?, ?),", len(unique)), ","),
+	)
+	if _, err := tx.Exec(sql, values...); err != nil {
+		return errors.Wrap(err, "insert initial label membership")
+	}
+
+	return nil
+}
+
 func (d *Datastore) AuthenticateHost(nodeKey string) (*fleet.Host, error) {
 	// Select everything besides `additional`
 	sqlStatement := `
 		SELECT
-			id,
-			osquery_host_id,
-			created_at,
-			updated_at,
-			detail_updated_at,
-			label_updated_at,
-			node_key,
-			hostname,
-			uuid,
-			platform,
-			osquery_version,
-			os_version,
-			build,
-			platform_like,
-			code_name,
-			uptime,
-			memory,
-			cpu_type,
-			cpu_subtype,
-			cpu_brand,
-			cpu_physical_cores,
-			cpu_logical_cores,
-			hardware_vendor,
-			hardware_model,
-			hardware_version,
-			hardware_serial,
-			computer_name,
-			primary_ip_id,
-			seen_time,
-			distributed_interval,
-			logger_tls_period,
-			config_tls_refresh,
-			primary_ip,
-			primary_mac,
-			refetch_requested,
-			team_id
-		FROM hosts
-		WHERE node_key = ?
+			h.id,
+			h.osquery_host_id,
+			h.created_at,
+			h.updated_at,
+			h.detail_updated_at,
+			h.label_updated_at,
+			h.node_key,
+			h.hostname,
+			h.uuid,
+			h.platform,
+			h.osquery_version,
+			h.os_version,
+			h.build,
+			h.platform_like,
+			h.code_name,
+			h.uptime,
+			h.memory,
+			h.cpu_type,
+			h.cpu_subtype,
+			h.cpu_brand,
+			h.cpu_physical_cores,
+			h.cpu_logical_cores,
+			h.hardware_vendor,
+			h.hardware_model,
+			h.hardware_version,
+			h.hardware_serial,
+			h.computer_name,
+			h.primary_ip_id,
+			h.seen_time,
+			h.distributed_interval,
+			h.logger_tls_period,
+			h.config_tls_refresh,
+			h.primary_ip,
+			h.primary_mac,
+			h.refetch_requested,
+			h.team_id,
+			h.decommissioned_at,
+			h.timezone,
+			h.kernel_version,
+			h.disk_encryption_enabled,
+			h.orbit_version,
+			h.cloud_provider,
+			h.cloud_instance_id,
+			h.mdm_enrolled,
+			h.mdm_server_url,
+			h.battery_cycle_count,
+			h.battery_health,
+			t.name AS team_name,
+			t.new_host_hours AS team_new_host_hours
+		FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
+		WHERE h.node_key = ?
 		LIMIT 1
 	`
 
@@ -556,23 +2038,57 @@ func (d *Datastore) AuthenticateHost(nodeKey string) (*fleet.Host, error) {
 	if err := d.db.Get(host, sqlStatement, nodeKey); err != nil {
 		switch err {
 		case sql.ErrNoRows:
+			var decommissioned bool
+			if err := d.db.Get(&decommissioned,
+				`SELECT EXISTS (SELECT 1 FROM hosts WHERE node_key = ? AND decommissioned_at IS NOT NULL)`,
+				decommissionedNodeKeyPrefix+nodeKey,
+			); err != nil {
+				return nil, errors.Wrap(err, "find decommissioned host")
+			}
+			if decommissioned {
+				return nil, fleet.ErrHostDecommissioned
+			}
 			return nil, notFound("Host")
 		default:
 			return nil, errors.New("find host")
 		}
 	}
+	if host.DecommissionedAt != nil {
+		return nil, fleet.ErrHostDecommissioned
+	}
 
 	return host, nil
 }
 
+// checkinLatencyUpdate is the assignment updating the rolling check-in latency
+// of a host seen at the time parameter. The latency of a check-in is the time
+// since the host was last seen, minus the expected check-in interval (the
+// same interval used for the online status), clamped to zero for early
+// check-ins. The stored value is an exponential moving average giving the
+// latest check-in a weight of 1/4. Hosts that haven't reported their
+// intervals yet are skipped.
+//
+// It must come before the seen_time assignment, since MySQL evaluates the
+// assignments of an UPDATE from left to right.
+const checkinLatencyUpdate = `
+	checkin_latency = IF(
+		LEAST(distributed_interval, config_tls_refresh) = 0,
+		checkin_latency,
+		ROUND(0.75 * checkin_latency + 0.25 * GREATEST(
+			TIMESTAMPDIFF(MICROSECOND, seen_time, ?) * 1000 - LEAST(distributed_interval, config_tls_refresh) * 1000000000,
+			0
+		))
+	)`
+
 func (d *Datastore) MarkHostSeen(host *fleet.Host, t time.Time) error {
 	sqlStatement := `
 		UPDATE hosts SET
+			` + checkinLatencyUpdate + `,
 			seen_time = ?
 		WHERE node_key=?
 	`
 
-	_, err := d.db.Exec(sqlStatement, t, host.NodeKey)
+	_, err := d.db.Exec(sqlStatement, t, t, host.NodeKey)
 	if err != nil {
 		return errors.Wrap(err, "marking host seen")
 	}
@@ -589,10 +2105,11 @@ func (d *Datastore) MarkHostsSeen(hostIDs []uint, t time.Time) error {
 	if err := d.withRetryTxx(func(tx *sqlx.Tx) error {
 		query := `
 		UPDATE hosts SET
+			` + checkinLatencyUpdate + `,
 			seen_time = ?
 		WHERE id IN (?)
 	`
-		query, args, err := sqlx.In(query, t, hostIDs)
+		query, args, err := sqlx.In(query, t, t, hostIDs)
 		if err != nil {
 			return errors.Wrap(err, "sqlx in")
 		}
@@ -609,24 +2126,49 @@ func (d *Datastore) MarkHostsSeen(hostIDs []uint, t time.Time) error {
 	return nil
 }
 
-func (d *Datastore) searchHostsWithOmits(filter fleet.TeamFilter, query string, omit ...uint) ([]*fleet.Host, error) {
+const (
+	// defaultSearchHostsLimit is the number of hosts returned by a search
+	// with a query when no limit is provided.
+	defaultSearchHostsLimit = 10
+	// defaultSearchHostsNoQueryLimit is the number of hosts returned by a
+	// search without a query when no limit is provided.
+	defaultSearchHostsNoQueryLimit = 5
+	// maxSearchHostsLimit caps the limit that callers can request so that a
+	// single search can't return an unbounded number of hosts.
+	maxSearchHostsLimit = 100
+)
+
+// searchHostsLimit returns the limit to use for a host search, applying the
+// default for an unset limit and the maximum.
+func searchHostsLimit(limit, defaultLimit int) int {
+	if limit <= 0 {
+		return defaultLimit
+	}
+	if limit > maxSearchHostsLimit {
+		return maxSearchHostsLimit
+	}
+	return limit
+}
+
+func (d *Datastore) searchHostsWithOmits(filter fleet.TeamFilter, query string, limit int, omit ...uint) ([]*fleet.Host, error) {
 	hostQuery := transformQuery(query)
 	ipQuery := `"` + query + `"`
 
 	sql := fmt.Sprintf(`
-			SELECT DISTINCT *
-			FROM hosts
+			SELECT DISTINCT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
+			FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
 			WHERE
 			(
-				MATCH (hostname, uuid) AGAINST (? IN BOOLEAN MODE)
-				OR MATCH (primary_ip, primary_mac) AGAINST (? IN BOOLEAN MODE)
+				MATCH (h.hostname, h.uuid) AGAINST (? IN BOOLEAN MODE)
+				OR MATCH (h.primary_ip, h.primary_mac) AGAINST (? IN BOOLEAN MODE)
+				OR h.cloud_instance_id = ?
 			)
-			AND id NOT IN (?) AND %s
-			LIMIT 10
-		`, d.whereFilterHostsByTeams(filter, "hosts"),
+			AND h.id NOT IN (?) AND %s
+			LIMIT ?
+		`, d.whereFilterHostsByTeams(filter, "h"),
 	)
 
-	sql, args, err := sqlx.In(sql, hostQuery, ipQuery, omit)
+	sql, args, err := sqlx.In(sql, hostQuery, ipQuery, strings.TrimSpace(query), omit, searchHostsLimit(limit, defaultSearchHostsLimit))
 	if err != nil {
 		return nil, errors.Wrap(err, "searching hosts")
 	}
@@ -642,13 +2184,14 @@ func (d *Datastore) searchHostsWithOmits(filter fleet.TeamFilter, query string,
 	return hosts, nil
 }
 
-func (d *Datastore) searchHostsDefault(filter fleet.TeamFilter, omit ...uint) ([]*fleet.Host, error) {
+func (d *Datastore) searchHostsDefault(filter fleet.TeamFilter, limit int, omit ...uint) ([]*fleet.Host, error) {
 	sql := fmt.Sprintf(`
-			SELECT * FROM hosts
-			WHERE id NOT in (?) AND %s
-			ORDER BY seen_time DESC
-			LIMIT 5
-		`, d.whereFilterHostsByTeams(filter, "hosts"),
+			SELECT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
+			FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
+			WHERE h.id NOT in (?) AND %s
+			ORDER BY h.seen_time DESC
+			LIMIT ?
+		`, d.whereFilterHostsByTeams(filter, "h"),
 	)
 
 	var in interface{}
@@ -662,7 +2205,7 @@ func (d *Datastore) searchHostsDefault(filter fleet.TeamFilter, omit ...uint) ([
 	}
 
 	var hosts []*fleet.Host
-	sql, args, err := sqlx.In(sql, in)
+	sql, args, err := sqlx.In(sql, in, searchHostsLimit(limit, defaultSearchHostsNoQueryLimit))
 	if err != nil {
 		return nil, errors.Wrap(err, "searching default hosts")
 	}
@@ -674,34 +2217,36 @@ func (d *Datastore) searchHostsDefault(filter fleet.TeamFilter, omit ...uint) ([
 	return hosts, nil
 }
 
-// SearchHosts find hosts by query containing an IP address, a host name or UUID.
+// SearchHosts find hosts by query containing an IP address, a host name, UUID
+// or cloud instance ID.
 // Optionally pass a list of IDs to omit from the search
-func (d *Datastore) SearchHosts(filter fleet.TeamFilter, query string, omit ...uint) ([]*fleet.Host, error) {
+func (d *Datastore) SearchHosts(filter fleet.TeamFilter, query string, limit int, omit ...uint) ([]*fleet.Host, error) {
 	hostQuery := transformQuery(query)
 	if !queryMinLength(hostQuery) {
-		return d.searchHostsDefault(filter, omit...)
+		return d.searchHostsDefault(filter, limit, omit...)
 	}
 	if len(omit) > 0 {
-		return d.searchHostsWithOmits(filter, query, omit...)
+		return d.searchHostsWithOmits(filter, query, limit, omit...)
 	}
 
 	// Needs quotes to avoid each . marking a word boundary
 	ipQuery := `"` + query + `"`
 
 	sql := fmt.Sprintf(`
-			SELECT DISTINCT *
-			FROM hosts
+			SELECT DISTINCT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
+			FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
 			WHERE
 			(
-				MATCH (hostname, uuid) AGAINST (? IN BOOLEAN MODE)
-				OR MATCH (primary_ip, primary_mac) AGAINST (? IN BOOLEAN MODE)
+				MATCH (h.hostname, h.uuid) AGAINST (? IN BOOLEAN MODE)
+				OR MATCH (h.primary_ip, h.primary_mac) AGAINST (? IN BOOLEAN MODE)
+				OR h.cloud_instance_id = ?
 			) AND %s
-			LIMIT 10
-		`, d.whereFilterHostsByTeams(filter, "hosts"),
+			LIMIT ?
+		`, d.whereFilterHostsByTeams(filter, "h"),
 	)
 
 	hosts := []*fleet.Host{}
-	if err := d.db.Select(&hosts, sql, hostQuery, ipQuery); err != nil {
+	if err := d.db.Select(&hosts, sql, hostQuery, ipQuery, strings.TrimSpace(query), searchHostsLimit(limit, defaultSearchHostsLimit)); err != nil {
 		return nil, errors.Wrap(err, "searching hosts")
 	}
 
@@ -709,6 +2254,128 @@ func (d *Datastore) SearchHosts(filter fleet.TeamFilter, query string, omit ...u
 
 }
 
+func (d *Datastore) SearchHostsWithMatches(filter fleet.TeamFilter, query string, limit int, omit ...uint) ([]*fleet.HostSearchResult, error) {
+	hosts, err := d.SearchHosts(filter, query, limit, omit...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*fleet.HostSearchResult, 0, len(hosts))
+	for _, host := range hosts {
+		results = append(results, &fleet.HostSearchResult{
+			Host:          host,
+			MatchedFields: host.SearchMatchedFields(query),
+		})
+	}
+	return results, nil
+}
+
+const (
+	// fuzzySearchPrefixLength is the number of leading characters of the
+	// query that candidates for a fuzzy search must share, so that the
+	// candidates can be found with the fulltext index.
+	fuzzySearchPrefixLength = 3
+	// fuzzySearchCandidateLimit caps the number of hosts ranked by a fuzzy
+	// search.
+	fuzzySearchCandidateLimit = 500
+	// maxFuzzySearchDistance is the largest edit distance accepted by a fuzzy
+	// search. Queries shorter than shortFuzzySearchQuery accept a distance of
+	// 1 only.
+	maxFuzzySearchDistance = 2
+	shortFuzzySearchQuery  = 6
+)
+
+// SearchHostsFuzzy finds hosts by a hostname or computer name that may be
+// mistyped. Candidates are found with the hostname fulltext index, using the
+// first characters of the query so that typos later in the name are still
+// matched, and are then ranked by edit distance to the query. The hostname
+// without its domain is also compared, so "web01" matches
+// "web01.example.com" exactly.
+//
+// Queries too short for the fulltext index fall back to SearchHosts.
+func (d *Datastore) SearchHostsFuzzy(filter fleet.TeamFilter, query string, limit int) ([]*fleet.Host, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	prefix := strings.TrimSpace(mysqlFTSSymbolRegexp.ReplaceAllLiteralString(query, " "))
+	if len([]rune(prefix)) < fuzzySearchPrefixLength {
+		return d.SearchHosts(filter, query, limit)
+	}
+	prefix = string([]rune(prefix)[:fuzzySearchPrefixLength])
+	if strings.Contains(prefix, " ") {
+		return d.SearchHosts(filter, query, limit)
+	}
+
+	// Hosts matching more of the query terms are scanned first, so that exact
+	// matches aren't cut off by the candidate limit.
+	ftsQuery := transformQuery(query) + " " + prefix + "*"
+	sql := fmt.Sprintf(`
+			SELECT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
+			FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
+			WHERE %s
+			AND MATCH (h.hostname, h.uuid) AGAINST (? IN BOOLEAN MODE)
+			ORDER BY MATCH (h.hostname, h.uuid) AGAINST (? IN BOOLEAN MODE) DESC, h.id
+			LIMIT ?
+		`, d.whereFilterHostsByTeams(filter, "h"),
+	)
+	var candidates []*fleet.Host
+	if err := d.db.Select(&candidates, sql, ftsQuery, ftsQuery, fuzzySearchCandidateLimit); err != nil {
+		return nil, errors.Wrap(err, "searching fuzzy host candidates")
+	}
+
+	maxDistance := maxFuzzySearchDistance
+	if len([]rune(query)) < shortFuzzySearchQuery {
+		maxDistance = 1
+	}
+
+	type rankedHost struct {
+		host     *fleet.Host
+		distance int
+	}
+	var ranked []rankedHost
+	for _, h := range candidates {
+		distance := fuzzyHostDistance(h, query)
+		if distance <= maxDistance {
+			ranked = append(ranked, rankedHost{host: h, distance: distance})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].distance != ranked[j].distance {
+			return ranked[i].distance < ranked[j].distance
+		}
+		return ranked[i].host.Hostname < ranked[j].host.Hostname
+	})
+
+	limit = searchHostsLimit(limit, defaultSearchHostsLimit)
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	hosts := make([]*fleet.Host, 0, len(ranked))
+	for _, r := range ranked {
+		hosts = append(hosts, r.host)
+	}
+	return hosts, nil
+}
+
+// fuzzyHostDistance returns the smallest edit distance between the lower case
+// query and the host's hostname, hostname without domain or computer name.
+func fuzzyHostDistance(h *fleet.Host, query string) int {
+	hostname := strings.ToLower(h.Hostname)
+	names := []string{hostname}
+	if i := strings.Index(hostname, "."); i > 0 {
+		names = append(names, hostname[:i])
+	}
+	if h.ComputerName != "" {
+		names = append(names, strings.ToLower(h.ComputerName))
+	}
+
+	distance := -1
+	for _, name := range names {
+		if dist := editDistance(query, name); distance < 0 || dist < distance {
+			distance = dist
+		}
+	}
+	return distance
+}
+
 func (d *Datastore) HostIDsByName(filter fleet.TeamFilter, hostnames []string) ([]uint, error) {
 	if len(hostnames) == 0 {
 		return []uint{}, nil
@@ -734,46 +2401,343 @@ func (d *Datastore) HostIDsByName(filter fleet.TeamFilter, hostnames []string) (
 
 }
 
-func (d *Datastore) HostByIdentifier(identifier string) (*fleet.Host, error) {
-	sql := `
-		SELECT * FROM hosts
-		WHERE ? IN (hostname, osquery_host_id, node_key, uuid)
-		LIMIT 1
-	`
-	host := &fleet.Host{}
-	err := d.db.Get(host, sql, identifier)
-	if err != nil {
-		return nil, errors.Wrap(err, "get host by identifier")
+// HostIdentifierResolver returns the host matching identifier, or nil if no
+// host matches. See HostByIdentifier.
+type HostIdentifierResolver func(q sqlx.Queryer, identifier string) (*fleet.Host, error)
+
+// HostColumnResolver returns a HostIdentifierResolver matching identifier
+// against a column of the hosts table. The column is part of the query, so it
+// must not come from user input. Empty identifiers never match, as most
+// columns are empty for some hosts.
+func HostColumnResolver(column string) HostIdentifierResolver {
+	query := fmt.Sprintf(`
+		SELECT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
+		FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
+		WHERE h.%s = ? LIMIT 1
+	`, column)
+	return func(q sqlx.Queryer, identifier string) (*fleet.Host, error) {
+		if identifier == "" {
+			return nil, nil
+		}
+		host := &fleet.Host{}
+		switch err := sqlx.Get(q, host, query, identifier); {
+		case err == sql.ErrNoRows:
+			return nil, nil
+		case err != nil:
+			return nil, errors.Wrapf(err, "get host by %s", column)
+		}
+		return host, nil
 	}
+}
 
-	if err := d.loadHostPackStats(host); err != nil {
-		return nil, err
+// defaultHostIdentifierResolvers are always tried by HostByIdentifier, before
+// the resolvers added with the HostIdentifierResolvers option.
+var defaultHostIdentifierResolvers = []HostIdentifierResolver{
+	HostColumnResolver("osquery_host_id"),
+	HostColumnResolver("node_key"),
+	HostColumnResolver("uuid"),
+	HostColumnResolver("hostname"),
+	HostColumnResolver("cloud_instance_id"),
+}
+
+// HostByIdentifier returns the host found by the first resolver that matches
+// the identifier, trying the osquery host ID, node key, UUID, hostname and
+// cloud instance ID, then the resolvers added with the
+// HostIdentifierResolvers option.
+func (d *Datastore) HostByIdentifier(identifier string) (*fleet.Host, error) {
+	resolvers := make([]HostIdentifierResolver, 0, len(defaultHostIdentifierResolvers)+len(d.hostIdentifierResolvers))
+	resolvers = append(resolvers, defaultHostIdentifierResolvers...)
+	resolvers = append(resolvers, d.hostIdentifierResolvers...)
+
+	for _, resolve := range resolvers {
+		host, err := resolve(d.db, identifier)
+		if err != nil {
+			return nil, errors.Wrap(err, "get host by identifier")
+		}
+		if host == nil {
+			continue
+		}
+
+		if err := d.loadHostPackStats(host); err != nil {
+			return nil, err
+		}
+		return host, nil
 	}
 
-	return host, nil
+	return nil, notFound("Host").WithName(identifier)
 }
 
-func (d *Datastore) AddHostsToTeam(teamID *uint, hostIDs []uint) error {
+func (d *Datastore) AddHostsToTeam(teamID *uint, hostIDs []uint, refetchLabels bool) (fleet.HostTeamAssignment, error) {
+	var assignment fleet.HostTeamAssignment
 	if len(hostIDs) == 0 {
-		return nil
+		return assignment, nil
 	}
 
-	sql := `
-		UPDATE hosts SET team_id = ?
-		WHERE id IN (?)
-	`
-	sql, args, err := sqlx.In(sql, teamID, hostIDs)
+	var moved []*fleet.Host
+	err := d.withRetryTxx(func(tx *sqlx.Tx) error {
+		assignment = fleet.HostTeamAssignment{}
+		moved = nil
+
+		stmt, args, err := sqlx.In(`SELECT id, team_id FROM hosts WHERE id IN (?) FOR UPDATE`, hostIDs)
+		if err != nil {
+			return errors.Wrap(err, "sqlx.In select AddHostsToTeam")
+		}
+		var hosts []*fleet.Host
+		if err := tx.Select(&hosts, stmt, args...); err != nil {
+			return errors.Wrap(err, "select AddHostsToTeam")
+		}
+
+		var movedIDs []uint
+		for _, host := range hosts {
+			if fleet.SameTeam(host.TeamID, teamID) {
+				assignment.AlreadyAssigned++
+				continue
+			}
+			moved = append(moved, host)
+			movedIDs = append(movedIDs, host.ID)
+		}
+		if len(movedIDs) == 0 {
+			return nil
+		}
+
+		stmt = `
+			UPDATE hosts SET team_id = ?
+			WHERE id IN (?)
+		`
+		args = []interface{}{teamID, movedIDs}
+		if refetchLabels {
+			// Resetting label_updated_at causes the label queries to be sent on
+			// the next distributed read, like for a newly enrolled host.
+			stmt = `
+				UPDATE hosts SET team_id = ?, label_updated_at = ?
+				WHERE id IN (?)
+			`
+			args = []interface{}{teamID, hostLabelsNeverUpdated, movedIDs}
+		}
+		stmt, args, err = sqlx.In(stmt, args...)
+		if err != nil {
+			return errors.Wrap(err, "sqlx.In AddHostsToTeam")
+		}
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return errors.Wrap(err, "exec AddHostsToTeam")
+		}
+		assignment.Changed = uint(len(movedIDs))
+		return nil
+	})
 	if err != nil {
-		return errors.Wrap(err, "sqlx.In AddHostsToTeam")
+		return fleet.HostTeamAssignment{}, err
 	}
 
-	if _, err := d.db.Exec(sql, args...); err != nil {
-		return errors.Wrap(err, "exec AddHostsToTeam")
+	// Only the moved hosts have their team change recorded.
+	var tracked []string
+	for _, field := range d.trackedHostFields {
+		if field == "team_id" {
+			tracked = append(tracked, field)
+		}
+	}
+	for _, before := range moved {
+		if err := d.recordHostFieldChanges(before, &fleet.Host{ID: before.ID, TeamID: teamID}, tracked); err != nil {
+			return assignment, err
+		}
 	}
 
+	return assignment, nil
+}
+
+func (d *Datastore) SetHostOwner(hostID uint, email string) error {
+	result, err := d.db.Exec(`UPDATE hosts SET assigned_owner = ? WHERE id = ?`, email, hostID)
+	if err != nil {
+		return errors.Wrap(err, "set host owner")
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		// RowsAffected is also 0 if the owner didn't change, so check that
+		// the host exists.
+		var count int
+		if err := d.db.Get(&count, `SELECT COUNT(*) FROM hosts WHERE id = ?`, hostID); err != nil {
+			return errors.Wrap(err, "check host exists")
+		}
+		if count == 0 {
+			return notFound("Host").WithID(hostID)
+		}
+	}
 	return nil
 }
 
+func (d *Datastore) HostsByOwner(filter fleet.TeamFilter, email string) ([]*fleet.Host, error) {
+	sql := fmt.Sprintf(`
+		SELECT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
+		FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
+		WHERE h.assigned_owner = ? AND %s
+		ORDER BY h.id
+	`, d.whereFilterHostsByTeams(filter, "h"),
+	)
+	hosts := []*fleet.Host{}
+	if err := d.db.Select(&hosts, sql, email); err != nil {
+		return nil, errors.Wrap(err, "get hosts by owner")
+	}
+	return hosts, nil
+}
+
+// hostAnnotationBatchSize is the number of identifiers matched per query by
+// ApplyHostAnnotations. Each identifier is used for 4 parameters, keeping
+// queries well under the MySQL max number of parameters.
+const hostAnnotationBatchSize = 10000
+
+func (d *Datastore) ApplyHostAnnotations(annotations map[string]fleet.HostAnnotation) (int, []string, error) {
+	identifiers := make([]string, 0, len(annotations))
+	for identifier := range annotations {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Strings(identifiers)
+
+	var applied int
+	var unmatched []string
+	err := d.withRetryTxx(func(tx *sqlx.Tx) error {
+		applied, unmatched = 0, nil
+
+		// Match the identifiers like HostByIdentifier, preferring the oldest
+		// host when an identifier matches several hosts.
+		hostIDs := make(map[string]uint, len(identifiers))
+		for start := 0; start < len(identifiers); start += hostAnnotationBatchSize {
+			end := start + hostAnnotationBatchSize
+			if end > len(identifiers) {
+				end = len(identifiers)
+			}
+			batch := identifiers[start:end]
+
+			stmt, args, err := sqlx.In(`
+				SELECT id, hostname, osquery_host_id, node_key, uuid
+				FROM hosts
+				WHERE hostname IN (?) OR osquery_host_id IN (?) OR node_key IN (?) OR uuid IN (?)
+				ORDER BY id DESC`,
+				batch, batch, batch, batch,
+			)
+			if err != nil {
+				return errors.Wrap(err, "build match host identifiers query")
+			}
+			var hosts []fleet.Host
+			if err := tx.Select(&hosts, tx.Rebind(stmt), args...); err != nil {
+				return errors.Wrap(err, "match host identifiers")
+			}
+			for _, h := range hosts {
+				for _, identifier := range []string{h.Hostname, h.OsqueryHostID, h.NodeKey, h.UUID} {
+					if _, ok := annotations[identifier]; ok {
+						hostIDs[identifier] = h.ID
+					}
+				}
+			}
+		}
+
+		for _, identifier := range identifiers {
+			hostID, ok := hostIDs[identifier]
+			if !ok {
+				unmatched = append(unmatched, identifier)
+				continue
+			}
+			annotation := annotations[identifier]
+			_, err := tx.Exec(
+				`UPDATE hosts SET assigned_owner = COALESCE(?, assigned_owner), notes = COALESCE(?, notes) WHERE id = ?`,
+				annotation.Owner, annotation.Notes, hostID,
+			)
+			if err != nil {
+				return errors.Wrap(err, "apply host annotation")
+			}
+			applied++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return applied, unmatched, nil
+}
+
+func (d *Datastore) StalestHostsByPlatform(filter fleet.TeamFilter, limit int) (map[string][]*fleet.Host, error) {
+	stalest := map[string][]*fleet.Host{}
+	if limit <= 0 {
+		return stalest, nil
+	}
+
+	var platforms []string
+	sql := fmt.Sprintf(
+		`SELECT DISTINCT h.platform FROM hosts h WHERE %s`,
+		d.whereFilterHostsByTeams(filter, "h"),
+	)
+	if err := d.db.Select(&platforms, sql); err != nil {
+		return nil, errors.Wrap(err, "get host platforms")
+	}
+
+	// One query per platform, each bounded by the limit through the
+	// (platform, seen_time) index.
+	sql = fmt.Sprintf(`
+		SELECT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
+		FROM hosts h LEFT JOIN teams t ON (h.team_id = t.id)
+		WHERE h.platform = ? AND %s
+		ORDER BY h.seen_time, h.id
+		LIMIT ?
+	`, d.whereFilterHostsByTeams(filter, "h"),
+	)
+	for _, platform := range platforms {
+		hosts := []*fleet.Host{}
+		if err := d.db.Select(&hosts, sql, platform, limit); err != nil {
+			return nil, errors.Wrapf(err, "get stalest %s hosts", platform)
+		}
+		stalest[platform] = hosts
+	}
+	return stalest, nil
+}
+
+func (d *Datastore) SetHostTags(hostIDs []uint, tags map[string]string) error {
+	if len(hostIDs) == 0 || len(tags) == 0 {
+		return nil
+	}
+
+	var args []interface{}
+	for _, hostID := range hostIDs {
+		for name, value := range tags {
+			args = append(args, hostID, name, value)
+		}
+	}
+
+	return d.withRetryTxx(func(tx *sqlx.Tx) error {
+		// Insert in batches to stay under the placeholder limit in MySQL.
+		const batchSize = 3 * 10000
+		for rest := args; len(rest) > 0; {
+			batch := rest
+			if len(batch) > batchSize {
+				batch = rest[:batchSize]
+			}
+			rest = rest[len(batch):]
+
+			values := strings.TrimSuffix(strings.Repeat("(?,?,?),", len(batch)/3), ",")
+			sql := fmt.Sprintf(`
+				INSERT INTO host_tags (host_id, name, value)
+				VALUES %s
+				ON DUPLICATE KEY UPDATE value = VALUES(value)
+			`, values)
+			if _, err := tx.Exec(sql, batch...); err != nil {
+				return errors.Wrap(err, "insert host tags")
+			}
+		}
+		return nil
+	})
+}
+
+func (d *Datastore) ListHostTags(hostID uint) (map[string]string, error) {
+	var rows []struct {
+		Name  string `db:"name"`
+		Value string `db:"value"`
+	}
+	if err := d.db.Select(&rows, `SELECT name, value FROM host_tags WHERE host_id = ?`, hostID); err != nil {
+		return nil, errors.Wrap(err, "list host tags")
+	}
+	tags := make(map[string]string, len(rows))
+	for _, row := range rows {
+		tags[row.Name] = row.Value
+	}
+	return tags, nil
+}
+
 func (d *Datastore) SaveHostAdditional(host *fleet.Host) error {
 	sql := `
 		INSERT INTO host_additional (host_id, additional)
@@ -800,7 +2764,7 @@ func (d *Datastore) SaveHostUsers(host *fleet.Host) error {
 	}
 
 	currentHost := &fleet.Host{ID: host.ID}
-	if err := d.loadHostUsers(currentHost); err != nil {
+	if err := d.LoadHostUsers(currentHost); err != nil {
 		return err
 	}
 
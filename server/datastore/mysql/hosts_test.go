@@ -13,6 +13,7 @@ import (
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/test"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -218,6 +219,140 @@ func TestSaveHostPackStats(t *testing.T) {
 	require.Len(t, host.PackStats, 0)
 }
 
+func TestHostScheduledQueryStats(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host := test.NewHost(t, ds, "foo.local", "192.168.1.1", "1", "1", time.Now())
+
+	pack1 := test.NewPack(t, ds, "test1")
+	pack2 := test.NewPack(t, ds, "test2")
+	query := test.NewQuery(t, ds, "time", "select * from time", 0, true)
+	squery1 := test.NewScheduledQuery(t, ds, pack1.ID, query.ID, 30, true, true, "time-scheduled")
+	squery2 := test.NewScheduledQuery(t, ds, pack2.ID, query.ID, 30, true, true, "time-scheduled")
+	test.NewScheduledQuery(t, ds, pack2.ID, query.ID, 30, true, true, "time-never-run")
+
+	host.PackStats = []fleet.PackStats{
+		{
+			PackName: pack1.Name,
+			QueryStats: []fleet.ScheduledQueryStats{{
+				ScheduledQueryName: squery1.Name,
+				PackName:           pack1.Name,
+				Executions:         10,
+				LastExecuted:       time.Unix(1620325191, 0).UTC(),
+				WallTime:           200,
+			}},
+		},
+		{
+			PackName: pack2.Name,
+			QueryStats: []fleet.ScheduledQueryStats{{
+				ScheduledQueryName: squery2.Name,
+				PackName:           pack2.Name,
+				Executions:         3,
+				LastExecuted:       time.Unix(1620325291, 0).UTC(),
+				WallTime:           1500,
+			}},
+		},
+	}
+	require.NoError(t, ds.SaveHost(host))
+
+	// The latest execution of the query scheduled in both packs
+	stats, err := ds.HostScheduledQueryStats(host.ID, "time-scheduled")
+	require.NoError(t, err)
+	assert.True(t, stats.Found)
+	assert.Equal(t, squery2.ID, stats.ScheduledQueryID)
+	assert.Equal(t, pack2.Name, stats.PackName)
+	assert.Equal(t, 3, stats.Executions)
+	assert.Equal(t, 1500, stats.WallTime)
+	assert.Equal(t, time.Unix(1620325291, 0).UTC(), stats.LastExecuted)
+
+	stats, err = ds.HostScheduledQueryStats(host.ID, "time-never-run")
+	require.NoError(t, err)
+	assert.Equal(t, fleet.ScheduledQueryStats{ScheduledQueryName: "time-never-run"}, stats)
+	assert.False(t, stats.Found)
+
+	other := test.NewHost(t, ds, "bar.local", "192.168.1.2", "2", "2", time.Now())
+	stats, err = ds.HostScheduledQueryStats(other.ID, "time-scheduled")
+	require.NoError(t, err)
+	assert.False(t, stats.Found)
+	assert.Zero(t, stats.Executions)
+}
+
+func TestSaveHostPrimaryInterface(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host, err := ds.NewHost(&fleet.Host{
+		DetailUpdatedAt: time.Now(),
+		LabelUpdatedAt:  time.Now(),
+		SeenTime:        time.Now(),
+		NodeKey:         "1",
+		UUID:            "1",
+		Hostname:        "foo.local",
+	})
+	require.NoError(t, err)
+
+	nics := []*fleet.NetworkInterface{
+		{IPAddress: "127.0.0.1", MAC: "00:00:00:00:00:00"},
+		{IPAddress: "10.0.0.2", MAC: "30-65-EC-6F-C4-58"},
+		{IPAddress: "10.0.0.3", MAC: "30-65-EC-6F-C4-59"},
+	}
+
+	// The primary IP is picked from the reported interfaces
+	host.NetworkInterfaces = nics
+	require.NoError(t, ds.SaveHost(host))
+	host, err = ds.Host(host.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", host.PrimaryIP)
+	assert.Equal(t, "30-65-EC-6F-C4-58", host.PrimaryMac)
+
+	// Saving without interfaces keeps the primary IP
+	require.NoError(t, ds.SaveHost(host))
+	host, err = ds.Host(host.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", host.PrimaryIP)
+
+	// The interface holding the default route overrides the heuristic
+	nics[2].DefaultRoute = true
+	host.NetworkInterfaces = nics
+	require.NoError(t, ds.SaveHost(host))
+	host, err = ds.Host(host.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.3", host.PrimaryIP)
+	assert.Equal(t, "30-65-EC-6F-C4-59", host.PrimaryMac)
+}
+
+func TestSaveHostFields(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	// A concurrent updater saves the hardware details
+	detailed, err := ds.Host(host.ID)
+	require.NoError(t, err)
+	detailed.HardwareSerial = "serial"
+	detailed.HardwareModel = "model"
+	require.NoError(t, ds.SaveHostFields(detailed, []string{"hardware_serial", "hardware_model"}))
+
+	// The stale host only saves the label update time
+	labelUpdatedAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	host.LabelUpdatedAt = labelUpdatedAt
+	host.Hostname = "ignored.local"
+	require.NoError(t, ds.SaveHostFields(host, []string{"label_updated_at"}))
+
+	saved, err := ds.Host(host.ID)
+	require.NoError(t, err)
+	assert.Equal(t, labelUpdatedAt, saved.LabelUpdatedAt.UTC())
+	assert.Equal(t, "serial", saved.HardwareSerial)
+	assert.Equal(t, "model", saved.HardwareModel)
+	assert.Equal(t, "foo.local", saved.Hostname)
+
+	assert.Error(t, ds.SaveHostFields(host, nil))
+	assert.Error(t, ds.SaveHostFields(host, []string{"label_updated_at", "id"}))
+	assert.Error(t, ds.SaveHostFields(host, []string{"hostname = 'x', platform"}))
+}
+
 func TestDeleteHost(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
@@ -240,6 +375,79 @@ func TestDeleteHost(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestDeleteHostWithReason(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	before := time.Now().Add(-time.Minute)
+	host := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "uuid-1", time.Now())
+	other := test.NewHost(t, ds, "bar.local", "192.168.1.11", "2", "uuid-2", time.Now())
+
+	require.NoError(t, ds.DeleteHostWithReason(host.ID, "decommissioned"))
+	_, err := ds.Host(host.ID)
+	assert.True(t, fleet.IsNotFound(err))
+
+	err = ds.DeleteHostWithReason(host.ID, "again")
+	assert.True(t, fleet.IsNotFound(err))
+
+	require.NoError(t, ds.DeleteHostWithReason(other.ID, ""))
+
+	deletions, err := ds.ListHostDeletions(before)
+	require.NoError(t, err)
+	require.Len(t, deletions, 2)
+	assert.Equal(t, host.ID, deletions[0].HostID)
+	assert.Equal(t, "foo.local", deletions[0].Hostname)
+	assert.Equal(t, "uuid-1", deletions[0].UUID)
+	assert.Equal(t, "decommissioned", deletions[0].Reason)
+	assert.False(t, deletions[0].DeletedAt.IsZero())
+	assert.Equal(t, other.ID, deletions[1].HostID)
+	assert.Equal(t, "", deletions[1].Reason)
+
+	deletions, err = ds.ListHostDeletions(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, deletions, 0)
+}
+
+func TestHostOrphanCheck(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	test.AddAllHostsLabel(t, ds)
+	host, err := ds.EnrollHost("1", "1", nil, 0, fleet.EnrollHostOptions{})
+	require.NoError(t, err)
+
+	host.Software = []fleet.Software{{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"}}
+	host.HostSoftware.Modified = true
+	host.Users = []fleet.HostUser{{Uid: 42, Username: "user"}}
+	host.Modified = true
+	require.NoError(t, ds.SaveHost(host))
+
+	_, err = ds.NewCarve(&fleet.CarveMetadata{
+		HostId:    host.ID,
+		Name:      "foobar",
+		CarveId:   "carve_id",
+		RequestId: "request_id",
+		SessionId: "session_id",
+		CreatedAt: mockCreatedAt,
+	}, 0)
+	require.NoError(t, err)
+
+	counts, err := ds.HostOrphanCheck(host.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, counts["host_software"])
+	assert.Equal(t, 1, counts["host_users"])
+	assert.Equal(t, 1, counts["label_membership"])
+	assert.Equal(t, 1, counts["carve_metadata"])
+
+	require.NoError(t, ds.DeleteHost(host.ID))
+
+	counts, err = ds.HostOrphanCheck(host.ID)
+	require.NoError(t, err)
+	for table, count := range counts {
+		assert.Zero(t, count, table)
+	}
+}
+
 func testListHosts(t *testing.T, ds fleet.Datastore) {
 	hosts := []*fleet.Host{}
 	for i := 0; i < 10; i++ {
@@ -372,6 +580,205 @@ func TestListHostsStatus(t *testing.T) {
 	assert.Equal(t, 10, len(hosts))
 }
 
+func TestListHostsUptime(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := ds.NewHost(&fleet.Host{
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			SeenTime:        time.Now(),
+			OsqueryHostID:   strconv.Itoa(i),
+			NodeKey:         fmt.Sprintf("%d", i),
+			UUID:            fmt.Sprintf("%d", i),
+			Hostname:        fmt.Sprintf("foo.local%d", i),
+			// Host 0 reports no uptime, the rest are up for i days
+			Uptime: time.Duration(i) * 24 * time.Hour,
+		})
+		require.NoError(t, err)
+	}
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+
+	hosts, err := ds.ListHosts(filter, fleet.HostListOptions{MinUptime: 2 * 24 * time.Hour})
+	require.NoError(t, err)
+	assert.Len(t, hosts, 3)
+
+	hosts, err = ds.ListHosts(filter, fleet.HostListOptions{MaxUptime: 2 * 24 * time.Hour})
+	require.NoError(t, err)
+	// Zero uptime is excluded
+	assert.Len(t, hosts, 2)
+
+	hosts, err = ds.ListHosts(filter, fleet.HostListOptions{MinUptime: 2 * 24 * time.Hour, MaxUptime: 3 * 24 * time.Hour})
+	require.NoError(t, err)
+	assert.Len(t, hosts, 2)
+
+	hosts, err = ds.ListHosts(filter, fleet.HostListOptions{
+		ListOptions: fleet.ListOptions{OrderKey: "uptime", OrderDirection: fleet.OrderDescending},
+	})
+	require.NoError(t, err)
+	require.Len(t, hosts, 5)
+	assert.Equal(t, 4*24*time.Hour, hosts[0].Uptime)
+	assert.Equal(t, time.Duration(0), hosts[4].Uptime)
+}
+
+func TestListHostsNoTeam(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 4; i++ {
+		h, err := ds.NewHost(&fleet.Host{
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			// Host 0 is new and online, the rest are MIA
+			SeenTime:      time.Now().Add(-time.Duration(i) * 31 * 24 * time.Hour),
+			OsqueryHostID: strconv.Itoa(i),
+			NodeKey:       fmt.Sprintf("%d", i),
+			UUID:          fmt.Sprintf("%d", i),
+			Hostname:      fmt.Sprintf("foo.local%d", i),
+		})
+		require.NoError(t, err)
+		hosts = append(hosts, h)
+	}
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{hosts[0].ID, hosts[1].ID}, false)
+	require.NoError(t, err)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+
+	listed, err := ds.ListHosts(filter, fleet.HostListOptions{NoTeam: true})
+	require.NoError(t, err)
+	var ids []uint
+	for _, h := range listed {
+		ids = append(ids, h.ID)
+		assert.Nil(t, h.TeamID)
+	}
+	assert.ElementsMatch(t, []uint{hosts[2].ID, hosts[3].ID}, ids)
+
+	// Composes with the status filter
+	listed, err = ds.ListHosts(filter, fleet.HostListOptions{NoTeam: true, StatusFilter: fleet.StatusMIA})
+	require.NoError(t, err)
+	assert.Len(t, listed, 2)
+	listed, err = ds.ListHosts(filter, fleet.HostListOptions{NoTeam: true, StatusFilter: fleet.StatusOnline})
+	require.NoError(t, err)
+	assert.Len(t, listed, 0)
+
+	listed, err = ds.ListHosts(filter, fleet.HostListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, listed, 4)
+}
+
+func TestListHostsOsqueryVersion(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	versions := []string{"", "3.3.2", "4.6.0", "4.9.0-dev", "4.10.1", "5.0.1", "5"}
+	hosts := map[string]uint{}
+	for i, v := range versions {
+		h, err := ds.NewHost(&fleet.Host{
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			SeenTime:        time.Now(),
+			OsqueryHostID:   strconv.Itoa(i),
+			NodeKey:         fmt.Sprintf("%d", i),
+			UUID:            fmt.Sprintf("%d", i),
+			Hostname:        fmt.Sprintf("foo.local%d", i),
+			OsqueryVersion:  v,
+		})
+		require.NoError(t, err)
+		hosts[v] = h.ID
+	}
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	list := func(constraint string, empty bool) []uint {
+		opt := fleet.HostListOptions{OsqueryVersionEmpty: empty}
+		if constraint != "" {
+			constraints, err := fleet.ParseOsqueryVersionConstraints(constraint)
+			require.NoError(t, err)
+			opt.OsqueryVersionConstraints = constraints
+		}
+		listed, err := ds.ListHosts(filter, opt)
+		require.NoError(t, err)
+		var ids []uint
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	assert.ElementsMatch(t,
+		[]uint{hosts["3.3.2"], hosts["4.6.0"], hosts["4.9.0-dev"], hosts["4.10.1"]},
+		list("<5.0.0", false),
+	)
+	assert.ElementsMatch(t,
+		[]uint{hosts["4.6.0"], hosts["4.9.0-dev"], hosts["4.10.1"]},
+		list(">=4.6, <5", false),
+	)
+	assert.ElementsMatch(t, []uint{hosts["4.9.0-dev"]}, list("=4.9.0", false))
+	assert.ElementsMatch(t, []uint{hosts["5"]}, list("5.0.0", false))
+	assert.ElementsMatch(t, []uint{hosts[""]}, list("", true))
+	assert.ElementsMatch(t, []uint{hosts[""], hosts["3.3.2"]}, list("<4", true))
+
+	// Matches the in-memory comparison
+	constraints, err := fleet.ParseOsqueryVersionConstraints("<4.10")
+	require.NoError(t, err)
+	var expected []uint
+	for v, id := range hosts {
+		if v != "" && constraints[0].Matches(v) {
+			expected = append(expected, id)
+		}
+	}
+	assert.ElementsMatch(t, expected, list("<4.10", false))
+}
+
+func TestListHostsFields(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h, err := ds.NewHost(&fleet.Host{
+		DetailUpdatedAt: time.Now(),
+		LabelUpdatedAt:  time.Now(),
+		SeenTime:        time.Now(),
+		OsqueryHostID:   "1",
+		NodeKey:         "1",
+		UUID:            "1",
+		Hostname:        "foo.local",
+		PrimaryIP:       "192.168.1.1",
+		OsqueryVersion:  "4.8.0",
+	})
+	require.NoError(t, err)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+
+	hosts, err := ds.ListHosts(filter, fleet.HostListOptions{Fields: []string{"hostname", "primary_ip"}})
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	assert.Equal(t, h.ID, hosts[0].ID)
+	assert.Equal(t, "foo.local", hosts[0].Hostname)
+	assert.Equal(t, "192.168.1.1", hosts[0].PrimaryIP)
+	assert.Empty(t, hosts[0].OsqueryVersion)
+	assert.Empty(t, hosts[0].UUID)
+
+	// Filters still apply to columns that aren't selected
+	hosts, err = ds.ListHosts(filter, fleet.HostListOptions{
+		Fields:      []string{"id"},
+		ListOptions: fleet.ListOptions{MatchQuery: "foo"},
+	})
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	assert.Equal(t, h.ID, hosts[0].ID)
+	assert.Empty(t, hosts[0].Hostname)
+
+	_, err = ds.ListHosts(filter, fleet.HostListOptions{Fields: []string{"hostname", "node_key"}})
+	require.Error(t, err)
+	_, err = ds.ListHosts(filter, fleet.HostListOptions{Fields: []string{"hostname; DROP TABLE hosts"}})
+	require.Error(t, err)
+}
+
 func TestListHostsQuery(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
@@ -458,18 +865,18 @@ func TestEnrollHost(t *testing.T) {
 	}
 
 	for _, tt := range enrollTests {
-		h, err := ds.EnrollHost(tt.uuid, tt.nodeKey, &team.ID, 0)
+		h, err := ds.EnrollHost(tt.uuid, tt.nodeKey, &team.ID, 0, fleet.EnrollHostOptions{})
 		require.Nil(t, err)
 
 		assert.Equal(t, tt.uuid, h.OsqueryHostID)
 		assert.Equal(t, tt.nodeKey, h.NodeKey)
 
 		// This host should be allowed to re-enroll immediately if cooldown is disabled
-		_, err = ds.EnrollHost(tt.uuid, tt.nodeKey+"new", nil, 0)
+		_, err = ds.EnrollHost(tt.uuid, tt.nodeKey+"new", nil, 0, fleet.EnrollHostOptions{})
 		require.NoError(t, err)
 
 		// This host should not be allowed to re-enroll immediately if cooldown is enabled
-		_, err = ds.EnrollHost(tt.uuid, tt.nodeKey+"new", nil, 10*time.Second)
+		_, err = ds.EnrollHost(tt.uuid, tt.nodeKey+"new", nil, 10*time.Second, fleet.EnrollHostOptions{})
 		require.Error(t, err)
 	}
 
@@ -481,66 +888,383 @@ func TestEnrollHost(t *testing.T) {
 	}
 }
 
-func TestAuthenticateHost(t *testing.T) {
+func TestEnrollHostStrategy(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
 
 	test.AddAllHostsLabel(t, ds)
-	for _, tt := range enrollTests {
-		h, err := ds.EnrollHost(tt.uuid, tt.nodeKey, nil, 0)
-		require.Nil(t, err)
 
-		returned, err := ds.AuthenticateHost(h.NodeKey)
-		require.NoError(t, err)
-		assert.Equal(t, h.NodeKey, returned.NodeKey)
-	}
+	h, err := ds.EnrollHost("host1", "key1", nil, 0, fleet.EnrollHostOptions{Strategy: fleet.EnrollStrategyReuse})
+	require.NoError(t, err)
+	h.Hostname = "foo.local"
+	h.Software = []fleet.Software{{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"}}
+	h.HostSoftware.Modified = true
+	require.NoError(t, ds.SaveHost(h))
 
-	_, err := ds.AuthenticateHost("7B1A9DC9-B042-489F-8D5A-EEC2412C95AA")
+	// Reuse keeps the host and its details
+	reused, err := ds.EnrollHost("host1", "key2", nil, 0, fleet.EnrollHostOptions{Strategy: fleet.EnrollStrategyReuse})
+	require.NoError(t, err)
+	assert.Equal(t, h.ID, reused.ID)
+	assert.Equal(t, "key2", reused.NodeKey)
+	assert.Equal(t, "foo.local", reused.Hostname)
+
+	// Reset enrolls a new host without the details
+	reset, err := ds.EnrollHost("host1", "key3", nil, 0, fleet.EnrollHostOptions{Strategy: fleet.EnrollStrategyReset})
+	require.NoError(t, err)
+	assert.NotEqual(t, h.ID, reset.ID)
+	assert.Equal(t, "key3", reset.NodeKey)
+	assert.Empty(t, reset.Hostname)
+
+	_, err = ds.Host(h.ID)
 	assert.Error(t, err)
+	counts, err := ds.HostOrphanCheck(h.ID)
+	require.NoError(t, err)
+	for table, count := range counts {
+		assert.Zero(t, count, table)
+	}
+	require.NoError(t, ds.LoadHostSoftware(reset))
+	assert.Empty(t, reset.Software)
 
-	_, err = ds.AuthenticateHost("")
+	_, err = ds.EnrollHost("host1", "key4", nil, 0, fleet.EnrollHostOptions{Strategy: "replace"})
 	assert.Error(t, err)
 }
 
-func TestAuthenticateHostCaseSensitive(t *testing.T) {
+func TestEnrollHostDedup(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
 
 	test.AddAllHostsLabel(t, ds)
-	for _, tt := range enrollTests {
-		h, err := ds.EnrollHost(tt.uuid, tt.nodeKey, nil, 0)
-		require.Nil(t, err)
 
-		_, err = ds.AuthenticateHost(strings.ToUpper(h.NodeKey))
-		require.Error(t, err, "node key authentication should be case sensitive")
-	}
+	ds.enrollDedupColumn = "hardware_serial"
+	ds.enrollDedupWindow = 10 * time.Minute
+
+	opt := fleet.EnrollHostOptions{Strategy: fleet.EnrollStrategyReset, UUID: "uuid1", HardwareSerial: "serial1"}
+	h, err := ds.EnrollHost("instance1", "key1", nil, 0, opt)
+	require.NoError(t, err)
+	assert.Equal(t, "uuid1", h.UUID)
+	assert.Equal(t, "serial1", h.HardwareSerial)
+	h.Hostname = "foo.local"
+	require.NoError(t, ds.SaveHost(h))
+
+	// Enrolling again within the window with the same identifier isn't reset
+	again, err := ds.EnrollHost("instance1", "key2", nil, 0, opt)
+	require.NoError(t, err)
+	assert.Equal(t, h.ID, again.ID)
+	assert.Equal(t, "key2", again.NodeKey)
+	assert.Equal(t, "foo.local", again.Hostname)
+
+	// A changed identifier with the same serial collapses onto the host
+	collapsed, err := ds.EnrollHost("instance2", "key3", nil, 0, opt)
+	require.NoError(t, err)
+	assert.Equal(t, h.ID, collapsed.ID)
+	assert.Equal(t, "key3", collapsed.NodeKey)
+	assert.Equal(t, "instance2", collapsed.OsqueryHostID)
+	assert.Equal(t, "foo.local", collapsed.Hostname)
+
+	// The reported serial is matched, not the identifier
+	other, err := ds.EnrollHost("serial1", "key4", nil, 0, fleet.EnrollHostOptions{HardwareSerial: "serial2"})
+	require.NoError(t, err)
+	assert.NotEqual(t, h.ID, other.ID)
+	// Without a reported serial, there is nothing to match
+	other, err = ds.EnrollHost("instance3", "key5", nil, 0, fleet.EnrollHostOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, h.ID, other.ID)
+
+	hosts, err := ds.ListHosts(fleet.TeamFilter{User: test.UserAdmin}, fleet.HostListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, hosts, 3)
+
+	// Outside of the window, the enroll strategy applies
+	_, err = ds.db.Exec(`UPDATE hosts SET last_enrolled_at = ? WHERE id = ?`, time.Now().Add(-time.Hour), h.ID)
+	require.NoError(t, err)
+	reset, err := ds.EnrollHost("instance2", "key6", nil, 0, opt)
+	require.NoError(t, err)
+	assert.NotEqual(t, h.ID, reset.ID)
+	assert.Empty(t, reset.Hostname)
+
+	// The uuid is matched the same way
+	ds.enrollDedupColumn = "uuid"
+	collapsed, err = ds.EnrollHost("instance4", "key7", nil, 0, fleet.EnrollHostOptions{UUID: "uuid1"})
+	require.NoError(t, err)
+	assert.Equal(t, reset.ID, collapsed.ID)
+	assert.Equal(t, "instance4", collapsed.OsqueryHostID)
+	assert.Equal(t, "serial1", collapsed.HardwareSerial)
+
+	require.Error(t, EnrollDedup("hostname", time.Minute)(&dbOptions{}))
+	require.NoError(t, EnrollDedup("hardware_serial", time.Minute)(&dbOptions{}))
 }
 
-func TestSearchHosts(t *testing.T) {
+func TestEnrollHostEnrolledFromIP(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
 
-	_, err := ds.NewHost(&fleet.Host{
-		OsqueryHostID:   "1234",
-		DetailUpdatedAt: time.Now(),
-		LabelUpdatedAt:  time.Now(),
-		SeenTime:        time.Now(),
-		NodeKey:         "1",
-		UUID:            "1",
-		Hostname:        "foo.local",
-	})
-	require.Nil(t, err)
+	test.AddAllHostsLabel(t, ds)
 
-	h2, err := ds.NewHost(&fleet.Host{
-		OsqueryHostID:   "5679",
-		DetailUpdatedAt: time.Now(),
-		LabelUpdatedAt:  time.Now(),
-		SeenTime:        time.Now(),
-		NodeKey:         "2",
-		UUID:            "2",
-		Hostname:        "bar.local",
-	})
-	require.Nil(t, err)
+	h, err := ds.EnrollHost("host1", "key1", nil, 0, fleet.EnrollHostOptions{EnrolledFromIP: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", h.EnrolledFromIP)
+	_, err = ds.EnrollHost("host2", "key2", nil, 0, fleet.EnrollHostOptions{EnrolledFromIP: "10.0.0.2"})
+	require.NoError(t, err)
+
+	// Re-enrollment records the latest IP
+	h, err = ds.EnrollHost("host1", "key1new", nil, 0, fleet.EnrollHostOptions{EnrolledFromIP: "192.168.1.1"})
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.1", h.EnrolledFromIP)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	hosts, err := ds.ListHosts(filter, fleet.HostListOptions{EnrolledFromIP: "192.168.1.1"})
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	assert.Equal(t, h.ID, hosts[0].ID)
+
+	hosts, err = ds.ListHosts(filter, fleet.HostListOptions{EnrolledFromIP: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.Len(t, hosts, 0)
+}
+
+func TestEnrollHostInitialLabels(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	test.AddAllHostsLabel(t, ds)
+
+	manual, err := ds.NewLabel(&fleet.Label{
+		Name:                "manual",
+		LabelMembershipType: fleet.LabelMembershipTypeManual,
+	})
+	require.NoError(t, err)
+	dynamic, err := ds.NewLabel(&fleet.Label{
+		Name:  "dynamic",
+		Query: "select 1",
+	})
+	require.NoError(t, err)
+
+	h, err := ds.EnrollHost("host1", "key1", nil, 0, fleet.EnrollHostOptions{InitialLabelIDs: []uint{manual.ID}})
+	require.NoError(t, err)
+
+	labels, err := ds.ListLabelsForHost(h.ID)
+	require.NoError(t, err)
+	var names []string
+	for _, l := range labels {
+		names = append(names, l.Name)
+	}
+	assert.ElementsMatch(t, []string{"All Hosts", "manual"}, names)
+
+	// Dynamic and unknown labels fail the enrollment
+	_, err = ds.EnrollHost("host2", "key2", nil, 0, fleet.EnrollHostOptions{InitialLabelIDs: []uint{manual.ID, dynamic.ID}})
+	require.Error(t, err)
+	_, err = ds.EnrollHost("host3", "key3", nil, 0, fleet.EnrollHostOptions{InitialLabelIDs: []uint{manual.ID + 1000}})
+	require.Error(t, err)
+
+	_, err = ds.AuthenticateHost("key2")
+	assert.True(t, fleet.IsNotFound(err))
+}
+
+func TestAuthenticateHost(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	test.AddAllHostsLabel(t, ds)
+	for _, tt := range enrollTests {
+		h, err := ds.EnrollHost(tt.uuid, tt.nodeKey, nil, 0, fleet.EnrollHostOptions{})
+		require.Nil(t, err)
+
+		returned, err := ds.AuthenticateHost(h.NodeKey)
+		require.NoError(t, err)
+		assert.Equal(t, h.NodeKey, returned.NodeKey)
+	}
+
+	_, err := ds.AuthenticateHost("7B1A9DC9-B042-489F-8D5A-EEC2412C95AA")
+	assert.Error(t, err)
+
+	_, err = ds.AuthenticateHost("")
+	assert.Error(t, err)
+}
+
+func TestEnrollHostByHardware(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	test.AddAllHostsLabel(t, ds)
+
+	h, err := ds.EnrollHostByHardware("host1", "serial1-board1", "key1", nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "serial1-board1", h.HardwareFingerprint)
+	h.Hostname = "foo.local"
+	require.NoError(t, ds.SaveHost(h))
+
+	// The reimaged machine enrolls with a new osquery identifier
+	reimaged, err := ds.EnrollHostByHardware("host1-reimaged", "serial1-board1", "key2", nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, h.ID, reimaged.ID)
+	assert.Equal(t, "host1-reimaged", reimaged.OsqueryHostID)
+	assert.Equal(t, "key2", reimaged.NodeKey)
+	assert.Equal(t, "foo.local", reimaged.Hostname)
+
+	// Other hardware enrolls a new host
+	other, err := ds.EnrollHostByHardware("host2", "serial2-board2", "key3", nil, 0)
+	require.NoError(t, err)
+	assert.NotEqual(t, h.ID, other.ID)
+
+	// An empty fingerprint matches by osquery identifier only, keeping the
+	// existing fingerprint
+	again, err := ds.EnrollHostByHardware("host2", "", "key4", nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, other.ID, again.ID)
+	assert.Equal(t, "serial2-board2", again.HardwareFingerprint)
+
+	fresh, err := ds.EnrollHostByHardware("host3", "", "key5", nil, 0)
+	require.NoError(t, err)
+	assert.NotEqual(t, h.ID, fresh.ID)
+	assert.NotEqual(t, other.ID, fresh.ID)
+	assert.Empty(t, fresh.HardwareFingerprint)
+
+	// A known host without a fingerprint gets one on its next enrollment
+	fresh, err = ds.EnrollHostByHardware("host3", "serial3-board3", "key6", nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "serial3-board3", fresh.HardwareFingerprint)
+
+	// The cooldown applies to hosts matched by hardware
+	_, err = ds.EnrollHostByHardware("host1-again", "serial1-board1", "key7", nil, time.Hour)
+	assert.Error(t, err)
+
+	hosts, err := ds.ListHosts(fleet.TeamFilter{User: test.UserAdmin}, fleet.HostListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, hosts, 3)
+}
+
+func TestDecommissionHost(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	mockClock := clock.NewMockClock()
+	ds.clock = mockClock
+
+	test.AddAllHostsLabel(t, ds)
+	h, err := ds.EnrollHost("host1", "key1", nil, 0, fleet.EnrollHostOptions{})
+	require.NoError(t, err)
+	other, err := ds.EnrollHost("host2", "key2", nil, 0, fleet.EnrollHostOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, ds.DecommissionHost(h.ID))
+	// Decommissioning again is a no-op
+	require.NoError(t, ds.DecommissionHost(h.ID))
+
+	_, err = ds.AuthenticateHost("key1")
+	assert.Equal(t, fleet.ErrHostDecommissioned, err)
+	_, err = ds.EnrollHost("host1", "key3", nil, 0, fleet.EnrollHostOptions{})
+	assert.Equal(t, fleet.ErrHostDecommissioned, err)
+	_, err = ds.EnrollHost("host1", "key3", nil, 0, fleet.EnrollHostOptions{Strategy: fleet.EnrollStrategyReset})
+	assert.Equal(t, fleet.ErrHostDecommissioned, err)
+
+	// The host is kept
+	h, err = ds.Host(h.ID)
+	require.NoError(t, err)
+	require.NotNil(t, h.DecommissionedAt)
+	assert.Equal(t, mockClock.Now().UTC().Truncate(time.Second), h.DecommissionedAt.UTC())
+	assert.NotEqual(t, "key1", h.NodeKey)
+
+	// Decommissioned hosts are excluded from listings and summaries
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	hosts, err := ds.ListHosts(filter, fleet.HostListOptions{})
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	assert.Equal(t, other.ID, hosts[0].ID)
+	hosts, err = ds.ListHosts(filter, fleet.HostListOptions{IncludeDecommissioned: true})
+	require.NoError(t, err)
+	assert.Len(t, hosts, 2)
+
+	var iterated []uint
+	require.NoError(t, ds.IterHosts(filter, fleet.HostListOptions{}, func(h *fleet.Host) error {
+		iterated = append(iterated, h.ID)
+		return nil
+	}))
+	assert.Equal(t, []uint{other.ID}, iterated)
+
+	summary, err := ds.GenerateHostStatusStatistics(filter, mockClock.Now())
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), summary.TotalCount)
+
+	labels, err := ds.ListLabels(filter, fleet.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, labels, 1)
+	assert.Equal(t, 1, labels[0].HostCount)
+
+	// Other hosts are unaffected
+	returned, err := ds.AuthenticateHost("key2")
+	require.NoError(t, err)
+	assert.Equal(t, other.ID, returned.ID)
+	assert.Nil(t, returned.DecommissionedAt)
+
+	err = ds.DecommissionHost(h.ID + other.ID)
+	assert.True(t, fleet.IsNotFound(err))
+}
+
+func TestHostExists(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h1 := test.NewHost(t, ds, "foo.local", "", "1", "1", time.Now())
+	h2 := test.NewHost(t, ds, "bar.local", "", "2", "2", time.Now())
+
+	exists, err := ds.HostExists(h1.ID)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	require.NoError(t, ds.DecommissionHost(h1.ID))
+	exists, err = ds.HostExists(h1.ID)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, ds.DeleteHost(h2.ID))
+	exists, err = ds.HostExists(h2.ID)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	exists, err = ds.HostExists(999)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestAuthenticateHostCaseSensitive(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	test.AddAllHostsLabel(t, ds)
+	for _, tt := range enrollTests {
+		h, err := ds.EnrollHost(tt.uuid, tt.nodeKey, nil, 0, fleet.EnrollHostOptions{})
+		require.Nil(t, err)
+
+		_, err = ds.AuthenticateHost(strings.ToUpper(h.NodeKey))
+		require.Error(t, err, "node key authentication should be case sensitive")
+	}
+}
+
+func TestSearchHosts(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	_, err := ds.NewHost(&fleet.Host{
+		OsqueryHostID:   "1234",
+		DetailUpdatedAt: time.Now(),
+		LabelUpdatedAt:  time.Now(),
+		SeenTime:        time.Now(),
+		NodeKey:         "1",
+		UUID:            "1",
+		Hostname:        "foo.local",
+	})
+	require.Nil(t, err)
+
+	h2, err := ds.NewHost(&fleet.Host{
+		OsqueryHostID:   "5679",
+		DetailUpdatedAt: time.Now(),
+		LabelUpdatedAt:  time.Now(),
+		SeenTime:        time.Now(),
+		NodeKey:         "2",
+		UUID:            "2",
+		Hostname:        "bar.local",
+	})
+	require.Nil(t, err)
 
 	h3, err := ds.NewHost(&fleet.Host{
 		OsqueryHostID:   "99999",
@@ -558,29 +1282,29 @@ func TestSearchHosts(t *testing.T) {
 
 	// We once threw errors when the search query was empty. Verify that we
 	// don't error.
-	_, err = ds.SearchHosts(filter, "")
+	_, err = ds.SearchHosts(filter, "", 0)
 	require.Nil(t, err)
 
-	hosts, err := ds.SearchHosts(filter, "foo")
+	hosts, err := ds.SearchHosts(filter, "foo", 0)
 	assert.Nil(t, err)
 	assert.Len(t, hosts, 2)
 
-	host, err := ds.SearchHosts(filter, "foo", h3.ID)
+	host, err := ds.SearchHosts(filter, "foo", 0, h3.ID)
 	require.Nil(t, err)
 	require.Len(t, host, 1)
 	assert.Equal(t, "foo.local", host[0].Hostname)
 
-	host, err = ds.SearchHosts(filter, "foo", h3.ID, h2.ID)
+	host, err = ds.SearchHosts(filter, "foo", 0, h3.ID, h2.ID)
 	require.Nil(t, err)
 	require.Len(t, host, 1)
 	assert.Equal(t, "foo.local", host[0].Hostname)
 
-	host, err = ds.SearchHosts(filter, "abc")
+	host, err = ds.SearchHosts(filter, "abc", 0)
 	require.Nil(t, err)
 	require.Len(t, host, 1)
 	assert.Equal(t, "abc-def-ghi", host[0].UUID)
 
-	none, err := ds.SearchHosts(filter, "xxx")
+	none, err := ds.SearchHosts(filter, "xxx", 0)
 	assert.Nil(t, err)
 	assert.Len(t, none, 0)
 
@@ -589,21 +1313,21 @@ func TestSearchHosts(t *testing.T) {
 	err = ds.SaveHost(h2)
 	require.Nil(t, err)
 
-	hits, err := ds.SearchHosts(filter, "99.100.101")
+	hits, err := ds.SearchHosts(filter, "99.100.101", 0)
 	require.Nil(t, err)
 	require.Equal(t, 1, len(hits))
 
-	hits, err = ds.SearchHosts(filter, "99.100.111")
+	hits, err = ds.SearchHosts(filter, "99.100.111", 0)
 	require.Nil(t, err)
 	assert.Equal(t, 0, len(hits))
 
 	h3.PrimaryIP = "99.100.101.104"
 	err = ds.SaveHost(h3)
 	require.Nil(t, err)
-	hits, err = ds.SearchHosts(filter, "99.100.101")
+	hits, err = ds.SearchHosts(filter, "99.100.101", 0)
 	require.Nil(t, err)
 	assert.Equal(t, 2, len(hits))
-	hits, err = ds.SearchHosts(filter, "99.100.101", h3.ID)
+	hits, err = ds.SearchHosts(filter, "99.100.101", 0, h3.ID)
 	require.Nil(t, err)
 	assert.Equal(t, 1, len(hits))
 }
@@ -627,9 +1351,88 @@ func TestSearchHostsLimit(t *testing.T) {
 		require.Nil(t, err)
 	}
 
-	hosts, err := ds.SearchHosts(filter, "foo")
+	hosts, err := ds.SearchHosts(filter, "foo", 0)
 	require.Nil(t, err)
 	assert.Len(t, hosts, 10)
+
+	hosts, err = ds.SearchHosts(filter, "foo", 3)
+	require.Nil(t, err)
+	assert.Len(t, hosts, 3)
+
+	hosts, err = ds.SearchHosts(filter, "foo", 12)
+	require.Nil(t, err)
+	assert.Len(t, hosts, 12)
+
+	hosts, err = ds.SearchHosts(filter, "", 7)
+	require.Nil(t, err)
+	assert.Len(t, hosts, 7)
+}
+
+func TestSearchHostsTeamIsolation(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	team1, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	team2, err := ds.NewTeam(&fleet.Team{Name: "team2"})
+	require.NoError(t, err)
+
+	var team1Hosts, team2Hosts []uint
+	for i := 0; i < 6; i++ {
+		h, err := ds.NewHost(&fleet.Host{
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			SeenTime:        time.Now(),
+			OsqueryHostID:   fmt.Sprintf("host%d", i),
+			NodeKey:         fmt.Sprintf("%d", i),
+			UUID:            fmt.Sprintf("%d", i),
+			Hostname:        fmt.Sprintf("foo.%d.local", i),
+		})
+		require.NoError(t, err)
+		if i%2 == 0 {
+			team1Hosts = append(team1Hosts, h.ID)
+		} else {
+			team2Hosts = append(team2Hosts, h.ID)
+		}
+	}
+	_, err = ds.AddHostsToTeam(&team1.ID, team1Hosts, false)
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team2.ID, team2Hosts, false)
+	require.NoError(t, err)
+
+	filter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team1}},
+	}}
+
+	hostIDs := func(hosts []*fleet.Host) []uint {
+		var ids []uint
+		for _, h := range hosts {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	// With and without a query, and with omits, only the team's hosts are
+	// returned
+	hosts, err := ds.SearchHosts(filter, "foo", 0)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, team1Hosts, hostIDs(hosts))
+
+	hosts, err = ds.SearchHosts(filter, "", 0)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, team1Hosts, hostIDs(hosts))
+
+	hosts, err = ds.SearchHosts(filter, "foo", 0, team1Hosts[0])
+	require.NoError(t, err)
+	assert.ElementsMatch(t, team1Hosts[1:], hostIDs(hosts))
+
+	// The limit applies within the team
+	hosts, err = ds.SearchHosts(filter, "foo", 2)
+	require.NoError(t, err)
+	require.Len(t, hosts, 2)
+	for _, h := range hosts {
+		assert.Contains(t, team1Hosts, h.ID)
+	}
 }
 
 func TestGenerateHostStatusStatistics(t *testing.T) {
@@ -639,12 +1442,9 @@ func TestGenerateHostStatusStatistics(t *testing.T) {
 	filter := fleet.TeamFilter{User: test.UserAdmin}
 	mockClock := clock.NewMockClock()
 
-	online, offline, mia, new, err := ds.GenerateHostStatusStatistics(filter, mockClock.Now())
+	summary, err := ds.GenerateHostStatusStatistics(filter, mockClock.Now())
 	assert.Nil(t, err)
-	assert.Equal(t, uint(0), online)
-	assert.Equal(t, uint(0), offline)
-	assert.Equal(t, uint(0), mia)
-	assert.Equal(t, uint(0), new)
+	assert.Equal(t, &fleet.HostSummary{PlatformCounts: map[string]uint{}}, summary)
 
 	// Online
 	h, err := ds.NewHost(&fleet.Host{
@@ -654,6 +1454,7 @@ func TestGenerateHostStatusStatistics(t *testing.T) {
 		DetailUpdatedAt: mockClock.Now().Add(-30 * time.Second),
 		LabelUpdatedAt:  mockClock.Now().Add(-30 * time.Second),
 		SeenTime:        mockClock.Now().Add(-30 * time.Second),
+		Platform:        "darwin",
 	})
 	require.Nil(t, err)
 	h.DistributedInterval = 15
@@ -668,6 +1469,7 @@ func TestGenerateHostStatusStatistics(t *testing.T) {
 		DetailUpdatedAt: mockClock.Now().Add(-1 * time.Minute),
 		LabelUpdatedAt:  mockClock.Now().Add(-1 * time.Minute),
 		SeenTime:        mockClock.Now().Add(-1 * time.Minute),
+		Platform:        "ubuntu",
 	})
 	require.Nil(t, err)
 	h.DistributedInterval = 60
@@ -682,6 +1484,7 @@ func TestGenerateHostStatusStatistics(t *testing.T) {
 		DetailUpdatedAt: mockClock.Now().Add(-1 * time.Hour),
 		LabelUpdatedAt:  mockClock.Now().Add(-1 * time.Hour),
 		SeenTime:        mockClock.Now().Add(-1 * time.Hour),
+		Platform:        "centos",
 	})
 	require.Nil(t, err)
 	h.DistributedInterval = 300
@@ -699,78 +1502,332 @@ func TestGenerateHostStatusStatistics(t *testing.T) {
 	})
 	require.Nil(t, err)
 
-	online, offline, mia, new, err = ds.GenerateHostStatusStatistics(filter, mockClock.Now())
+	summary, err = ds.GenerateHostStatusStatistics(filter, mockClock.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), summary.OnlineCount)
+	assert.Equal(t, uint(1), summary.OfflineCount)
+	assert.Equal(t, uint(1), summary.MIACount)
+	assert.Equal(t, uint(4), summary.NewCount)
+	// New hosts are not added to the total
+	assert.Equal(t, uint(4), summary.TotalCount)
+	assert.Equal(t, map[string]uint{"darwin": 1, "linux": 2, "other": 1}, summary.PlatformCounts)
+
+	summary, err = ds.GenerateHostStatusStatistics(filter, mockClock.Now().Add(1*time.Hour))
 	assert.Nil(t, err)
-	assert.Equal(t, uint(2), online)
-	assert.Equal(t, uint(1), offline)
-	assert.Equal(t, uint(1), mia)
-	assert.Equal(t, uint(4), new)
+	assert.Equal(t, uint(0), summary.OnlineCount)
+	assert.Equal(t, uint(3), summary.OfflineCount)
+	assert.Equal(t, uint(1), summary.MIACount)
+	assert.Equal(t, uint(4), summary.NewCount)
+	assert.Equal(t, uint(4), summary.TotalCount)
 
-	online, offline, mia, new, err = ds.GenerateHostStatusStatistics(filter, mockClock.Now().Add(1*time.Hour))
+	// The total respects the filter
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{1, 3}, false)
+	require.NoError(t, err)
+	teamFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team}},
+	}}
+	summary, err = ds.GenerateHostStatusStatistics(teamFilter, mockClock.Now())
 	assert.Nil(t, err)
-	assert.Equal(t, uint(0), online)
-	assert.Equal(t, uint(3), offline)
-	assert.Equal(t, uint(1), mia)
-	assert.Equal(t, uint(4), new)
+	assert.Equal(t, uint(2), summary.TotalCount)
+	assert.Equal(t, uint(1), summary.OnlineCount)
+	assert.Equal(t, uint(1), summary.OfflineCount)
+	assert.Equal(t, map[string]uint{"darwin": 1, "linux": 1}, summary.PlatformCounts)
 }
 
-func TestMarkHostSeen(t *testing.T) {
+func TestTeamHostStatusStatistics(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
 
+	filter := fleet.TeamFilter{User: test.UserAdmin}
 	mockClock := clock.NewMockClock()
+	now := mockClock.Now()
 
-	anHourAgo := mockClock.Now().Add(-1 * time.Hour).UTC()
-	aDayAgo := mockClock.Now().Add(-24 * time.Hour).UTC()
+	summaries, err := ds.TeamHostStatusStatistics(filter, now)
+	require.NoError(t, err)
+	assert.Empty(t, summaries)
 
-	h1, err := ds.NewHost(&fleet.Host{
-		ID:              1,
-		OsqueryHostID:   "1",
-		UUID:            "1",
-		NodeKey:         "1",
-		DetailUpdatedAt: aDayAgo,
-		LabelUpdatedAt:  aDayAgo,
-		SeenTime:        aDayAgo,
-	})
-	assert.Nil(t, err)
+	team1, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	team2, err := ds.NewTeam(&fleet.Team{Name: "team2"})
+	require.NoError(t, err)
+	_, err = ds.NewTeam(&fleet.Team{Name: "empty"})
+	require.NoError(t, err)
 
-	{
-		h1Verify, err := ds.Host(1)
-		assert.Nil(t, err)
-		require.NotNil(t, h1Verify)
-		assert.WithinDuration(t, aDayAgo, h1Verify.SeenTime, time.Second)
+	newHost := func(id uint, seen time.Time, platform string, teamID *uint) {
+		h, err := ds.NewHost(&fleet.Host{
+			OsqueryHostID:   strconv.Itoa(int(id)),
+			NodeKey:         strconv.Itoa(int(id)),
+			DetailUpdatedAt: seen,
+			LabelUpdatedAt:  seen,
+			SeenTime:        seen,
+			Platform:        platform,
+		})
+		require.NoError(t, err)
+		h.DistributedInterval = 60
+		h.ConfigTLSRefresh = 60
+		h.TeamID = teamID
+		require.NoError(t, ds.SaveHost(h))
 	}
+	newHost(1, now, "darwin", &team1.ID)
+	newHost(2, now.Add(-time.Hour), "ubuntu", &team1.ID)
+	newHost(3, now.Add(-35*24*time.Hour), "windows", &team2.ID)
+	newHost(4, now, "centos", nil)
 
-	err = ds.MarkHostSeen(h1, anHourAgo)
-	assert.Nil(t, err)
+	summaries, err = ds.TeamHostStatusStatistics(filter, now)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint]fleet.HostSummary{
+		team1.ID: {
+			OnlineCount:    1,
+			OfflineCount:   1,
+			NewCount:       2,
+			TotalCount:     2,
+			PlatformCounts: map[string]uint{"darwin": 1, "linux": 1},
+		},
+		team2.ID: {
+			MIACount:       1,
+			NewCount:       1,
+			TotalCount:     1,
+			PlatformCounts: map[string]uint{"windows": 1},
+		},
+		0: {
+			OnlineCount:    1,
+			NewCount:       1,
+			TotalCount:     1,
+			PlatformCounts: map[string]uint{"linux": 1},
+		},
+	}, summaries)
 
-	{
-		h1Verify, err := ds.Host(1)
-		assert.Nil(t, err)
-		require.NotNil(t, h1Verify)
-		assert.WithinDuration(t, anHourAgo, h1Verify.SeenTime, time.Second)
+	// The per team counts agree with the overall summary
+	summary, err := ds.GenerateHostStatusStatistics(filter, now)
+	require.NoError(t, err)
+	var total fleet.HostSummary
+	for _, s := range summaries {
+		total.OnlineCount += s.OnlineCount
+		total.OfflineCount += s.OfflineCount
+		total.MIACount += s.MIACount
+		total.TotalCount += s.TotalCount
 	}
+	assert.Equal(t, summary.OnlineCount, total.OnlineCount)
+	assert.Equal(t, summary.OfflineCount, total.OfflineCount)
+	assert.Equal(t, summary.MIACount, total.MIACount)
+	assert.Equal(t, summary.TotalCount, total.TotalCount)
+
+	// Only the teams allowed by the filter are included
+	teamFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team2}},
+	}}
+	summaries, err = ds.TeamHostStatusStatistics(teamFilter, now)
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, uint(1), summaries[team2.ID].MIACount)
 }
 
-func TestMarkHostsSeen(t *testing.T) {
+func TestMultiHostStatusStatistics(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
 
 	mockClock := clock.NewMockClock()
+	now := mockClock.Now()
 
-	aSecondAgo := mockClock.Now().Add(-1 * time.Second).UTC()
-	anHourAgo := mockClock.Now().Add(-1 * time.Hour).UTC()
-	aDayAgo := mockClock.Now().Add(-24 * time.Hour).UTC()
+	team1, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	team2, err := ds.NewTeam(&fleet.Team{Name: "team2"})
+	require.NoError(t, err)
 
-	h1, err := ds.NewHost(&fleet.Host{
-		ID:              1,
-		OsqueryHostID:   "1",
-		UUID:            "1",
-		NodeKey:         "1",
-		DetailUpdatedAt: aDayAgo,
-		LabelUpdatedAt:  aDayAgo,
-		SeenTime:        aDayAgo,
-	})
+	newHost := func(id uint, seen time.Time, platform string, teamID *uint) *fleet.Host {
+		h, err := ds.NewHost(&fleet.Host{
+			OsqueryHostID:   strconv.Itoa(int(id)),
+			NodeKey:         strconv.Itoa(int(id)),
+			DetailUpdatedAt: seen,
+			LabelUpdatedAt:  seen,
+			SeenTime:        seen,
+			Platform:        platform,
+		})
+		require.NoError(t, err)
+		h.DistributedInterval = 60
+		h.ConfigTLSRefresh = 60
+		h.TeamID = teamID
+		require.NoError(t, ds.SaveHost(h))
+		return h
+	}
+	host1 := newHost(1, now, "darwin", &team1.ID)
+	newHost(2, now.Add(-time.Hour), "ubuntu", &team1.ID)
+	host3 := newHost(3, now.Add(-35*24*time.Hour), "windows", &team2.ID)
+	host4 := newHost(4, now, "centos", nil)
+
+	label, err := ds.NewLabel(&fleet.Label{Name: "label", Query: "select 1"})
+	require.NoError(t, err)
+	emptyLabel, err := ds.NewLabel(&fleet.Label{Name: "empty", Query: "select 1"})
+	require.NoError(t, err)
+	for _, h := range []*fleet.Host{host1, host3, host4} {
+		require.NoError(t, ds.RecordLabelQueryExecutions(h, map[uint]bool{label.ID: true, emptyLabel.ID: false}, now))
+	}
+
+	adminFilter := fleet.TeamFilter{User: test.UserAdmin}
+	team2Filter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team2}},
+	}}
+	observerFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleObserver, Team: *team1}},
+	}}
+	filters := []fleet.NamedTeamFilter{
+		{Name: "all", Filter: adminFilter},
+		{Name: "team2", Filter: team2Filter},
+		{Name: "linux", Filter: adminFilter, Platform: "linux"},
+		{Name: "observer", Filter: observerFilter},
+		{Name: "observer_included", Filter: fleet.TeamFilter{User: observerFilter.User, IncludeObserver: true}},
+		{Name: "label", Filter: adminFilter, LabelID: label.ID},
+		{Name: "label_team2", Filter: team2Filter, LabelID: label.ID},
+		{Name: "label_linux", Filter: adminFilter, LabelID: label.ID, Platform: "linux"},
+		{Name: "empty_label", Filter: adminFilter, LabelID: emptyLabel.ID},
+	}
+
+	summaries, err := ds.MultiHostStatusStatistics(filters, now)
+	require.NoError(t, err)
+	require.Len(t, summaries, len(filters))
+
+	// Each summary agrees with GenerateHostStatusStatistics
+	for _, f := range filters {
+		if f.Platform != "" || f.LabelID != 0 {
+			continue
+		}
+		summary, err := ds.GenerateHostStatusStatistics(f.Filter, now)
+		require.NoError(t, err)
+		assert.Equal(t, *summary, summaries[f.Name], f.Name)
+	}
+
+	assert.Equal(t, uint(4), summaries["all"].TotalCount)
+	assert.Equal(t, uint(1), summaries["team2"].MIACount)
+	assert.Equal(t, uint(1), summaries["team2"].TotalCount)
+	assert.Equal(t, uint(0), summaries["observer"].TotalCount)
+	assert.Equal(t, uint(2), summaries["observer_included"].TotalCount)
+
+	linux := summaries["linux"]
+	assert.Equal(t, uint(1), linux.OnlineCount)
+	assert.Equal(t, uint(1), linux.OfflineCount)
+	assert.Equal(t, uint(2), linux.TotalCount)
+	assert.Equal(t, map[string]uint{"linux": 2}, linux.PlatformCounts)
+
+	labelSummary := summaries["label"]
+	assert.Equal(t, uint(2), labelSummary.OnlineCount)
+	assert.Equal(t, uint(1), labelSummary.MIACount)
+	assert.Equal(t, uint(3), labelSummary.TotalCount)
+	assert.Equal(t, uint(1), summaries["label_team2"].TotalCount)
+	assert.Equal(t, uint(1), summaries["label_team2"].MIACount)
+	assert.Equal(t, uint(1), summaries["label_linux"].OnlineCount)
+	assert.Equal(t, map[string]uint{"linux": 1}, summaries["label_linux"].PlatformCounts)
+	assert.Equal(t, uint(0), summaries["empty_label"].TotalCount)
+
+	summaries, err = ds.MultiHostStatusStatistics(nil, now)
+	require.NoError(t, err)
+	assert.Empty(t, summaries)
+
+	_, err = ds.MultiHostStatusStatistics([]fleet.NamedTeamFilter{
+		{Name: "all", Filter: adminFilter},
+		{Name: "all", Filter: team2Filter},
+	}, now)
+	assert.Error(t, err)
+}
+
+func TestMarkHostSeen(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	mockClock := clock.NewMockClock()
+
+	anHourAgo := mockClock.Now().Add(-1 * time.Hour).UTC()
+	aDayAgo := mockClock.Now().Add(-24 * time.Hour).UTC()
+
+	h1, err := ds.NewHost(&fleet.Host{
+		ID:              1,
+		OsqueryHostID:   "1",
+		UUID:            "1",
+		NodeKey:         "1",
+		DetailUpdatedAt: aDayAgo,
+		LabelUpdatedAt:  aDayAgo,
+		SeenTime:        aDayAgo,
+	})
+	assert.Nil(t, err)
+
+	{
+		h1Verify, err := ds.Host(1)
+		assert.Nil(t, err)
+		require.NotNil(t, h1Verify)
+		assert.WithinDuration(t, aDayAgo, h1Verify.SeenTime, time.Second)
+	}
+
+	err = ds.MarkHostSeen(h1, anHourAgo)
+	assert.Nil(t, err)
+
+	{
+		h1Verify, err := ds.Host(1)
+		assert.Nil(t, err)
+		require.NotNil(t, h1Verify)
+		assert.WithinDuration(t, anHourAgo, h1Verify.SeenTime, time.Second)
+	}
+}
+
+func TestMarkHostSeenCheckinLatency(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	h1 := test.NewHost(t, ds, "foo.local", "", "1", "1", now)
+	h1.SeenTime = now
+	h1.DistributedInterval = 10
+	h1.ConfigTLSRefresh = 60
+	require.NoError(t, ds.SaveHost(h1))
+	// Host without reported intervals
+	h2 := test.NewHost(t, ds, "bar.local", "", "2", "2", now)
+
+	checkinLatency := func(id uint) time.Duration {
+		hosts, err := ds.ListHosts(fleet.TeamFilter{User: test.UserAdmin}, fleet.HostListOptions{})
+		require.NoError(t, err)
+		for _, h := range hosts {
+			if h.ID == id {
+				return h.CheckinLatency
+			}
+		}
+		t.Fatalf("host %d not listed", id)
+		return 0
+	}
+
+	// 30s gap with a 10s interval is 20s late, weighted 1/4
+	require.NoError(t, ds.MarkHostSeen(h1, now.Add(30*time.Second)))
+	assert.Equal(t, 5*time.Second, checkinLatency(h1.ID))
+
+	// Early check-ins count as zero latency
+	require.NoError(t, ds.MarkHostSeen(h1, now.Add(35*time.Second)))
+	assert.Equal(t, 3750*time.Millisecond, checkinLatency(h1.ID))
+
+	// 10s late, through the batched update
+	require.NoError(t, ds.MarkHostsSeen([]uint{h1.ID, h2.ID}, now.Add(55*time.Second)))
+	assert.Equal(t, 5312500*time.Microsecond, checkinLatency(h1.ID))
+
+	assert.Zero(t, checkinLatency(h2.ID))
+}
+
+func TestMarkHostsSeen(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	mockClock := clock.NewMockClock()
+
+	aSecondAgo := mockClock.Now().Add(-1 * time.Second).UTC()
+	anHourAgo := mockClock.Now().Add(-1 * time.Hour).UTC()
+	aDayAgo := mockClock.Now().Add(-24 * time.Hour).UTC()
+
+	h1, err := ds.NewHost(&fleet.Host{
+		ID:              1,
+		OsqueryHostID:   "1",
+		UUID:            "1",
+		NodeKey:         "1",
+		DetailUpdatedAt: aDayAgo,
+		LabelUpdatedAt:  aDayAgo,
+		SeenTime:        aDayAgo,
+	})
 	require.Nil(t, err)
 
 	h2, err := ds.NewHost(&fleet.Host{
@@ -816,6 +1873,71 @@ func TestMarkHostsSeen(t *testing.T) {
 
 }
 
+func TestClearStaleRefetchRequests(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		h, err := ds.NewHost(&fleet.Host{
+			DetailUpdatedAt: time.Now().Add(-48 * time.Hour),
+			LabelUpdatedAt:  time.Now(),
+			SeenTime:        time.Now(),
+			OsqueryHostID:   strconv.Itoa(i),
+			NodeKey:         fmt.Sprintf("%d", i),
+			UUID:            fmt.Sprintf("%d", i),
+			Hostname:        fmt.Sprintf("foo.local%d", i),
+		})
+		require.NoError(t, err)
+		hosts = append(hosts, h)
+	}
+
+	// Hosts 0 and 1 are asked to refetch, host 2 isn't
+	for _, h := range hosts[:2] {
+		h.RefetchRequested = true
+		require.NoError(t, ds.SaveHost(h))
+	}
+	h, err := ds.Host(hosts[0].ID)
+	require.NoError(t, err)
+	require.NotNil(t, h.RefetchRequestedAt)
+	requestedAt := *h.RefetchRequestedAt
+
+	// Saving again doesn't move the request time
+	require.NoError(t, ds.SaveHost(h))
+	h, err = ds.Host(hosts[0].ID)
+	require.NoError(t, err)
+	require.NotNil(t, h.RefetchRequestedAt)
+	assert.Equal(t, requestedAt, *h.RefetchRequestedAt)
+
+	// Requests are not stale yet
+	cleared, err := ds.ClearStaleRefetchRequests(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, cleared)
+
+	// Host 1 updates its details, so only host 0 is stale
+	hosts[1].DetailUpdatedAt = time.Now().Add(2 * time.Hour)
+	require.NoError(t, ds.SaveHost(hosts[1]))
+
+	cleared, err = ds.ClearStaleRefetchRequests(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, cleared)
+
+	h, err = ds.Host(hosts[0].ID)
+	require.NoError(t, err)
+	assert.False(t, h.RefetchRequested)
+	assert.Nil(t, h.RefetchRequestedAt)
+	h, err = ds.Host(hosts[1].ID)
+	require.NoError(t, err)
+	assert.True(t, h.RefetchRequested)
+
+	// Clearing the flag clears the request time
+	h.RefetchRequested = false
+	require.NoError(t, ds.SaveHost(h))
+	h, err = ds.Host(hosts[1].ID)
+	require.NoError(t, err)
+	assert.Nil(t, h.RefetchRequestedAt)
+}
+
 func TestCleanupIncomingHosts(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
@@ -1004,6 +2126,54 @@ func TestHostByIdentifier(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestHostByIdentifierResolvers(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h1 := test.NewHost(t, ds, "host1", "", "key1", "uuid1", time.Now())
+	h1.HardwareSerial = "serial1"
+	require.NoError(t, ds.SaveHost(h1))
+	// The hostname of h2 is the UUID of h1, the UUID resolver comes first.
+	h2 := test.NewHost(t, ds, "uuid1", "", "key2", "uuid2", time.Now())
+
+	h, err := ds.HostByIdentifier("uuid1")
+	require.NoError(t, err)
+	assert.Equal(t, h1.ID, h.ID)
+	h, err = ds.HostByIdentifier("uuid2")
+	require.NoError(t, err)
+	assert.Equal(t, h2.ID, h.ID)
+
+	// Serials aren't matched by default.
+	_, err = ds.HostByIdentifier("serial1")
+	assert.True(t, fleet.IsNotFound(err))
+
+	var customCalls int
+	ds.hostIdentifierResolvers = []HostIdentifierResolver{
+		HostColumnResolver("hardware_serial"),
+		func(q sqlx.Queryer, identifier string) (*fleet.Host, error) {
+			customCalls++
+			if identifier != "custom" {
+				return nil, nil
+			}
+			return HostColumnResolver("node_key")(q, "key2")
+		},
+	}
+
+	h, err = ds.HostByIdentifier("serial1")
+	require.NoError(t, err)
+	assert.Equal(t, h1.ID, h.ID)
+	assert.Equal(t, 0, customCalls)
+
+	h, err = ds.HostByIdentifier("custom")
+	require.NoError(t, err)
+	assert.Equal(t, h2.ID, h.ID)
+	assert.Equal(t, 1, customCalls)
+
+	_, err = ds.HostByIdentifier("unknown")
+	require.Error(t, err)
+	assert.Equal(t, 2, customCalls)
+}
+
 func TestAddHostsToTeam(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
@@ -1023,8 +2193,10 @@ func TestAddHostsToTeam(t *testing.T) {
 		assert.Nil(t, host.TeamID)
 	}
 
-	require.NoError(t, ds.AddHostsToTeam(&team1.ID, []uint{1, 2, 3}))
-	require.NoError(t, ds.AddHostsToTeam(&team2.ID, []uint{3, 4, 5}))
+	_, err = ds.AddHostsToTeam(&team1.ID, []uint{1, 2, 3}, false)
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team2.ID, []uint{3, 4, 5}, false)
+	require.NoError(t, err)
 
 	for i := 1; i <= 10; i++ {
 		host, err := ds.Host(uint(i))
@@ -1039,8 +2211,10 @@ func TestAddHostsToTeam(t *testing.T) {
 		assert.Equal(t, expectedID, host.TeamID)
 	}
 
-	require.NoError(t, ds.AddHostsToTeam(nil, []uint{1, 2, 3, 4}))
-	require.NoError(t, ds.AddHostsToTeam(&team1.ID, []uint{5, 6, 7, 8, 9, 10}))
+	_, err = ds.AddHostsToTeam(nil, []uint{1, 2, 3, 4}, false)
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team1.ID, []uint{5, 6, 7, 8, 9, 10}, false)
+	require.NoError(t, err)
 
 	for i := 1; i <= 10; i++ {
 		host, err := ds.Host(uint(i))
@@ -1054,6 +2228,94 @@ func TestAddHostsToTeam(t *testing.T) {
 	}
 }
 
+func TestAddHostsToTeamRefetchLabels(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	team1, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	host1 := test.NewHost(t, ds, "1", "", "key1", "uuid1", now)
+	host2 := test.NewHost(t, ds, "2", "", "key2", "uuid2", now)
+
+	_, err = ds.AddHostsToTeam(&team1.ID, []uint{host1.ID}, false)
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team1.ID, []uint{host2.ID}, true)
+	require.NoError(t, err)
+
+	host, err := ds.Host(host1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &team1.ID, host.TeamID)
+	assert.False(t, host.LabelUpdatedAt.Before(now))
+
+	// The moved host should be due for label queries right away
+	host, err = ds.Host(host2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &team1.ID, host.TeamID)
+	assert.True(t, host.LabelUpdatedAt.Before(now.Add(-time.Hour)))
+}
+
+func TestAddHostsToTeamIdempotent(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	ds.trackedHostFields = []string{"team_id"}
+
+	team1, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var hostIDs []uint
+	for i := 0; i < 3; i++ {
+		h := test.NewHost(t, ds, fmt.Sprint(i), "", fmt.Sprintf("key%d", i), fmt.Sprintf("uuid%d", i), now)
+		hostIDs = append(hostIDs, h.ID)
+	}
+	_, err = ds.AddHostsToTeam(&team1.ID, hostIDs[:1], false)
+	require.NoError(t, err)
+
+	// Unknown host IDs are not counted
+	assignment, err := ds.AddHostsToTeam(&team1.ID, append(hostIDs, 999), true)
+	require.NoError(t, err)
+	assert.Equal(t, fleet.HostTeamAssignment{Changed: 2, AlreadyAssigned: 1}, assignment)
+
+	// The host already in the team is left untouched
+	host, err := ds.Host(hostIDs[0])
+	require.NoError(t, err)
+	assert.False(t, host.LabelUpdatedAt.Before(now))
+	host, err = ds.Host(hostIDs[1])
+	require.NoError(t, err)
+	assert.True(t, host.LabelUpdatedAt.Before(now.Add(-time.Hour)))
+
+	changes, err := ds.ListHostFieldChanges(hostIDs[0], nil)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	changes, err = ds.ListHostFieldChanges(hostIDs[1], nil)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "team_id", changes[0].Field)
+	assert.Equal(t, "", changes[0].OldValue)
+	assert.Equal(t, fmt.Sprint(team1.ID), changes[0].NewValue)
+
+	// Retrying changes nothing
+	assignment, err = ds.AddHostsToTeam(&team1.ID, hostIDs, true)
+	require.NoError(t, err)
+	assert.Equal(t, fleet.HostTeamAssignment{AlreadyAssigned: 3}, assignment)
+	for _, id := range hostIDs {
+		changes, err := ds.ListHostFieldChanges(id, nil)
+		require.NoError(t, err)
+		assert.Len(t, changes, 1)
+	}
+
+	// Hosts without a team are already assigned to no team
+	assignment, err = ds.AddHostsToTeam(nil, hostIDs[1:], false)
+	require.NoError(t, err)
+	assert.Equal(t, fleet.HostTeamAssignment{Changed: 2}, assignment)
+	assignment, err = ds.AddHostsToTeam(nil, hostIDs, false)
+	require.NoError(t, err)
+	assert.Equal(t, fleet.HostTeamAssignment{Changed: 1, AlreadyAssigned: 2}, assignment)
+}
+
 func TestSaveUsers(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
@@ -1074,9 +2336,10 @@ func TestSaveUsers(t *testing.T) {
 	err = ds.SaveHost(host)
 	require.Nil(t, err)
 
-	host, err = ds.Host(host.ID)
+	users, count, err := ds.ListHostUsers(host.ID, fleet.ListOptions{})
 	require.Nil(t, err)
-	assert.Len(t, host.Users, 0)
+	assert.Len(t, users, 0)
+	assert.Zero(t, count)
 
 	u1 := fleet.HostUser{
 		Uid:       42,
@@ -1096,10 +2359,16 @@ func TestSaveUsers(t *testing.T) {
 	err = ds.SaveHost(host)
 	require.Nil(t, err)
 
+	users, count, err = ds.ListHostUsers(host.ID, fleet.ListOptions{})
+	require.Nil(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, uint(2), count)
+	test.ElementsMatchSkipID(t, users, []fleet.HostUser{u1, u2})
+
+	// Host does not load the users
 	host, err = ds.Host(host.ID)
 	require.Nil(t, err)
-	require.Len(t, host.Users, 2)
-	test.ElementsMatchSkipID(t, host.Users, []fleet.HostUser{u1, u2})
+	assert.Nil(t, host.Users)
 
 	// remove u1 user
 	host.Users = []fleet.HostUser{u2}
@@ -1108,8 +2377,1840 @@ func TestSaveUsers(t *testing.T) {
 	err = ds.SaveHost(host)
 	require.Nil(t, err)
 
-	host, err = ds.Host(host.ID)
+	users, count, err = ds.ListHostUsers(host.ID, fleet.ListOptions{})
 	require.Nil(t, err)
-	require.Len(t, host.Users, 1)
-	assert.Equal(t, host.Users[0].Uid, u2.Uid)
+	require.Len(t, users, 1)
+	assert.Equal(t, uint(1), count)
+	assert.Equal(t, users[0].Uid, u2.Uid)
+}
+
+func TestListHostUsersPagination(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	for i := 0; i < 5; i++ {
+		host.Users = append(host.Users, fleet.HostUser{
+			Uid:      uint(100 - i),
+			Username: fmt.Sprintf("user%d", i),
+			Type:     "domain",
+		})
+	}
+	host.Modified = true
+	require.NoError(t, ds.SaveHost(host))
+
+	users, count, err := ds.ListHostUsers(host.ID, fleet.ListOptions{PerPage: 2})
+	require.NoError(t, err)
+	assert.Equal(t, uint(5), count)
+	require.Len(t, users, 2)
+	assert.Equal(t, uint(96), users[0].Uid)
+	assert.Equal(t, uint(97), users[1].Uid)
+
+	users, count, err = ds.ListHostUsers(host.ID, fleet.ListOptions{PerPage: 2, Page: 2})
+	require.NoError(t, err)
+	assert.Equal(t, uint(5), count)
+	require.Len(t, users, 1)
+	assert.Equal(t, uint(100), users[0].Uid)
+
+	users, _, err = ds.ListHostUsers(host.ID, fleet.ListOptions{OrderKey: "username", OrderDirection: fleet.OrderDescending, PerPage: 1})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "user4", users[0].Username)
+}
+
+func TestLoadHostUsers(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+
+	const numUsers = 250
+	for i := 0; i < numUsers; i++ {
+		host.Users = append(host.Users, fleet.HostUser{
+			Uid:      uint(i),
+			Username: fmt.Sprintf("user%d", i),
+			Type:     "domain",
+		})
+	}
+	host.Modified = true
+	require.NoError(t, ds.SaveHost(host))
+
+	// All the users are loaded, without the pagination of ListHostUsers
+	loaded := &fleet.Host{ID: host.ID}
+	require.NoError(t, ds.LoadHostUsers(loaded))
+	assert.Len(t, loaded.Users, numUsers)
+
+	// Removed users aren't loaded
+	host.Users = host.Users[:10]
+	host.Modified = true
+	require.NoError(t, ds.SaveHost(host))
+	loaded = &fleet.Host{ID: host.ID}
+	require.NoError(t, ds.LoadHostUsers(loaded))
+	assert.Len(t, loaded.Users, 10)
+}
+
+func TestHostOwner(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		hosts = append(hosts, h)
+	}
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{hosts[0].ID}, false)
+	require.NoError(t, err)
+
+	require.NoError(t, ds.SetHostOwner(hosts[0].ID, "jane@example.com"))
+	require.NoError(t, ds.SetHostOwner(hosts[1].ID, "jane@example.com"))
+	require.NoError(t, ds.SetHostOwner(hosts[2].ID, "bob@example.com"))
+	// Setting the same owner again is not an error
+	require.NoError(t, ds.SetHostOwner(hosts[2].ID, "bob@example.com"))
+
+	err = ds.SetHostOwner(999, "jane@example.com")
+	require.Error(t, err)
+	assert.True(t, fleet.IsNotFound(err))
+
+	h, err := ds.Host(hosts[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", h.AssignedOwner)
+
+	adminFilter := fleet.TeamFilter{User: test.UserAdmin}
+	teamFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team}},
+	}}
+
+	owned, err := ds.HostsByOwner(adminFilter, "jane@example.com")
+	require.NoError(t, err)
+	require.Len(t, owned, 2)
+	assert.Equal(t, hosts[0].ID, owned[0].ID)
+	assert.Equal(t, hosts[1].ID, owned[1].ID)
+
+	owned, err = ds.HostsByOwner(teamFilter, "jane@example.com")
+	require.NoError(t, err)
+	require.Len(t, owned, 1)
+	assert.Equal(t, hosts[0].ID, owned[0].ID)
+
+	listed, err := ds.ListHosts(adminFilter, fleet.HostListOptions{OwnerFilter: "bob@example.com"})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, hosts[2].ID, listed[0].ID)
+
+	// Clearing the owner
+	require.NoError(t, ds.SetHostOwner(hosts[2].ID, ""))
+	owned, err = ds.HostsByOwner(adminFilter, "bob@example.com")
+	require.NoError(t, err)
+	assert.Empty(t, owned)
+	owned, err = ds.HostsByOwner(adminFilter, "")
+	require.NoError(t, err)
+	require.Len(t, owned, 1)
+	assert.Equal(t, hosts[2].ID, owned[0].ID)
+}
+
+func TestApplyHostAnnotations(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h1 := test.NewHost(t, ds, "foo.local", "", "key1", "uuid1", time.Now())
+	h2 := test.NewHost(t, ds, "bar.local", "", "key2", "uuid2", time.Now())
+	require.NoError(t, ds.SetHostOwner(h2.ID, "bob@example.com"))
+
+	applied, unmatched, err := ds.ApplyHostAnnotations(map[string]fleet.HostAnnotation{
+		"foo.local": {Owner: ptr.String("jane@example.com"), Notes: ptr.String("rack 4")},
+		"uuid2":     {Notes: ptr.String("loaner")},
+		"missing1":  {Owner: ptr.String("jane@example.com")},
+		"missing2":  {Notes: ptr.String("gone")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, applied)
+	assert.Equal(t, []string{"missing1", "missing2"}, unmatched)
+
+	h, err := ds.Host(h1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", h.AssignedOwner)
+	assert.Equal(t, "rack 4", h.Notes)
+
+	// Nil fields are left unchanged
+	h, err = ds.Host(h2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "bob@example.com", h.AssignedOwner)
+	assert.Equal(t, "loaner", h.Notes)
+
+	applied, unmatched, err = ds.ApplyHostAnnotations(map[string]fleet.HostAnnotation{
+		h2.OsqueryHostID: {Owner: ptr.String(""), Notes: ptr.String("")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	assert.Empty(t, unmatched)
+
+	h, err = ds.Host(h2.ID)
+	require.NoError(t, err)
+	assert.Empty(t, h.AssignedOwner)
+	assert.Empty(t, h.Notes)
+
+	applied, unmatched, err = ds.ApplyHostAnnotations(nil)
+	require.NoError(t, err)
+	assert.Zero(t, applied)
+	assert.Empty(t, unmatched)
+}
+func TestHostTags(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		hosts = append(hosts, h)
+	}
+
+	// Empty selection is a no-op
+	require.NoError(t, ds.SetHostTags(nil, map[string]string{"env": "prod"}))
+
+	require.NoError(t, ds.SetHostTags([]uint{hosts[0].ID, hosts[1].ID}, map[string]string{"env": "prod", "owner": "it"}))
+	require.NoError(t, ds.SetHostTags([]uint{hosts[1].ID}, map[string]string{"env": "dev"}))
+
+	tags, err := ds.ListHostTags(hosts[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "owner": "it"}, tags)
+
+	// Existing values are replaced and other tags kept
+	tags, err = ds.ListHostTags(hosts[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "dev", "owner": "it"}, tags)
+
+	tags, err = ds.ListHostTags(hosts[2].ID)
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+
+	// Tags are deleted with the host
+	require.NoError(t, ds.DeleteHost(hosts[0].ID))
+	counts, err := ds.HostOrphanCheck(hosts[0].ID)
+	require.NoError(t, err)
+	assert.Zero(t, counts["host_tags"])
+}
+
+func TestListHostsAdditionalKey(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 4; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		hosts = append(hosts, h)
+	}
+
+	for i, raw := range []string{
+		`{"field1": "v1", "field.2": "v2"}`,
+		`{"field1": null}`,
+		`{"other": {"field1": "nested"}}`,
+		// hosts[3] has no additional data
+	} {
+		additional := json.RawMessage(raw)
+		hosts[i].Additional = &additional
+		require.NoError(t, ds.SaveHostAdditional(hosts[i]))
+	}
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listIDs := func(opt fleet.HostListOptions) []uint {
+		listed, err := ds.ListHosts(filter, opt)
+		require.NoError(t, err)
+		var ids []uint
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	// A null value still counts as present, nested keys don't
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[1].ID}, listIDs(fleet.HostListOptions{AdditionalKey: "field1"}))
+	assert.ElementsMatch(t, []uint{hosts[2].ID, hosts[3].ID}, listIDs(fleet.HostListOptions{AdditionalKey: "field1", AdditionalKeyMissing: true}))
+
+	// Keys that need quoting in a JSON path
+	assert.ElementsMatch(t, []uint{hosts[0].ID}, listIDs(fleet.HostListOptions{AdditionalKey: "field.2"}))
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[1].ID, hosts[2].ID, hosts[3].ID}, listIDs(fleet.HostListOptions{AdditionalKey: `a"b`, AdditionalKeyMissing: true}))
+}
+
+func TestListHostsLabelUpdatedBefore(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var hosts []*fleet.Host
+	for i, age := range []time.Duration{time.Minute, 2 * time.Hour, 48 * time.Hour} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), now)
+		h.LabelUpdatedAt = now.Add(-age)
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listIDs := func(opt fleet.HostListOptions) []uint {
+		listed, err := ds.ListHosts(filter, opt)
+		require.NoError(t, err)
+		var ids []uint
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	before := now.Add(-time.Hour)
+	assert.ElementsMatch(t, []uint{hosts[1].ID, hosts[2].ID}, listIDs(fleet.HostListOptions{LabelUpdatedBefore: &before}))
+	before = now.Add(-24 * time.Hour)
+	assert.ElementsMatch(t, []uint{hosts[2].ID}, listIDs(fleet.HostListOptions{LabelUpdatedBefore: &before}))
+	before = now.Add(-72 * time.Hour)
+	assert.Empty(t, listIDs(fleet.HostListOptions{LabelUpdatedBefore: &before}))
+
+	// Composes with the status filter, all hosts are online
+	before = now.Add(-time.Hour)
+	assert.ElementsMatch(t, []uint{hosts[1].ID, hosts[2].ID}, listIDs(fleet.HostListOptions{LabelUpdatedBefore: &before, StatusFilter: fleet.StatusOnline}))
+	assert.Empty(t, listIDs(fleet.HostListOptions{LabelUpdatedBefore: &before, StatusFilter: fleet.StatusMIA}))
+}
+
+func TestListHostsModifiedSince(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	old := now.Add(-72 * time.Hour)
+	newHost := func(i int, detail, label, seen time.Time) *fleet.Host {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), now)
+		h.DetailUpdatedAt = detail
+		h.LabelUpdatedAt = label
+		h.SeenTime = seen
+		require.NoError(t, ds.SaveHost(h))
+		return h
+	}
+	h1 := newHost(1, now.Add(-time.Minute), old, old)
+	h2 := newHost(2, old, now.Add(-2*time.Hour), old)
+	h3 := newHost(3, old, old, now.Add(-time.Hour))
+	h4 := newHost(4, old, old, old)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listIDs := func(opt fleet.HostListOptions) []uint {
+		listed, err := ds.ListHosts(filter, opt)
+		require.NoError(t, err)
+		var ids []uint
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	// Ordered by the latest update
+	since := now.Add(-24 * time.Hour)
+	assert.Equal(t, []uint{h2.ID, h3.ID, h1.ID}, listIDs(fleet.HostListOptions{ModifiedSince: &since}))
+	since = now.Add(-90 * time.Minute)
+	assert.Equal(t, []uint{h3.ID, h1.ID}, listIDs(fleet.HostListOptions{ModifiedSince: &since}))
+	since = now
+	assert.Empty(t, listIDs(fleet.HostListOptions{ModifiedSince: &since}))
+
+	// Pagination follows the same order
+	since = old
+	assert.Equal(t, []uint{h4.ID, h2.ID}, listIDs(fleet.HostListOptions{ModifiedSince: &since, ListOptions: fleet.ListOptions{PerPage: 2}}))
+	assert.Equal(t, []uint{h3.ID, h1.ID}, listIDs(fleet.HostListOptions{ModifiedSince: &since, ListOptions: fleet.ListOptions{PerPage: 2, Page: 1}}))
+
+	// An explicit order key takes precedence
+	since = now.Add(-24 * time.Hour)
+	assert.Equal(t, []uint{h1.ID, h2.ID, h3.ID}, listIDs(fleet.HostListOptions{ModifiedSince: &since, ListOptions: fleet.ListOptions{OrderKey: "hostname"}}))
+}
+
+func TestHostsNewTeamOverride(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	now := time.Now().UTC().Truncate(time.Second)
+
+	short, err := ds.NewTeam(&fleet.Team{Name: "short", NewHostHours: ptr.Uint(1)})
+	require.NoError(t, err)
+	long, err := ds.NewTeam(&fleet.Team{Name: "long", NewHostHours: ptr.Uint(72)})
+	require.NoError(t, err)
+
+	newHost := func(id uint, created time.Time, teamID *uint) *fleet.Host {
+		h, err := ds.NewHost(&fleet.Host{
+			OsqueryHostID:   strconv.Itoa(int(id)),
+			NodeKey:         strconv.Itoa(int(id)),
+			DetailUpdatedAt: now,
+			LabelUpdatedAt:  now,
+			SeenTime:        now,
+		})
+		require.NoError(t, err)
+		h.TeamID = teamID
+		require.NoError(t, ds.SaveHost(h))
+		_, err = ds.db.Exec(`UPDATE hosts SET created_at = ? WHERE id = ?`, created, h.ID)
+		require.NoError(t, err)
+		return h
+	}
+	// New by the global duration only
+	h1 := newHost(1, now.Add(-2*time.Hour), nil)
+	// Not new, the team's duration is shorter
+	h2 := newHost(2, now.Add(-2*time.Hour), &short.ID)
+	// New, the team's duration is longer
+	h3 := newHost(3, now.Add(-48*time.Hour), &long.ID)
+	// Not new by the global duration
+	newHost(4, now.Add(-48*time.Hour), nil)
+
+	summary, err := ds.GenerateHostStatusStatistics(filter, now)
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), summary.NewCount)
+
+	hosts, err := ds.ListHosts(filter, fleet.HostListOptions{StatusFilter: "new"})
+	require.NoError(t, err)
+	var ids []uint
+	for _, h := range hosts {
+		ids = append(ids, h.ID)
+	}
+	assert.ElementsMatch(t, []uint{h1.ID, h3.ID}, ids)
+
+	for _, h := range []*fleet.Host{h1, h2, h3} {
+		loaded, err := ds.Host(h.ID)
+		require.NoError(t, err)
+		assert.Equal(t, h.ID != h2.ID, loaded.IsNew(now), "host %d", h.ID)
+
+		loaded, err = ds.AuthenticateHost(h.NodeKey)
+		require.NoError(t, err)
+		assert.Equal(t, h.ID != h2.ID, loaded.IsNew(now), "host %d", h.ID)
+
+		loaded, err = ds.HostByIdentifier(h.OsqueryHostID)
+		require.NoError(t, err)
+		assert.Equal(t, h.ID != h2.ID, loaded.IsNew(now), "host %d", h.ID)
+	}
+
+	searched, err := ds.SearchHosts(filter, "", 10)
+	require.NoError(t, err)
+	require.Len(t, searched, 4)
+	for _, h := range searched {
+		if h.ID == h3.ID {
+			assert.Equal(t, ptr.Uint(72), h.TeamNewHostHours)
+			assert.True(t, h.IsNew(now))
+		}
+	}
+
+	metrics, err := ds.CountHostsInTargets(filter, fleet.HostTargets{TeamIDs: []uint{short.ID, long.ID}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), metrics.NewHosts)
+}
+
+func TestHostSummaryDelta(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, platform := range []string{"darwin", "ubuntu"} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), now)
+		h.Platform = platform
+		h.DistributedInterval = 60
+		h.ConfigTLSRefresh = 60
+		require.NoError(t, ds.SaveHost(h))
+	}
+
+	_, err := ds.HostSummaryDelta(now, now)
+	assert.True(t, fleet.IsNotFound(err))
+
+	require.NoError(t, ds.SnapshotHostSummary(now))
+	// Both hosts are offline an hour later, and a windows host enrolled
+	later := now.Add(time.Hour)
+	h := test.NewHost(t, ds, "bar.local", "", "bar", "bar", later)
+	h.Platform = "windows"
+	h.DistributedInterval = 60
+	h.ConfigTLSRefresh = 60
+	require.NoError(t, ds.SaveHost(h))
+	require.NoError(t, ds.SnapshotHostSummary(later))
+
+	delta, err := ds.HostSummaryDelta(now, later)
+	require.NoError(t, err)
+	assert.Equal(t, fleet.HostSummaryDelta{
+		OnlineCount:    -1,
+		OfflineCount:   2,
+		TotalCount:     1,
+		NewCount:       1,
+		PlatformCounts: map[string]int{"darwin": 0, "linux": 0, "windows": 1},
+	}, delta)
+
+	// The latest snapshots at or before the times are used
+	delta, err = ds.HostSummaryDelta(now.Add(30*time.Minute), later.Add(30*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 2, delta.OfflineCount)
+
+	// No snapshot before from
+	_, err = ds.HostSummaryDelta(now.Add(-time.Minute), later)
+	assert.True(t, fleet.IsNotFound(err))
+}
+
+func TestPlatformCountHistory(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	history, err := ds.PlatformCountHistory(now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	for i, platform := range []string{"darwin", "ubuntu", "centos"} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), now)
+		h.Platform = platform
+		require.NoError(t, ds.SaveHost(h))
+	}
+	require.NoError(t, ds.SnapshotHostSummary(now))
+
+	// No snapshot is taken in the next hour
+	later := now.Add(2 * time.Hour)
+	h := test.NewHost(t, ds, "bar.local", "", "bar", "bar", later)
+	h.Platform = "darwin"
+	require.NoError(t, ds.SaveHost(h))
+	h = test.NewHost(t, ds, "baz.local", "", "baz", "baz", later)
+	h.Platform = "windows"
+	require.NoError(t, ds.SaveHost(h))
+	require.NoError(t, ds.SnapshotHostSummary(later))
+
+	history, err = ds.PlatformCountHistory(now, later)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]fleet.PlatformCountPoint{
+		"darwin":  {{T: now, Count: 1}, {T: later, Count: 2}},
+		"linux":   {{T: now, Count: 2}, {T: later, Count: 2}},
+		"windows": {{T: now, Count: 0}, {T: later, Count: 1}},
+	}, history)
+
+	// Only the snapshots in the range are included
+	history, err = ds.PlatformCountHistory(now.Add(time.Second), later.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []fleet.PlatformCountPoint{{T: later, Count: 2}}, history["darwin"])
+	assert.Len(t, history["windows"], 1)
+}
+
+func TestHostResourceHistory(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	require.Error(t, HostResourceSampleInterval(time.Second)(&dbOptions{}))
+	require.NoError(t, HostResourceSampleInterval(time.Hour)(&dbOptions{}))
+	ds.hostResourceSampleInterval = time.Hour
+
+	h := test.NewHost(t, ds, "foo.local", "192.168.1.10", "1", "1", time.Now())
+	// Hosts that haven't reported their memory yet aren't sampled
+	require.NoError(t, ds.SaveHost(h))
+
+	h.Memory = 8 << 30
+	h.HostDisks = fleet.HostDisks{
+		Modified: true,
+		Disks:    []fleet.HostDisk{{Name: "/dev/sda", Size: 1000}, {Name: "/dev/sdb", Size: 2000}},
+	}
+	require.NoError(t, ds.SaveHost(h))
+	// Saving again within the interval doesn't sample
+	h.Memory = 16 << 30
+	require.NoError(t, ds.SaveHost(h))
+
+	samples, err := ds.HostResourceHistory(h.ID, time.Now().Add(-time.Hour), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, h.ID, samples[0].HostID)
+	assert.Equal(t, int64(8<<30), samples[0].Memory)
+	assert.Equal(t, int64(3000), samples[0].DiskSpace)
+
+	_, err = ds.db.Exec(`UPDATE host_resource_samples SET created_at = ? WHERE host_id = ?`, time.Now().Add(-2*time.Hour), h.ID)
+	require.NoError(t, err)
+	require.NoError(t, ds.SaveHost(h))
+
+	samples, err = ds.HostResourceHistory(h.ID, time.Now().Add(-3*time.Hour), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, int64(8<<30), samples[0].Memory)
+	assert.Equal(t, int64(16<<30), samples[1].Memory)
+	assert.True(t, samples[0].CreatedAt.Before(samples[1].CreatedAt))
+
+	samples, err = ds.HostResourceHistory(h.ID, time.Now().Add(-time.Hour), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, int64(16<<30), samples[0].Memory)
+
+	samples, err = ds.HostResourceHistory(h.ID+1, time.Now().Add(-3*time.Hour), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+
+	require.NoError(t, ds.DeleteHost(h.ID))
+	samples, err = ds.HostResourceHistory(h.ID, time.Now().Add(-3*time.Hour), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+}
+
+func TestHostBySerial(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	now := time.Now()
+	var hosts []*fleet.Host
+	for i, serial := range []string{"serial1", "serial2", "serial2", ""} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), now)
+		h.HardwareSerial = serial
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	h, err := ds.HostBySerial("serial1")
+	require.NoError(t, err)
+	assert.Equal(t, hosts[0].ID, h.ID)
+	assert.Equal(t, "foo0.local", h.Hostname)
+
+	_, err = ds.HostBySerial("serial2")
+	require.IsType(t, &fleet.HostSerialAmbiguousError{}, err)
+	assert.Equal(t, []uint{hosts[1].ID, hosts[2].ID}, err.(*fleet.HostSerialAmbiguousError).HostIDs)
+
+	_, err = ds.HostBySerial("serial3")
+	assert.True(t, fleet.IsNotFound(err))
+
+	// Hosts without a serial are never matched
+	_, err = ds.HostBySerial("")
+	assert.True(t, fleet.IsNotFound(err))
+}
+
+func TestListHostsLabelIDs(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 4; i++ {
+		hosts = append(hosts, test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now()))
+	}
+	labelA, err := ds.NewLabel(&fleet.Label{Name: "a", LabelMembershipType: fleet.LabelMembershipTypeManual})
+	require.NoError(t, err)
+	labelB, err := ds.NewLabel(&fleet.Label{Name: "b", LabelMembershipType: fleet.LabelMembershipTypeManual})
+	require.NoError(t, err)
+	require.NoError(t, ds.AddHostsToLabel(labelA.ID, []uint{hosts[0].ID, hosts[1].ID}))
+	require.NoError(t, ds.AddHostsToLabel(labelB.ID, []uint{hosts[1].ID, hosts[2].ID}))
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listIDs := func(opt fleet.HostListOptions) []uint {
+		listed, err := ds.ListHosts(filter, opt)
+		require.NoError(t, err)
+		var ids []uint
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	both := []uint{labelA.ID, labelB.ID}
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[1].ID, hosts[2].ID}, listIDs(fleet.HostListOptions{LabelIDs: both}))
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[1].ID, hosts[2].ID}, listIDs(fleet.HostListOptions{LabelIDs: both, LabelMatchMode: fleet.LabelMatchAny}))
+	assert.ElementsMatch(t, []uint{hosts[1].ID}, listIDs(fleet.HostListOptions{LabelIDs: both, LabelMatchMode: fleet.LabelMatchAll}))
+	// Duplicate label IDs don't change the result
+	assert.ElementsMatch(t, []uint{hosts[1].ID}, listIDs(fleet.HostListOptions{LabelIDs: append(both, labelA.ID), LabelMatchMode: fleet.LabelMatchAll}))
+
+	// A single label is the same as listing the hosts in the label
+	single := listIDs(fleet.HostListOptions{LabelIDs: []uint{labelB.ID}, LabelMatchMode: fleet.LabelMatchAll})
+	assert.ElementsMatch(t, []uint{hosts[1].ID, hosts[2].ID}, single)
+	inLabel, err := ds.ListHostsInLabel(filter, labelB.ID, fleet.HostListOptions{})
+	require.NoError(t, err)
+	require.Len(t, inLabel, 2)
+	assert.ElementsMatch(t, single, []uint{inLabel[0].ID, inLabel[1].ID})
+
+	// Composes with other filters
+	assert.ElementsMatch(t, []uint{hosts[1].ID}, listIDs(fleet.HostListOptions{LabelIDs: both, ListOptions: fleet.ListOptions{MatchQuery: "foo1"}}))
+
+	_, err = ds.ListHosts(filter, fleet.HostListOptions{LabelIDs: both, LabelMatchMode: "some"})
+	assert.Error(t, err)
+}
+
+func TestHostTimezone(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i, tz := range []string{"PST", "CET", "PST", ""} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		h.Timezone = tz
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	h, err := ds.Host(hosts[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "CET", h.Timezone)
+	h, err = ds.AuthenticateHost(hosts[0].NodeKey)
+	require.NoError(t, err)
+	assert.Equal(t, "PST", h.Timezone)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listed, err := ds.ListHosts(filter, fleet.HostListOptions{TimezoneFilter: "PST"})
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[2].ID}, []uint{listed[0].ID, listed[1].ID})
+
+	require.NoError(t, ds.SaveHostFields(&fleet.Host{ID: hosts[3].ID, Timezone: "JST"}, []string{"timezone"}))
+	h, err = ds.Host(hosts[3].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "JST", h.Timezone)
+}
+
+func TestEnrollHostTeamChange(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	mockClock := clock.NewMockClock()
+	ds.clock = mockClock
+	start := mockClock.Now().UTC().Truncate(time.Second)
+
+	test.AddAllHostsLabel(t, ds)
+	team1, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	team2, err := ds.NewTeam(&fleet.Team{Name: "team2"})
+	require.NoError(t, err)
+
+	h, err := ds.EnrollHost("host1", "key1", &team1.ID, 0, fleet.EnrollHostOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, &team1.ID, h.TeamID)
+
+	// Enrolling again with the same team is not a team change
+	_, err = ds.EnrollHost("host1", "key2", &team1.ID, 0, fleet.EnrollHostOptions{})
+	require.NoError(t, err)
+	changes, err := ds.ListHostTeamChanges(start)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// Pending confirmation, the host stays on its team
+	mockClock.AddTime(time.Minute)
+	h, err = ds.EnrollHost("host1", "key3", &team2.ID, 0, fleet.EnrollHostOptions{TeamChange: fleet.EnrollTeamChangeConfirm})
+	require.NoError(t, err)
+	assert.Equal(t, &team1.ID, h.TeamID)
+	assert.Equal(t, "key3", h.NodeKey)
+
+	// Applied by default
+	mockClock.AddTime(time.Minute)
+	h, err = ds.EnrollHost("host1", "key4", &team2.ID, 0, fleet.EnrollHostOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, &team2.ID, h.TeamID)
+
+	mockClock.AddTime(time.Minute)
+	h, err = ds.EnrollHost("host1", "key5", nil, 0, fleet.EnrollHostOptions{TeamChange: fleet.EnrollTeamChangeApply})
+	require.NoError(t, err)
+	assert.Nil(t, h.TeamID)
+
+	_, err = ds.EnrollHost("host1", "key6", &team1.ID, 0, fleet.EnrollHostOptions{TeamChange: "bogus"})
+	require.Error(t, err)
+
+	changes, err = ds.ListHostTeamChanges(start)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	assert.Equal(t, h.ID, changes[0].HostID)
+	assert.Equal(t, &team1.ID, changes[0].FromTeamID)
+	assert.Equal(t, &team2.ID, changes[0].ToTeamID)
+	assert.False(t, changes[0].Applied)
+	assert.Equal(t, start.Add(time.Minute), changes[0].CreatedAt)
+	assert.Equal(t, &team1.ID, changes[1].FromTeamID)
+	assert.Equal(t, &team2.ID, changes[1].ToTeamID)
+	assert.True(t, changes[1].Applied)
+	assert.Equal(t, &team2.ID, changes[2].FromTeamID)
+	assert.Nil(t, changes[2].ToTeamID)
+	assert.True(t, changes[2].Applied)
+
+	changes, err = ds.ListHostTeamChanges(start.Add(3 * time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+
+	// The team changes are deleted with the host
+	counts, err := ds.HostOrphanCheck(h.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, counts["host_team_changes"])
+	require.NoError(t, ds.DeleteHost(h.ID))
+	changes, err = ds.ListHostTeamChanges(start)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestListFailedEnrollments(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	mockClock := clock.NewMockClock()
+	ds.clock = mockClock
+	start := mockClock.Now().UTC().Truncate(time.Second)
+
+	test.AddAllHostsLabel(t, ds)
+	h, err := ds.EnrollHost("host1", "key1", nil, time.Hour, fleet.EnrollHostOptions{EnrolledFromIP: "10.0.0.1"})
+	require.NoError(t, err)
+
+	// Successful enrollments are not recorded
+	attempts, err := ds.ListFailedEnrollments(start)
+	require.NoError(t, err)
+	assert.Empty(t, attempts)
+
+	_, err = ds.EnrollHost("host1", "key2", nil, time.Hour, fleet.EnrollHostOptions{EnrolledFromIP: "10.0.0.2"})
+	require.Error(t, err)
+	mockClock.AddTime(time.Minute)
+	_, err = ds.EnrollHost("", "key3", nil, 0, fleet.EnrollHostOptions{EnrolledFromIP: "10.0.0.3"})
+	require.Error(t, err)
+	mockClock.AddTime(time.Minute)
+	_, err = ds.EnrollHost("host2", "key4", nil, 0, fleet.EnrollHostOptions{Strategy: "bogus", EnrolledFromIP: "10.0.0.4"})
+	require.Error(t, err)
+	mockClock.AddTime(time.Minute)
+	require.NoError(t, ds.DecommissionHost(h.ID))
+	_, err = ds.EnrollHost("host1", "key5", nil, 0, fleet.EnrollHostOptions{EnrolledFromIP: "10.0.0.5"})
+	// The rejection error is returned unchanged
+	assert.Equal(t, fleet.ErrHostDecommissioned, err)
+
+	attempts, err = ds.ListFailedEnrollments(start)
+	require.NoError(t, err)
+	require.Len(t, attempts, 4)
+	assert.Equal(t, "host1", attempts[0].OsqueryHostID)
+	assert.Equal(t, "10.0.0.2", attempts[0].EnrolledFromIP)
+	assert.Contains(t, attempts[0].Reason, "enrolling too often")
+	assert.Equal(t, start, attempts[0].CreatedAt)
+	assert.Equal(t, "", attempts[1].OsqueryHostID)
+	assert.Contains(t, attempts[1].Reason, "missing osquery host identifier")
+	assert.Contains(t, attempts[2].Reason, "invalid enroll strategy")
+	assert.Equal(t, "10.0.0.5", attempts[3].EnrolledFromIP)
+	assert.Equal(t, fleet.ErrHostDecommissioned.Error(), attempts[3].Reason)
+
+	attempts, err = ds.ListFailedEnrollments(start.Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	assert.Contains(t, attempts[0].Reason, "invalid enroll strategy")
+}
+
+func TestHostKernelVersion(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i, kernel := range []string{"5.4.0-80-generic", "20.6.0", "5.4.0-80-generic", ""} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		h.KernelVersion = kernel
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	h, err := ds.Host(hosts[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "20.6.0", h.KernelVersion)
+	h, err = ds.AuthenticateHost(hosts[0].NodeKey)
+	require.NoError(t, err)
+	assert.Equal(t, "5.4.0-80-generic", h.KernelVersion)
+	h, err = ds.Host(hosts[3].ID)
+	require.NoError(t, err)
+	assert.Empty(t, h.KernelVersion)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listed, err := ds.ListHosts(filter, fleet.HostListOptions{KernelVersionFilter: "5.4.0-80-generic"})
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[2].ID}, []uint{listed[0].ID, listed[1].ID})
+
+	require.NoError(t, ds.SaveHostFields(&fleet.Host{ID: hosts[3].ID, KernelVersion: "10.0.19042"}, []string{"kernel_version"}))
+	h, err = ds.Host(hosts[3].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.19042", h.KernelVersion)
+}
+
+func TestStalestHostsByPlatform(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var hosts []*fleet.Host
+	for i, platform := range []string{"darwin", "ubuntu", "darwin", "darwin", "windows"} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), now)
+		h.Platform = platform
+		h.SeenTime = now.Add(-time.Duration(i) * time.Hour)
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{hosts[0].ID, hosts[1].ID}, false)
+	require.NoError(t, err)
+
+	hostIDs := func(hosts []*fleet.Host) []uint {
+		var ids []uint
+		for _, h := range hosts {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	adminFilter := fleet.TeamFilter{User: test.UserAdmin}
+	stalest, err := ds.StalestHostsByPlatform(adminFilter, 2)
+	require.NoError(t, err)
+	require.Len(t, stalest, 3)
+	assert.Equal(t, []uint{hosts[3].ID, hosts[2].ID}, hostIDs(stalest["darwin"]))
+	assert.Equal(t, []uint{hosts[1].ID}, hostIDs(stalest["ubuntu"]))
+	assert.Equal(t, []uint{hosts[4].ID}, hostIDs(stalest["windows"]))
+
+	teamFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team}},
+	}}
+	stalest, err = ds.StalestHostsByPlatform(teamFilter, 2)
+	require.NoError(t, err)
+	require.Len(t, stalest, 2)
+	assert.Equal(t, []uint{hosts[0].ID}, hostIDs(stalest["darwin"]))
+	assert.Equal(t, "team1", *stalest["darwin"][0].TeamName)
+	assert.Equal(t, []uint{hosts[1].ID}, hostIDs(stalest["ubuntu"]))
+
+	stalest, err = ds.StalestHostsByPlatform(adminFilter, 0)
+	require.NoError(t, err)
+	assert.Empty(t, stalest)
+}
+
+func TestRotateHostNodeKey(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h := test.NewHost(t, ds, "foo.local", "", "1", "key1", time.Now())
+	other := test.NewHost(t, ds, "bar.local", "", "2", "key2", time.Now())
+
+	nodeKey, err := ds.RotateHostNodeKey(h.ID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, nodeKey)
+	assert.NotEqual(t, "key1", nodeKey)
+
+	_, err = ds.AuthenticateHost("key1")
+	assert.True(t, fleet.IsNotFound(err))
+	authed, err := ds.AuthenticateHost(nodeKey)
+	require.NoError(t, err)
+	assert.Equal(t, h.ID, authed.ID)
+
+	// Other hosts are not affected
+	authed, err = ds.AuthenticateHost("key2")
+	require.NoError(t, err)
+	assert.Equal(t, other.ID, authed.ID)
+
+	rotated, err := ds.RotateHostNodeKey(h.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, nodeKey, rotated)
+
+	_, err = ds.RotateHostNodeKey(999)
+	assert.True(t, fleet.IsNotFound(err))
+}
+
+func TestHostDiskEncryption(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i, encrypted := range []*bool{ptr.Bool(true), ptr.Bool(false), nil, ptr.Bool(true)} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		h.DiskEncryptionEnabled = encrypted
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	h, err := ds.Host(hosts[1].ID)
+	require.NoError(t, err)
+	require.NotNil(t, h.DiskEncryptionEnabled)
+	assert.False(t, *h.DiskEncryptionEnabled)
+	h, err = ds.Host(hosts[2].ID)
+	require.NoError(t, err)
+	assert.Nil(t, h.DiskEncryptionEnabled)
+	h, err = ds.AuthenticateHost(hosts[0].NodeKey)
+	require.NoError(t, err)
+	require.NotNil(t, h.DiskEncryptionEnabled)
+	assert.True(t, *h.DiskEncryptionEnabled)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listIDs := func(encrypted bool) []uint {
+		listed, err := ds.ListHosts(filter, fleet.HostListOptions{DiskEncryptionFilter: &encrypted})
+		require.NoError(t, err)
+		var ids []uint
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[3].ID}, listIDs(true))
+	assert.ElementsMatch(t, []uint{hosts[1].ID}, listIDs(false))
+
+	require.NoError(t, ds.SaveHostFields(&fleet.Host{ID: hosts[2].ID, DiskEncryptionEnabled: ptr.Bool(false)}, []string{"disk_encryption_enabled"}))
+	assert.ElementsMatch(t, []uint{hosts[1].ID, hosts[2].ID}, listIDs(false))
+}
+
+func TestRefetchStatus(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	detailUpdatedAt := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Second)
+	h, err := ds.NewHost(&fleet.Host{
+		DetailUpdatedAt: detailUpdatedAt,
+		LabelUpdatedAt:  time.Now(),
+		SeenTime:        time.Now(),
+		OsqueryHostID:   "1",
+		NodeKey:         "1",
+		UUID:            "1",
+		Hostname:        "foo.local",
+	})
+	require.NoError(t, err)
+
+	requested, completed, err := ds.RefetchStatus(h.ID)
+	require.NoError(t, err)
+	assert.False(t, requested)
+	assert.False(t, completed)
+
+	h.RefetchRequested = true
+	require.NoError(t, ds.SaveHost(h))
+	requested, completed, err = ds.RefetchStatus(h.ID)
+	require.NoError(t, err)
+	assert.True(t, requested)
+	assert.False(t, completed)
+
+	// The details are updated while the flag is still set
+	h.DetailUpdatedAt = detailUpdatedAt.Add(time.Hour)
+	require.NoError(t, ds.SaveHost(h))
+	requested, completed, err = ds.RefetchStatus(h.ID)
+	require.NoError(t, err)
+	assert.True(t, requested)
+	assert.True(t, completed)
+
+	// Detecting the completion clears the flag
+	h, err = ds.Host(h.ID)
+	require.NoError(t, err)
+	assert.False(t, h.RefetchRequested)
+	assert.Nil(t, h.RefetchRequestedAt)
+
+	// A new request is in progress until the details are updated again
+	h.RefetchRequested = true
+	require.NoError(t, ds.SaveHost(h))
+	requested, completed, err = ds.RefetchStatus(h.ID)
+	require.NoError(t, err)
+	assert.True(t, requested)
+	assert.False(t, completed)
+
+	h.RefetchRequested = false
+	h.DetailUpdatedAt = detailUpdatedAt.Add(2 * time.Hour)
+	require.NoError(t, ds.SaveHost(h))
+	requested, completed, err = ds.RefetchStatus(h.ID)
+	require.NoError(t, err)
+	assert.True(t, requested)
+	assert.True(t, completed)
+
+	_, _, err = ds.RefetchStatus(999)
+	assert.True(t, fleet.IsNotFound(err))
+}
+
+func TestCountHostsBySubnet(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i, ip := range []string{"10.0.1.5", "10.0.1.200", "10.0.2.7", "", "fe80::1"} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		h.PrimaryIP = ip
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{hosts[0].ID, hosts[2].ID}, false)
+	require.NoError(t, err)
+
+	adminFilter := fleet.TeamFilter{User: test.UserAdmin}
+	counts, err := ds.CountHostsBySubnet(adminFilter, 24)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint{"10.0.1.0/24": 2, "10.0.2.0/24": 1, fleet.SubnetUnknown: 2}, counts)
+
+	counts, err = ds.CountHostsBySubnet(adminFilter, 16)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint{"10.0.0.0/16": 3, fleet.SubnetUnknown: 2}, counts)
+
+	teamFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team}},
+	}}
+	counts, err = ds.CountHostsBySubnet(teamFilter, 24)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint{"10.0.1.0/24": 1, "10.0.2.0/24": 1}, counts)
+
+	_, err = ds.CountHostsBySubnet(adminFilter, 33)
+	assert.Error(t, err)
+}
+
+func TestSearchHostsWithMatches(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h1, err := ds.NewHost(&fleet.Host{
+		OsqueryHostID:   "1",
+		DetailUpdatedAt: time.Now(),
+		LabelUpdatedAt:  time.Now(),
+		SeenTime:        time.Now(),
+		NodeKey:         "1",
+		UUID:            "uuid-foo",
+		Hostname:        "foo.local",
+		PrimaryIP:       "192.168.1.10",
+	})
+	require.NoError(t, err)
+	h2, err := ds.NewHost(&fleet.Host{
+		OsqueryHostID:   "2",
+		DetailUpdatedAt: time.Now(),
+		LabelUpdatedAt:  time.Now(),
+		SeenTime:        time.Now(),
+		NodeKey:         "2",
+		UUID:            "uuid-bar",
+		Hostname:        "bar.local",
+		PrimaryIP:       "192.168.1.11",
+	})
+	require.NoError(t, err)
+	// The primary IP is saved with the host details
+	require.NoError(t, ds.SaveHost(h1))
+	require.NoError(t, ds.SaveHost(h2))
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	matchedFields := func(results []*fleet.HostSearchResult) map[uint][]string {
+		fields := map[uint][]string{}
+		for _, r := range results {
+			fields[r.ID] = r.MatchedFields
+		}
+		return fields
+	}
+
+	results, err := ds.SearchHostsWithMatches(filter, "foo", 0)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint][]string{h1.ID: {"hostname", "uuid"}}, matchedFields(results))
+
+	results, err = ds.SearchHostsWithMatches(filter, "192.168.1.11", 0)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint][]string{h2.ID: {"primary_ip"}}, matchedFields(results))
+
+	results, err = ds.SearchHostsWithMatches(filter, "bar", 0, h2.ID)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestSearchHostsFuzzy(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	now := time.Now()
+	web1 := test.NewHost(t, ds, "webserver01.example.com", "", "1", "1", now)
+	web2 := test.NewHost(t, ds, "webserver02.example.com", "", "2", "2", now)
+	test.NewHost(t, ds, "websrv99.example.com", "", "3", "3", now)
+	db := test.NewHost(t, ds, "db01", "", "4", "4", now)
+	db.ComputerName = "DB01-Primary"
+	require.NoError(t, ds.SaveHost(db))
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	hostIDs := func(hosts []*fleet.Host) []uint {
+		ids := []uint{}
+		for _, h := range hosts {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	// Exact matches rank first.
+	hosts, err := ds.SearchHostsFuzzy(filter, "webserver02", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{web2.ID, web1.ID}, hostIDs(hosts))
+
+	hosts, err = ds.SearchHostsFuzzy(filter, "webserver01.example.com", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{web1.ID, web2.ID}, hostIDs(hosts))
+
+	// Typos are tolerated.
+	hosts, err = ds.SearchHostsFuzzy(filter, "webservr01", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{web1.ID, web2.ID}, hostIDs(hosts))
+
+	hosts, err = ds.SearchHostsFuzzy(filter, "WebSrever01", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{web1.ID}, hostIDs(hosts))
+
+	// Short queries accept a single edit.
+	hosts, err = ds.SearchHostsFuzzy(filter, "db02", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{db.ID}, hostIDs(hosts))
+
+	hosts, err = ds.SearchHostsFuzzy(filter, "db123", 0)
+	require.NoError(t, err)
+	assert.Empty(t, hosts)
+
+	// The computer name is compared too.
+	hosts, err = ds.SearchHostsFuzzy(filter, "db01-primray", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{db.ID}, hostIDs(hosts))
+
+	hosts, err = ds.SearchHostsFuzzy(filter, "nomatch", 0)
+	require.NoError(t, err)
+	assert.Empty(t, hosts)
+}
+
+func TestHostRetirement(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	mockClock := clock.NewMockClock()
+	ds.clock = mockClock
+	now := mockClock.Now().UTC().Truncate(time.Second)
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), now)
+		hosts = append(hosts, h)
+	}
+
+	require.NoError(t, ds.ScheduleHostRetirement(hosts[0].ID, now.Add(time.Hour)))
+	require.NoError(t, ds.ScheduleHostRetirement(hosts[1].ID, now.Add(2*time.Hour)))
+	// Rescheduling replaces the previous time
+	require.NoError(t, ds.ScheduleHostRetirement(hosts[1].ID, now.Add(3*time.Hour)))
+
+	h, err := ds.Host(hosts[0].ID)
+	require.NoError(t, err)
+	require.NotNil(t, h.RetireAt)
+	assert.Equal(t, now.Add(time.Hour), *h.RetireAt)
+	assert.Nil(t, h.DecommissionedAt)
+
+	retired, err := ds.ProcessHostRetirements(now)
+	require.NoError(t, err)
+	assert.Zero(t, retired)
+
+	retired, err = ds.ProcessHostRetirements(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, retired)
+	h, err = ds.Host(hosts[0].ID)
+	require.NoError(t, err)
+	require.NotNil(t, h.DecommissionedAt)
+	_, err = ds.AuthenticateHost(hosts[0].NodeKey)
+	assert.Equal(t, fleet.ErrHostDecommissioned, err)
+
+	// Already retired hosts are not counted again
+	retired, err = ds.ProcessHostRetirements(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, retired)
+	h, err = ds.Host(hosts[1].ID)
+	require.NoError(t, err)
+	assert.Nil(t, h.DecommissionedAt)
+
+	// Scheduling in the past retires immediately
+	require.NoError(t, ds.ScheduleHostRetirement(hosts[2].ID, now.Add(-time.Hour)))
+	h, err = ds.Host(hosts[2].ID)
+	require.NoError(t, err)
+	require.NotNil(t, h.DecommissionedAt)
+	assert.Equal(t, now, *h.DecommissionedAt)
+
+	assert.True(t, fleet.IsNotFound(ds.ScheduleHostRetirement(999, now)))
+}
+
+func TestListHostsHasActiveCarves(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 4; i++ {
+		hosts = append(hosts, test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now()))
+	}
+	newCarve := func(h *fleet.Host, name string, maxBlock int64, expired bool) {
+		c, err := ds.NewCarve(&fleet.CarveMetadata{
+			HostId:     h.ID,
+			Name:       name,
+			BlockCount: 3,
+			BlockSize:  8,
+			CarveSize:  20,
+			CarveId:    name,
+			RequestId:  name,
+			SessionId:  name,
+			CreatedAt:  time.Now(),
+		}, 0)
+		require.NoError(t, err)
+		c.MaxBlock = maxBlock
+		c.Expired = expired
+		require.NoError(t, ds.UpdateCarve(c))
+	}
+	// In progress
+	newCarve(hosts[0], "carve0", 1, false)
+	// Complete and expired carves are not active
+	newCarve(hosts[1], "carve1", 2, false)
+	newCarve(hosts[2], "carve2", 0, true)
+	newCarve(hosts[2], "carve3", -1, false)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listed, err := ds.ListHosts(filter, fleet.HostListOptions{HasActiveCarves: true})
+	require.NoError(t, err)
+	var ids []uint
+	for _, h := range listed {
+		ids = append(ids, h.ID)
+	}
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[2].ID}, ids)
+
+	listed, err = ds.ListHosts(filter, fleet.HostListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, listed, 4)
+}
+
+func TestListHostsHasUser(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i, username := range []string{"Admin", "admin", "bob"} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		h.Users = []fleet.HostUser{{Uid: 500, Username: username, Type: "local", GroupName: "staff"}}
+		h.Modified = true
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{hosts[1].ID}, false)
+	require.NoError(t, err)
+
+	listIDs := func(filter fleet.TeamFilter, username string) []uint {
+		listed, err := ds.ListHosts(filter, fleet.HostListOptions{HasUser: username})
+		require.NoError(t, err)
+		var ids []uint
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	adminFilter := fleet.TeamFilter{User: test.UserAdmin}
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[1].ID}, listIDs(adminFilter, "ADMIN"))
+	assert.ElementsMatch(t, []uint{hosts[2].ID}, listIDs(adminFilter, "bob"))
+	assert.Empty(t, listIDs(adminFilter, "adm"))
+
+	teamFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team}},
+	}}
+	assert.ElementsMatch(t, []uint{hosts[1].ID}, listIDs(teamFilter, "admin"))
+
+	// Removed users no longer match
+	hosts[0].Users = []fleet.HostUser{}
+	hosts[0].Modified = true
+	require.NoError(t, ds.SaveHost(hosts[0]))
+	assert.ElementsMatch(t, []uint{hosts[1].ID}, listIDs(adminFilter, "admin"))
+}
+
+func TestHostCloudInstance(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i, instance := range []struct{ provider, id string }{
+		{"aws", "i-0123456789abcdef0"},
+		{"azure", "8d10da9d-2b2d-4b7e-9e5a-1a0a3c8b6f11"},
+		{"aws", "i-0fedcba9876543210"},
+		{"", ""},
+	} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		h.CloudProvider = instance.provider
+		h.CloudInstanceID = instance.id
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	h, err := ds.Host(hosts[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "azure", h.CloudProvider)
+	assert.Equal(t, "8d10da9d-2b2d-4b7e-9e5a-1a0a3c8b6f11", h.CloudInstanceID)
+	h, err = ds.AuthenticateHost(hosts[0].NodeKey)
+	require.NoError(t, err)
+	assert.Equal(t, "aws", h.CloudProvider)
+	assert.Equal(t, "i-0123456789abcdef0", h.CloudInstanceID)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listIDs := func(opt fleet.HostListOptions) []uint {
+		listed, err := ds.ListHosts(filter, opt)
+		require.NoError(t, err)
+		ids := []uint{}
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[2].ID}, listIDs(fleet.HostListOptions{CloudProviderFilter: "aws"}))
+	assert.ElementsMatch(t, []uint{hosts[2].ID}, listIDs(fleet.HostListOptions{CloudInstanceIDFilter: "i-0fedcba9876543210"}))
+	assert.ElementsMatch(t, []uint{hosts[1].ID}, listIDs(fleet.HostListOptions{ListOptions: fleet.ListOptions{MatchQuery: "8d10da9d"}}))
+
+	h, err = ds.HostByIdentifier("i-0fedcba9876543210")
+	require.NoError(t, err)
+	assert.Equal(t, hosts[2].ID, h.ID)
+
+	// On-prem hosts have no instance ID to match.
+	_, err = ds.HostByIdentifier("")
+	assert.True(t, fleet.IsNotFound(err))
+
+	searched, err := ds.SearchHosts(filter, "i-0123456789abcdef0", 0)
+	require.NoError(t, err)
+	require.Len(t, searched, 1)
+	assert.Equal(t, hosts[0].ID, searched[0].ID)
+
+	require.NoError(t, ds.SaveHostFields(&fleet.Host{ID: hosts[3].ID, CloudProvider: "aws", CloudInstanceID: "i-0aaaaaaaaaaaaaaaa"}, []string{"cloud_provider", "cloud_instance_id"}))
+	h, err = ds.Host(hosts[3].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "aws", h.CloudProvider)
+	assert.Equal(t, "i-0aaaaaaaaaaaaaaaa", h.CloudInstanceID)
+}
+
+func TestHostMDM(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i, enrolled := range []*bool{ptr.Bool(true), ptr.Bool(false), nil, ptr.Bool(true)} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		h.MDMEnrolled = enrolled
+		if enrolled != nil && *enrolled {
+			h.MDMServerURL = fmt.Sprintf("https://mdm%d.example.com", i)
+		}
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	h, err := ds.Host(hosts[1].ID)
+	require.NoError(t, err)
+	require.NotNil(t, h.MDMEnrolled)
+	assert.False(t, *h.MDMEnrolled)
+	h, err = ds.Host(hosts[2].ID)
+	require.NoError(t, err)
+	assert.Nil(t, h.MDMEnrolled)
+	h, err = ds.AuthenticateHost(hosts[0].NodeKey)
+	require.NoError(t, err)
+	require.NotNil(t, h.MDMEnrolled)
+	assert.True(t, *h.MDMEnrolled)
+	assert.Equal(t, "https://mdm0.example.com", h.MDMServerURL)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listIDs := func(opt fleet.HostListOptions) []uint {
+		listed, err := ds.ListHosts(filter, opt)
+		require.NoError(t, err)
+		ids := []uint{}
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[3].ID}, listIDs(fleet.HostListOptions{MDMEnrolledFilter: ptr.Bool(true)}))
+	assert.ElementsMatch(t, []uint{hosts[1].ID}, listIDs(fleet.HostListOptions{MDMEnrolledFilter: ptr.Bool(false)}))
+	assert.ElementsMatch(t, []uint{hosts[3].ID}, listIDs(fleet.HostListOptions{MDMServerURLFilter: "https://mdm3.example.com"}))
+
+	require.NoError(t, ds.SaveHostFields(&fleet.Host{ID: hosts[2].ID, MDMEnrolled: ptr.Bool(false)}, []string{"mdm_enrolled"}))
+	assert.ElementsMatch(t, []uint{hosts[1].ID, hosts[2].ID}, listIDs(fleet.HostListOptions{MDMEnrolledFilter: ptr.Bool(false)}))
+}
+
+func TestHostBattery(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i, battery := range []struct {
+		cycles *int
+		health *string
+	}{
+		{ptr.Int(120), ptr.String(fleet.BatteryHealthGood)},
+		{ptr.Int(640), ptr.String(fleet.BatteryHealthFair)},
+		// Desktop without a battery
+		{nil, nil},
+		{ptr.Int(1200), ptr.String(fleet.BatteryHealthGood)},
+		{nil, ptr.String(fleet.BatteryHealthPoor)},
+	} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		h.BatteryCycleCount = battery.cycles
+		h.BatteryHealth = battery.health
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	h, err := ds.Host(hosts[1].ID)
+	require.NoError(t, err)
+	require.NotNil(t, h.BatteryCycleCount)
+	assert.Equal(t, 640, *h.BatteryCycleCount)
+	require.NotNil(t, h.BatteryHealth)
+	assert.Equal(t, fleet.BatteryHealthFair, *h.BatteryHealth)
+	h, err = ds.AuthenticateHost(hosts[2].NodeKey)
+	require.NoError(t, err)
+	assert.Nil(t, h.BatteryCycleCount)
+	assert.Nil(t, h.BatteryHealth)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listed, err := ds.ListHosts(filter, fleet.HostListOptions{BatteryHealthFilter: fleet.BatteryHealthGood})
+	require.NoError(t, err)
+	ids := []uint{}
+	for _, h := range listed {
+		ids = append(ids, h.ID)
+	}
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[3].ID}, ids)
+
+	poor, err := ds.ListHostsWithPoorBatteryHealth(filter)
+	require.NoError(t, err)
+	ids = []uint{}
+	for _, h := range poor {
+		assert.True(t, h.PoorBatteryHealth())
+		ids = append(ids, h.ID)
+	}
+	assert.Equal(t, []uint{hosts[1].ID, hosts[3].ID, hosts[4].ID}, ids)
+
+	// The battery was replaced
+	require.NoError(t, ds.SaveHostFields(&fleet.Host{ID: hosts[3].ID, BatteryCycleCount: ptr.Int(3), BatteryHealth: ptr.String(fleet.BatteryHealthGood)}, []string{"battery_cycle_count", "battery_health"}))
+	poor, err = ds.ListHostsWithPoorBatteryHealth(filter)
+	require.NoError(t, err)
+	assert.Len(t, poor, 2)
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{hosts[1].ID}, false)
+	require.NoError(t, err)
+	poor, err = ds.ListHostsWithPoorBatteryHealth(fleet.TeamFilter{User: &fleet.User{Teams: []fleet.UserTeam{{Team: *team, Role: fleet.RoleObserver}}}})
+	require.NoError(t, err)
+	require.Len(t, poor, 1)
+	assert.Equal(t, hosts[1].ID, poor[0].ID)
+}
+
+func TestHostDetailQueryStatus(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	h := test.NewHost(t, ds, "foo.local", "", "1", "1", time.Now())
+
+	status, err := ds.HostDetailQueryStatus(h.ID)
+	require.NoError(t, err)
+	assert.Empty(t, status)
+
+	first := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	h.DetailUpdatedAt = first
+	h.DetailQueriesSucceeded = []string{"os_version", "uptime"}
+	require.NoError(t, ds.SaveHost(h))
+
+	second := first.Add(30 * time.Minute)
+	h.DetailUpdatedAt = second
+	h.DetailQueriesSucceeded = []string{"uptime", "timezone"}
+	require.NoError(t, ds.SaveHost(h))
+
+	// Saving without detail queries keeps the status
+	h.DetailQueriesSucceeded = nil
+	require.NoError(t, ds.SaveHost(h))
+
+	status, err = ds.HostDetailQueryStatus(h.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Time{
+		"os_version": first,
+		"uptime":     second,
+		"timezone":   second,
+	}, status)
+
+	require.NoError(t, ds.DeleteHost(h.ID))
+	status, err = ds.HostDetailQueryStatus(h.ID)
+	require.NoError(t, err)
+	assert.Empty(t, status)
+}
+
+func TestListHostsRefetchRequested(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 3; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		hosts = append(hosts, h)
+	}
+	hosts[0].RefetchRequested = true
+	require.NoError(t, ds.SaveHost(hosts[0]))
+	hosts[2].RefetchRequested = true
+	require.NoError(t, ds.SaveHost(hosts[2]))
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listIDs := func(opt fleet.HostListOptions) []uint {
+		listed, err := ds.ListHosts(filter, opt)
+		require.NoError(t, err)
+		ids := []uint{}
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[2].ID}, listIDs(fleet.HostListOptions{RefetchRequestedFilter: ptr.Bool(true)}))
+	assert.ElementsMatch(t, []uint{hosts[1].ID}, listIDs(fleet.HostListOptions{RefetchRequestedFilter: ptr.Bool(false)}))
+	assert.Len(t, listIDs(fleet.HostListOptions{}), 3)
+
+	// Refetches stuck on offline hosts
+	hosts[2].SeenTime = time.Now().Add(-time.Hour)
+	require.NoError(t, ds.SaveHost(hosts[2]))
+	assert.Equal(t, []uint{hosts[2].ID}, listIDs(fleet.HostListOptions{
+		StatusFilter:           fleet.StatusOffline,
+		RefetchRequestedFilter: ptr.Bool(true),
+	}))
+}
+
+func TestHostOrbitVersion(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i, version := range []string{"0.0.3", "0.0.2", "0.0.3", ""} {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		h.OrbitVersion = version
+		require.NoError(t, ds.SaveHost(h))
+		hosts = append(hosts, h)
+	}
+
+	h, err := ds.Host(hosts[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.2", h.OrbitVersion)
+	h, err = ds.AuthenticateHost(hosts[0].NodeKey)
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.3", h.OrbitVersion)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listed, err := ds.ListHosts(filter, fleet.HostListOptions{OrbitVersionFilter: "0.0.3"})
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.ElementsMatch(t, []uint{hosts[0].ID, hosts[2].ID}, []uint{listed[0].ID, listed[1].ID})
+
+	require.NoError(t, ds.SaveHostFields(&fleet.Host{ID: hosts[3].ID, OrbitVersion: "0.0.4"}, []string{"orbit_version"}))
+	h, err = ds.Host(hosts[3].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.4", h.OrbitVersion)
+}
+
+func TestIterHosts(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i := 0; i < 5; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		hosts = append(hosts, h)
+	}
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{hosts[1].ID}, false)
+	require.NoError(t, err)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	iterIDs := func(opt fleet.HostListOptions) []uint {
+		var ids []uint
+		require.NoError(t, ds.IterHosts(filter, opt, func(h *fleet.Host) error {
+			ids = append(ids, h.ID)
+			return nil
+		}))
+		return ids
+	}
+
+	opt := fleet.HostListOptions{ListOptions: fleet.ListOptions{OrderKey: "hostname", OrderDirection: fleet.OrderDescending}}
+	assert.Equal(t, []uint{hosts[4].ID, hosts[3].ID, hosts[2].ID, hosts[1].ID, hosts[0].ID}, iterIDs(opt))
+	opt.PerPage = 2
+	opt.Page = 1
+	assert.Equal(t, []uint{hosts[2].ID, hosts[1].ID}, iterIDs(opt))
+
+	// The hosts are loaded like ListHosts
+	var iterated *fleet.Host
+	require.NoError(t, ds.IterHosts(filter, fleet.HostListOptions{ListOptions: fleet.ListOptions{MatchQuery: "foo1"}}, func(h *fleet.Host) error {
+		iterated = h
+		return nil
+	}))
+	require.NotNil(t, iterated)
+	listed, err := ds.ListHosts(filter, fleet.HostListOptions{ListOptions: fleet.ListOptions{MatchQuery: "foo1"}})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, listed[0], iterated)
+	assert.Equal(t, "team1", *iterated.TeamName)
+
+	// Iteration stops at the first error
+	stop := fmt.Errorf("stop")
+	var count int
+	err = ds.IterHosts(filter, fleet.HostListOptions{}, func(h *fleet.Host) error {
+		count++
+		if count == 2 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestListHostsDefaultOrder(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hosts []*fleet.Host
+	for i, name := range []string{"charlie.local", "alpha.local", "bravo.local", "alpha.local"} {
+		h := test.NewHost(t, ds, name, "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		hosts = append(hosts, h)
+	}
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	listIDs := func(opt fleet.HostListOptions) []uint {
+		listed, err := ds.ListHosts(filter, opt)
+		require.NoError(t, err)
+		ids := []uint{}
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	// By hostname, then by ID when the hostnames are equal
+	assert.Equal(t, []uint{hosts[1].ID, hosts[3].ID, hosts[2].ID, hosts[0].ID}, listIDs(fleet.HostListOptions{}))
+	assert.Equal(t, []uint{hosts[3].ID, hosts[2].ID}, listIDs(fleet.HostListOptions{ListOptions: fleet.ListOptions{PerPage: 2, Page: 1}}))
+
+	var iterated []uint
+	require.NoError(t, ds.IterHosts(filter, fleet.HostListOptions{}, func(h *fleet.Host) error {
+		iterated = append(iterated, h.ID)
+		return nil
+	}))
+	assert.Equal(t, []uint{hosts[1].ID, hosts[3].ID, hosts[2].ID, hosts[0].ID}, iterated)
+
+	// An explicit order key replaces the default
+	assert.Equal(t, []uint{hosts[3].ID, hosts[2].ID, hosts[1].ID, hosts[0].ID}, listIDs(fleet.HostListOptions{ListOptions: fleet.ListOptions{OrderKey: "id", OrderDirection: fleet.OrderDescending}}))
+	assert.Len(t, listIDs(fleet.HostListOptions{ListOptions: fleet.ListOptions{OrderKey: "team_name"}}), 4)
+
+	_, err := ds.ListHosts(filter, fleet.HostListOptions{ListOptions: fleet.ListOptions{OrderKey: "node_key"}})
+	require.Error(t, err)
+	err = ds.IterHosts(filter, fleet.HostListOptions{ListOptions: fleet.ListOptions{OrderKey: "bogus"}}, func(h *fleet.Host) error { return nil })
+	require.Error(t, err)
+}
+
+func TestListEnrolledNeverSeenHosts(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	var hosts []*fleet.Host
+	for i := 0; i < 4; i++ {
+		h, err := ds.EnrollHost(fmt.Sprint(i), fmt.Sprintf("key%d", i), nil, 0, fleet.EnrollHostOptions{})
+		require.NoError(t, err)
+		hosts = append(hosts, h)
+	}
+	// The last host never reported its details
+	for _, h := range hosts[:3] {
+		h.Hostname = fmt.Sprintf("foo%d.local", h.ID)
+		require.NoError(t, ds.SaveHostFields(h, []string{"hostname"}))
+	}
+	require.NoError(t, ds.MarkHostSeen(hosts[1], time.Now().Add(time.Minute)))
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{hosts[2].ID}, false)
+	require.NoError(t, err)
+
+	listIDs := func(filter fleet.TeamFilter) []uint {
+		listed, err := ds.ListEnrolledNeverSeenHosts(filter)
+		require.NoError(t, err)
+		ids := []uint{}
+		for _, h := range listed {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []uint{hosts[0].ID, hosts[2].ID}, listIDs(fleet.TeamFilter{User: test.UserAdmin}))
+
+	teamFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team}},
+	}}
+	assert.Equal(t, []uint{hosts[2].ID}, listIDs(teamFilter))
+
+	// Once seen, the host is not listed anymore
+	require.NoError(t, ds.MarkHostSeen(hosts[0], time.Now().Add(time.Minute)))
+	assert.Equal(t, []uint{hosts[2].ID}, listIDs(fleet.TeamFilter{User: test.UserAdmin}))
+}
+
+func TestListHostsWithPendingActions(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+
+	var hosts []*fleet.Host
+	for i := 0; i < 5; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		hosts = append(hosts, h)
+	}
+	hosts[0].RefetchRequested = true
+	require.NoError(t, ds.SaveHost(hosts[0]))
+	require.NoError(t, ds.ScheduleHostRetirement(hosts[1].ID, time.Now().Add(24*time.Hour)))
+	hosts[2].RefetchRequested = true
+	require.NoError(t, ds.SaveHost(hosts[2]))
+	require.NoError(t, ds.ScheduleHostRetirement(hosts[2].ID, time.Now().Add(24*time.Hour)))
+	// Decommissioned hosts can't act on their refetch request
+	hosts[3].RefetchRequested = true
+	require.NoError(t, ds.SaveHost(hosts[3]))
+	require.NoError(t, ds.DecommissionHost(hosts[3].ID))
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{hosts[2].ID}, false)
+	require.NoError(t, err)
+
+	listed, err := ds.ListHostsWithPendingActions(fleet.TeamFilter{User: test.UserAdmin})
+	require.NoError(t, err)
+	require.Len(t, listed, 3)
+	assert.Equal(t, hosts[0].ID, listed[0].ID)
+	assert.Equal(t, []string{fleet.HostActionRefetch}, listed[0].PendingActions())
+	assert.Equal(t, hosts[1].ID, listed[1].ID)
+	assert.Equal(t, []string{fleet.HostActionRetire}, listed[1].PendingActions())
+	assert.Equal(t, hosts[2].ID, listed[2].ID)
+	assert.Equal(t, []string{fleet.HostActionRefetch, fleet.HostActionRetire}, listed[2].PendingActions())
+	assert.Equal(t, "team1", *listed[2].TeamName)
+
+	teamFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team}},
+	}}
+	listed, err = ds.ListHostsWithPendingActions(teamFilter)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, hosts[2].ID, listed[0].ID)
+}
+
+func TestHostFieldChanges(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	mockClock := clock.NewMockClock()
+	ds.clock = mockClock
+	ds.trackedHostFields = []string{"hostname", "hardware_serial", "team_id"}
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	h := test.NewHost(t, ds, "foo.local", "", "1", "1", time.Now())
+
+	// Saving without changes records nothing
+	require.NoError(t, ds.SaveHost(h))
+	changes, err := ds.ListHostFieldChanges(h.ID, nil)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	h.Hostname = "bar.local"
+	h.HardwareSerial = "ABC123"
+	h.Platform = "darwin" // not tracked
+	require.NoError(t, ds.SaveHost(h))
+
+	mockClock.AddTime(time.Minute)
+	h.TeamID = &team.ID
+	require.NoError(t, ds.SaveHost(h))
+
+	mockClock.AddTime(time.Minute)
+	h.Hostname = "baz.local"
+	h.HardwareSerial = "XYZ789"
+	require.NoError(t, ds.SaveHostFields(h, []string{"hostname", "platform"}))
+
+	changes, err = ds.ListHostFieldChanges(h.ID, nil)
+	require.NoError(t, err)
+	require.Len(t, changes, 4)
+	assert.Equal(t, "hostname", changes[0].Field)
+	assert.Equal(t, "foo.local", changes[0].OldValue)
+	assert.Equal(t, "bar.local", changes[0].NewValue)
+	assert.Equal(t, "hardware_serial", changes[1].Field)
+	assert.Equal(t, "", changes[1].OldValue)
+	assert.Equal(t, "ABC123", changes[1].NewValue)
+	assert.Equal(t, "team_id", changes[2].Field)
+	assert.Equal(t, "", changes[2].OldValue)
+	assert.Equal(t, strconv.Itoa(int(team.ID)), changes[2].NewValue)
+	assert.Equal(t, mockClock.Now().Add(-time.Minute).UTC().Truncate(time.Second), changes[2].CreatedAt)
+	// Only the saved fields are compared by SaveHostFields
+	assert.Equal(t, "hostname", changes[3].Field)
+	assert.Equal(t, "bar.local", changes[3].OldValue)
+	assert.Equal(t, "baz.local", changes[3].NewValue)
+
+	changes, err = ds.ListHostFieldChanges(h.ID, []string{"hostname"})
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "baz.local", changes[1].NewValue)
+
+	changes, err = ds.ListHostFieldChanges(h.ID, []string{"platform"})
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	require.Error(t, TrackHostFields("hostname", "bogus")(&dbOptions{}))
+	require.NoError(t, TrackHostFields("hostname", "team_id")(&dbOptions{}))
 }
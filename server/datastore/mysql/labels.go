@@ -246,7 +246,7 @@ func (d *Datastore) Label(lid uint) (*fleet.Label, error) {
 func (d *Datastore) ListLabels(filter fleet.TeamFilter, opt fleet.ListOptions) ([]*fleet.Label, error) {
 	query := fmt.Sprintf(`
 			SELECT *,
-				(SELECT COUNT(1) FROM label_membership lm JOIN hosts h ON (lm.host_id = h.id) WHERE label_id = l.id AND %s) AS host_count
+				(SELECT COUNT(1) FROM label_membership lm JOIN hosts h ON (lm.host_id = h.id) WHERE label_id = l.id AND h.decommissioned_at IS NULL AND %s) AS host_count
 			FROM labels l
 		`, d.whereFilterHostsByTeams(filter, "h"),
 	)
@@ -401,26 +401,9 @@ func (d *Datastore) ListLabelsForHost(hid uint) ([]*fleet.Label, error) {
 // ListHostsInLabel returns a list of fleet.Host that are associated
 // with fleet.Label referened by Label ID
 func (d *Datastore) ListHostsInLabel(filter fleet.TeamFilter, lid uint, opt fleet.HostListOptions) ([]*fleet.Host, error) {
-	sql := fmt.Sprintf(`
-			SELECT h.*, (SELECT name FROM teams t WHERE t.id = h.team_id) AS team_name
-			FROM label_membership lm
-			JOIN hosts h
-			ON lm.host_id = h.id
-			WHERE lm.label_id = ? AND %s
-		`, d.whereFilterHostsByTeams(filter, "h"),
-	)
-
-	params := []interface{}{lid}
-
-	sql, params = searchLike(sql, params, opt.MatchQuery, hostSearchColumns...)
-
-	sql = appendListOptionsToSQL(sql, opt.ListOptions)
-	hosts := []*fleet.Host{}
-	err := d.db.Select(&hosts, sql, params...)
-	if err != nil {
-		return nil, errors.Wrap(err, "selecting label query executions")
-	}
-	return hosts, nil
+	opt.LabelIDs = []uint{lid}
+	opt.LabelMatchMode = fleet.LabelMatchAny
+	return d.ListHosts(filter, opt)
 }
 
 func (d *Datastore) ListUniqueHostsInLabels(filter fleet.TeamFilter, labels []uint) ([]*fleet.Host, error) {
@@ -429,11 +412,12 @@ func (d *Datastore) ListUniqueHostsInLabels(filter fleet.TeamFilter, labels []ui
 	}
 
 	sqlStatement := fmt.Sprintf(`
-			SELECT DISTINCT h.*, (SELECT name FROM teams t WHERE t.id = h.team_id) AS team_name
+			SELECT DISTINCT h.*, t.name AS team_name, t.new_host_hours AS team_new_host_hours
 			FROM label_membership lm
 			JOIN hosts h
 			ON lm.host_id = h.id
-			WHERE lm.label_id IN (?) AND %s
+			LEFT JOIN teams t ON (h.team_id = t.id)
+			WHERE lm.label_id IN (?) AND h.decommissioned_at IS NULL AND %s
 		`, d.whereFilterHostsByTeams(filter, "h"),
 	)
 
@@ -460,7 +444,7 @@ func (d *Datastore) searchLabelsWithOmits(filter fleet.TeamFilter, query string,
 			SELECT *,
 				(SELECT COUNT(1)
 					FROM label_membership lm JOIN hosts h ON (lm.host_id = h.id)
-					WHERE label_id = l.id AND %s
+					WHERE label_id = l.id AND h.decommissioned_at IS NULL AND %s
 				) AS host_count
 			FROM labels l
 			WHERE (
@@ -501,7 +485,7 @@ func (d *Datastore) addAllHostsLabelToList(filter fleet.TeamFilter, labels []*fl
 			SELECT *,
 				(SELECT COUNT(1)
 					FROM label_membership lm JOIN hosts h ON (lm.host_id = h.id)
-					WHERE label_id = l.id AND %s
+					WHERE label_id = l.id AND h.decommissioned_at IS NULL AND %s
 				) AS host_count
 			FROM labels l
 			WHERE
@@ -536,7 +520,7 @@ func (d *Datastore) searchLabelsDefault(filter fleet.TeamFilter, omit ...uint) (
 			SELECT *,
 				(SELECT COUNT(1)
 					FROM label_membership lm JOIN hosts h ON (lm.host_id = h.id)
-					WHERE label_id = l.id AND %s
+					WHERE label_id = l.id AND h.decommissioned_at IS NULL AND %s
 				) AS host_count
 			FROM labels l
 			WHERE id NOT IN (?)
@@ -592,7 +576,7 @@ func (d *Datastore) SearchLabels(filter fleet.TeamFilter, query string, omit ...
 			SELECT *,
 				(SELECT COUNT(1)
 						FROM label_membership lm JOIN hosts h ON (lm.host_id = h.id)
-						WHERE label_id = l.id AND %s
+						WHERE label_id = l.id AND h.decommissioned_at IS NULL AND %s
 					) AS host_count
 				FROM labels l
 			WHERE (
@@ -639,3 +623,93 @@ func (d *Datastore) LabelIDsByName(labels []string) ([]uint, error) {
 	return labelIDs, nil
 
 }
+
+// manualLabel returns an error unless the label exists and is a manual label.
+func (d *Datastore) manualLabel(labelID uint) error {
+	var membershipType fleet.LabelMembershipType
+	err := d.db.Get(&membershipType, `SELECT label_membership_type FROM labels WHERE id = ?`, labelID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return notFound("Label").WithID(labelID)
+		}
+		return errors.Wrap(err, "get label membership type")
+	}
+	if membershipType != fleet.LabelMembershipTypeManual {
+		return &fleet.LabelNotManualError{LabelID: labelID}
+	}
+	return nil
+}
+
+func (d *Datastore) AddHostsToLabel(labelID uint, hostIDs []uint) error {
+	if err := d.manualLabel(labelID); err != nil {
+		return err
+	}
+	if len(hostIDs) == 0 {
+		return nil
+	}
+
+	stmt, args, err := sqlx.In(
+		`INSERT IGNORE INTO label_membership (label_id, host_id) (SELECT ?, id FROM hosts WHERE id IN (?))`,
+		labelID, hostIDs,
+	)
+	if err != nil {
+		return errors.Wrap(err, "build membership IN statement")
+	}
+	if _, err := d.db.Exec(stmt, args...); err != nil {
+		return errors.Wrap(err, "add hosts to label")
+	}
+	return nil
+}
+
+func (d *Datastore) RemoveHostsFromLabel(labelID uint, hostIDs []uint) error {
+	if err := d.manualLabel(labelID); err != nil {
+		return err
+	}
+	if len(hostIDs) == 0 {
+		return nil
+	}
+
+	stmt, args, err := sqlx.In(
+		`DELETE FROM label_membership WHERE label_id = ? AND host_id IN (?)`,
+		labelID, hostIDs,
+	)
+	if err != nil {
+		return errors.Wrap(err, "build membership IN statement")
+	}
+	if _, err := d.db.Exec(stmt, args...); err != nil {
+		return errors.Wrap(err, "remove hosts from label")
+	}
+	return nil
+}
+
+func (d *Datastore) SnapshotLabelCounts(now time.Time) error {
+	_, err := d.db.Exec(`
+		INSERT INTO label_count_history (label_id, created_at, count)
+		SELECT l.id, ?, COUNT(h.id)
+		FROM labels l
+		LEFT JOIN label_membership lm ON (lm.label_id = l.id)
+		LEFT JOIN hosts h ON (h.id = lm.host_id AND h.decommissioned_at IS NULL)
+		GROUP BY l.id
+		ON DUPLICATE KEY UPDATE count = VALUES(count)`,
+		now.UTC().Truncate(time.Second),
+	)
+	if err != nil {
+		return errors.Wrap(err, "snapshot label counts")
+	}
+	return nil
+}
+
+func (d *Datastore) LabelCountHistory(labelID uint, from, to time.Time) ([]fleet.LabelCountPoint, error) {
+	points := []fleet.LabelCountPoint{}
+	err := d.db.Select(&points, `
+		SELECT created_at, count
+		FROM label_count_history
+		WHERE label_id = ? AND created_at BETWEEN ? AND ?
+		ORDER BY created_at`,
+		labelID, from, to,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "get label count history")
+	}
+	return points, nil
+}
@@ -44,7 +44,7 @@ func TestLabels(t *testing.T) {
 	var host *fleet.Host
 	var err error
 	for i := 0; i < 10; i++ {
-		host, err = db.EnrollHost(fmt.Sprint(i), fmt.Sprint(i), nil, 0)
+		host, err = db.EnrollHost(fmt.Sprint(i), fmt.Sprint(i), nil, 0, fleet.EnrollHostOptions{})
 		require.Nil(t, err, "enrollment should succeed")
 		hosts = append(hosts, *host)
 	}
@@ -544,3 +544,97 @@ func TestSaveLabel(t *testing.T) {
 	assert.Equal(t, label.Name, saved.Name)
 	assert.Equal(t, label.Description, saved.Description)
 }
+
+func TestAddRemoveHostsLabel(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hostIDs []uint
+	for i := 0; i < 4; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		hostIDs = append(hostIDs, h.ID)
+	}
+
+	manual, err := ds.NewLabel(&fleet.Label{Name: "manual", LabelMembershipType: fleet.LabelMembershipTypeManual})
+	require.NoError(t, err)
+	dynamic, err := ds.NewLabel(&fleet.Label{Name: "dynamic", Query: "select 1"})
+	require.NoError(t, err)
+
+	listIDs := func(labelID uint) []uint {
+		hosts, err := ds.ListHostsInLabel(fleet.TeamFilter{User: test.UserAdmin}, labelID, fleet.HostListOptions{})
+		require.NoError(t, err)
+		var ids []uint
+		for _, h := range hosts {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	// Missing hosts and duplicates are ignored
+	require.NoError(t, ds.AddHostsToLabel(manual.ID, []uint{hostIDs[0], hostIDs[1], hostIDs[1], 999}))
+	require.NoError(t, ds.AddHostsToLabel(manual.ID, []uint{hostIDs[1], hostIDs[2]}))
+	assert.ElementsMatch(t, hostIDs[:3], listIDs(manual.ID))
+
+	require.NoError(t, ds.RemoveHostsFromLabel(manual.ID, []uint{hostIDs[0], hostIDs[2], hostIDs[3]}))
+	assert.ElementsMatch(t, []uint{hostIDs[1]}, listIDs(manual.ID))
+
+	require.NoError(t, ds.AddHostsToLabel(manual.ID, nil))
+	require.NoError(t, ds.RemoveHostsFromLabel(manual.ID, nil))
+
+	// Dynamic labels can't be edited
+	err = ds.AddHostsToLabel(dynamic.ID, hostIDs)
+	require.IsType(t, &fleet.LabelNotManualError{}, err)
+	assert.Equal(t, dynamic.ID, err.(*fleet.LabelNotManualError).LabelID)
+	err = ds.RemoveHostsFromLabel(dynamic.ID, hostIDs)
+	require.IsType(t, &fleet.LabelNotManualError{}, err)
+	assert.Empty(t, listIDs(dynamic.ID))
+
+	err = ds.AddHostsToLabel(dynamic.ID+manual.ID, hostIDs)
+	assert.True(t, fleet.IsNotFound(err))
+}
+
+func TestLabelCountHistory(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var hostIDs []uint
+	for i := 0; i < 3; i++ {
+		h := test.NewHost(t, ds, fmt.Sprintf("foo%d.local", i), "", strconv.Itoa(i), strconv.Itoa(i), time.Now())
+		hostIDs = append(hostIDs, h.ID)
+	}
+
+	label, err := ds.NewLabel(&fleet.Label{Name: "manual", LabelMembershipType: fleet.LabelMembershipTypeManual})
+	require.NoError(t, err)
+	empty, err := ds.NewLabel(&fleet.Label{Name: "empty", LabelMembershipType: fleet.LabelMembershipTypeManual})
+	require.NoError(t, err)
+
+	start := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, ds.AddHostsToLabel(label.ID, hostIDs[:1]))
+	require.NoError(t, ds.SnapshotLabelCounts(start))
+	require.NoError(t, ds.AddHostsToLabel(label.ID, hostIDs))
+	require.NoError(t, ds.SnapshotLabelCounts(start.Add(time.Hour)))
+	// Snapshotting the same time again replaces the counts
+	require.NoError(t, ds.RemoveHostsFromLabel(label.ID, hostIDs[:1]))
+	require.NoError(t, ds.SnapshotLabelCounts(start.Add(time.Hour)))
+	require.NoError(t, ds.SnapshotLabelCounts(start.Add(3*time.Hour)))
+
+	history, err := ds.LabelCountHistory(label.ID, start, start.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []fleet.LabelCountPoint{
+		{T: start, Count: 1},
+		{T: start.Add(time.Hour), Count: 2},
+		{T: start.Add(3 * time.Hour), Count: 2},
+	}, history)
+
+	history, err = ds.LabelCountHistory(label.ID, start.Add(time.Minute), start.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []fleet.LabelCountPoint{{T: start.Add(time.Hour), Count: 2}}, history)
+
+	history, err = ds.LabelCountHistory(empty.ID, start, start)
+	require.NoError(t, err)
+	assert.Equal(t, []fleet.LabelCountPoint{{T: start, Count: 0}}, history)
+
+	history, err = ds.LabelCountHistory(label.ID, start.Add(4*time.Hour), start.Add(5*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
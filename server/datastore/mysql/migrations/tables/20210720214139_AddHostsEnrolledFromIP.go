@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210720214139, Down_20210720214139)
+}
+
+func Up_20210720214139(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN enrolled_from_ip varchar(255) NOT NULL DEFAULT '',
+		ADD INDEX idx_hosts_enrolled_from_ip (enrolled_from_ip)
+	`); err != nil {
+		return errors.Wrap(err, "add enrolled_from_ip")
+	}
+
+	return nil
+}
+
+func Down_20210720214139(tx *sql.Tx) error {
+	return nil
+}
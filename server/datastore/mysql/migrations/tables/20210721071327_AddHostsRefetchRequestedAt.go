@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210721071327, Down_20210721071327)
+}
+
+func Up_20210721071327(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN refetch_requested_at timestamp NULL DEFAULT NULL
+	`); err != nil {
+		return errors.Wrap(err, "add refetch_requested_at")
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE hosts SET refetch_requested_at = NOW() WHERE refetch_requested
+	`); err != nil {
+		return errors.Wrap(err, "set refetch_requested_at")
+	}
+
+	return nil
+}
+
+func Down_20210721071327(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210721214030, Down_20210721214030)
+}
+
+func Up_20210721214030(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS software_metadata (
+			id int unsigned PRIMARY KEY AUTO_INCREMENT,
+			name varchar(255) NOT NULL,
+			version varchar(255) NOT NULL DEFAULT '',
+			license varchar(255) NOT NULL DEFAULT '',
+			vendor varchar(255) NOT NULL DEFAULT '',
+			UNIQUE KEY idx_software_metadata_name_version (name, version)
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create table software_metadata")
+	}
+
+	return nil
+}
+
+func Down_20210721214030(tx *sql.Tx) error {
+	return nil
+}
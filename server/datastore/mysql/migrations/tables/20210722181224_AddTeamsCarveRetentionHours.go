@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210722181224, Down_20210722181224)
+}
+
+func Up_20210722181224(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE teams
+		ADD COLUMN carve_retention_hours int unsigned DEFAULT NULL
+	`); err != nil {
+		return errors.Wrap(err, "add carve_retention_hours")
+	}
+
+	return nil
+}
+
+func Down_20210722181224(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210723011343, Down_20210723011343)
+}
+
+func Up_20210723011343(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN assigned_owner varchar(255) NOT NULL DEFAULT '',
+		ADD KEY idx_hosts_assigned_owner (assigned_owner)
+	`); err != nil {
+		return errors.Wrap(err, "add assigned_owner")
+	}
+
+	return nil
+}
+
+func Down_20210723011343(tx *sql.Tx) error {
+	return nil
+}
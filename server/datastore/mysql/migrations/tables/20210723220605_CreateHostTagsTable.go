@@ -0,0 +1,31 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210723220605, Down_20210723220605)
+}
+
+func Up_20210723220605(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS host_tags (
+			host_id INT UNSIGNED NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			value VARCHAR(255) NOT NULL DEFAULT '',
+			PRIMARY KEY (host_id, name),
+			FOREIGN KEY (host_id) REFERENCES hosts (id) ON DELETE CASCADE
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create host_tags")
+	}
+
+	return nil
+}
+
+func Down_20210723220605(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210725040909, Down_20210725040909)
+}
+
+func Up_20210725040909(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN checkin_latency bigint NOT NULL DEFAULT 0
+	`); err != nil {
+		return errors.Wrap(err, "add checkin_latency")
+	}
+
+	return nil
+}
+
+func Down_20210725040909(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,62 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210725124750, Down_20210725124750)
+}
+
+func Up_20210725124750(tx *sql.Tx) error {
+	// Carve block data is stored once per sha256 of the data, with carve_blocks
+	// referencing it. refcount is the number of carve_blocks rows with the
+	// hash.
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS carve_block_data (
+			sha256 BINARY(32) NOT NULL PRIMARY KEY,
+			data LONGBLOB,
+			refcount INT UNSIGNED NOT NULL DEFAULT 0
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create carve_block_data")
+	}
+
+	if _, err := tx.Exec(`
+		ALTER TABLE carve_blocks
+		ADD COLUMN sha256 BINARY(32) NULL,
+		ADD KEY idx_carve_blocks_sha256 (sha256)
+	`); err != nil {
+		return errors.Wrap(err, "add carve_blocks sha256")
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE carve_blocks SET sha256 = UNHEX(SHA2(COALESCE(data, ''), 256))
+	`); err != nil {
+		return errors.Wrap(err, "hash carve_blocks data")
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO carve_block_data (sha256, data, refcount)
+		SELECT sha256, COALESCE(data, ''), 1 FROM carve_blocks
+		ON DUPLICATE KEY UPDATE refcount = refcount + 1
+	`); err != nil {
+		return errors.Wrap(err, "move carve_blocks data")
+	}
+
+	if _, err := tx.Exec(`
+		ALTER TABLE carve_blocks
+		DROP COLUMN data,
+		MODIFY sha256 BINARY(32) NOT NULL
+	`); err != nil {
+		return errors.Wrap(err, "drop carve_blocks data")
+	}
+
+	return nil
+}
+
+func Down_20210725124750(tx *sql.Tx) error {
+	return nil
+}
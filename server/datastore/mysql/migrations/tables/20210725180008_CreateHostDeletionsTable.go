@@ -0,0 +1,35 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210725180008, Down_20210725180008)
+}
+
+func Up_20210725180008(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS host_deletions (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+			host_id INT UNSIGNED NOT NULL,
+			hostname VARCHAR(255) NOT NULL DEFAULT '',
+			uuid VARCHAR(255) NOT NULL DEFAULT '',
+			osquery_host_id VARCHAR(255) NOT NULL DEFAULT '',
+			hardware_serial VARCHAR(255) NOT NULL DEFAULT '',
+			reason TEXT NOT NULL,
+			deleted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			KEY idx_host_deletions_deleted_at (deleted_at)
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create host_deletions")
+	}
+
+	return nil
+}
+
+func Down_20210725180008(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210726205627, Down_20210726205627)
+}
+
+func Up_20210726205627(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS host_software_updates (
+			host_id INT UNSIGNED NOT NULL PRIMARY KEY,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (host_id) REFERENCES hosts (id) ON DELETE CASCADE
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create host_software_updates")
+	}
+
+	return nil
+}
+
+func Down_20210726205627(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210727053553, Down_20210727053553)
+}
+
+func Up_20210727053553(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE teams
+		ADD COLUMN new_host_hours int unsigned DEFAULT NULL
+	`); err != nil {
+		return errors.Wrap(err, "add new_host_hours")
+	}
+
+	return nil
+}
+
+func Down_20210727053553(tx *sql.Tx) error {
+	return nil
+}
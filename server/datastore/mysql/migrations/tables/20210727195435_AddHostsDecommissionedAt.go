@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210727195435, Down_20210727195435)
+}
+
+func Up_20210727195435(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN decommissioned_at timestamp NULL DEFAULT NULL
+	`); err != nil {
+		return errors.Wrap(err, "add decommissioned_at")
+	}
+
+	return nil
+}
+
+func Down_20210727195435(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210728054115, Down_20210728054115)
+}
+
+func Up_20210728054115(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN hardware_fingerprint varchar(255) NOT NULL DEFAULT '',
+		ADD INDEX idx_hosts_hardware_fingerprint (hardware_fingerprint)
+	`); err != nil {
+		return errors.Wrap(err, "add hardware_fingerprint")
+	}
+
+	return nil
+}
+
+func Down_20210728054115(tx *sql.Tx) error {
+	return nil
+}
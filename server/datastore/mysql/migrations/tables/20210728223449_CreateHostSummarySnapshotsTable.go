@@ -0,0 +1,36 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210728223449, Down_20210728223449)
+}
+
+func Up_20210728223449(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS host_summary_snapshots (
+			id int unsigned NOT NULL AUTO_INCREMENT,
+			created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			online int unsigned NOT NULL DEFAULT 0,
+			offline int unsigned NOT NULL DEFAULT 0,
+			mia int unsigned NOT NULL DEFAULT 0,
+			new int unsigned NOT NULL DEFAULT 0,
+			total int unsigned NOT NULL DEFAULT 0,
+			platform_counts json NOT NULL,
+			PRIMARY KEY (id),
+			KEY idx_host_summary_snapshots_created_at (created_at)
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create host_summary_snapshots")
+	}
+
+	return nil
+}
+
+func Down_20210728223449(tx *sql.Tx) error {
+	return nil
+}
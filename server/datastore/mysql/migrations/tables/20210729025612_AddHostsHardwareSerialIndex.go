@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210729025612, Down_20210729025612)
+}
+
+func Up_20210729025612(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD INDEX idx_hosts_hardware_serial (hardware_serial)
+	`); err != nil {
+		return errors.Wrap(err, "add hardware_serial index")
+	}
+
+	return nil
+}
+
+func Down_20210729025612(tx *sql.Tx) error {
+	return nil
+}
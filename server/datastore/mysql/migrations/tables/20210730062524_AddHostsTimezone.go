@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210730062524, Down_20210730062524)
+}
+
+func Up_20210730062524(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN timezone varchar(255) NOT NULL DEFAULT '',
+		ADD INDEX idx_hosts_timezone (timezone)
+	`); err != nil {
+		return errors.Wrap(err, "add timezone")
+	}
+
+	return nil
+}
+
+func Down_20210730062524(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210730213612, Down_20210730213612)
+}
+
+func Up_20210730213612(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE host_software_updates
+		ADD COLUMN software_hash char(64) NOT NULL DEFAULT ''
+	`); err != nil {
+		return errors.Wrap(err, "add software_hash")
+	}
+
+	return nil
+}
+
+func Down_20210730213612(tx *sql.Tx) error {
+	return nil
+}
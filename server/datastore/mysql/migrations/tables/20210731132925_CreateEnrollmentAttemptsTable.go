@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210731132925, Down_20210731132925)
+}
+
+func Up_20210731132925(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS enrollment_attempts (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+			osquery_host_id VARCHAR(255) NOT NULL DEFAULT '',
+			reason TEXT NOT NULL,
+			enrolled_from_ip VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			KEY idx_enrollment_attempts_created_at (created_at)
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create enrollment_attempts")
+	}
+
+	return nil
+}
+
+func Down_20210731132925(tx *sql.Tx) error {
+	return nil
+}
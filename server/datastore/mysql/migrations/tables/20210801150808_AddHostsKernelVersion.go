@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210801150808, Down_20210801150808)
+}
+
+func Up_20210801150808(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN kernel_version varchar(255) NOT NULL DEFAULT '',
+		ADD INDEX idx_hosts_kernel_version (kernel_version)
+	`); err != nil {
+		return errors.Wrap(err, "add kernel_version")
+	}
+
+	return nil
+}
+
+func Down_20210801150808(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210802124009, Down_20210802124009)
+}
+
+func Up_20210802124009(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD INDEX idx_hosts_platform_seen_time (platform, seen_time)
+	`); err != nil {
+		return errors.Wrap(err, "add platform seen_time index")
+	}
+
+	return nil
+}
+
+func Down_20210802124009(tx *sql.Tx) error {
+	return nil
+}
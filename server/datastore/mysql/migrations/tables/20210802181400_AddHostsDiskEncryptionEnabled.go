@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210802181400, Down_20210802181400)
+}
+
+func Up_20210802181400(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN disk_encryption_enabled TINYINT(1) NULL DEFAULT NULL,
+		ADD INDEX idx_hosts_disk_encryption_enabled (disk_encryption_enabled)
+	`); err != nil {
+		return errors.Wrap(err, "add disk_encryption_enabled")
+	}
+
+	return nil
+}
+
+func Down_20210802181400(tx *sql.Tx) error {
+	return nil
+}
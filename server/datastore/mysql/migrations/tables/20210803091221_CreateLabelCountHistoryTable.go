@@ -0,0 +1,31 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210803091221, Down_20210803091221)
+}
+
+func Up_20210803091221(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS label_count_history (
+			label_id INT UNSIGNED NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			count INT UNSIGNED NOT NULL DEFAULT 0,
+			PRIMARY KEY (label_id, created_at),
+			FOREIGN KEY (label_id) REFERENCES labels (id) ON DELETE CASCADE
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create label_count_history")
+	}
+
+	return nil
+}
+
+func Down_20210803091221(tx *sql.Tx) error {
+	return nil
+}
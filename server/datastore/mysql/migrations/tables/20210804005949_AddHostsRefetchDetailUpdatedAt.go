@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210804005949, Down_20210804005949)
+}
+
+func Up_20210804005949(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN refetch_detail_updated_at timestamp NULL DEFAULT NULL
+	`); err != nil {
+		return errors.Wrap(err, "add refetch_detail_updated_at")
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE hosts SET refetch_detail_updated_at = detail_updated_at WHERE refetch_requested
+	`); err != nil {
+		return errors.Wrap(err, "set refetch_detail_updated_at")
+	}
+
+	return nil
+}
+
+func Down_20210804005949(tx *sql.Tx) error {
+	return nil
+}
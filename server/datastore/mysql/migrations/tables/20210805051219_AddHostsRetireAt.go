@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210805051219, Down_20210805051219)
+}
+
+func Up_20210805051219(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN retire_at timestamp NULL DEFAULT NULL,
+		ADD INDEX idx_hosts_retire_at (retire_at)
+	`); err != nil {
+		return errors.Wrap(err, "add retire_at")
+	}
+
+	return nil
+}
+
+func Down_20210805051219(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210805101851, Down_20210805101851)
+}
+
+func Up_20210805101851(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE carve_metadata
+		ADD INDEX idx_carve_metadata_request_id (request_id)
+	`); err != nil {
+		return errors.Wrap(err, "add request_id index")
+	}
+
+	return nil
+}
+
+func Down_20210805101851(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210806153155, Down_20210806153155)
+}
+
+func Up_20210806153155(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN orbit_version varchar(255) NOT NULL DEFAULT '',
+		ADD INDEX idx_hosts_orbit_version (orbit_version)
+	`); err != nil {
+		return errors.Wrap(err, "add orbit_version")
+	}
+
+	return nil
+}
+
+func Down_20210806153155(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210807062930, Down_20210807062930)
+}
+
+func Up_20210807062930(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS host_team_changes (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+			host_id INT UNSIGNED NOT NULL,
+			from_team_id INT UNSIGNED NULL,
+			to_team_id INT UNSIGNED NULL,
+			applied TINYINT(1) NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			KEY idx_host_team_changes_created_at (created_at)
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create host_team_changes")
+	}
+
+	return nil
+}
+
+func Down_20210807062930(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210808064256, Down_20210808064256)
+}
+
+func Up_20210808064256(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN notes VARCHAR(1024) NOT NULL DEFAULT ''
+	`); err != nil {
+		return errors.Wrap(err, "add notes")
+	}
+
+	return nil
+}
+
+func Down_20210808064256(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210809073910, Down_20210809073910)
+}
+
+func Up_20210809073910(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS host_field_changes (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+			host_id INT UNSIGNED NOT NULL,
+			field VARCHAR(255) NOT NULL,
+			old_value TEXT NOT NULL,
+			new_value TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			KEY idx_host_field_changes_host_field (host_id, field),
+			FOREIGN KEY (host_id) REFERENCES hosts (id) ON DELETE CASCADE
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create host_field_changes")
+	}
+
+	return nil
+}
+
+func Down_20210809073910(tx *sql.Tx) error {
+	return nil
+}
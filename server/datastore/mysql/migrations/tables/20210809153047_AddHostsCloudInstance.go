@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210809153047, Down_20210809153047)
+}
+
+func Up_20210809153047(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN cloud_provider varchar(255) NOT NULL DEFAULT '',
+		ADD COLUMN cloud_instance_id varchar(255) NOT NULL DEFAULT '',
+		ADD INDEX idx_hosts_cloud_instance_id (cloud_instance_id)
+	`); err != nil {
+		return errors.Wrap(err, "add cloud instance columns")
+	}
+
+	return nil
+}
+
+func Down_20210809153047(tx *sql.Tx) error {
+	return nil
+}
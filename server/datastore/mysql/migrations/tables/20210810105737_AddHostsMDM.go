@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210810105737, Down_20210810105737)
+}
+
+func Up_20210810105737(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN mdm_enrolled tinyint(1) NULL DEFAULT NULL,
+		ADD COLUMN mdm_server_url varchar(255) NOT NULL DEFAULT ''
+	`); err != nil {
+		return errors.Wrap(err, "add mdm columns")
+	}
+
+	return nil
+}
+
+func Down_20210810105737(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210811102308, Down_20210811102308)
+}
+
+func Up_20210811102308(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE hosts
+		ADD COLUMN battery_cycle_count int NULL DEFAULT NULL,
+		ADD COLUMN battery_health varchar(255) NULL DEFAULT NULL
+	`); err != nil {
+		return errors.Wrap(err, "add battery columns")
+	}
+
+	return nil
+}
+
+func Down_20210811102308(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,31 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210812022731, Down_20210812022731)
+}
+
+func Up_20210812022731(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS host_detail_query_status (
+			host_id INT UNSIGNED NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			last_success_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (host_id, name),
+			FOREIGN KEY (host_id) REFERENCES hosts (id) ON DELETE CASCADE
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create host_detail_query_status")
+	}
+
+	return nil
+}
+
+func Down_20210812022731(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210813032244, Down_20210813032244)
+}
+
+func Up_20210813032244(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS host_disks (
+			host_id INT UNSIGNED NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			size BIGINT NOT NULL DEFAULT 0,
+			type VARCHAR(255) NOT NULL DEFAULT '',
+			PRIMARY KEY (host_id, name),
+			FOREIGN KEY (host_id) REFERENCES hosts (id) ON DELETE CASCADE
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create host_disks")
+	}
+
+	return nil
+}
+
+func Down_20210813032244(tx *sql.Tx) error {
+	return nil
+}
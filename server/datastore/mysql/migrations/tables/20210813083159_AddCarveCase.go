@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210813083159, Down_20210813083159)
+}
+
+func Up_20210813083159(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE carve_metadata
+		ADD COLUMN case_id VARCHAR(255) NOT NULL DEFAULT '',
+		ADD COLUMN notes VARCHAR(1024) NOT NULL DEFAULT '',
+		ADD INDEX idx_carve_metadata_case_id (case_id)
+	`); err != nil {
+		return errors.Wrap(err, "add carve case columns")
+	}
+
+	return nil
+}
+
+func Down_20210813083159(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210814120519, Down_20210814120519)
+}
+
+func Up_20210814120519(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS host_enroll_secrets (
+			host_id INT UNSIGNED NOT NULL PRIMARY KEY,
+			secret VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			KEY idx_host_enroll_secrets_secret (secret),
+			FOREIGN KEY (host_id) REFERENCES hosts (id) ON DELETE CASCADE
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create host_enroll_secrets")
+	}
+
+	return nil
+}
+
+func Down_20210814120519(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210814211912, Down_20210814211912)
+}
+
+func Up_20210814211912(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS host_resource_samples (
+			host_id INT UNSIGNED NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			memory BIGINT NOT NULL DEFAULT 0,
+			disk_space BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (host_id, created_at),
+			FOREIGN KEY (host_id) REFERENCES hosts (id) ON DELETE CASCADE
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create host_resource_samples")
+	}
+
+	return nil
+}
+
+func Down_20210814211912(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210815045025, Down_20210815045025)
+}
+
+func Up_20210815045025(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS policies (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+			query_id INT UNSIGNED NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (id),
+			FOREIGN KEY (query_id) REFERENCES queries (id)
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create policies")
+	}
+
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS policy_membership (
+			policy_id INT UNSIGNED NOT NULL,
+			host_id INT UNSIGNED NOT NULL,
+			passes TINYINT(1) DEFAULT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (policy_id, host_id),
+			KEY idx_policy_membership_host_passes (host_id, passes),
+			FOREIGN KEY (policy_id) REFERENCES policies (id) ON DELETE CASCADE,
+			FOREIGN KEY (host_id) REFERENCES hosts (id) ON DELETE CASCADE
+		)
+	`); err != nil {
+		return errors.Wrap(err, "create policy_membership")
+	}
+
+	return nil
+}
+
+func Down_20210815045025(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20210816074352, Down_20210816074352)
+}
+
+func Up_20210816074352(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		DELETE FROM host_team_changes
+		WHERE host_id NOT IN (SELECT id FROM hosts)
+	`); err != nil {
+		return errors.Wrap(err, "delete orphaned host_team_changes")
+	}
+
+	if _, err := tx.Exec(`
+		ALTER TABLE host_team_changes
+		ADD FOREIGN KEY (host_id) REFERENCES hosts (id) ON DELETE CASCADE
+	`); err != nil {
+		return errors.Wrap(err, "add host_team_changes host foreign key")
+	}
+
+	return nil
+}
+
+func Down_20210816074352(tx *sql.Tx) error {
+	return nil
+}
@@ -44,6 +44,13 @@ type Datastore struct {
 	logger log.Logger
 	clock  clock.Clock
 	config config.MysqlConfig
+
+	trackedHostFields       []string
+	hostIdentifierResolvers []HostIdentifierResolver
+	enrollDedupColumn       string
+	enrollDedupWindow       time.Duration
+
+	hostResourceSampleInterval time.Duration
 }
 
 type txFn func(*sqlx.Tx) error
@@ -153,7 +160,9 @@ func New(config config.MysqlConfig, c clock.Clock, opts ...DBOption) (*Datastore
 	}
 
 	for _, setOpt := range opts {
-		setOpt(options)
+		if err := setOpt(options); err != nil {
+			return nil, err
+		}
 	}
 
 	if config.PasswordPath != "" && config.Password != "" {
@@ -207,10 +216,16 @@ func New(config config.MysqlConfig, c clock.Clock, opts ...DBOption) (*Datastore
 	}
 
 	ds := &Datastore{
-		db:     db,
-		logger: options.logger,
-		clock:  c,
-		config: config,
+		db:                      db,
+		logger:                  options.logger,
+		clock:                   c,
+		config:                  config,
+		trackedHostFields:       options.trackedHostFields,
+		hostIdentifierResolvers: options.hostIdentifierResolvers,
+		enrollDedupColumn:       options.enrollDedupColumn,
+		enrollDedupWindow:       options.enrollDedupWindow,
+
+		hostResourceSampleInterval: options.hostResourceSampleInterval,
 	}
 
 	return ds, nil
@@ -326,15 +341,7 @@ func sanitizeColumn(col string) string {
 }
 
 func appendListOptionsToSQL(sql string, opts fleet.ListOptions) string {
-	if opts.OrderKey != "" {
-		direction := "ASC"
-		if opts.OrderDirection == fleet.OrderDescending {
-			direction = "DESC"
-		}
-		orderKey := sanitizeColumn(opts.OrderKey)
-
-		sql = fmt.Sprintf("%s ORDER BY %s %s", sql, orderKey, direction)
-	}
+	sql = appendOrderToSQL(sql, opts)
 	// REVIEW: If caller doesn't supply a limit apply a default limit of 1000
 	// to insure that an unbounded query with many results doesn't consume too
 	// much memory or hang
@@ -353,6 +360,21 @@ func appendListOptionsToSQL(sql string, opts fleet.ListOptions) string {
 	return sql
 }
 
+// appendOrderToSQL appends the ORDER BY clause of the list options, if any,
+// without paginating.
+func appendOrderToSQL(sql string, opts fleet.ListOptions) string {
+	if opts.OrderKey == "" {
+		return sql
+	}
+	direction := "ASC"
+	if opts.OrderDirection == fleet.OrderDescending {
+		direction = "DESC"
+	}
+	orderKey := sanitizeColumn(opts.OrderKey)
+
+	return fmt.Sprintf("%s ORDER BY %s %s", sql, orderKey, direction)
+}
+
 // whereFilterHostsByTeams returns the appropriate condition to use in the WHERE
 // clause to render only the appropriate teams.
 //
@@ -402,6 +424,38 @@ func (d *Datastore) whereFilterHostsByTeams(filter fleet.TeamFilter, hostKey str
 	return fmt.Sprintf("%s.team_id IN (%s)", hostKey, strings.Join(idStrs, ","))
 }
 
+// filterAllowsHostTeam returns whether the hosts of the team, nil for hosts
+// without a team, are allowed by the filter. It must remain synchronized with
+// whereFilterHostsByTeams.
+func filterAllowsHostTeam(filter fleet.TeamFilter, teamID *uint) bool {
+	if filter.User == nil {
+		return false
+	}
+
+	if filter.User.GlobalRole != nil {
+		switch *filter.User.GlobalRole {
+		case fleet.RoleAdmin, fleet.RoleMaintainer:
+			return true
+		case fleet.RoleObserver:
+			return filter.IncludeObserver
+		}
+	}
+
+	if teamID == nil {
+		return false
+	}
+	for _, team := range filter.User.Teams {
+		if team.ID != *teamID {
+			continue
+		}
+		if team.Role == fleet.RoleAdmin || team.Role == fleet.RoleMaintainer ||
+			(team.Role == fleet.RoleObserver && filter.IncludeObserver) {
+			return true
+		}
+	}
+	return false
+}
+
 // whereFilterTeams returns the appropriate condition to use in the WHERE
 // clause to render only the appropriate teams.
 //
@@ -532,6 +586,13 @@ func isChildForeignKeyError(err error) bool {
 	return mysqlErr.Number == ER_NO_REFERENCED_ROW_2
 }
 
+// escapeLike escapes the LIKE wildcards in s, so that it matches literally.
+func escapeLike(s string) string {
+	s = strings.Replace(s, "_", "\\_", -1)
+	s = strings.Replace(s, "%", "\\%", -1)
+	return s
+}
+
 // searchLike adds SQL and parameters for a "search" using LIKE syntax.
 //
 // The input columns must be sanitized if they are provided by the user.
@@ -540,9 +601,7 @@ func searchLike(sql string, params []interface{}, match string, columns ...strin
 		return sql, params
 	}
 
-	match = strings.Replace(match, "_", "\\_", -1)
-	match = strings.Replace(match, "%", "\\%", -1)
-	pattern := "%" + match + "%"
+	pattern := "%" + escapeLike(match) + "%"
 	ors := make([]string, 0, len(columns))
 	for _, column := range columns {
 		ors = append(ors, column+" LIKE ?")
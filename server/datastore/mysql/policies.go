@@ -0,0 +1,152 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// policySelect selects the policies with their host counts. Hosts whose query
+// failed to run have a NULL result, and are counted as neither passing nor
+// failing.
+const policySelect = `
+	SELECT
+		p.*,
+		q.name AS query_name,
+		(SELECT COUNT(*) FROM policy_membership pm WHERE pm.policy_id = p.id AND pm.passes = 1) AS passing_host_count,
+		(SELECT COUNT(*) FROM policy_membership pm WHERE pm.policy_id = p.id AND pm.passes = 0) AS failing_host_count
+	FROM policies p JOIN queries q ON (p.query_id = q.id)
+`
+
+func (d *Datastore) NewGlobalPolicy(queryID uint) (*fleet.Policy, error) {
+	res, err := d.db.Exec(`INSERT INTO policies (query_id) VALUES (?)`, queryID)
+	switch {
+	case isChildForeignKeyError(err):
+		return nil, notFound("Query").WithID(queryID)
+	case err != nil:
+		return nil, errors.Wrap(err, "insert policy")
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, errors.Wrap(err, "last insert id for policy")
+	}
+	return d.Policy(uint(id))
+}
+
+func (d *Datastore) Policy(id uint) (*fleet.Policy, error) {
+	var policy fleet.Policy
+	err := d.db.Get(&policy, policySelect+`WHERE p.id = ?`, id)
+	if err == sql.ErrNoRows {
+		return nil, notFound("Policy").WithID(id)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get policy")
+	}
+	return &policy, nil
+}
+
+func (d *Datastore) ListGlobalPolicies() ([]*fleet.Policy, error) {
+	policies := []*fleet.Policy{}
+	if err := d.db.Select(&policies, policySelect+`ORDER BY p.id`); err != nil {
+		return nil, errors.Wrap(err, "list global policies")
+	}
+	return policies, nil
+}
+
+func (d *Datastore) DeleteGlobalPolicies(ids []uint) ([]uint, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var deleted []uint
+	err := d.withRetryTxx(func(tx *sqlx.Tx) error {
+		stmt, args, err := sqlx.In(`SELECT id FROM policies WHERE id IN (?) ORDER BY id FOR UPDATE`, ids)
+		if err != nil {
+			return errors.Wrap(err, "build select policies")
+		}
+		deleted = nil
+		if err := tx.Select(&deleted, stmt, args...); err != nil {
+			return errors.Wrap(err, "select policies")
+		}
+		if len(deleted) == 0 {
+			return nil
+		}
+
+		stmt, args, err = sqlx.In(`DELETE FROM policies WHERE id IN (?)`, deleted)
+		if err != nil {
+			return errors.Wrap(err, "build delete policies")
+		}
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return errors.Wrap(err, "delete policies")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "delete global policies")
+	}
+	return deleted, nil
+}
+
+func (d *Datastore) PolicyQueriesForHost(host *fleet.Host, cutoff time.Time) (map[string]string, error) {
+	var rows []struct {
+		ID    uint   `db:"id"`
+		Query string `db:"query"`
+	}
+	err := d.db.Select(&rows, `
+		SELECT p.id, q.query
+		FROM policies p JOIN queries q ON (p.query_id = q.id)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM policy_membership pm
+			WHERE pm.policy_id = p.id AND pm.host_id = ? AND pm.updated_at >= ?
+		)`,
+		host.ID, cutoff,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "select policy queries for host")
+	}
+
+	queries := make(map[string]string, len(rows))
+	for _, row := range rows {
+		queries[strconv.Itoa(int(row.ID))] = row.Query
+	}
+	return queries, nil
+}
+
+func (d *Datastore) RecordPolicyQueryExecutions(host *fleet.Host, results map[uint]*bool, updated time.Time) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	// Sorted like label query executions, to minimize deadlocks.
+	orderedIDs := make([]uint, 0, len(results))
+	for policyID := range results {
+		orderedIDs = append(orderedIDs, policyID)
+	}
+	sort.Slice(orderedIDs, func(i, j int) bool { return orderedIDs[i] < orderedIDs[j] })
+
+	bindvars := make([]string, 0, len(orderedIDs))
+	vals := make([]interface{}, 0, 4*len(orderedIDs))
+	for _, policyID := range orderedIDs {
+		bindvars = append(bindvars, "(?,?,?,?)")
+		vals = append(vals, policyID, host.ID, results[policyID], updated)
+	}
+
+	// Results of policies deleted since the host got its queries are
+	// ignored.
+	sql := fmt.Sprintf(`
+		INSERT IGNORE INTO policy_membership (policy_id, host_id, passes, updated_at) VALUES %s
+		ON DUPLICATE KEY UPDATE passes = VALUES(passes), updated_at = VALUES(updated_at)`,
+		strings.Join(bindvars, ","),
+	)
+	if _, err := d.db.Exec(sql, vals...); err != nil {
+		return errors.Wrap(err, "insert policy query executions")
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package mysql
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/fleetdm/fleet/v4/server/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobalPolicies(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	q1, err := ds.NewQuery(&fleet.Query{Name: "q1", Query: "select 1"})
+	require.NoError(t, err)
+	q2, err := ds.NewQuery(&fleet.Query{Name: "q2", Query: "select 2"})
+	require.NoError(t, err)
+
+	p1, err := ds.NewGlobalPolicy(q1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, q1.ID, p1.QueryID)
+	assert.Equal(t, "q1", p1.QueryName)
+	p2, err := ds.NewGlobalPolicy(q2.ID)
+	require.NoError(t, err)
+
+	_, err = ds.NewGlobalPolicy(q1.ID + q2.ID)
+	assert.True(t, fleet.IsNotFound(err))
+
+	host1 := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host2 := test.NewHost(t, ds, "host2", "", "host2key", "host2uuid", time.Now())
+	now := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, ds.RecordPolicyQueryExecutions(host1, map[uint]*bool{p1.ID: ptr.Bool(true), p2.ID: ptr.Bool(false)}, now))
+	require.NoError(t, ds.RecordPolicyQueryExecutions(host2, map[uint]*bool{p1.ID: ptr.Bool(true), p2.ID: nil}, now))
+
+	policies, err := ds.ListGlobalPolicies()
+	require.NoError(t, err)
+	require.Len(t, policies, 2)
+	assert.Equal(t, p1.ID, policies[0].ID)
+	assert.Equal(t, uint(2), policies[0].PassingHostCount)
+	assert.Equal(t, uint(0), policies[0].FailingHostCount)
+	assert.Equal(t, p2.ID, policies[1].ID)
+	assert.Equal(t, uint(0), policies[1].PassingHostCount)
+	assert.Equal(t, uint(1), policies[1].FailingHostCount)
+
+	deleted, err := ds.DeleteGlobalPolicies([]uint{p1.ID, p1.ID + p2.ID})
+	require.NoError(t, err)
+	assert.Equal(t, []uint{p1.ID}, deleted)
+	_, err = ds.Policy(p1.ID)
+	assert.True(t, fleet.IsNotFound(err))
+
+	// The results of a deleted policy are deleted, and late results ignored
+	require.NoError(t, ds.RecordPolicyQueryExecutions(host1, map[uint]*bool{p1.ID: ptr.Bool(false)}, now))
+	h, err := ds.Host(host1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), h.PolicyPassCount)
+	assert.Equal(t, uint(1), h.PolicyFailCount)
+
+	// A policy's query can't be deleted
+	err = ds.DeleteQuery(q2.Name)
+	assert.True(t, fleet.IsForeignKey(err))
+}
+
+func TestPolicyQueriesForHost(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	q1, err := ds.NewQuery(&fleet.Query{Name: "q1", Query: "select 1"})
+	require.NoError(t, err)
+	q2, err := ds.NewQuery(&fleet.Query{Name: "q2", Query: "select 2"})
+	require.NoError(t, err)
+	p1, err := ds.NewGlobalPolicy(q1.ID)
+	require.NoError(t, err)
+	p2, err := ds.NewGlobalPolicy(q2.ID)
+	require.NoError(t, err)
+
+	host := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	now := time.Now().UTC().Truncate(time.Second)
+
+	queries, err := ds.PolicyQueriesForHost(host, now)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{fmt.Sprint(p1.ID): "select 1", fmt.Sprint(p2.ID): "select 2"}, queries)
+
+	// Policies checked since the cutoff are not returned, even if the query
+	// failed to run
+	require.NoError(t, ds.RecordPolicyQueryExecutions(host, map[uint]*bool{p1.ID: nil}, now))
+	queries, err = ds.PolicyQueriesForHost(host, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{fmt.Sprint(p2.ID): "select 2"}, queries)
+
+	require.NoError(t, ds.RecordPolicyQueryExecutions(host, map[uint]*bool{p2.ID: ptr.Bool(true)}, now))
+	queries, err = ds.PolicyQueriesForHost(host, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, queries)
+
+	queries, err = ds.PolicyQueriesForHost(host, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, queries, 2)
+}
+
+func TestHostPolicyCounts(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	var policies []*fleet.Policy
+	for _, name := range []string{"q1", "q2", "q3"} {
+		q, err := ds.NewQuery(&fleet.Query{Name: name, Query: "select 1"})
+		require.NoError(t, err)
+		p, err := ds.NewGlobalPolicy(q.ID)
+		require.NoError(t, err)
+		policies = append(policies, p)
+	}
+
+	host1 := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host2 := test.NewHost(t, ds, "host2", "", "host2key", "host2uuid", time.Now())
+	host3 := test.NewHost(t, ds, "host3", "", "host3key", "host3uuid", time.Now())
+	now := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, ds.RecordPolicyQueryExecutions(host1, map[uint]*bool{
+		policies[0].ID: ptr.Bool(true),
+		policies[1].ID: ptr.Bool(false),
+		policies[2].ID: nil,
+	}, now))
+	require.NoError(t, ds.RecordPolicyQueryExecutions(host2, map[uint]*bool{
+		policies[0].ID: ptr.Bool(true),
+		policies[1].ID: ptr.Bool(true),
+	}, now))
+
+	for _, tc := range []struct {
+		host       *fleet.Host
+		pass, fail uint
+	}{
+		{host1, 1, 1},
+		{host2, 2, 0},
+		// Hosts without results show zero
+		{host3, 0, 0},
+	} {
+		h, err := ds.Host(tc.host.ID)
+		require.NoError(t, err)
+		assert.Equal(t, tc.pass, h.PolicyPassCount, h.Hostname)
+		assert.Equal(t, tc.fail, h.PolicyFailCount, h.Hostname)
+	}
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	hosts, err := ds.ListHosts(filter, fleet.HostListOptions{HasFailingPolicies: true})
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	assert.Equal(t, host1.ID, hosts[0].ID)
+	assert.Equal(t, uint(1), hosts[0].PolicyFailCount)
+
+	hosts, err = ds.ListHosts(filter, fleet.HostListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, hosts, 3)
+
+	// Passing the failed policy clears the filter
+	require.NoError(t, ds.RecordPolicyQueryExecutions(host1, map[uint]*bool{policies[1].ID: ptr.Bool(true)}, now))
+	hosts, err = ds.ListHosts(filter, fleet.HostListOptions{HasFailingPolicies: true})
+	require.NoError(t, err)
+	assert.Empty(t, hosts)
+}
@@ -1,8 +1,12 @@
 package mysql
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/jmoiron/sqlx"
@@ -13,6 +17,8 @@ const (
 	maxSoftwareNameLen    = 255
 	maxSoftwareVersionLen = 255
 	maxSoftwareSourceLen  = 64
+
+	maxSoftwareMetadataLen = 255
 )
 
 func truncateString(str string, length int) string {
@@ -51,24 +57,91 @@ func softwareSliceToIdMap(softwareSlice []fleet.Software) map[string]uint {
 	return result
 }
 
+// softwareHash returns a hash of the set of software, independent of the
+// order and duplicates in the slice.
+func softwareHash(software []fleet.Software) string {
+	unique := make([]string, 0, len(software))
+	for s := range softwareSliceToSet(software) {
+		unique = append(unique, s)
+	}
+	sort.Strings(unique)
+
+	h := sha256.New()
+	for _, s := range unique {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// mergeSoftwareSources returns the incoming software, along with the stored
+// software of the sources not present in incoming.
+func mergeSoftwareSources(stored []fleet.Software, incoming []fleet.Software) []fleet.Software {
+	sources := make(map[string]bool)
+	for _, s := range incoming {
+		sources[s.Source] = true
+	}
+	merged := append([]fleet.Software(nil), incoming...)
+	for _, s := range stored {
+		if !sources[s.Source] {
+			merged = append(merged, s)
+		}
+	}
+	return merged
+}
+
 func (d *Datastore) SaveHostSoftware(host *fleet.Host) error {
 	if !host.HostSoftware.Modified {
 		return nil
 	}
 
+	updatedAt := d.clock.Now().UTC().Truncate(time.Second)
 	if err := d.withRetryTxx(func(tx *sqlx.Tx) error {
-		if len(host.HostSoftware.Software) == 0 {
+		software := host.HostSoftware.Software
+		if host.HostSoftware.PartialSources {
+			stored, err := d.hostSoftwareFromHostID(tx, host.ID)
+			if err != nil {
+				return errors.Wrap(err, "loading current software for host")
+			}
+			software = mergeSoftwareSources(stored, software)
+		}
+		hash := softwareHash(software)
+
+		// The stored software is only loaded and diffed if the hash of the
+		// incoming software differs from the hash of the last saved software.
+		var storedHash []string
+		if err := tx.Select(&storedHash, `SELECT software_hash FROM host_software_updates WHERE host_id = ?`, host.ID); err != nil {
+			return errors.Wrap(err, "get host software hash")
+		}
+		unchanged := len(storedHash) > 0 && storedHash[0] == hash
+
+		switch {
+		case unchanged:
+			// Only the collection time is written, it is reported as the
+			// host's SoftwareUpdatedAt even if the software didn't change.
+			sql := `UPDATE host_software_updates SET updated_at = ? WHERE host_id = ?`
+			if _, err := tx.Exec(sql, updatedAt, host.ID); err != nil {
+				return errors.Wrap(err, "update host software updated_at")
+			}
+			return nil
+		case len(software) == 0:
 			// Clear join table for this host
 			sql := "DELETE FROM host_software WHERE host_id = ?"
 			if _, err := tx.Exec(sql, host.ID); err != nil {
 				return errors.Wrap(err, "clear join table entries")
 			}
-
-			return nil
+		default:
+			if err := d.applyChangesForNewSoftware(tx, host.ID, software); err != nil {
+				return err
+			}
 		}
 
-		if err := d.applyChangesForNewSoftware(tx, host); err != nil {
-			return err
+		sql := `
+			INSERT INTO host_software_updates (host_id, updated_at, software_hash) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE updated_at = VALUES(updated_at), software_hash = VALUES(software_hash)
+		`
+		if _, err := tx.Exec(sql, host.ID, updatedAt, hash); err != nil {
+			return errors.Wrap(err, "update host software updated_at")
 		}
 
 		return nil
@@ -77,6 +150,7 @@ func (d *Datastore) SaveHostSoftware(host *fleet.Host) error {
 	}
 
 	host.HostSoftware.Modified = false
+	host.HostSoftware.SoftwareUpdatedAt = updatedAt
 	return nil
 }
 
@@ -98,24 +172,24 @@ func nothingChanged(current []fleet.Software, incoming []fleet.Software) bool {
 	return true
 }
 
-func (d *Datastore) applyChangesForNewSoftware(tx *sqlx.Tx, host *fleet.Host) error {
-	storedCurrentSoftware, err := d.hostSoftwareFromHostID(tx, host.ID)
+func (d *Datastore) applyChangesForNewSoftware(tx *sqlx.Tx, hostID uint, software []fleet.Software) error {
+	storedCurrentSoftware, err := d.hostSoftwareFromHostID(tx, hostID)
 	if err != nil {
 		return errors.Wrap(err, "loading current software for host")
 	}
 
-	if nothingChanged(storedCurrentSoftware, host.Software) {
+	if nothingChanged(storedCurrentSoftware, software) {
 		return nil
 	}
 
 	current := softwareSliceToIdMap(storedCurrentSoftware)
-	incoming := softwareSliceToSet(host.Software)
+	incoming := softwareSliceToSet(software)
 
-	if err = d.deleteUninstalledHostSoftware(tx, host.ID, current, incoming); err != nil {
+	if err = d.deleteUninstalledHostSoftware(tx, hostID, current, incoming); err != nil {
 		return err
 	}
 
-	if err = d.insertNewInstalledHostSoftware(tx, host.ID, current, incoming); err != nil {
+	if err = d.insertNewInstalledHostSoftware(tx, hostID, current, incoming); err != nil {
 		return err
 	}
 
@@ -224,10 +298,360 @@ func (d *Datastore) hostSoftwareFromHostID(tx *sqlx.Tx, id uint) ([]fleet.Softwa
 
 func (d *Datastore) LoadHostSoftware(host *fleet.Host) error {
 	host.HostSoftware = fleet.HostSoftware{Modified: false}
-	software, err := d.hostSoftwareFromHostID(nil, host.ID)
-	if err != nil {
-		return err
+	// Metadata for the specific version takes precedence over metadata for
+	// all versions.
+	sql := `
+		SELECT
+			s.*,
+			COALESCE(mv.license, m.license, '') AS license,
+			COALESCE(mv.vendor, m.vendor, '') AS vendor
+		FROM software s
+		LEFT JOIN software_metadata mv ON (mv.name = s.name AND mv.version = s.version)
+		LEFT JOIN software_metadata m ON (m.name = s.name AND m.version = '')
+		WHERE s.id IN
+			(SELECT software_id FROM host_software WHERE host_id = ?)
+	`
+	var software []fleet.Software
+	if err := d.db.Select(&software, sql, host.ID); err != nil {
+		return errors.Wrap(err, "load host software")
 	}
 	host.Software = software
+
+	var updatedAt []time.Time
+	if err := d.db.Select(&updatedAt, `SELECT updated_at FROM host_software_updates WHERE host_id = ?`, host.ID); err != nil {
+		return errors.Wrap(err, "load host software updated_at")
+	}
+	if len(updatedAt) > 0 {
+		host.SoftwareUpdatedAt = updatedAt[0]
+	}
+	return nil
+}
+
+func (d *Datastore) LoadHostsSoftware(hosts []*fleet.Host) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	byID := make(map[uint][]*fleet.Host, len(hosts))
+	var hostIDs []uint
+	for _, host := range hosts {
+		host.HostSoftware = fleet.HostSoftware{Software: []fleet.Software{}, Modified: false}
+		if _, ok := byID[host.ID]; !ok {
+			hostIDs = append(hostIDs, host.ID)
+		}
+		byID[host.ID] = append(byID[host.ID], host)
+	}
+
+	// Same metadata precedence as LoadHostSoftware.
+	sql, args, err := sqlx.In(`
+		SELECT
+			hs.host_id,
+			s.*,
+			COALESCE(mv.license, m.license, '') AS license,
+			COALESCE(mv.vendor, m.vendor, '') AS vendor
+		FROM host_software hs
+		JOIN software s ON (s.id = hs.software_id)
+		LEFT JOIN software_metadata mv ON (mv.name = s.name AND mv.version = s.version)
+		LEFT JOIN software_metadata m ON (m.name = s.name AND m.version = '')
+		WHERE hs.host_id IN (?)
+	`, hostIDs)
+	if err != nil {
+		return errors.Wrap(err, "build load hosts software query")
+	}
+	var software []struct {
+		HostID uint `db:"host_id"`
+		fleet.Software
+	}
+	if err := d.db.Select(&software, sql, args...); err != nil {
+		return errors.Wrap(err, "load hosts software")
+	}
+	for _, s := range software {
+		for _, host := range byID[s.HostID] {
+			host.Software = append(host.Software, s.Software)
+		}
+	}
+
+	sql, args, err = sqlx.In(`SELECT host_id, updated_at FROM host_software_updates WHERE host_id IN (?)`, hostIDs)
+	if err != nil {
+		return errors.Wrap(err, "build load hosts software updated_at query")
+	}
+	var updates []struct {
+		HostID    uint      `db:"host_id"`
+		UpdatedAt time.Time `db:"updated_at"`
+	}
+	if err := d.db.Select(&updates, sql, args...); err != nil {
+		return errors.Wrap(err, "load hosts software updated_at")
+	}
+	for _, u := range updates {
+		for _, host := range byID[u.HostID] {
+			host.SoftwareUpdatedAt = u.UpdatedAt
+		}
+	}
+	return nil
+}
+
+func (d *Datastore) HostHasSoftware(hostID uint, name string, version string) (bool, error) {
+	sql := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM host_software hs
+			JOIN software s ON (s.id = hs.software_id)
+			WHERE hs.host_id = ? AND s.name = ?`
+	params := []interface{}{hostID, name}
+	if version != "" {
+		sql += ` AND s.version = ?`
+		params = append(params, version)
+	}
+	sql += `)`
+
+	var exists bool
+	if err := d.db.Get(&exists, sql, params...); err != nil {
+		return false, errors.Wrap(err, "check host software")
+	}
+	return exists, nil
+}
+
+func (d *Datastore) CountHostsMissingSoftware(filter fleet.TeamFilter, teamID uint, name string, minVersion string) (uint, error) {
+	teamCond := "h.team_id IS NULL"
+	params := []interface{}{name}
+	if teamID != 0 {
+		teamCond = "h.team_id = ?"
+	}
+
+	versionCond := ""
+	if minVersion != "" {
+		if v := fleet.NormalizeVersion(minVersion); v == "" || v[0] < '0' || v[0] > '9' {
+			return 0, errors.Errorf("invalid minimum version %q", minVersion)
+		}
+
+		// Versions don't compare in SQL, so the installed versions at
+		// minVersion or later are picked here.
+		var versions []string
+		if err := d.db.Select(&versions, `SELECT DISTINCT version FROM software WHERE name = ?`, name); err != nil {
+			return 0, errors.Wrap(err, "select software versions")
+		}
+		var accepted []interface{}
+		for _, v := range versions {
+			if fleet.CompareVersions(v, minVersion) >= 0 {
+				accepted = append(accepted, v)
+			}
+		}
+		if len(accepted) == 0 {
+			versionCond = "AND FALSE"
+		} else {
+			versionCond = fmt.Sprintf("AND s.version IN (%s)", strings.TrimSuffix(strings.Repeat("?,", len(accepted)), ","))
+			params = append(params, accepted...)
+		}
+	}
+	if teamID != 0 {
+		params = append(params, teamID)
+	}
+
+	sql := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM hosts h
+		LEFT JOIN (
+			SELECT DISTINCT hs.host_id
+			FROM host_software hs
+			JOIN software s ON (s.id = hs.software_id)
+			WHERE s.name = ? %s
+		) installed ON (installed.host_id = h.id)
+		WHERE installed.host_id IS NULL AND %s AND %s
+	`, versionCond, teamCond, d.whereFilterHostsByTeams(filter, "h"),
+	)
+
+	var count uint
+	if err := d.db.Get(&count, sql, params...); err != nil {
+		return 0, errors.Wrap(err, "count hosts missing software")
+	}
+	return count, nil
+}
+
+func (d *Datastore) HostSoftwareUpgradeCandidates(hostID uint) ([]fleet.SoftwareUpgradeCandidate, error) {
+	current, err := d.hostSoftwareFromHostID(nil, hostID)
+	if err != nil {
+		return nil, err
+	}
+
+	// All the installed versions of the host's software, the newest is
+	// picked here as versions don't compare as strings.
+	var installed []fleet.Software
+	err = d.db.Select(&installed, `
+		SELECT DISTINCT s.name, s.version, s.source
+		FROM software s
+		JOIN host_software hs ON (hs.software_id = s.id)
+		WHERE (s.name, s.source) IN (
+			SELECT hs2s.name, hs2s.source
+			FROM host_software hs2
+			JOIN software hs2s ON (hs2s.id = hs2.software_id)
+			WHERE hs2.host_id = ?
+		)
+	`, hostID)
+	if err != nil {
+		return nil, errors.Wrap(err, "select installed software versions")
+	}
+
+	type key struct{ name, source string }
+	latest := make(map[key]string)
+	for _, s := range installed {
+		k := key{s.Name, s.Source}
+		if v, ok := latest[k]; !ok || fleet.CompareVersions(s.Version, v) > 0 {
+			latest[k] = s.Version
+		}
+	}
+
+	candidates := []fleet.SoftwareUpgradeCandidate{}
+	for _, s := range current {
+		if v := latest[key{s.Name, s.Source}]; fleet.CompareVersions(v, s.Version) > 0 {
+			candidates = append(candidates, fleet.SoftwareUpgradeCandidate{Current: s, LatestSeen: v})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i].Current, candidates[j].Current
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Version < b.Version
+	})
+	return candidates, nil
+}
+
+func (d *Datastore) HostSoftwareCountsBySource(hostID uint) (map[string]uint, error) {
+	var rows []struct {
+		Source string `db:"source"`
+		Count  uint   `db:"count"`
+	}
+	err := d.db.Select(&rows, `
+		SELECT s.source, COUNT(*) AS count
+		FROM host_software hs
+		JOIN software s ON (s.id = hs.software_id)
+		WHERE hs.host_id = ?
+		GROUP BY s.source
+	`, hostID)
+	if err != nil {
+		return nil, errors.Wrap(err, "count host software by source")
+	}
+
+	counts := make(map[string]uint, len(rows))
+	for _, row := range rows {
+		counts[row.Source] = row.Count
+	}
+	return counts, nil
+}
+
+func (d *Datastore) HostSoftwareDriftFromBaseline(hostID uint, baseline []fleet.Software, opt fleet.SoftwareDriftOptions) ([]fleet.Software, []fleet.Software, error) {
+	installed, err := d.hostSoftwareFromHostID(nil, hostID)
+	if err != nil {
+		return nil, nil, err
+	}
+	missing, extra := fleet.SoftwareDrift(installed, baseline, opt)
+	return missing, extra, nil
+}
+
+func (d *Datastore) CountSoftwareVersions(filter fleet.TeamFilter, opt fleet.SoftwareCountOptions) ([]fleet.SoftwareVersionCount, error) {
+	if opt.NormalizeVersions {
+		return d.countNormalizedSoftwareVersions(filter)
+	}
+
+	sql := fmt.Sprintf(`
+		SELECT s.name, s.version, s.source, COUNT(DISTINCT hs.host_id) AS hosts_count
+		FROM host_software hs
+		JOIN software s ON (s.id = hs.software_id)
+		JOIN hosts h ON (h.id = hs.host_id)
+		WHERE %s
+		GROUP BY s.name, s.version, s.source
+		ORDER BY s.name, s.source, s.version
+	`, d.whereFilterHostsByTeams(filter, "h"),
+	)
+	counts := []fleet.SoftwareVersionCount{}
+	if err := d.db.Select(&counts, sql); err != nil {
+		return nil, errors.Wrap(err, "count software versions")
+	}
+	return counts, nil
+}
+
+// countNormalizedSoftwareVersions counts the hosts with each normalized
+// version of software installed. The raw versions are stored, so the hosts
+// are grouped by normalized version here, counting each host once per
+// normalized version and keeping the order of the first raw version.
+func (d *Datastore) countNormalizedSoftwareVersions(filter fleet.TeamFilter) ([]fleet.SoftwareVersionCount, error) {
+	sql := fmt.Sprintf(`
+		SELECT DISTINCT s.name, s.version, s.source, hs.host_id
+		FROM host_software hs
+		JOIN software s ON (s.id = hs.software_id)
+		JOIN hosts h ON (h.id = hs.host_id)
+		WHERE %s
+		ORDER BY s.name, s.source, s.version
+	`, d.whereFilterHostsByTeams(filter, "h"),
+	)
+	rows, err := d.db.Queryx(sql)
+	if err != nil {
+		return nil, errors.Wrap(err, "count normalized software versions")
+	}
+	defer rows.Close()
+
+	type key struct{ name, version, source string }
+	index := make(map[key]int)
+	hosts := []map[uint]bool{}
+	counts := []fleet.SoftwareVersionCount{}
+	for rows.Next() {
+		var row struct {
+			Name    string `db:"name"`
+			Version string `db:"version"`
+			Source  string `db:"source"`
+			HostID  uint   `db:"host_id"`
+		}
+		if err := rows.StructScan(&row); err != nil {
+			return nil, errors.Wrap(err, "scan normalized software versions")
+		}
+		version := fleet.NormalizeVersion(row.Version)
+		k := key{row.Name, version, row.Source}
+		i, ok := index[k]
+		if !ok {
+			i = len(counts)
+			index[k] = i
+			counts = append(counts, fleet.SoftwareVersionCount{Name: row.Name, Version: version, Source: row.Source})
+			hosts = append(hosts, map[uint]bool{})
+		}
+		if !hosts[i][row.HostID] {
+			hosts[i][row.HostID] = true
+			counts[i].HostsCount++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterate normalized software versions")
+	}
+	return counts, nil
+}
+
+func (d *Datastore) UpsertSoftwareMetadata(metadata []fleet.SoftwareMetadata) error {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	var args []interface{}
+	for _, m := range metadata {
+		args = append(args,
+			truncateString(m.Name, maxSoftwareNameLen),
+			truncateString(m.Version, maxSoftwareVersionLen),
+			truncateString(m.License, maxSoftwareMetadataLen),
+			truncateString(m.Vendor, maxSoftwareMetadataLen),
+		)
+	}
+	values := strings.TrimSuffix(strings.Repeat("(?,?,?,?),", len(metadata)), ",")
+	sql := fmt.Sprintf(`
+		INSERT INTO software_metadata (name, version, license, vendor)
+		VALUES %s
+		ON DUPLICATE KEY UPDATE
+			license = VALUES(license),
+			vendor = VALUES(vendor)
+	`, values)
+	if _, err := d.db.Exec(sql, args...); err != nil {
+		return errors.Wrap(err, "upsert software metadata")
+	}
+
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/WatchBeam/clock"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/test"
 	"github.com/stretchr/testify/assert"
@@ -97,3 +98,591 @@ func TestSaveHostSoftware(t *testing.T) {
 	assert.False(t, host1.HostSoftware.Modified)
 	test.ElementsMatchSkipID(t, soft1.Software, host1.HostSoftware.Software)
 }
+
+func TestHostSoftwareUpdatedAt(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+	mockClock := clock.NewMockClock()
+	ds.clock = mockClock
+
+	host := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+
+	// Never reported
+	require.NoError(t, ds.LoadHostSoftware(host))
+	assert.True(t, host.SoftwareUpdatedAt.IsZero())
+
+	host.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"}},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	first := host.SoftwareUpdatedAt
+	require.False(t, first.IsZero())
+
+	require.NoError(t, ds.LoadHostSoftware(host))
+	assert.Equal(t, first, host.SoftwareUpdatedAt.UTC())
+
+	// Reporting the same software still updates the time
+	mockClock.AddTime(time.Hour)
+	host.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"}},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	require.NoError(t, ds.LoadHostSoftware(host))
+	assert.Equal(t, first.Add(time.Hour), host.SoftwareUpdatedAt.UTC())
+
+	// As does reporting no software
+	mockClock.AddTime(time.Hour)
+	host.HostSoftware = fleet.HostSoftware{Modified: true}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	require.NoError(t, ds.LoadHostSoftware(host))
+	assert.Empty(t, host.Software)
+	assert.Equal(t, first.Add(2*time.Hour), host.SoftwareUpdatedAt.UTC())
+}
+
+func TestLoadHostSoftwareMetadata(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"},
+			{Name: "foo", Version: "0.0.3", Source: "chrome_extensions"},
+			{Name: "bar", Version: "0.0.3", Source: "deb_packages"},
+			{Name: "unknown", Version: "1.0.0", Source: "apps"},
+		},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host))
+
+	require.NoError(t, ds.UpsertSoftwareMetadata([]fleet.SoftwareMetadata{
+		{Name: "foo", License: "MIT", Vendor: "Foo Inc"},
+		{Name: "foo", Version: "0.0.3", License: "Apache-2.0", Vendor: "Foo Inc"},
+		{Name: "bar", Version: "0.0.3", License: "GPL", Vendor: "Bar"},
+	}))
+	// Upserting replaces the existing metadata
+	require.NoError(t, ds.UpsertSoftwareMetadata([]fleet.SoftwareMetadata{
+		{Name: "bar", Version: "0.0.3", License: "GPL-2.0", Vendor: "Bar Ltd"},
+	}))
+	require.NoError(t, ds.UpsertSoftwareMetadata(nil))
+
+	require.NoError(t, ds.LoadHostSoftware(host))
+	test.ElementsMatchSkipID(t, []fleet.Software{
+		{Name: "foo", Version: "0.0.1", Source: "chrome_extensions", License: "MIT", Vendor: "Foo Inc"},
+		{Name: "foo", Version: "0.0.3", Source: "chrome_extensions", License: "Apache-2.0", Vendor: "Foo Inc"},
+		{Name: "bar", Version: "0.0.3", Source: "deb_packages", License: "GPL-2.0", Vendor: "Bar Ltd"},
+		{Name: "unknown", Version: "1.0.0", Source: "apps"},
+	}, host.Software)
+}
+
+func TestLoadHostsSoftware(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host1 := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host2 := test.NewHost(t, ds, "host2", "", "host2key", "host2uuid", time.Now())
+	host3 := test.NewHost(t, ds, "host3", "", "host3key", "host3uuid", time.Now())
+
+	host1.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"},
+			{Name: "bar", Version: "0.0.3", Source: "deb_packages"},
+		},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host1))
+	host2.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"},
+		},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host2))
+	require.NoError(t, ds.UpsertSoftwareMetadata([]fleet.SoftwareMetadata{
+		{Name: "foo", License: "MIT", Vendor: "Foo Inc"},
+	}))
+
+	require.NoError(t, ds.LoadHostSoftware(host1))
+	require.NoError(t, ds.LoadHostSoftware(host2))
+
+	hosts := []*fleet.Host{{ID: host1.ID}, {ID: host2.ID}, {ID: host3.ID}}
+	require.NoError(t, ds.LoadHostsSoftware(hosts))
+	test.ElementsMatchSkipID(t, host1.Software, hosts[0].Software)
+	assert.Equal(t, host1.SoftwareUpdatedAt, hosts[0].SoftwareUpdatedAt)
+	test.ElementsMatchSkipID(t, []fleet.Software{
+		{Name: "foo", Version: "0.0.1", Source: "chrome_extensions", License: "MIT", Vendor: "Foo Inc"},
+	}, hosts[1].Software)
+	assert.Equal(t, host2.SoftwareUpdatedAt, hosts[1].SoftwareUpdatedAt)
+	assert.NotNil(t, hosts[2].Software)
+	assert.Empty(t, hosts[2].Software)
+	assert.True(t, hosts[2].SoftwareUpdatedAt.IsZero())
+
+	require.NoError(t, ds.LoadHostsSoftware(nil))
+}
+
+func TestCountSoftwareVersions(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host1 := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host2 := test.NewHost(t, ds, "host2", "", "host2key", "host2uuid", time.Now())
+	host3 := test.NewHost(t, ds, "host3", "", "host3key", "host3uuid", time.Now())
+
+	host1.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "curl", Version: "7.68.0-1ubuntu2", Source: "deb_packages"},
+			{Name: "zoom", Version: "5.7.1 (build 1)", Source: "apps"},
+		},
+	}
+	host2.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "curl", Version: "7.68.0-1ubuntu2.5", Source: "deb_packages"},
+			{Name: "zoom", Version: "5.7.1 (build 2)", Source: "apps"},
+		},
+	}
+	host3.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "curl", Version: "7.74.0-1", Source: "deb_packages"},
+		},
+	}
+	for _, h := range []*fleet.Host{host1, host2, host3} {
+		require.NoError(t, ds.SaveHostSoftware(h))
+	}
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team.ID, []uint{host3.ID}, false)
+	require.NoError(t, err)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+
+	counts, err := ds.CountSoftwareVersions(filter, fleet.SoftwareCountOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []fleet.SoftwareVersionCount{
+		{Name: "curl", Version: "7.68.0-1ubuntu2", Source: "deb_packages", HostsCount: 1},
+		{Name: "curl", Version: "7.68.0-1ubuntu2.5", Source: "deb_packages", HostsCount: 1},
+		{Name: "curl", Version: "7.74.0-1", Source: "deb_packages", HostsCount: 1},
+		{Name: "zoom", Version: "5.7.1 (build 1)", Source: "apps", HostsCount: 1},
+		{Name: "zoom", Version: "5.7.1 (build 2)", Source: "apps", HostsCount: 1},
+	}, counts)
+
+	counts, err = ds.CountSoftwareVersions(filter, fleet.SoftwareCountOptions{NormalizeVersions: true})
+	require.NoError(t, err)
+	assert.Equal(t, []fleet.SoftwareVersionCount{
+		{Name: "curl", Version: "7.68.0", Source: "deb_packages", HostsCount: 2},
+		{Name: "curl", Version: "7.74.0", Source: "deb_packages", HostsCount: 1},
+		{Name: "zoom", Version: "5.7.1", Source: "apps", HostsCount: 2},
+	}, counts)
+
+	// The raw versions are still stored
+	require.NoError(t, ds.LoadHostSoftware(host1))
+	test.ElementsMatchSkipID(t, []fleet.Software{
+		{Name: "curl", Version: "7.68.0-1ubuntu2", Source: "deb_packages"},
+		{Name: "zoom", Version: "5.7.1 (build 1)", Source: "apps"},
+	}, host1.Software)
+
+	// Only hosts allowed by the filter are counted
+	teamFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleMaintainer, Team: *team}},
+	}}
+	counts, err = ds.CountSoftwareVersions(teamFilter, fleet.SoftwareCountOptions{NormalizeVersions: true})
+	require.NoError(t, err)
+	assert.Equal(t, []fleet.SoftwareVersionCount{
+		{Name: "curl", Version: "7.74.0", Source: "deb_packages", HostsCount: 1},
+	}, counts)
+
+	// A host with several raw versions normalizing to the same version is
+	// counted once
+	host1.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "curl", Version: "7.68.0-1ubuntu2", Source: "deb_packages"},
+			{Name: "curl", Version: "7.68.0-1ubuntu2.5", Source: "deb_packages"},
+			{Name: "zoom", Version: "5.7.1 (build 1)", Source: "apps"},
+		},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host1))
+	counts, err = ds.CountSoftwareVersions(filter, fleet.SoftwareCountOptions{NormalizeVersions: true})
+	require.NoError(t, err)
+	assert.Equal(t, []fleet.SoftwareVersionCount{
+		{Name: "curl", Version: "7.68.0", Source: "deb_packages", HostsCount: 2},
+		{Name: "curl", Version: "7.74.0", Source: "deb_packages", HostsCount: 1},
+		{Name: "zoom", Version: "5.7.1", Source: "apps", HostsCount: 2},
+	}, counts)
+}
+
+func TestHostSoftwareCountsBySource(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host1 := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host2 := test.NewHost(t, ds, "host2", "", "host2key", "host2uuid", time.Now())
+
+	counts, err := ds.HostSoftwareCountsBySource(host1.ID)
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+
+	host1.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "foo", Version: "0.0.1", Source: "deb_packages"},
+			{Name: "bar", Version: "0.0.2", Source: "deb_packages"},
+			{Name: "baz", Version: "0.0.3", Source: "apps"},
+			{Name: "ext", Version: "1.0.0", Source: "chrome_extensions"},
+		},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host1))
+	host2.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "foo", Version: "0.0.1", Source: "deb_packages"},
+		},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host2))
+
+	counts, err = ds.HostSoftwareCountsBySource(host1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint{"deb_packages": 2, "apps": 1, "chrome_extensions": 1}, counts)
+
+	counts, err = ds.HostSoftwareCountsBySource(host2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint{"deb_packages": 1}, counts)
+}
+
+func TestHostHasSoftware(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host1 := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host2 := test.NewHost(t, ds, "host2", "", "host2key", "host2uuid", time.Now())
+	host1.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"},
+			{Name: "bar", Version: "0.0.3", Source: "deb_packages"},
+		},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host1))
+	host2.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "foo", Version: "0.0.2", Source: "chrome_extensions"},
+		},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host2))
+
+	for _, tc := range []struct {
+		hostID  uint
+		name    string
+		version string
+		has     bool
+	}{
+		{host1.ID, "foo", "0.0.1", true},
+		{host1.ID, "foo", "0.0.2", false},
+		{host1.ID, "foo", "", true},
+		{host1.ID, "bar", "", true},
+		{host1.ID, "baz", "", false},
+		{host2.ID, "foo", "0.0.2", true},
+		{host2.ID, "bar", "", false},
+		{999, "foo", "", false},
+	} {
+		has, err := ds.HostHasSoftware(tc.hostID, tc.name, tc.version)
+		require.NoError(t, err)
+		assert.Equal(t, tc.has, has, "%d %s %s", tc.hostID, tc.name, tc.version)
+	}
+}
+
+func TestCountHostsMissingSoftware(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	team1, err := ds.NewTeam(&fleet.Team{Name: "team1"})
+	require.NoError(t, err)
+	team2, err := ds.NewTeam(&fleet.Team{Name: "team2"})
+	require.NoError(t, err)
+
+	host1 := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host2 := test.NewHost(t, ds, "host2", "", "host2key", "host2uuid", time.Now())
+	host3 := test.NewHost(t, ds, "host3", "", "host3key", "host3uuid", time.Now())
+	host4 := test.NewHost(t, ds, "host4", "", "host4key", "host4uuid", time.Now())
+	test.NewHost(t, ds, "host5", "", "host5key", "host5uuid", time.Now())
+	host1.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "curl", Version: "7.68.0-1ubuntu2", Source: "deb_packages"},
+			{Name: "openssl", Version: "1.1.1-1ubuntu2", Source: "deb_packages"},
+		},
+	}
+	host2.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "curl", Version: "7.74", Source: "deb_packages"},
+			{Name: "openssl", Version: "1.1.1.4", Source: "deb_packages"},
+		},
+	}
+	host4.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "curl", Version: "7.80.0", Source: "deb_packages"},
+		},
+	}
+	for _, h := range []*fleet.Host{host1, host2, host4} {
+		require.NoError(t, ds.SaveHostSoftware(h))
+	}
+	_, err = ds.AddHostsToTeam(&team1.ID, []uint{host1.ID, host2.ID, host3.ID}, false)
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team2.ID, []uint{host4.ID}, false)
+	require.NoError(t, err)
+
+	filter := fleet.TeamFilter{User: test.UserAdmin}
+	for _, tc := range []struct {
+		teamID     uint
+		name       string
+		minVersion string
+		missing    uint
+	}{
+		{team1.ID, "curl", "", 1},
+		{team1.ID, "curl", "7.68", 1},
+		{team1.ID, "curl", "7.68.1", 2},
+		{team1.ID, "curl", "7.74.0", 2},
+		{team1.ID, "curl", "8", 3},
+		{team1.ID, "wget", "", 3},
+		// Suffixed and 4-part versions compare like any other software
+		// versions
+		{team1.ID, "openssl", "", 1},
+		{team1.ID, "openssl", "1.1.1", 1},
+		{team1.ID, "openssl", "1.1.1-1ubuntu9", 1},
+		{team1.ID, "openssl", "1.1.1.1", 2},
+		{team1.ID, "openssl", "1.1.1.4", 2},
+		{team1.ID, "openssl", "1.1.1.5", 3},
+		{team1.ID, "openssl", "1.1.2", 3},
+		{team2.ID, "curl", "7.80.0", 0},
+		{team2.ID, "curl", "v7.80.1", 1},
+		// host5 isn't in a team
+		{0, "curl", "", 1},
+	} {
+		missing, err := ds.CountHostsMissingSoftware(filter, tc.teamID, tc.name, tc.minVersion)
+		require.NoError(t, err)
+		assert.Equal(t, tc.missing, missing, "%d %s %s", tc.teamID, tc.name, tc.minVersion)
+	}
+
+	// Hosts outside of the filter are not counted.
+	teamFilter := fleet.TeamFilter{User: &fleet.User{
+		Teams: []fleet.UserTeam{{Role: fleet.RoleObserver, Team: *team2}},
+	}}
+	missing, err := ds.CountHostsMissingSoftware(teamFilter, team1.ID, "curl", "")
+	require.NoError(t, err)
+	assert.Zero(t, missing)
+	missing, err = ds.CountHostsMissingSoftware(teamFilter, team2.ID, "curl", "8.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), missing)
+
+	_, err = ds.CountHostsMissingSoftware(filter, team1.ID, "curl", "not-a-version")
+	assert.Error(t, err)
+}
+
+func TestHostSoftwareUpgradeCandidates(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host1 := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host2 := test.NewHost(t, ds, "host2", "", "host2key", "host2uuid", time.Now())
+	host3 := test.NewHost(t, ds, "host3", "", "host3key", "host3uuid", time.Now())
+	host1.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "curl", Version: "7.68.0-1ubuntu2", Source: "deb_packages"},
+			{Name: "zoom", Version: "5.7.1", Source: "apps"},
+			{Name: "vim", Version: "8.2", Source: "deb_packages"},
+		},
+	}
+	host2.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "curl", Version: "7.9.0", Source: "deb_packages"},
+			{Name: "zoom", Version: "5.10.0", Source: "apps"},
+			// Another source of the same name is not compared
+			{Name: "vim", Version: "9.0", Source: "homebrew_packages"},
+		},
+	}
+	host3.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "curl", Version: "7.74.0-1", Source: "deb_packages"},
+			{Name: "zoom", Version: "5.7.1", Source: "apps"},
+		},
+	}
+	for _, h := range []*fleet.Host{host1, host2, host3} {
+		require.NoError(t, ds.SaveHostSoftware(h))
+	}
+
+	latest := func(hostID uint) map[string]string {
+		candidates, err := ds.HostSoftwareUpgradeCandidates(hostID)
+		require.NoError(t, err)
+		m := map[string]string{}
+		for _, c := range candidates {
+			m[c.Current.Name+" "+c.Current.Version] = c.LatestSeen
+		}
+		return m
+	}
+
+	assert.Equal(t, map[string]string{
+		"curl 7.68.0-1ubuntu2": "7.74.0-1",
+		"zoom 5.7.1":           "5.10.0",
+	}, latest(host1.ID))
+	assert.Equal(t, map[string]string{"curl 7.9.0": "7.74.0-1"}, latest(host2.ID))
+	assert.Equal(t, map[string]string{"zoom 5.7.1": "5.10.0"}, latest(host3.ID))
+
+	candidates, err := ds.HostSoftwareUpgradeCandidates(host1.ID)
+	require.NoError(t, err)
+	require.Len(t, candidates, 2)
+	assert.Equal(t, "curl", candidates[0].Current.Name)
+	assert.Equal(t, "zoom", candidates[1].Current.Name)
+
+	candidates, err = ds.HostSoftwareUpgradeCandidates(999)
+	require.NoError(t, err)
+	assert.Empty(t, candidates)
+}
+
+func TestSaveHostSoftwareUnchangedHash(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+	mockClock := clock.NewMockClock()
+	ds.clock = mockClock
+
+	host := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+
+	software := []fleet.Software{
+		{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"},
+		{Name: "bar", Version: "0.0.3", Source: "deb_packages"},
+	}
+	host.HostSoftware = fleet.HostSoftware{Modified: true, Software: software}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	assert.False(t, host.HostSoftware.Modified)
+
+	// Remove the stored software behind the datastore's back, so that
+	// skipped writes are observable
+	_, err := ds.db.Exec(`DELETE FROM host_software WHERE host_id = ?`, host.ID)
+	require.NoError(t, err)
+
+	// The same software in a different order is not written again, only its
+	// collection time is
+	mockClock.AddTime(time.Hour)
+	host.HostSoftware = fleet.HostSoftware{Modified: true, Software: []fleet.Software{software[1], software[0], software[1]}}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	assert.False(t, host.HostSoftware.Modified)
+	require.NoError(t, ds.LoadHostSoftware(host))
+	assert.Empty(t, host.Software)
+	assert.Equal(t, mockClock.Now().UTC().Truncate(time.Second), host.SoftwareUpdatedAt.UTC())
+
+	// Changed software is written
+	host.HostSoftware = fleet.HostSoftware{Modified: true, Software: software[:1]}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	require.NoError(t, ds.LoadHostSoftware(host))
+	test.ElementsMatchSkipID(t, software[:1], host.Software)
+
+	// Unmodified software is never saved
+	host.HostSoftware = fleet.HostSoftware{Software: software}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	require.NoError(t, ds.LoadHostSoftware(host))
+	test.ElementsMatchSkipID(t, software[:1], host.Software)
+
+	// Clearing the software is written
+	host.HostSoftware = fleet.HostSoftware{Modified: true}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	require.NoError(t, ds.LoadHostSoftware(host))
+	assert.Empty(t, host.Software)
+}
+
+func TestSaveHostSoftwarePartialSources(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+
+	software := []fleet.Software{
+		{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"},
+		{Name: "bar", Version: "0.0.3", Source: "deb_packages"},
+		{Name: "baz", Version: "1.0.0", Source: "deb_packages"},
+	}
+	host.HostSoftware = fleet.HostSoftware{Modified: true, Software: software}
+	require.NoError(t, ds.SaveHostSoftware(host))
+
+	// Only the chrome extensions are replaced
+	host.HostSoftware = fleet.HostSoftware{
+		Modified:       true,
+		PartialSources: true,
+		Software:       []fleet.Software{{Name: "foo", Version: "0.0.2", Source: "chrome_extensions"}},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	assert.False(t, host.HostSoftware.Modified)
+	require.NoError(t, ds.LoadHostSoftware(host))
+	test.ElementsMatchSkipID(t, []fleet.Software{
+		{Name: "foo", Version: "0.0.2", Source: "chrome_extensions"},
+		software[1],
+		software[2],
+	}, host.Software)
+
+	// A new source is added to the stored software
+	host.HostSoftware = fleet.HostSoftware{
+		Modified:       true,
+		PartialSources: true,
+		Software:       []fleet.Software{{Name: "towel", Version: "42.0.0", Source: "apps"}},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	require.NoError(t, ds.LoadHostSoftware(host))
+	assert.Len(t, host.Software, 4)
+
+	// Nothing is cleared by partial software without sources
+	host.HostSoftware = fleet.HostSoftware{Modified: true, PartialSources: true}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	require.NoError(t, ds.LoadHostSoftware(host))
+	assert.Len(t, host.Software, 4)
+
+	// Replacing all the software is still the default
+	host.HostSoftware = fleet.HostSoftware{Modified: true, Software: software[1:2]}
+	require.NoError(t, ds.SaveHostSoftware(host))
+	require.NoError(t, ds.LoadHostSoftware(host))
+	test.ElementsMatchSkipID(t, software[1:2], host.Software)
+}
+
+func TestHostSoftwareDriftFromBaseline(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	host := test.NewHost(t, ds, "host1", "", "host1key", "host1uuid", time.Now())
+	host.HostSoftware = fleet.HostSoftware{
+		Modified: true,
+		Software: []fleet.Software{
+			{Name: "foo", Version: "0.0.1", Source: "chrome_extensions"},
+			{Name: "bar", Version: "0.0.3", Source: "deb_packages"},
+		},
+	}
+	require.NoError(t, ds.SaveHostSoftware(host))
+
+	baseline := []fleet.Software{
+		{Name: "bar", Version: "0.0.4", Source: "deb_packages"},
+		{Name: "baz", Version: "1.0.0", Source: "deb_packages"},
+	}
+	missing, extra, err := ds.HostSoftwareDriftFromBaseline(host.ID, baseline, fleet.SoftwareDriftOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, baseline, missing)
+	test.ElementsMatchSkipID(t, host.Software, extra)
+
+	missing, extra, err = ds.HostSoftwareDriftFromBaseline(host.ID, baseline, fleet.SoftwareDriftOptions{IgnoreVersions: true})
+	require.NoError(t, err)
+	assert.Equal(t, baseline[1:], missing)
+	test.ElementsMatchSkipID(t, host.Software[:1], extra)
+
+	// A host without software is missing all the baseline
+	host2 := test.NewHost(t, ds, "host2", "", "host2key", "host2uuid", time.Now())
+	missing, extra, err = ds.HostSoftwareDriftFromBaseline(host2.ID, baseline, fleet.SoftwareDriftOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, baseline, missing)
+	assert.Empty(t, extra)
+}
@@ -174,7 +174,7 @@ func TestHostStatus(t *testing.T) {
 
 	mockClock := clock.NewMockClock()
 
-	h, err := ds.EnrollHost("1", "key1", nil, 0)
+	h, err := ds.EnrollHost("1", "key1", nil, 0, fleet.EnrollHostOptions{})
 	require.Nil(t, err)
 
 	user := &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}
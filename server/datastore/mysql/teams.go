@@ -17,14 +17,18 @@ func (d *Datastore) NewTeam(team *fleet.Team) (*fleet.Team, error) {
 	INSERT INTO teams (
 		name,
 		agent_options,
-		description
-	) VALUES ( ?, ?, ? )
+		description,
+		carve_retention_hours,
+		new_host_hours
+	) VALUES ( ?, ?, ?, ?, ? )
 	`
 	result, err := d.db.Exec(
 		query,
 		team.Name,
 		team.AgentOptions,
 		team.Description,
+		team.CarveRetentionHours,
+		team.NewHostHours,
 	)
 	if err != nil {
 		return nil, errors.Wrap(err, "insert team")
@@ -154,10 +158,12 @@ func (d *Datastore) SaveTeam(team *fleet.Team) (*fleet.Team, error) {
 		UPDATE teams SET
 			name = ?,
 			agent_options = ?,
-			description = ?
+			description = ?,
+			carve_retention_hours = ?,
+			new_host_hours = ?
 		WHERE id = ?
 	`
-	_, err := d.db.Exec(query, team.Name, team.AgentOptions, team.Description, team.ID)
+	_, err := d.db.Exec(query, team.Name, team.AgentOptions, team.Description, team.CarveRetentionHours, team.NewHostHours, team.ID)
 	if err != nil {
 		return nil, errors.Wrap(err, "saving team")
 	}
@@ -51,6 +51,46 @@ func TestTeamGetSetDelete(t *testing.T) {
 	}
 }
 
+func TestTeamCarveRetention(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team", CarveRetentionHours: ptr.Uint(12)})
+	require.NoError(t, err)
+
+	team, err = ds.Team(team.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ptr.Uint(12), team.CarveRetentionHours)
+
+	team.CarveRetentionHours = nil
+	team, err = ds.SaveTeam(team)
+	require.NoError(t, err)
+
+	team, err = ds.Team(team.ID)
+	require.NoError(t, err)
+	assert.Nil(t, team.CarveRetentionHours)
+}
+
+func TestTeamNewHostHours(t *testing.T) {
+	ds := CreateMySQLDS(t)
+	defer ds.Close()
+
+	team, err := ds.NewTeam(&fleet.Team{Name: "team", NewHostHours: ptr.Uint(48)})
+	require.NoError(t, err)
+
+	team, err = ds.Team(team.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ptr.Uint(48), team.NewHostHours)
+
+	team.NewHostHours = nil
+	team, err = ds.SaveTeam(team)
+	require.NoError(t, err)
+
+	team, err = ds.Team(team.ID)
+	require.NoError(t, err)
+	assert.Nil(t, team.NewHostHours)
+}
+
 func TestTeamUsers(t *testing.T) {
 	ds := CreateMySQLDS(t)
 	defer ds.Close()
@@ -138,8 +178,10 @@ func TestTeamListTeams(t *testing.T) {
 	host1 := test.NewHost(t, ds, "1", "1", "1", "1", time.Now())
 	host2 := test.NewHost(t, ds, "2", "2", "2", "2", time.Now())
 	host3 := test.NewHost(t, ds, "3", "3", "3", "3", time.Now())
-	require.NoError(t, ds.AddHostsToTeam(&team1.ID, []uint{host1.ID}))
-	require.NoError(t, ds.AddHostsToTeam(&team2.ID, []uint{host2.ID, host3.ID}))
+	_, err = ds.AddHostsToTeam(&team1.ID, []uint{host1.ID}, false)
+	require.NoError(t, err)
+	_, err = ds.AddHostsToTeam(&team2.ID, []uint{host2.ID, host3.ID}, false)
+	require.NoError(t, err)
 
 	team1.Users = []fleet.TeamUser{
 		{User: user1, Role: "maintainer"},
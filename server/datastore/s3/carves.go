@@ -2,7 +2,9 @@ package s3
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"strings"
 	"time"
@@ -10,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/pkg/errors"
 )
 
@@ -30,7 +33,7 @@ func (d *Datastore) generateS3Key(metadata *fleet.CarveMetadata) string {
 }
 
 // NewCarve initializes a new file carving session
-func (d *Datastore) NewCarve(metadata *fleet.CarveMetadata) (*fleet.CarveMetadata, error) {
+func (d *Datastore) NewCarve(metadata *fleet.CarveMetadata, maxActivePerHost int) (*fleet.CarveMetadata, error) {
 	objectKey := d.generateS3Key(metadata)
 	res, err := d.s3client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
 		Bucket: &d.bucket,
@@ -40,7 +43,19 @@ func (d *Datastore) NewCarve(metadata *fleet.CarveMetadata) (*fleet.CarveMetadat
 		return nil, errors.Wrap(err, "s3 multipart carve create")
 	}
 	metadata.SessionId = *res.UploadId
-	return d.metadatadb.NewCarve(metadata)
+	carve, err := d.metadatadb.NewCarve(metadata, maxActivePerHost)
+	if err != nil {
+		// Don't leave the upload behind if the carve was rejected
+		if _, abortErr := d.s3client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   &d.bucket,
+			Key:      &objectKey,
+			UploadId: res.UploadId,
+		}); abortErr != nil {
+			return nil, errors.Wrapf(err, "abort s3 multipart carve create: %s", abortErr)
+		}
+		return nil, err
+	}
+	return carve, nil
 }
 
 // UpdateCarve updates carve definition in database
@@ -87,54 +102,117 @@ func (d *Datastore) listS3Carves(lastPrefix string, maxKeys int) (map[string]boo
 // CleanupCarves is a noop on the S3 side since users should rely on the bucket
 // lifecycle configurations provided by AWS. This will compare a portion of the
 // metadata present in the database and mark as expired the carves no longer
-// available in S3 (ignores the `now` argument)
-func (d *Datastore) CleanupCarves(now time.Time) (int, error) {
+// available in S3 (ignores the `now` argument and team carve retention)
+func (d *Datastore) CleanupCarves(now time.Time) (map[uint]int, error) {
 	var err error
 	// Get the 1000 oldest carves
-	nonExpiredCarves, err := d.ListCarves(fleet.CarveListOptions{
+	filter := fleet.TeamFilter{User: &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}}
+	nonExpiredCarves, err := d.ListCarves(filter, fleet.CarveListOptions{
 		ListOptions: fleet.ListOptions{PerPage: cleanupSize},
 		Expired:     false,
 	})
 	if err != nil {
-		return 0, errors.Wrap(err, "s3 carve cleanup")
+		return nil, errors.Wrap(err, "s3 carve cleanup")
 	}
 	// List carves in S3 up to a hour+1 prefix
 	lastCarveNextHour := nonExpiredCarves[len(nonExpiredCarves)-1].CreatedAt.Add(time.Hour)
 	lastCarvePrefix := d.prefix + lastCarveNextHour.Format(timePrefixFormat)
 	carveKeys, err := d.listS3Carves(lastCarvePrefix, 2*cleanupSize)
 	if err != nil {
-		return 0, errors.Wrap(err, "s3 carve cleanup")
+		return nil, errors.Wrap(err, "s3 carve cleanup")
 	}
 	// Compare carve metadata in DB with S3 listing and update expiration flag
-	cleanCount := 0
+	cleanCount := make(map[uint]int)
 	for _, carve := range nonExpiredCarves {
 		if _, ok := carveKeys[d.generateS3Key(carve)]; !ok {
 			carve.Expired = true
 			err = d.UpdateCarve(carve)
-			cleanCount++
+			var teamID uint
+			if carve.TeamID != nil {
+				teamID = *carve.TeamID
+			}
+			cleanCount[teamID]++
 		}
 	}
 	return cleanCount, err
 }
 
+// DeleteCarves deletes the S3 objects of the carves, aborting the multipart
+// uploads that are still in progress, then deletes the carves metadata. The
+// S3 deletions are not transactional, so carves whose objects were deleted
+// may remain if deleting the metadata fails.
+func (d *Datastore) DeleteCarves(ids []int64) (int, error) {
+	for _, id := range ids {
+		metadata, err := d.metadatadb.Carve(id)
+		if err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				continue
+			}
+			return 0, errors.Wrap(err, "s3 carve delete")
+		}
+		if metadata.Expired {
+			continue
+		}
+		objectKey := d.generateS3Key(metadata)
+		if metadata.BlocksComplete() {
+			_, err = d.s3client.DeleteObject(&s3.DeleteObjectInput{
+				Bucket: &d.bucket,
+				Key:    &objectKey,
+			})
+		} else {
+			_, err = d.s3client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   &d.bucket,
+				Key:      &objectKey,
+				UploadId: &metadata.SessionId,
+			})
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "s3 carve delete")
+		}
+	}
+	return d.metadatadb.DeleteCarves(ids)
+}
+
 // Carve returns carve metadata by ID
 func (d *Datastore) Carve(carveID int64) (*fleet.CarveMetadata, error) {
 	return d.metadatadb.Carve(carveID)
 }
 
+// HostCarveStorage returns the storage used by the carves of a host
+func (d *Datastore) HostCarveStorage(hostID uint) (int64, int, error) {
+	return d.metadatadb.HostCarveStorage(hostID)
+}
+
 // CarveBySessionId returns carve metadata by session ID
 func (d *Datastore) CarveBySessionId(sessionID string) (*fleet.CarveMetadata, error) {
 	return d.metadatadb.CarveBySessionId(sessionID)
 }
 
 // CarveByName returns carve metadata by name
-func (d *Datastore) CarveByName(name string) (*fleet.CarveMetadata, error) {
-	return d.metadatadb.CarveByName(name)
+func (d *Datastore) CarveByName(filter fleet.TeamFilter, name string) (*fleet.CarveMetadata, error) {
+	return d.metadatadb.CarveByName(filter, name)
+}
+
+// CarveByRequestId returns carve metadata by request ID
+func (d *Datastore) CarveByRequestId(requestID string) (*fleet.CarveMetadata, error) {
+	return d.metadatadb.CarveByRequestId(requestID)
 }
 
 // ListCarves returns a list of the currently available carves
-func (d *Datastore) ListCarves(opt fleet.CarveListOptions) ([]*fleet.CarveMetadata, error) {
-	return d.metadatadb.ListCarves(opt)
+func (d *Datastore) ListCarves(filter fleet.TeamFilter, opt fleet.CarveListOptions) ([]*fleet.CarveMetadata, error) {
+	return d.metadatadb.ListCarves(filter, opt)
+}
+
+// UpdateCarveCase sets the case of a carve
+func (d *Datastore) UpdateCarveCase(carveID int64, caseID string, notes string) error {
+	return d.metadatadb.UpdateCarveCase(carveID, caseID, notes)
+}
+
+// ListCarvesExpiringBefore returns the carves expiring before t according to
+// the team carve retention. The carves may be removed from S3 earlier by the
+// bucket lifecycle configuration, see CleanupCarves.
+func (d *Datastore) ListCarvesExpiringBefore(t time.Time) ([]*fleet.CarveMetadata, error) {
+	return d.metadatadb.ListCarvesExpiringBefore(t)
 }
 
 // listCompletedParts returns a list of the parts in a multipart updaload given a key and uploadID
@@ -168,6 +246,10 @@ func (d *Datastore) listCompletedParts(objectKey, uploadID string) ([]*s3.Comple
 
 // NewBlock uploads a new block for a specific carve
 func (d *Datastore) NewBlock(metadata *fleet.CarveMetadata, blockID int64, data []byte) error {
+	if err := metadata.ValidateBlockSize(blockID, int64(len(data))); err != nil {
+		return err
+	}
+
 	objectKey := d.generateS3Key(metadata)
 	partNumber := blockID + 1 // PartNumber is 1-indexed
 	_, err := d.s3client.UploadPart(&s3.UploadPartInput{
@@ -205,6 +287,56 @@ func (d *Datastore) NewBlock(metadata *fleet.CarveMetadata, blockID int64, data
 	return nil
 }
 
+// CarveReaderAt returns a reader over the data of a carve, fetching the ranges
+// of the S3 object that cover each read.
+func (d *Datastore) CarveReaderAt(carve *fleet.CarveMetadata) (io.ReaderAt, int64, error) {
+	return fleet.NewCarveReaderAt(d.GetBlock, carve)
+}
+
+// WriteCarve writes the data of a carve to w, fetching the range of the S3
+// object of each block in order.
+func (d *Datastore) WriteCarve(carve *fleet.CarveMetadata, w io.Writer) (int64, error) {
+	if carve.Expired {
+		return 0, fleet.ErrCarveExpired
+	}
+	status, err := d.CarveBlockStatus(carve.ID)
+	if err != nil {
+		return 0, err
+	}
+	return fleet.WriteCarveBlocks(d.GetBlock, status, carve, w)
+}
+
+// CarveBlockStatus returns whether each block of a carve was uploaded. The
+// parts of multipart uploads in progress are listed, and all the blocks of
+// completed uploads are present.
+func (d *Datastore) CarveBlockStatus(carveID int64) ([]bool, error) {
+	metadata, err := d.Carve(carveID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]bool, metadata.BlockCount)
+	switch {
+	case metadata.Expired:
+	case metadata.BlocksComplete():
+		for i := range status {
+			status[i] = true
+		}
+	default:
+		parts, err := d.listCompletedParts(d.generateS3Key(metadata), metadata.SessionId)
+		if err != nil {
+			return nil, errors.Wrap(err, "s3 carve block status")
+		}
+		for _, p := range parts {
+			blockID := *p.PartNumber - 1 // PartNumber is 1-indexed
+			if blockID >= 0 && blockID < metadata.BlockCount {
+				status[blockID] = true
+			}
+		}
+	}
+	return status, nil
+}
+
 // GetBlock returns a block of data for a carve
 func (d *Datastore) GetBlock(metadata *fleet.CarveMetadata, blockID int64) ([]byte, error) {
 	objectKey := d.generateS3Key(metadata)
@@ -23,6 +23,13 @@ type AppConfigStore interface {
 	GetEnrollSecrets(teamID *uint) ([]*EnrollSecret, error)
 	// ApplyEnrollSecrets replaces the current enroll secrets for a team with the provided secrets.
 	ApplyEnrollSecrets(teamID *uint, secrets []*EnrollSecret) error
+	// RecordHostEnrollSecret records the enroll secret a host used during its
+	// latest enrollment.
+	RecordHostEnrollSecret(hostID uint, secret string) error
+	// EnrollmentSecretUsage returns the number of hosts enrolled with each
+	// enroll secret, keyed by secret. Secrets no host enrolled with report
+	// zero.
+	EnrollmentSecretUsage() (map[string]uint, error)
 }
 
 // AppConfigService provides methods for configuring
@@ -2,22 +2,117 @@ package fleet
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"time"
 )
 
+var (
+	// ErrDuplicateCarveName is returned by NewCarve when a carve with the
+	// same name already exists.
+	ErrDuplicateCarveName = errors.New("carve with this name already exists")
+	// ErrCarveQuotaExceeded is returned by NewCarve when the host already has
+	// the maximum number of carves in progress.
+	ErrCarveQuotaExceeded = errors.New("host has too many carves in progress")
+	// ErrCarveExpired is returned when reading the data of an expired carve.
+	ErrCarveExpired = errors.New("carve is expired")
+)
+
+// CarveBlockMissingError is returned by WriteCarve when a block of the carve
+// is not stored.
+type CarveBlockMissingError struct {
+	BlockID int64
+}
+
+func (e *CarveBlockMissingError) Error() string {
+	return fmt.Sprintf("carve block %d is missing", e.BlockID)
+}
+
+// CarveBlockSizeError is returned by NewBlock when the size of a block doesn't
+// match the block size declared for the carve.
+type CarveBlockSizeError struct {
+	BlockID   int64
+	BlockSize int64
+	Size      int64
+	// Final is whether the block is the last block of the carve, which may be
+	// smaller than BlockSize.
+	Final bool
+}
+
+func (e *CarveBlockSizeError) Error() string {
+	if e.Final {
+		return fmt.Sprintf("final block %d exceeds declared block size %d: %d", e.BlockID, e.BlockSize, e.Size)
+	}
+	return fmt.Sprintf("block %d does not match declared block size %d: %d", e.BlockID, e.BlockSize, e.Size)
+}
+
+// DefaultCarveRetention is how long carves are kept before they expire, for
+// hosts without a team or on teams without their own carve retention.
+const DefaultCarveRetention = 24 * time.Hour
+
 type CarveStore interface {
-	NewCarve(metadata *CarveMetadata) (*CarveMetadata, error)
+	// NewCarve creates a new carve. Carve names must be unique, and
+	// ErrDuplicateCarveName is returned if the name is already in use.
+	//
+	// If maxActivePerHost is greater than 0, ErrCarveQuotaExceeded is returned
+	// if the host already has that many carves that are neither complete nor
+	// expired.
+	NewCarve(metadata *CarveMetadata, maxActivePerHost int) (*CarveMetadata, error)
 	UpdateCarve(metadata *CarveMetadata) error
 	Carve(carveId int64) (*CarveMetadata, error)
 	CarveBySessionId(sessionId string) (*CarveMetadata, error)
-	CarveByName(name string) (*CarveMetadata, error)
-	ListCarves(opt CarveListOptions) ([]*CarveMetadata, error)
+	// CarveByName returns the carve with the name if its host belongs to a
+	// team visible under the filter.
+	CarveByName(filter TeamFilter, name string) (*CarveMetadata, error)
+	// CarveByRequestId returns the latest carve started by the query with
+	// the request ID. A NotFoundError is returned if there is no such carve.
+	CarveByRequestId(requestId string) (*CarveMetadata, error)
+	// ListCarves lists the carves of the hosts in teams visible under the
+	// filter. Carves of hosts that were deleted are only visible to users
+	// with a global role allowed by the filter. The carves are ordered by id
+	// unless the options set an order key.
+	ListCarves(filter TeamFilter, opt CarveListOptions) ([]*CarveMetadata, error)
+	// UpdateCarveCase sets the CaseID and Notes of the carve, which are not
+	// changed by UpdateCarve. A NotFoundError is returned if the carve
+	// doesn't exist.
+	UpdateCarveCase(carveId int64, caseID string, notes string) error
+	// HostCarveStorage returns the total CarveSize and the number of the
+	// carves of the host that are not expired.
+	HostCarveStorage(hostID uint) (totalBytes int64, carveCount int, err error)
+	// NewBlock stores a block of the carve. A *CarveBlockSizeError is
+	// returned if the size of the block is invalid for the carve, see
+	// CarveMetadata.ValidateBlockSize.
 	NewBlock(metadata *CarveMetadata, blockId int64, data []byte) error
 	GetBlock(metadata *CarveMetadata, blockId int64) ([]byte, error)
-	// CleanupCarves will mark carves older than 24 hours expired, and delete the
-	// associated data blocks. This behaves differently for carves stored in S3
-	// (check the implementation godoc comment for more details)
-	CleanupCarves(now time.Time) (expired int, err error)
+	// CarveReaderAt returns a reader over the reassembled carve data along
+	// with its total size (CarveSize). Reads fetch only the blocks covering
+	// the requested range, see NewCarveReaderAt.
+	CarveReaderAt(carve *CarveMetadata) (io.ReaderAt, int64, error)
+	// WriteCarve writes the reassembled carve data to w one block at a time,
+	// returning the number of bytes written. ErrCarveExpired is returned for
+	// expired carves, and a *CarveBlockMissingError if a block is not
+	// stored, in both cases before anything is written.
+	WriteCarve(carve *CarveMetadata, w io.Writer) (int64, error)
+	// CarveBlockStatus returns whether each block of the carve is stored,
+	// indexed by block ID, with BlockCount entries. Expired carves have no
+	// stored blocks.
+	CarveBlockStatus(carveId int64) ([]bool, error)
+	// CleanupCarves will mark carves older than the retention of their host's
+	// team (DefaultCarveRetention for hosts without a team or teams without
+	// a retention) expired, and delete the associated data blocks. The
+	// number of carves expired is returned by team ID, with 0 for hosts
+	// without a team. This behaves differently for carves stored in S3 (check
+	// the implementation godoc comment for more details)
+	CleanupCarves(now time.Time) (expired map[uint]int, err error)
+	// ListCarvesExpiringBefore returns the carves that are not expired yet
+	// and whose retention (see CleanupCarves) ends before t, that is the
+	// carves that CleanupCarves(t) would expire, oldest first.
+	ListCarvesExpiringBefore(t time.Time) ([]*CarveMetadata, error)
+	// DeleteCarves deletes the carves with the IDs along with their data,
+	// returning the number of carves deleted. IDs of carves that don't
+	// exist are skipped.
+	DeleteCarves(ids []int64) (deleted int, err error)
 }
 
 type CarveService interface {
@@ -51,6 +146,14 @@ type CarveMetadata struct {
 	SessionId string `json:"session_id" db:"session_id"`
 	// Expired is whether the carve has "expired" (data has been purged).
 	Expired bool `json:"expired" db:"expired"`
+	// TeamID is the team of the host that initiated the carve. This value is
+	// not stored directly, but loaded from the hosts table.
+	TeamID *uint `json:"team_id,omitempty" db:"team_id"`
+	// CaseID is the investigation the carve belongs to, grouping carves
+	// across hosts. It is empty if the carve is not part of a case.
+	CaseID string `json:"case_id" db:"case_id"`
+	// Notes is free form text about the carve.
+	Notes string `json:"notes" db:"notes"`
 
 	// MaxBlock is the highest block number currently stored for this carve.
 	// This value is not stored directly, but generated from the carve_blocks
@@ -66,11 +169,124 @@ func (m *CarveMetadata) BlocksComplete() bool {
 	return m.MaxBlock == m.BlockCount-1
 }
 
+// ValidateBlockSize returns a *CarveBlockSizeError if size is not a valid size
+// for the block. All blocks but the last must be exactly BlockSize, and the
+// last block must be no larger than BlockSize.
+func (m *CarveMetadata) ValidateBlockSize(blockId int64, size int64) error {
+	final := blockId >= m.BlockCount-1
+	if size > m.BlockSize || (!final && size != m.BlockSize) {
+		return &CarveBlockSizeError{
+			BlockID:   blockId,
+			BlockSize: m.BlockSize,
+			Size:      size,
+			Final:     final,
+		}
+	}
+	return nil
+}
+
+// NewCarveReaderAt returns an io.ReaderAt over the data of carve, using
+// getBlock to fetch the blocks that cover each read. Reads at or past
+// CarveSize return io.EOF.
+func NewCarveReaderAt(getBlock func(metadata *CarveMetadata, blockId int64) ([]byte, error), carve *CarveMetadata) (io.ReaderAt, int64, error) {
+	if carve.Expired {
+		return nil, 0, ErrCarveExpired
+	}
+	if carve.BlockSize <= 0 {
+		return nil, 0, fmt.Errorf("invalid carve block size %d", carve.BlockSize)
+	}
+	return &carveReaderAt{getBlock: getBlock, carve: carve}, carve.CarveSize, nil
+}
+
+type carveReaderAt struct {
+	getBlock func(metadata *CarveMetadata, blockId int64) ([]byte, error)
+	carve    *CarveMetadata
+}
+
+func (r *carveReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	size := r.carve.CarveSize
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && off < size {
+		blockId := off / r.carve.BlockSize
+		data, err := r.getBlock(r.carve, blockId)
+		if err != nil {
+			return n, err
+		}
+		start := off % r.carve.BlockSize
+		if start >= int64(len(data)) {
+			// The block is shorter than the carve size implies
+			return n, io.ErrUnexpectedEOF
+		}
+		end := int64(len(data))
+		if remaining := size - blockId*r.carve.BlockSize; end > remaining {
+			end = remaining
+		}
+		copied := copy(p[n:], data[start:end])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteCarveBlocks writes the data of carve to w, using getBlock to fetch the
+// blocks in order, and returns the number of bytes written. status is whether
+// each block is stored, as returned by CarveBlockStatus, and is checked before
+// anything is written. The data past CarveSize is not written.
+func WriteCarveBlocks(getBlock func(metadata *CarveMetadata, blockId int64) ([]byte, error), status []bool, carve *CarveMetadata, w io.Writer) (int64, error) {
+	if carve.Expired {
+		return 0, ErrCarveExpired
+	}
+	if carve.BlockSize <= 0 {
+		return 0, fmt.Errorf("invalid carve block size %d", carve.BlockSize)
+	}
+	for blockId := int64(0); blockId < carve.BlockCount; blockId++ {
+		if blockId >= int64(len(status)) || !status[blockId] {
+			return 0, &CarveBlockMissingError{BlockID: blockId}
+		}
+	}
+
+	var written int64
+	for blockId := int64(0); blockId < carve.BlockCount && written < carve.CarveSize; blockId++ {
+		data, err := getBlock(carve, blockId)
+		if err != nil {
+			return written, err
+		}
+		if remaining := carve.CarveSize - written; int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+		n, err := w.Write(data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	if written < carve.CarveSize {
+		// The blocks are shorter than the carve size implies
+		return written, io.ErrUnexpectedEOF
+	}
+	return written, nil
+}
+
 type CarveListOptions struct {
 	ListOptions
 
 	// Expired determines whether to include expired carves.
 	Expired bool
+	// NamePrefix, if set, only includes carves with names starting with the
+	// prefix.
+	NamePrefix string
+	// CaseID, if set, only includes the carves of the case.
+	CaseID string
 }
 
 type CarveBeginPayload struct {
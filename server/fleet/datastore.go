@@ -17,8 +17,10 @@ type Datastore interface {
 	CarveStore
 	TeamStore
 	SoftwareStore
+	DiskStore
 	ActivitiesStore
 	StatisticsStore
+	PolicyStore
 
 	Name() string
 	Drop() error
@@ -0,0 +1,31 @@
+package fleet
+
+type DiskStore interface {
+	// SaveHostDisks replaces the disks of the host with the host's Disks, if
+	// they were modified since loading. Disks are identified by name, only
+	// the last of several disks with the same name is saved.
+	SaveHostDisks(host *Host) error
+	// LoadHostDisks loads the disks of the host, ordered by name.
+	LoadHostDisks(host *Host) error
+}
+
+// HostDisk is a disk device of a host.
+type HostDisk struct {
+	// Name is the device name, eg. /dev/sda or \\.\PHYSICALDRIVE0.
+	Name string `json:"name" db:"name"`
+	// Size is the size of the disk in bytes.
+	Size int64 `json:"size" db:"size"`
+	// Type is the type of the disk reported by the host, eg. its interface
+	// type on Windows.
+	Type string `json:"type" db:"type"`
+}
+
+// HostDisks is the set of disks of a specific host
+type HostDisks struct {
+	// Disks is the disk information.
+	Disks []HostDisk `json:"disks,omitempty"`
+	// Modified is a boolean indicating whether this has been modified since
+	// loading. If Modified is true, datastore implementations should save the
+	// data.
+	Modified bool `json:"-"`
+}
@@ -3,9 +3,28 @@ package fleet
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
 	"time"
 )
 
+// ErrHostDecommissioned is returned when a decommissioned host attempts to
+// authenticate or enroll.
+var ErrHostDecommissioned = errors.New("host is decommissioned")
+
+// HostSerialAmbiguousError is returned when looking up a host by a hardware
+// serial number shared by several hosts.
+type HostSerialAmbiguousError struct {
+	Serial  string
+	HostIDs []uint
+}
+
+func (e *HostSerialAmbiguousError) Error() string {
+	return fmt.Sprintf("hardware serial %q matches %d hosts", e.Serial, len(e.HostIDs))
+}
+
 type HostStatus string
 
 const (
@@ -38,14 +57,93 @@ type HostStore interface {
 	// enrolled via EnrollHost.
 	NewHost(host *Host) (*Host, error)
 	SaveHost(host *Host) error
+	// SaveHostFields updates only the named columns of the host, leaving the
+	// other columns and the host's related data untouched, so that
+	// concurrent updates of different fields don't overwrite each other.
+	// Fields are the column names of the host details saved by SaveHost,
+	// other fields return an error.
+	SaveHostFields(host *Host, fields []string) error
 	DeleteHost(hid uint) error
+	// DeleteHostWithReason deletes the host like DeleteHost, recording a
+	// HostDeletion with the reason.
+	DeleteHostWithReason(hid uint, reason string) error
+	// ListHostDeletions returns the host deletions recorded since the
+	// provided time, oldest first.
+	ListHostDeletions(since time.Time) ([]*HostDeletion, error)
+	// DecommissionHost marks the host decommissioned, keeping its record and
+	// history. A decommissioned host can no longer authenticate with its node
+	// key or enroll again, ErrHostDecommissioned is returned instead. It is
+	// excluded from host listings and summaries.
+	DecommissionHost(hid uint) error
+	// ScheduleHostRetirement schedules the host to be decommissioned at the
+	// provided time, replacing any previous schedule. A time that has already
+	// passed decommissions the host immediately.
+	ScheduleHostRetirement(hostID uint, at time.Time) error
+	// ProcessHostRetirements decommissions the hosts scheduled to retire at
+	// or before now, returning the number of hosts decommissioned.
+	ProcessHostRetirements(now time.Time) (int, error)
+	// RotateHostNodeKey replaces the node key of the host with a newly
+	// generated key, which is returned. The previous key no longer
+	// authenticates, so the host enrolls again to get a key.
+	RotateHostNodeKey(hostID uint) (string, error)
 	Host(id uint) (*Host, error)
+	// HostExists returns whether the host exists and is not decommissioned,
+	// without loading it.
+	HostExists(id uint) (bool, error)
+	// HostBySerial returns the host with the hardware serial number. A
+	// NotFoundError is returned if no host has the serial, or the serial is
+	// empty, and a *HostSerialAmbiguousError if several hosts have it.
+	HostBySerial(serial string) (*Host, error)
 	// EnrollHost will enroll a new host with the given identifier, setting the
 	// node key, and team. Implementations of this method should respect the
 	// provided host enrollment cooldown, by returning an error if the host has
 	// enrolled within the cooldown period.
-	EnrollHost(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration) (*Host, error)
+	//
+	// The options control the handling of existing hosts and the details
+	// recorded with the enrollment, see EnrollHostOptions.
+	EnrollHost(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration, opt EnrollHostOptions) (*Host, error)
+	// EnrollHostByHardware enrolls a host like EnrollHost, but matches an
+	// existing host by its hardware fingerprint (eg. serial number and board)
+	// before its osquery identifier, so that reimaged machines are enrolled
+	// again instead of creating duplicate hosts. The osquery identifier of the
+	// matching host is updated. An empty fingerprint behaves like EnrollHost.
+	EnrollHostByHardware(osqueryHostId, fingerprint, nodeKey string, teamID *uint, cooldown time.Duration) (*Host, error)
+	// ListFailedEnrollments returns the enrollments rejected by EnrollHost or
+	// EnrollHostByHardware since the provided time, oldest first.
+	ListFailedEnrollments(since time.Time) ([]*EnrollmentAttempt, error)
+	// ListHostTeamChanges returns the team changes of existing hosts
+	// enrolling again with a different team since the provided time, oldest
+	// first.
+	ListHostTeamChanges(since time.Time) ([]*HostTeamChange, error)
+	// ListHosts returns the hosts matching opt. Unless opt sets an OrderKey,
+	// the hosts are ordered by hostname, then by ID (or by their latest
+	// update when ModifiedSince is set).
 	ListHosts(filter TeamFilter, opt HostListOptions) ([]*Host, error)
+	// IterHosts calls fn with each host selected like ListHosts, streaming
+	// the hosts rather than loading them all. All the matching hosts are
+	// iterated unless opt sets a page size. Iteration stops at the first
+	// error returned by fn, which is returned.
+	IterHosts(filter TeamFilter, opt HostListOptions, fn func(*Host) error) error
+	// HostDetailQueryStatus returns when each detail query last succeeded on
+	// the host, by detail query name. Detail queries that never succeeded
+	// are missing.
+	HostDetailQueryStatus(hostID uint) (map[string]time.Time, error)
+	// HostScheduledQueryStats returns the stats of the scheduled query with
+	// the name reported by the host. If the query is scheduled in several
+	// packs, the stats of the latest execution are returned. The stats are
+	// zeroed, with Found false, if the host never ran the query.
+	HostScheduledQueryStats(hostID uint, queryName string) (ScheduledQueryStats, error)
+	// ListHostsWithPoorBatteryHealth returns the hosts with a battery that
+	// should be replaced, see Host.PoorBatteryHealth.
+	ListHostsWithPoorBatteryHealth(filter TeamFilter) ([]*Host, error)
+	// ListEnrolledNeverSeenHosts returns the hosts that reported their
+	// details but were not seen since they last enrolled, such as agents that
+	// died after enrolling. Incoming hosts, see CleanupIncomingHosts, are not
+	// included.
+	ListEnrolledNeverSeenHosts(filter TeamFilter) ([]*Host, error)
+	// ListHostsWithPendingActions returns the hosts with at least one
+	// pending action, see Host.PendingActions, ordered by ID.
+	ListHostsWithPendingActions(filter TeamFilter) ([]*Host, error)
 	// AuthenticateHost authenticates and returns host metadata by node key.
 	// This method should not return the host "additional" information as this
 	// is not typically necessary for the operations performed by the osquery
@@ -53,7 +151,19 @@ type HostStore interface {
 	AuthenticateHost(nodeKey string) (*Host, error)
 	MarkHostSeen(host *Host, t time.Time) error
 	MarkHostsSeen(hostIDs []uint, t time.Time) error
-	SearchHosts(filter TeamFilter, query string, omit ...uint) ([]*Host, error)
+	// SearchHosts searches hosts matching the query, excluding the omitted
+	// host IDs. Only hosts allowed by the filter are considered. At most limit
+	// hosts are returned, a limit of 0 uses the default.
+	SearchHosts(filter TeamFilter, query string, limit int, omit ...uint) ([]*Host, error)
+	// SearchHostsWithMatches searches hosts like SearchHosts, and reports
+	// the fields of each host that matched the query, see
+	// Host.SearchMatchedFields.
+	SearchHostsWithMatches(filter TeamFilter, query string, limit int, omit ...uint) ([]*HostSearchResult, error)
+	// SearchHostsFuzzy searches hosts whose hostname or computer name is
+	// within a small edit distance of the query, ordered by distance so that
+	// exact matches come first. At most limit hosts are returned, a limit of 0
+	// uses the default.
+	SearchHostsFuzzy(filter TeamFilter, query string, limit int) ([]*Host, error)
 	// CleanupIncomingHosts deletes hosts that have enrolled but never
 	// updated their status details. This clears dead "incoming hosts" that
 	// never complete their registration.
@@ -62,43 +172,135 @@ type HostStore interface {
 	// osquery_version fields are empty. This means that multiple different
 	// osquery queries failed to populate details.
 	CleanupIncomingHosts(now time.Time) error
+	// ClearStaleRefetchRequests clears the refetch requested flag of hosts
+	// that were asked to refetch before olderThan and haven't updated their
+	// details since then, returning the number of hosts cleared. This keeps
+	// the flag from being stuck on hosts that never came back online.
+	ClearStaleRefetchRequests(olderThan time.Time) (int, error)
+	// RefetchStatus returns whether a refetch of the host details was ever
+	// requested, and whether the latest one completed, that is the details
+	// were updated since it was requested. The refetch requested flag is
+	// cleared when a completed refetch is detected.
+	RefetchStatus(hostID uint) (requested bool, completed bool, err error)
 	// GenerateHostStatusStatistics retrieves the count of online, offline,
-	// MIA and new hosts.
-	GenerateHostStatusStatistics(filter TeamFilter, now time.Time) (online, offline, mia, new uint, err error)
+	// MIA and new hosts, along with the total count of hosts.
+	GenerateHostStatusStatistics(filter TeamFilter, now time.Time) (*HostSummary, error)
+	// TeamHostStatusStatistics retrieves the same counts as
+	// GenerateHostStatusStatistics for each team, keyed by team ID with 0
+	// for the hosts without a team. Teams without hosts are omitted.
+	TeamHostStatusStatistics(filter TeamFilter, now time.Time) (map[uint]HostSummary, error)
+	// MultiHostStatusStatistics retrieves the same counts as
+	// GenerateHostStatusStatistics for each of the filters, keyed by the
+	// filter names, which must be unique.
+	MultiHostStatusStatistics(filters []NamedTeamFilter, now time.Time) (map[string]HostSummary, error)
+	// CountHostsBySubnet returns the number of hosts allowed by the filter in
+	// each subnet of their primary IP, keyed as returned by HostSubnet.
+	// maskBits must be between 0 and 32.
+	CountHostsBySubnet(filter TeamFilter, maskBits int) (map[string]uint, error)
+	// SnapshotHostSummary stores the summary of all hosts at now, for
+	// HostSummaryDelta.
+	SnapshotHostSummary(now time.Time) error
+	// HostSummaryDelta returns the change in the host summary between the
+	// latest snapshots taken at or before from and to. A NotFoundError is
+	// returned if there is no such snapshot.
+	HostSummaryDelta(from, to time.Time) (HostSummaryDelta, error)
+	// PlatformCountHistory returns the host counts by platform family
+	// recorded by SnapshotHostSummary between from and to (inclusive), oldest
+	// first. Periods without a snapshot have no points, and platforms
+	// without hosts in a snapshot have a zero count.
+	PlatformCountHistory(from, to time.Time) (map[string][]PlatformCountPoint, error)
+	// HostResourceHistory returns the memory and disk samples of the host
+	// recorded by SaveHost between from and to (inclusive), oldest first.
+	// Samples are taken at most once per sampling interval of the datastore.
+	HostResourceHistory(hostID uint, from, to time.Time) ([]HostResourceSample, error)
 	// HostIDsByName Retrieve the IDs associated with the given hostnames
 	HostIDsByName(filter TeamFilter, hostnames []string) ([]uint, error)
 	// HostByIdentifier returns one host matching the provided identifier.
-	// Possible matches can be on osquery_host_identifier, node_key, UUID, or
-	// hostname.
+	// Possible matches can be on osquery_host_identifier, node_key, UUID,
+	// hostname or cloud instance ID.
 	HostByIdentifier(identifier string) (*Host, error)
 	// AddHostsToTeam adds hosts to an existing team, clearing their team
-	// settings if teamID is nil.
-	AddHostsToTeam(teamID *uint, hostIDs []uint) error
+	// settings if teamID is nil. If refetchLabels is true, the moved hosts
+	// have their label_updated_at reset in the same statement so that all
+	// label queries are run on their next check in. Hosts already in the team
+	// are left untouched, so that calling it again with the same arguments
+	// changes nothing.
+	AddHostsToTeam(teamID *uint, hostIDs []uint, refetchLabels bool) (HostTeamAssignment, error)
 	// SaveHostAdditional saves the information generated by the
 	// additional_queries.
 	SaveHostAdditional(host *Host) error
+	// HostOrphanCheck returns, keyed by table name, the number of rows in
+	// tables related to hosts that still reference the provided host ID. This
+	// is intended for verifying that DeleteHost removed all related data, in
+	// which case all counts are zero.
+	HostOrphanCheck(hostID uint) (map[string]int, error)
+	// SetHostOwner sets the assigned owner email of the host. An empty email
+	// clears the owner.
+	SetHostOwner(hostID uint, email string) error
+	// HostsByOwner returns the hosts allowed by the filter that are assigned
+	// to the owner email.
+	HostsByOwner(filter TeamFilter, email string) ([]*Host, error)
+	// ApplyHostAnnotations sets the owner and notes of the hosts matching
+	// the identifiers, as matched by HostByIdentifier. The number of
+	// annotations applied is returned along with the identifiers that
+	// didn't match a host, which are not an error.
+	ApplyHostAnnotations(annotations map[string]HostAnnotation) (applied int, unmatched []string, err error)
+	// ListHostFieldChanges returns the changes of the host's fields saved by
+	// SaveHost or SaveHostFields, oldest first. Only the fields tracked by
+	// the datastore configuration are recorded. If fields is empty, the
+	// changes of all fields are returned.
+	ListHostFieldChanges(hostID uint, fields []string) ([]*HostFieldChange, error)
+	// StalestHostsByPlatform returns, for each platform of the hosts allowed
+	// by the filter, up to limit hosts that were seen least recently, oldest
+	// first.
+	StalestHostsByPlatform(filter TeamFilter, limit int) (map[string][]*Host, error)
+	// ListHostUsers returns a page of the users currently on the host,
+	// along with the total count of those users. Host does not load the
+	// users.
+	ListHostUsers(hostID uint, opt ListOptions) ([]HostUser, uint, error)
+	// LoadHostUsers loads all the users currently on the host, without
+	// paginating.
+	LoadHostUsers(host *Host) error
+	// SetHostTags sets the tags on the hosts, replacing the value of tags
+	// that are already set. Other tags on the hosts are kept.
+	SetHostTags(hostIDs []uint, tags map[string]string) error
+	// ListHostTags returns the tags set on the host.
+	ListHostTags(hostID uint) (map[string]string, error)
 }
 
 type HostService interface {
 	ListHosts(ctx context.Context, opt HostListOptions) (hosts []*Host, err error)
-	GetHost(ctx context.Context, id uint) (host *HostDetail, err error)
+	GetHost(ctx context.Context, id uint, opt HostDetailOptions) (host *HostDetail, err error)
 	GetHostSummary(ctx context.Context) (summary *HostSummary, err error)
-	DeleteHost(ctx context.Context, id uint) (err error)
+	// DeleteHost deletes the host, recording the reason for the deletion.
+	DeleteHost(ctx context.Context, id uint, reason string) (err error)
 	// HostByIdentifier returns one host matching the provided identifier.
-	// Possible matches can be on osquery_host_identifier, node_key, UUID, or
-	// hostname.
+	// Possible matches can be on osquery_host_identifier, node_key, UUID,
+	// hostname or cloud instance ID.
 	HostByIdentifier(ctx context.Context, identifier string) (*HostDetail, error)
 	// RefetchHost requests a refetch of host details for the provided host.
 	RefetchHost(ctx context.Context, id uint) (err error)
+	// ListHostUsers returns a page of the users currently on the host, along
+	// with the total count of those users.
+	ListHostUsers(ctx context.Context, id uint, opt ListOptions) (users []HostUser, count uint, err error)
+	// HostSoftwareUpgradeCandidates returns the software installed on the
+	// host with a newer version installed elsewhere in the fleet.
+	HostSoftwareUpgradeCandidates(ctx context.Context, id uint) ([]SoftwareUpgradeCandidate, error)
+	// SetHostOwner assigns the owner email of the host, an empty email
+	// clears the owner.
+	SetHostOwner(ctx context.Context, id uint, email string) (err error)
 
 	FlushSeenHosts(ctx context.Context) error
 	// AddHostsToTeam adds hosts to an existing team, clearing their team
 	// settings if teamID is nil.
-	AddHostsToTeam(ctx context.Context, teamID *uint, hostIDs []uint) error
+	AddHostsToTeam(ctx context.Context, teamID *uint, hostIDs []uint) (HostTeamAssignment, error)
 	// AddHostsToTeamByFilter adds hosts to an existing team, clearing their
 	// team settings if teamID is nil. Hosts are selected by the label and
 	// HostListOptions provided.
-	AddHostsToTeamByFilter(ctx context.Context, teamID *uint, opt HostListOptions, lid *uint) error
+	AddHostsToTeamByFilter(ctx context.Context, teamID *uint, opt HostListOptions, lid *uint) (HostTeamAssignment, error)
+	// SetHostTagsByFilter sets the tags on the hosts selected by the label
+	// and HostListOptions provided, returning the number of hosts tagged.
+	SetHostTagsByFilter(ctx context.Context, opt HostListOptions, lid *uint, tags map[string]string) (count int, err error)
 }
 
 type HostListOptions struct {
@@ -109,6 +311,189 @@ type HostListOptions struct {
 	AdditionalFilters []string
 	// StatusFilter selects the online status of the hosts.
 	StatusFilter HostStatus
+	// MinUptime, if non-zero, selects hosts with an uptime of at least this
+	// duration. Hosts reporting zero uptime (usually because details have
+	// not been ingested yet) are excluded when either uptime bound is set.
+	MinUptime time.Duration
+	// MaxUptime, if non-zero, selects hosts with an uptime of at most this
+	// duration.
+	MaxUptime time.Duration
+	// NoTeam selects only hosts that are not assigned to a team. This is
+	// applied in addition to the authorization TeamFilter.
+	NoTeam bool
+	// EnrolledFromIP, if set, selects hosts whose latest enrollment request
+	// came from this IP.
+	EnrolledFromIP string
+	// OsqueryVersionConstraints, if set, selects hosts whose osquery version
+	// satisfies all of the constraints. Hosts with an empty osquery version
+	// never satisfy a constraint.
+	OsqueryVersionConstraints []OsqueryVersionConstraint
+	// OsqueryVersionEmpty selects hosts that haven't reported an osquery
+	// version. When combined with OsqueryVersionConstraints, hosts matching
+	// either are selected.
+	OsqueryVersionEmpty bool
+	// OwnerFilter, if set, selects hosts with this assigned owner.
+	OwnerFilter string
+	// HasUser, if set, selects hosts with a current local user account of
+	// this username, ignoring case.
+	HasUser string
+	// HasActiveCarves selects hosts with at least one carve that is neither
+	// complete nor expired.
+	HasActiveCarves bool
+	// IncludeDecommissioned includes decommissioned hosts, which are
+	// excluded by default.
+	IncludeDecommissioned bool
+	// HasFailingPolicies selects hosts that failed at least one policy when
+	// they last checked it.
+	HasFailingPolicies bool
+	// AdditionalKey, if set, selects hosts whose additional data has this
+	// top-level key.
+	AdditionalKey string
+	// AdditionalKeyMissing selects hosts whose additional data doesn't have
+	// AdditionalKey instead. Hosts without additional data are missing every
+	// key.
+	AdditionalKeyMissing bool
+	// LabelUpdatedBefore, if set, selects hosts whose labels were last
+	// updated before this time, to find hosts with stale label membership.
+	LabelUpdatedBefore *time.Time
+	// ModifiedSince, if set, selects hosts whose detail_updated_at,
+	// label_updated_at or seen_time is at or after this time, for
+	// incremental syncs. Unless an order key is set, the hosts are ordered by
+	// the latest of these times.
+	ModifiedSince *time.Time
+	// LabelIDs, if set, selects hosts that are members of the labels, either
+	// any or all of them depending on LabelMatchMode.
+	LabelIDs []uint
+	// LabelMatchMode determines whether hosts must be members of any
+	// (default) or all of LabelIDs.
+	LabelMatchMode LabelMatchMode
+	// TimezoneFilter, if set, selects hosts in the timezone.
+	TimezoneFilter string
+	// KernelVersionFilter, if set, selects hosts running the kernel version.
+	KernelVersionFilter string
+	// DiskEncryptionFilter, if set, selects hosts whose disk encryption
+	// status is known and matches. Hosts with an unknown status never match.
+	DiskEncryptionFilter *bool
+	// OrbitVersionFilter, if set, selects hosts running the orbit version.
+	OrbitVersionFilter string
+	// CloudProviderFilter, if set, selects hosts running in the cloud
+	// provider, eg. "aws".
+	CloudProviderFilter string
+	// CloudInstanceIDFilter, if set, selects the hosts with the cloud
+	// instance ID.
+	CloudInstanceIDFilter string
+	// MDMEnrolledFilter, if set, selects hosts whose MDM enrollment status is
+	// known and matches. Hosts with an unknown status never match.
+	MDMEnrolledFilter *bool
+	// MDMServerURLFilter, if set, selects hosts enrolled in the MDM server.
+	MDMServerURLFilter string
+	// BatteryHealthFilter, if set, selects hosts whose battery reports this
+	// health, eg. "Poor".
+	BatteryHealthFilter string
+	// PoorBatteryHealth selects hosts with a battery that should be
+	// replaced, see Host.PoorBatteryHealth.
+	PoorBatteryHealth bool
+	// RefetchRequestedFilter, if set, selects hosts that have (true) or
+	// don't have (false) a pending refetch.
+	RefetchRequestedFilter *bool
+	// Fields, if set, restricts the host columns that are loaded. The id is
+	// always loaded. Fields must be in HostListFields. Note that the host
+	// status is computed from seen_time, distributed_interval and
+	// config_tls_refresh.
+	Fields []string
+}
+
+// LabelMatchMode determines how HostListOptions.LabelIDs are matched.
+type LabelMatchMode string
+
+const (
+	// LabelMatchAny selects hosts that are members of any of the labels. It is
+	// the default for an empty LabelMatchMode.
+	LabelMatchAny LabelMatchMode = "any"
+	// LabelMatchAll selects hosts that are members of all of the labels.
+	LabelMatchAll LabelMatchMode = "all"
+)
+
+// Valid returns whether the mode is a known mode or empty.
+func (m LabelMatchMode) Valid() bool {
+	switch m {
+	case "", LabelMatchAny, LabelMatchAll:
+		return true
+	}
+	return false
+}
+
+// HostListFields are the host columns that can be selected with
+// HostListOptions.Fields.
+var HostListFields = map[string]bool{
+	"id":                      true,
+	"created_at":              true,
+	"updated_at":              true,
+	"detail_updated_at":       true,
+	"label_updated_at":        true,
+	"last_enrolled_at":        true,
+	"seen_time":               true,
+	"refetch_requested":       true,
+	"hostname":                true,
+	"uuid":                    true,
+	"platform":                true,
+	"osquery_version":         true,
+	"orbit_version":           true,
+	"cloud_provider":          true,
+	"cloud_instance_id":       true,
+	"mdm_enrolled":            true,
+	"mdm_server_url":          true,
+	"battery_cycle_count":     true,
+	"battery_health":          true,
+	"os_version":              true,
+	"build":                   true,
+	"kernel_version":          true,
+	"platform_like":           true,
+	"code_name":               true,
+	"uptime":                  true,
+	"memory":                  true,
+	"cpu_type":                true,
+	"cpu_subtype":             true,
+	"cpu_brand":               true,
+	"cpu_physical_cores":      true,
+	"cpu_logical_cores":       true,
+	"hardware_vendor":         true,
+	"hardware_model":          true,
+	"hardware_version":        true,
+	"hardware_serial":         true,
+	"computer_name":           true,
+	"primary_ip":              true,
+	"primary_mac":             true,
+	"distributed_interval":    true,
+	"config_tls_refresh":      true,
+	"logger_tls_period":       true,
+	"team_id":                 true,
+	"enrolled_from_ip":        true,
+	"assigned_owner":          true,
+	"notes":                   true,
+	"checkin_latency":         true,
+	"timezone":                true,
+	"disk_encryption_enabled": true,
+}
+
+// ValidateFields returns an error if any of the Fields can't be selected.
+func (opt HostListOptions) ValidateFields() error {
+	for _, field := range opt.Fields {
+		if !HostListFields[field] {
+			return NewInvalidArgumentError("fields", fmt.Sprintf("unknown host field %s", field))
+		}
+	}
+	return nil
+}
+
+// ValidateOrderKey returns an error if the hosts can't be ordered by the
+// OrderKey. The hosts can be ordered by any of the HostListFields, or by
+// team_name.
+func (opt HostListOptions) ValidateOrderKey() error {
+	if opt.OrderKey == "" || opt.OrderKey == "team_name" || HostListFields[opt.OrderKey] {
+		return nil
+	}
+	return NewInvalidArgumentError("order_key", fmt.Sprintf("unknown host order key %s", opt.OrderKey))
 }
 
 type HostUser struct {
@@ -122,6 +507,7 @@ type HostUser struct {
 type Host struct {
 	UpdateCreateTimestamps
 	HostSoftware
+	HostDisks
 	ID uint `json:"id"`
 	// OsqueryHostID is the key used in the request context that is
 	// used to retrieve host information.  It is sent from osquery and may currently be
@@ -137,8 +523,10 @@ type Host struct {
 	UUID             string        `json:"uuid" db:"uuid"`         // there is a fulltext index on this field
 	Platform         string        `json:"platform"`
 	OsqueryVersion   string        `json:"osquery_version" db:"osquery_version"`
+	OrbitVersion     string        `json:"orbit_version" db:"orbit_version"` // Empty for hosts not running orbit
 	OSVersion        string        `json:"os_version" db:"os_version"`
 	Build            string        `json:"build"`
+	KernelVersion    string        `json:"kernel_version" db:"kernel_version"` // Empty for platforms that don't report it
 	PlatformLike     string        `json:"platform_like" db:"platform_like"`
 	CodeName         string        `json:"code_name" db:"code_name"`
 	Uptime           time.Duration `json:"uptime"`
@@ -154,6 +542,9 @@ type Host struct {
 	HardwareVersion  string `json:"hardware_version" db:"hardware_version"`
 	HardwareSerial   string `json:"hardware_serial" db:"hardware_serial"`
 	ComputerName     string `json:"computer_name" db:"computer_name"`
+	// Empty for hosts that aren't running in a known cloud
+	CloudProvider   string `json:"cloud_provider" db:"cloud_provider"`
+	CloudInstanceID string `json:"cloud_instance_id" db:"cloud_instance_id"`
 	// PrimaryNetworkInterfaceID if present indicates to primary network for the host, the details of which
 	// can be found in the NetworkInterfaces element with the same ip_address.
 	PrimaryNetworkInterfaceID *uint               `json:"primary_ip_id,omitempty" db:"primary_ip_id"`
@@ -164,11 +555,68 @@ type Host struct {
 	ConfigTLSRefresh          uint                `json:"config_tls_refresh" db:"config_tls_refresh"`
 	LoggerTLSPeriod           uint                `json:"logger_tls_period" db:"logger_tls_period"`
 	TeamID                    *uint               `json:"team_id" db:"team_id"`
+	// EnrolledFromIP is the source IP of the latest enrollment request.
+	EnrolledFromIP string `json:"enrolled_from_ip" db:"enrolled_from_ip"`
+	// RefetchRequestedAt is when the pending refetch was requested, it is
+	// maintained by the datastore when saving RefetchRequested.
+	RefetchRequestedAt *time.Time `json:"-" db:"refetch_requested_at"`
+	// RefetchDetailUpdatedAt is the DetailUpdatedAt of the host when the
+	// latest refetch was requested, kept after the refetch completes. It is
+	// maintained by the datastore when saving RefetchRequested.
+	RefetchDetailUpdatedAt *time.Time `json:"-" db:"refetch_detail_updated_at"`
+	// AssignedOwner is the email of the person responsible for the host, as
+	// assigned by an admin. It is independent of the users logged in to the
+	// host.
+	AssignedOwner string `json:"assigned_owner" db:"assigned_owner"`
+	// Notes is free form text about the host, such as imported from a CMDB.
+	Notes string `json:"notes,omitempty" db:"notes"`
+	// CheckinLatency is a rolling average of how late the host checks in
+	// compared to its expected check-in interval. Early check-ins count as
+	// zero latency.
+	CheckinLatency time.Duration `json:"checkin_latency" db:"checkin_latency"`
+	// Timezone is the host's local timezone abbreviation (eg. "PST") as
+	// reported by osquery, empty until reported.
+	Timezone string `json:"timezone" db:"timezone"`
+	// DiskEncryptionEnabled is whether the system disk is encrypted
+	// (FileVault, LUKS or BitLocker), nil until reported or if the status is
+	// unknown.
+	DiskEncryptionEnabled *bool `json:"disk_encryption_enabled" db:"disk_encryption_enabled"`
+	// MDMEnrolled is whether the host is enrolled in an MDM server, nil until
+	// reported or if the status is unknown.
+	MDMEnrolled *bool `json:"mdm_enrolled" db:"mdm_enrolled"`
+	// MDMServerURL is the URL of the MDM server the host is enrolled in,
+	// empty if the host isn't enrolled.
+	MDMServerURL string `json:"mdm_server_url" db:"mdm_server_url"`
+	// BatteryCycleCount is the number of charge cycles of the battery of the
+	// host, nil until reported or for hosts without a battery.
+	BatteryCycleCount *int `json:"battery_cycle_count" db:"battery_cycle_count"`
+	// BatteryHealth is the health of the battery of the host reported by the
+	// operating system, one of the BatteryHealth constants on macOS. It is
+	// nil until reported or for hosts without a battery.
+	BatteryHealth *string `json:"battery_health" db:"battery_health"`
+	// DecommissionedAt is when the host was decommissioned, nil for active
+	// hosts.
+	DecommissionedAt *time.Time `json:"decommissioned_at,omitempty" db:"decommissioned_at"`
+	// RetireAt is when the host is scheduled to be decommissioned, nil if it
+	// isn't scheduled.
+	RetireAt *time.Time `json:"retire_at,omitempty" db:"retire_at"`
+	// HardwareFingerprint identifies the host's hardware for
+	// EnrollHostByHardware, it is empty for hosts enrolled otherwise.
+	HardwareFingerprint string `json:"-" db:"hardware_fingerprint"`
 
 	// Loaded via JOIN in DB
 	PackStats []PackStats `json:"pack_stats"`
 	// TeamName is the name of the team, loaded by JOIN to the teams table.
 	TeamName *string `json:"team_name" db:"team_name"`
+	// TeamNewHostHours is the team's NewHostHours override, loaded by JOIN to
+	// the teams table.
+	TeamNewHostHours *uint `json:"-" db:"team_new_host_hours"`
+	// PolicyPassCount is the count of policies the host passed when it last
+	// checked them, loaded by JOIN to the policy results.
+	PolicyPassCount uint `json:"policy_pass_count" db:"policy_pass_count"`
+	// PolicyFailCount is the count of policies the host failed when it last
+	// checked them.
+	PolicyFailCount uint `json:"policy_fail_count" db:"policy_fail_count"`
 	// Additional is the additional information from the host
 	// additional_queries. This should be stored in a separate DB table.
 	Additional *json.RawMessage `json:"additional,omitempty" db:"additional"`
@@ -176,6 +624,11 @@ type Host struct {
 	// Users currently in the host
 	Users []HostUser `json:"users,omitempty"`
 
+	// DetailQueriesSucceeded are the names of the detail queries that
+	// succeeded in the latest detail update. Their last success is recorded
+	// at DetailUpdatedAt when the host is saved, see HostDetailQueryStatus.
+	DetailQueriesSucceeded []string `json:"-" db:"-"`
+
 	Modified bool `json:"-"`
 }
 
@@ -183,6 +636,267 @@ func (h Host) AuthzType() string {
 	return "host"
 }
 
+const (
+	// BatteryHealthGood is the health of a well-performing battery.
+	BatteryHealthGood = "Good"
+	// BatteryHealthFair is the health of a functional battery with limited
+	// capacity.
+	BatteryHealthFair = "Fair"
+	// BatteryHealthPoor is the health of a battery that can't provide power.
+	BatteryHealthPoor = "Poor"
+
+	// BatteryCycleCountLimit is the number of charge cycles after which a
+	// battery should be replaced, the rated cycle count of most laptop
+	// batteries.
+	BatteryCycleCountLimit = 1000
+)
+
+// PoorBatteryHealth returns whether the host has a battery that should be
+// replaced, because its health isn't good or it reached
+// BatteryCycleCountLimit. Hosts without a battery never have a poor battery
+// health.
+func (h *Host) PoorBatteryHealth() bool {
+	if h.BatteryHealth != nil && *h.BatteryHealth != BatteryHealthGood {
+		return true
+	}
+	return h.BatteryCycleCount != nil && *h.BatteryCycleCount >= BatteryCycleCountLimit
+}
+
+// Actions pending on a host, returned by Host.PendingActions.
+const (
+	// HostActionRefetch is pending when the host was asked to refetch its
+	// details, see Host.RefetchRequested.
+	HostActionRefetch = "refetch"
+	// HostActionRetire is pending when the host is scheduled to be
+	// decommissioned, see Host.RetireAt.
+	HostActionRetire = "retire"
+)
+
+// PendingActions returns the actions pending on the host, in the order of
+// the HostAction constants. Decommissioned hosts have no pending actions, as
+// they can't check in anymore.
+func (h *Host) PendingActions() []string {
+	if h.DecommissionedAt != nil {
+		return nil
+	}
+	var actions []string
+	if h.RefetchRequested {
+		actions = append(actions, HostActionRefetch)
+	}
+	if h.RetireAt != nil {
+		actions = append(actions, HostActionRetire)
+	}
+	return actions
+}
+
+// EnrollHostOptions are the optional settings of EnrollHost. The zero value
+// enrolls the host with the default handling of existing hosts.
+type EnrollHostOptions struct {
+	// Strategy determines what happens when a host with the same identifier
+	// already exists, see EnrollStrategy. The datastore may be configured to
+	// collapse repeated enrollments of a host within a short window onto the
+	// same host, regardless of the strategy.
+	Strategy EnrollStrategy
+	// TeamChange determines whether an existing host enrolling with a
+	// different team is moved to that team, see EnrollTeamChange. The team
+	// change is recorded either way, see ListHostTeamChanges.
+	TeamChange EnrollTeamChange
+	// InitialLabelIDs are labels the host is added to on enrollment. They
+	// must all be manual labels so that the membership is not overwritten by
+	// label query results, otherwise the enrollment fails.
+	InitialLabelIDs []uint
+	// EnrolledFromIP is the source IP of the enrollment request. It is
+	// updated on every enrollment so that it reflects the latest one.
+	EnrolledFromIP string
+	// UUID and HardwareSerial are the system_info values reported in the
+	// enrollment request, if any. They are saved with the host, and are the
+	// values matched when collapsing repeated enrollments.
+	UUID           string
+	HardwareSerial string
+}
+
+// EnrollStrategy determines how EnrollHost handles a host enrolling with the
+// identifier of an existing host.
+type EnrollStrategy string
+
+const (
+	// EnrollStrategyReuse keeps the existing host and its details, only
+	// updating the node key, team and enrollment information. This is the
+	// default, used for hosts re-enrolling after an agent restart.
+	EnrollStrategyReuse EnrollStrategy = "reuse"
+	// EnrollStrategyReset deletes the existing host and its details, and
+	// enrolls the host as a new host with a new ID. This is used for hosts
+	// that are re-imaged with the same identifier.
+	EnrollStrategyReset EnrollStrategy = "reset"
+)
+
+// Valid returns whether the strategy is a known strategy. The empty strategy
+// is valid, and is the same as EnrollStrategyReuse.
+func (s EnrollStrategy) Valid() bool {
+	switch s {
+	case "", EnrollStrategyReuse, EnrollStrategyReset:
+		return true
+	}
+	return false
+}
+
+// EnrollTeamChange determines how EnrollHost handles an existing host
+// enrolling with a different team than its current team, such as when
+// enrolling with the enroll secret of another team.
+type EnrollTeamChange string
+
+const (
+	// EnrollTeamChangeApply moves the host to the team of the enrollment.
+	// This is the default.
+	EnrollTeamChangeApply EnrollTeamChange = "apply"
+	// EnrollTeamChangeConfirm keeps the host on its current team, so that
+	// the change is applied by transferring the host once confirmed.
+	EnrollTeamChangeConfirm EnrollTeamChange = "confirm"
+)
+
+// Valid returns whether the team change handling is known. The empty value
+// is valid, and is the same as EnrollTeamChangeApply.
+func (c EnrollTeamChange) Valid() bool {
+	switch c {
+	case "", EnrollTeamChangeApply, EnrollTeamChangeConfirm:
+		return true
+	}
+	return false
+}
+
+// HostTeamChange records an existing host enrolling with a different team.
+// Applied is false if the host was kept on its team pending confirmation.
+type HostTeamChange struct {
+	ID         uint      `json:"id" db:"id"`
+	HostID     uint      `json:"host_id" db:"host_id"`
+	FromTeamID *uint     `json:"from_team_id" db:"from_team_id"`
+	ToTeamID   *uint     `json:"to_team_id" db:"to_team_id"`
+	Applied    bool      `json:"applied" db:"applied"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// EnrollTeamRule assigns a host enrolling without a team to the team named
+// Team when each of the attributes in Match equals the attribute reported by
+// the host at enrollment, ignoring case. See EnrollAttributes for the known
+// attributes.
+type EnrollTeamRule struct {
+	Match map[string]string
+	Team  string
+}
+
+// enrollAttributes are the host attributes known at enrollment that can be
+// matched by enroll team rules, by the detail and column they are read from.
+var enrollAttributes = map[string]struct{ detail, column string }{
+	"platform":        {"os_version", "platform"},
+	"platform_like":   {"os_version", "platform_like"},
+	"os_name":         {"os_version", "name"},
+	"hostname":        {"system_info", "hostname"},
+	"hardware_vendor": {"system_info", "hardware_vendor"},
+	"hardware_model":  {"system_info", "hardware_model"},
+}
+
+// EnrollAttributes returns the attributes matched by enroll team rules from
+// the host details sent by osquery when enrolling. The platform_family
+// attribute is the PlatformFamily of the platform.
+func EnrollAttributes(hostDetails map[string](map[string]string)) map[string]string {
+	attrs := make(map[string]string)
+	for name, src := range enrollAttributes {
+		if v := hostDetails[src.detail][src.column]; v != "" {
+			attrs[name] = v
+		}
+	}
+	attrs["platform_family"] = PlatformFamily(attrs["platform"])
+	return attrs
+}
+
+// ParseEnrollTeamRules parses enroll team rules separated by ";". Each rule
+// is a comma separated list of attribute=value conditions, followed by ":"
+// and the name of the team, i.e.
+// "platform=darwin:Workstations;platform_family=linux:Servers".
+func ParseEnrollTeamRules(s string) ([]EnrollTeamRule, error) {
+	var rules []EnrollTeamRule
+	for _, r := range strings.Split(s, ";") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		i := strings.Index(r, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("enroll team rule %q is missing a team", r)
+		}
+		rule := EnrollTeamRule{Match: make(map[string]string), Team: strings.TrimSpace(r[i+1:])}
+		if rule.Team == "" {
+			return nil, fmt.Errorf("enroll team rule %q is missing a team", r)
+		}
+		for _, cond := range strings.Split(r[:i], ",") {
+			parts := strings.SplitN(cond, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid condition %q in enroll team rule %q", cond, r)
+			}
+			name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			if _, ok := enrollAttributes[name]; !ok && name != "platform_family" {
+				return nil, fmt.Errorf("unknown attribute %q in enroll team rule %q", name, r)
+			}
+			rule.Match[name] = value
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// MatchEnrollTeamRules returns the team of the first rule matching the
+// attributes, or false if no rule matches.
+func MatchEnrollTeamRules(rules []EnrollTeamRule, attrs map[string]string) (string, bool) {
+rules:
+	for _, rule := range rules {
+		for name, value := range rule.Match {
+			if !strings.EqualFold(attrs[name], value) {
+				continue rules
+			}
+		}
+		return rule.Team, true
+	}
+	return "", false
+}
+
+// SameTeam returns whether the team IDs are the same team, nil being no team.
+func SameTeam(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// HostDeletion records the deletion of a host. The host identifiers are a
+// snapshot taken before the host was deleted.
+type HostDeletion struct {
+	ID             uint      `json:"id" db:"id"`
+	HostID         uint      `json:"host_id" db:"host_id"`
+	Hostname       string    `json:"hostname" db:"hostname"`
+	UUID           string    `json:"uuid" db:"uuid"`
+	OsqueryHostID  string    `json:"osquery_host_id" db:"osquery_host_id"`
+	HardwareSerial string    `json:"hardware_serial" db:"hardware_serial"`
+	Reason         string    `json:"reason" db:"reason"`
+	DeletedAt      time.Time `json:"deleted_at" db:"deleted_at"`
+}
+
+// EnrollmentAttempt records an enrollment that was rejected, such as for
+// enrolling too often or with invalid options.
+type EnrollmentAttempt struct {
+	ID             uint      `json:"id" db:"id"`
+	OsqueryHostID  string    `json:"osquery_host_id" db:"osquery_host_id"`
+	Reason         string    `json:"reason" db:"reason"`
+	EnrolledFromIP string    `json:"enrolled_from_ip" db:"enrolled_from_ip"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// HostDetailOptions selects the optional host details that are loaded.
+type HostDetailOptions struct {
+	// IncludeUsers loads the users currently on the host. Hosts may have
+	// hundreds of users, use ListHostUsers to page through them instead.
+	IncludeUsers bool
+}
+
 // HostDetail provides the full host metadata along with associated labels and
 // packs.
 type HostDetail struct {
@@ -201,10 +915,200 @@ const (
 // set of hosts in the database. This structure is returned by the HostService
 // method GetHostSummary
 type HostSummary struct {
-	OnlineCount  uint `json:"online_count"`
-	OfflineCount uint `json:"offline_count"`
-	MIACount     uint `json:"mia_count"`
-	NewCount     uint `json:"new_count"`
+	OnlineCount  uint `json:"online_count" db:"online"`
+	OfflineCount uint `json:"offline_count" db:"offline"`
+	MIACount     uint `json:"mia_count" db:"mia"`
+	// NewCount is the count of hosts enrolled in the last day. New hosts are
+	// also counted in their status, so NewCount overlaps the other counts
+	// and must not be added to them.
+	NewCount uint `json:"new_count" db:"new"`
+	// TotalCount is the count of hosts.
+	TotalCount uint `json:"total_count" db:"total"`
+	// PlatformCounts is the count of hosts by platform family, see
+	// PlatformFamily.
+	PlatformCounts map[string]uint `json:"platform_counts"`
+}
+
+// NamedTeamFilter is a TeamFilter identified by a name, see
+// MultiHostStatusStatistics.
+type NamedTeamFilter struct {
+	Name   string
+	Filter TeamFilter
+	// Platform, if set, restricts the hosts to the platform family, see
+	// PlatformFamily.
+	Platform string
+	// LabelID, if set, restricts the hosts to the members of the label.
+	LabelID uint
+}
+
+// HostSummaryDelta is the difference between two HostSummary, each count is
+// the later count minus the earlier one.
+type HostSummaryDelta struct {
+	OnlineCount    int            `json:"online_count"`
+	OfflineCount   int            `json:"offline_count"`
+	MIACount       int            `json:"mia_count"`
+	NewCount       int            `json:"new_count"`
+	TotalCount     int            `json:"total_count"`
+	PlatformCounts map[string]int `json:"platform_counts"`
+}
+
+// PlatformCountPoint is the number of hosts of a platform family at a point
+// in time.
+type PlatformCountPoint struct {
+	T     time.Time `json:"t"`
+	Count uint      `json:"count"`
+}
+
+// HostResourceSample is the memory and disk space of a host at a point in
+// time.
+type HostResourceSample struct {
+	HostID    uint      `json:"host_id" db:"host_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// Memory is the physical memory in bytes.
+	Memory int64 `json:"memory" db:"memory"`
+	// DiskSpace is the total size of the host disks in bytes.
+	DiskSpace int64 `json:"disk_space" db:"disk_space"`
+}
+
+// Sub returns the delta from the earlier summary to s.
+func (s HostSummary) Sub(earlier HostSummary) HostSummaryDelta {
+	delta := HostSummaryDelta{
+		OnlineCount:    int(s.OnlineCount) - int(earlier.OnlineCount),
+		OfflineCount:   int(s.OfflineCount) - int(earlier.OfflineCount),
+		MIACount:       int(s.MIACount) - int(earlier.MIACount),
+		NewCount:       int(s.NewCount) - int(earlier.NewCount),
+		TotalCount:     int(s.TotalCount) - int(earlier.TotalCount),
+		PlatformCounts: map[string]int{},
+	}
+	for platform, count := range s.PlatformCounts {
+		delta.PlatformCounts[platform] += int(count)
+	}
+	for platform, count := range earlier.PlatformCounts {
+		delta.PlatformCounts[platform] -= int(count)
+	}
+	return delta
+}
+
+// Platform families returned by PlatformFamily.
+const (
+	PlatformFamilyDarwin  = "darwin"
+	PlatformFamilyWindows = "windows"
+	PlatformFamilyLinux   = "linux"
+	PlatformFamilyOther   = "other"
+)
+
+var linuxPlatforms = map[string]bool{
+	"linux":    true,
+	"ubuntu":   true,
+	"debian":   true,
+	"centos":   true,
+	"rhel":     true,
+	"fedora":   true,
+	"amzn":     true,
+	"arch":     true,
+	"gentoo":   true,
+	"opensuse": true,
+	"suse":     true,
+	"sles":     true,
+	"ol":       true,
+}
+
+// PlatformFamily returns the family of the platform reported by osquery:
+// darwin, windows, linux for the Linux distributions, or other for unknown
+// platforms and hosts that haven't reported their platform yet.
+func PlatformFamily(platform string) string {
+	platform = strings.ToLower(platform)
+	switch {
+	case platform == "darwin":
+		return PlatformFamilyDarwin
+	case platform == "windows":
+		return PlatformFamilyWindows
+	case linuxPlatforms[platform]:
+		return PlatformFamilyLinux
+	default:
+		return PlatformFamilyOther
+	}
+}
+
+// HostSearchResult is a host found by a search, along with the fields that
+// matched the query.
+type HostSearchResult struct {
+	*Host
+	MatchedFields []string `json:"matched_fields"`
+}
+
+// hostSearchFields are the host fields matched by searches, by name.
+var hostSearchFields = []struct {
+	name  string
+	value func(h *Host) string
+}{
+	{"hostname", func(h *Host) string { return h.Hostname }},
+	{"uuid", func(h *Host) string { return h.UUID }},
+	{"primary_ip", func(h *Host) string { return h.PrimaryIP }},
+	{"primary_mac", func(h *Host) string { return h.PrimaryMac }},
+	{"cloud_instance_id", func(h *Host) string { return h.CloudInstanceID }},
+}
+
+// SearchMatchedFields returns the names of the searched fields (hostname,
+// uuid, primary_ip, primary_mac and cloud_instance_id) containing the query
+// or one of its terms, ignoring case. Terms are separated by spaces and the
+// characters that the fulltext search treats specially ("+" and "-").
+func (h *Host) SearchMatchedFields(query string) []string {
+	matched := []string{}
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return matched
+	}
+	terms := strings.FieldsFunc(query, func(r rune) bool {
+		return r == ' ' || r == '+' || r == '-'
+	})
+	for _, field := range hostSearchFields {
+		value := strings.ToLower(field.value(h))
+		if value == "" {
+			continue
+		}
+		match := strings.Contains(value, query)
+		for _, term := range terms {
+			match = match || strings.Contains(value, term)
+		}
+		if match {
+			matched = append(matched, field.name)
+		}
+	}
+	return matched
+}
+
+// SubnetUnknown is the subnet of hosts without a valid IPv4 primary IP.
+const SubnetUnknown = "unknown"
+
+// HostSubnet returns the subnet of the primary IP in CIDR notation (eg.
+// "10.0.1.0/24"), with maskBits between 0 and 32. Only IPv4 is supported,
+// empty, invalid and IPv6 addresses are in SubnetUnknown.
+func HostSubnet(primaryIP string, maskBits int) string {
+	ip := net.ParseIP(primaryIP).To4()
+	if ip == nil || maskBits < 0 || maskBits > 32 {
+		return SubnetUnknown
+	}
+	subnet := net.IPNet{IP: ip.Mask(net.CIDRMask(maskBits, 32)), Mask: net.CIDRMask(maskBits, 32)}
+	return subnet.String()
+}
+
+// CumulativeHostSummary holds host status counts nested by severity, as
+// opposed to the mutually exclusive buckets of HostSummary. Each count
+// includes the hosts of all the less severe statuses.
+type CumulativeHostSummary struct {
+	OnlineCount             uint `json:"online_count"`
+	OnlineOrOfflineCount    uint `json:"online_or_offline_count"`
+	OnlineOfflineOrMIACount uint `json:"online_offline_or_mia_count"`
+}
+
+// Cumulative returns the status counts of the summary nested by severity.
+func (s HostSummary) Cumulative() CumulativeHostSummary {
+	return CumulativeHostSummary{
+		OnlineCount:             s.OnlineCount,
+		OnlineOrOfflineCount:    s.OnlineCount + s.OfflineCount,
+		OnlineOfflineOrMIACount: s.OnlineCount + s.OfflineCount + s.MIACount,
+	}
 }
 
 // Status calculates the online status of the host
@@ -230,8 +1134,64 @@ func (h *Host) Status(now time.Time) HostStatus {
 	}
 }
 
+// HostFieldChange records a change of a host field, by column name. The values
+// are formatted as strings, with an empty string for null values.
+type HostFieldChange struct {
+	ID        uint      `json:"id" db:"id"`
+	HostID    uint      `json:"host_id" db:"host_id"`
+	Field     string    `json:"field" db:"field"`
+	OldValue  string    `json:"old_value" db:"old_value"`
+	NewValue  string    `json:"new_value" db:"new_value"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// HostTeamAssignment counts the hosts of AddHostsToTeam. Host IDs that don't
+// exist are not counted.
+type HostTeamAssignment struct {
+	// Changed is the number of hosts moved to the team.
+	Changed uint `json:"changed"`
+	// AlreadyAssigned is the number of hosts that were already in the team.
+	AlreadyAssigned uint `json:"already_assigned"`
+}
+
+// HostAnnotation is the annotation applied to a host by
+// ApplyHostAnnotations. Nil fields are left unchanged.
+type HostAnnotation struct {
+	// Owner is the assigned owner email, see Host.AssignedOwner.
+	Owner *string `json:"owner"`
+	Notes *string `json:"notes"`
+}
+
+// HostNeverSeen is returned by DaysSinceLastSeen for hosts that were never
+// seen.
+const HostNeverSeen = -1
+
+// DaysSinceLastSeen returns the number of days, including fractions of days,
+// from SeenTime to now. HostNeverSeen is returned if SeenTime is the zero
+// time.
+func (h *Host) DaysSinceLastSeen(now time.Time) float64 {
+	if h.SeenTime.IsZero() {
+		return HostNeverSeen
+	}
+	return now.Sub(h.SeenTime).Hours() / 24
+}
+
+// ComputeStatuses calculates the online status of each of the hosts, keyed by
+// host ID. The results are identical to calling Status on each host.
+func ComputeStatuses(hosts []*Host, now time.Time) map[uint]HostStatus {
+	statuses := make(map[uint]HostStatus, len(hosts))
+	for _, h := range hosts {
+		statuses[h.ID] = h.Status(now)
+	}
+	return statuses
+}
+
 func (h *Host) IsNew(now time.Time) bool {
-	withDuration := h.CreatedAt.Add(NewDuration)
+	newDuration := NewDuration
+	if h.TeamNewHostHours != nil {
+		newDuration = time.Duration(*h.TeamNewHostHours) * time.Hour
+	}
+	withDuration := h.CreatedAt.Add(newDuration)
 	if withDuration.After(now) ||
 		withDuration.Equal(now) {
 		return true
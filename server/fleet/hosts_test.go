@@ -5,7 +5,9 @@ import (
 	"time"
 
 	"github.com/WatchBeam/clock"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHostStatus(t *testing.T) {
@@ -50,6 +52,33 @@ func TestHostStatus(t *testing.T) {
 
 }
 
+func TestComputeStatuses(t *testing.T) {
+	mockClock := clock.NewMockClock()
+	now := mockClock.Now()
+
+	hosts := []*Host{
+		{ID: 1, SeenTime: now.Add(-1 * time.Second), DistributedInterval: 10, ConfigTLSRefresh: 10},
+		{ID: 2, SeenTime: now.Add(-1 * time.Minute), DistributedInterval: 10, ConfigTLSRefresh: 10},
+		{ID: 3, SeenTime: now.Add(-31 * 24 * time.Hour), DistributedInterval: 10, ConfigTLSRefresh: 10},
+		{ID: 4, SeenTime: now.Add(-70 * time.Second), DistributedInterval: 60, ConfigTLSRefresh: 60},
+		{ID: 5, SeenTime: now.Add(-1 * time.Minute)},
+	}
+
+	statuses := ComputeStatuses(hosts, now)
+	assert.Equal(t, map[uint]HostStatus{
+		1: StatusOnline,
+		2: StatusOffline,
+		3: StatusMIA,
+		4: StatusOnline,
+		5: StatusOffline,
+	}, statuses)
+	for _, h := range hosts {
+		assert.Equal(t, h.Status(now), statuses[h.ID])
+	}
+
+	assert.Empty(t, ComputeStatuses(nil, now))
+}
+
 func TestHostIsNew(t *testing.T) {
 	mockClock := clock.NewMockClock()
 
@@ -60,4 +89,201 @@ func TestHostIsNew(t *testing.T) {
 
 	host.CreatedAt = mockClock.Now().AddDate(0, 0, -2)
 	assert.False(t, host.IsNew(mockClock.Now()))
+
+	// The team's override replaces NewDuration
+	hours := uint(72)
+	host.TeamNewHostHours = &hours
+	assert.True(t, host.IsNew(mockClock.Now()))
+
+	hours = 1
+	host.CreatedAt = mockClock.Now().Add(-2 * time.Hour)
+	assert.False(t, host.IsNew(mockClock.Now()))
+}
+
+func TestHostDaysSinceLastSeen(t *testing.T) {
+	mockClock := clock.NewMockClock()
+	now := mockClock.Now()
+
+	host := Host{}
+	assert.Equal(t, float64(HostNeverSeen), host.DaysSinceLastSeen(now))
+
+	host.SeenTime = now
+	assert.Equal(t, float64(0), host.DaysSinceLastSeen(now))
+
+	host.SeenTime = now.Add(-36 * time.Hour)
+	assert.Equal(t, 1.5, host.DaysSinceLastSeen(now))
+}
+
+func TestHostSummarySub(t *testing.T) {
+	earlier := HostSummary{
+		OnlineCount:    5,
+		OfflineCount:   1,
+		TotalCount:     6,
+		PlatformCounts: map[string]uint{"darwin": 4, "linux": 2},
+	}
+	later := HostSummary{
+		OnlineCount:    3,
+		OfflineCount:   4,
+		TotalCount:     7,
+		PlatformCounts: map[string]uint{"darwin": 5, "windows": 2},
+	}
+
+	assert.Equal(t, HostSummaryDelta{
+		OnlineCount:    -2,
+		OfflineCount:   3,
+		TotalCount:     1,
+		PlatformCounts: map[string]int{"darwin": 1, "linux": -2, "windows": 2},
+	}, later.Sub(earlier))
+	assert.Equal(t, HostSummaryDelta{PlatformCounts: map[string]int{}}, HostSummary{}.Sub(HostSummary{}))
+}
+
+func TestHostSummaryCumulative(t *testing.T) {
+	summary := HostSummary{OnlineCount: 3, OfflineCount: 5, MIACount: 7, NewCount: 2}
+	assert.Equal(t, CumulativeHostSummary{
+		OnlineCount:             3,
+		OnlineOrOfflineCount:    8,
+		OnlineOfflineOrMIACount: 15,
+	}, summary.Cumulative())
+
+	assert.Equal(t, CumulativeHostSummary{}, HostSummary{NewCount: 1}.Cumulative())
+}
+
+func TestPlatformFamily(t *testing.T) {
+	for platform, family := range map[string]string{
+		"darwin":  PlatformFamilyDarwin,
+		"windows": PlatformFamilyWindows,
+		"Windows": PlatformFamilyWindows,
+		"ubuntu":  PlatformFamilyLinux,
+		"centos":  PlatformFamilyLinux,
+		"rhel":    PlatformFamilyLinux,
+		"linux":   PlatformFamilyLinux,
+		"freebsd": PlatformFamilyOther,
+		"":        PlatformFamilyOther,
+	} {
+		assert.Equal(t, family, PlatformFamily(platform), platform)
+	}
+}
+
+func TestHostPoorBatteryHealth(t *testing.T) {
+	for _, tc := range []struct {
+		cycles *int
+		health *string
+		poor   bool
+	}{
+		{nil, nil, false},
+		{ptr.Int(200), ptr.String(BatteryHealthGood), false},
+		{ptr.Int(200), ptr.String(BatteryHealthFair), true},
+		{nil, ptr.String(BatteryHealthPoor), true},
+		{ptr.Int(BatteryCycleCountLimit), ptr.String(BatteryHealthGood), true},
+		{ptr.Int(BatteryCycleCountLimit - 1), nil, false},
+	} {
+		h := &Host{BatteryCycleCount: tc.cycles, BatteryHealth: tc.health}
+		assert.Equal(t, tc.poor, h.PoorBatteryHealth())
+	}
+}
+
+func TestHostPendingActions(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		host    Host
+		actions []string
+	}{
+		{Host{}, nil},
+		{Host{RefetchRequested: true}, []string{HostActionRefetch}},
+		{Host{RetireAt: &now}, []string{HostActionRetire}},
+		{Host{RefetchRequested: true, RetireAt: &now}, []string{HostActionRefetch, HostActionRetire}},
+		{Host{RefetchRequested: true, RetireAt: &now, DecommissionedAt: &now}, nil},
+	} {
+		assert.Equal(t, tc.actions, tc.host.PendingActions())
+	}
+}
+
+func TestHostSubnet(t *testing.T) {
+	for _, tc := range []struct {
+		ip       string
+		maskBits int
+		subnet   string
+	}{
+		{"10.0.1.17", 24, "10.0.1.0/24"},
+		{"10.0.1.17", 16, "10.0.0.0/16"},
+		{"10.0.1.17", 32, "10.0.1.17/32"},
+		{"10.0.1.17", 0, "0.0.0.0/0"},
+		{"192.168.200.5", 20, "192.168.192.0/20"},
+		{"", 24, SubnetUnknown},
+		{"not an ip", 24, SubnetUnknown},
+		{"fe80::1", 24, SubnetUnknown},
+		{"10.0.1.17", 33, SubnetUnknown},
+	} {
+		assert.Equal(t, tc.subnet, HostSubnet(tc.ip, tc.maskBits), tc.ip)
+	}
+}
+
+func TestHostSearchMatchedFields(t *testing.T) {
+	host := &Host{
+		Hostname:        "Foo-Bar.local",
+		UUID:            "abc-def",
+		PrimaryIP:       "192.168.1.10",
+		PrimaryMac:      "aa:bb:cc:dd:ee:ff",
+		CloudInstanceID: "i-0987654321fedcba0",
+	}
+	assert.Equal(t, []string{"hostname"}, host.SearchMatchedFields("foo"))
+	assert.Equal(t, []string{"hostname"}, host.SearchMatchedFields("BAR"))
+	assert.Equal(t, []string{"uuid"}, host.SearchMatchedFields("abc-def"))
+	assert.Equal(t, []string{"primary_ip"}, host.SearchMatchedFields("192.168.1"))
+	assert.Equal(t, []string{"primary_mac"}, host.SearchMatchedFields("dd:ee"))
+	assert.Equal(t, []string{"cloud_instance_id"}, host.SearchMatchedFields("i-0987654321fedcba0"))
+	// Each term is matched separately
+	assert.Equal(t, []string{"hostname", "uuid"}, host.SearchMatchedFields("foo abc"))
+	assert.Empty(t, host.SearchMatchedFields("zzz"))
+	assert.Empty(t, host.SearchMatchedFields(""))
+}
+
+func TestParseEnrollTeamRules(t *testing.T) {
+	rules, err := ParseEnrollTeamRules("")
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	rules, err = ParseEnrollTeamRules("platform=darwin:Workstations; platform_family=linux, hardware_vendor=VMware:Virtual Servers;")
+	require.NoError(t, err)
+	assert.Equal(t, []EnrollTeamRule{
+		{Match: map[string]string{"platform": "darwin"}, Team: "Workstations"},
+		{Match: map[string]string{"platform_family": "linux", "hardware_vendor": "VMware"}, Team: "Virtual Servers"},
+	}, rules)
+
+	for _, s := range []string{
+		"platform=darwin",
+		"platform=darwin:",
+		"platform:Workstations",
+		"serial=123:Workstations",
+	} {
+		_, err := ParseEnrollTeamRules(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestMatchEnrollTeamRules(t *testing.T) {
+	rules, err := ParseEnrollTeamRules("platform=darwin:Workstations;platform_family=linux,hardware_vendor=vmware:Virtual Servers;platform_family=linux:Servers")
+	require.NoError(t, err)
+
+	attrs := func(platform, vendor string) map[string]string {
+		return EnrollAttributes(map[string](map[string]string){
+			"os_version":  {"platform": platform},
+			"system_info": {"hardware_vendor": vendor},
+		})
+	}
+	for _, tc := range []struct {
+		attrs map[string]string
+		team  string
+	}{
+		{attrs("darwin", "Apple Inc."), "Workstations"},
+		{attrs("ubuntu", "VMware, Inc."), "Servers"},
+		{attrs("rhel", "VMware"), "Virtual Servers"},
+		{attrs("centos", "Dell Inc."), "Servers"},
+		{attrs("windows", "Dell Inc."), ""},
+		{EnrollAttributes(nil), ""},
+	} {
+		team, ok := MatchEnrollTeamRules(rules, tc.attrs)
+		assert.Equal(t, tc.team != "", ok, tc.attrs)
+		assert.Equal(t, tc.team, team, tc.attrs)
+	}
 }
@@ -2,6 +2,7 @@ package fleet
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/pkg/errors"
@@ -52,6 +53,22 @@ type LabelStore interface {
 
 	// LabelIDsByName Retrieve the IDs associated with the given labels
 	LabelIDsByName(labels []string) ([]uint, error)
+
+	// AddHostsToLabel adds the hosts to the manual label. Hosts already in the
+	// label and IDs of missing hosts are ignored. A *LabelNotManualError is
+	// returned if the label is dynamic.
+	AddHostsToLabel(labelID uint, hostIDs []uint) error
+	// RemoveHostsFromLabel removes the hosts from the manual label. A
+	// *LabelNotManualError is returned if the label is dynamic.
+	RemoveHostsFromLabel(labelID uint, hostIDs []uint) error
+
+	// SnapshotLabelCounts records the number of hosts in each label at now,
+	// for LabelCountHistory.
+	SnapshotLabelCounts(now time.Time) error
+	// LabelCountHistory returns the recorded host counts of the label
+	// between from and to (inclusive), oldest first. Periods without a
+	// snapshot have no points.
+	LabelCountHistory(labelID uint, from, to time.Time) ([]LabelCountPoint, error)
 }
 
 type LabelService interface {
@@ -127,6 +144,16 @@ func (t *LabelType) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// LabelNotManualError is returned when editing the membership of a dynamic
+// label, which is determined by the label query instead.
+type LabelNotManualError struct {
+	LabelID uint
+}
+
+func (e *LabelNotManualError) Error() string {
+	return fmt.Sprintf("label %d is not a manual label", e.LabelID)
+}
+
 // LabelMembershipType sets how the membership of the label is determined.
 type LabelMembershipType uint
 
@@ -181,6 +208,12 @@ const (
 	LabelKind = "label"
 )
 
+// LabelCountPoint is the number of hosts in a label at a point in time.
+type LabelCountPoint struct {
+	T     time.Time `json:"t" db:"created_at"`
+	Count uint      `json:"count" db:"count"`
+}
+
 type LabelQueryExecution struct {
 	ID        uint
 	UpdatedAt time.Time
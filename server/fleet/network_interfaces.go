@@ -1,5 +1,7 @@
 package fleet
 
+import "net"
+
 type NetworkInterface struct {
 	UpdateCreateTimestamps
 	ID uint `json:"id"`
@@ -23,4 +25,67 @@ type NetworkInterface struct {
 
 	OErrors    int64 `json:"oerrors"`
 	LastChange int64 `json:"last_change" db:"last_change"`
+
+	// DefaultRoute is set when osquery reports the default route of the host
+	// through the interface, which makes it the explicit primary interface.
+	DefaultRoute bool `json:"-" db:"-"`
+}
+
+// SelectPrimaryNetworkInterface picks the interface that best represents the
+// host on the network, or nil if there are no interfaces. The heuristic is:
+//
+//  1. Skip interfaces without a parseable IP, and loopback or link-local
+//     interfaces.
+//  2. Prefer the interfaces holding the default route, as reported by
+//     osquery, over the others.
+//  3. Prefer IPv4 interfaces over IPv6, whether the IP is public or private.
+//  4. Otherwise keep the reported order. osquery reports interfaces ordered
+//     by traffic.
+//
+// If every interface is skipped, the first one is used so that the host still
+// gets a primary IP.
+func SelectPrimaryNetworkInterface(nics []*NetworkInterface) *NetworkInterface {
+	if len(nics) == 0 {
+		return nil
+	}
+
+	var defaultRoute []*NetworkInterface
+	for _, nic := range nics {
+		if nic.DefaultRoute {
+			defaultRoute = append(defaultRoute, nic)
+		}
+	}
+	if nic := selectNetworkInterface(defaultRoute); nic != nil {
+		return nic
+	}
+	if nic := selectNetworkInterface(nics); nic != nil {
+		return nic
+	}
+	return nics[0]
+}
+
+// selectNetworkInterface returns the first IPv4 interface, or else the first
+// IPv6 interface, skipping loopback and link-local interfaces. It returns nil
+// if every interface is skipped.
+func selectNetworkInterface(nics []*NetworkInterface) *NetworkInterface {
+	var firstIPv4, firstIPv6 *NetworkInterface
+	for _, nic := range nics {
+		ip := net.ParseIP(nic.IPAddress)
+		if ip == nil || ip.IsLinkLocalUnicast() || ip.IsLoopback() {
+			continue
+		}
+
+		if ip.To4() != nil {
+			if firstIPv4 == nil {
+				firstIPv4 = nic
+			}
+		} else if firstIPv6 == nil {
+			firstIPv6 = nic
+		}
+	}
+
+	if firstIPv4 != nil {
+		return firstIPv4
+	}
+	return firstIPv6
 }
@@ -0,0 +1,42 @@
+package fleet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectPrimaryNetworkInterface(t *testing.T) {
+	assert.Nil(t, SelectPrimaryNetworkInterface(nil))
+
+	loopback := &NetworkInterface{IPAddress: "127.0.0.1", MAC: "00:00:00:00:00:00"}
+	linkLocal := &NetworkInterface{IPAddress: "fe80::df:429b:971c:d051", MAC: "f4:5c:89:92:57:5b"}
+	zoned := &NetworkInterface{IPAddress: "fe80::1%lo0", MAC: "00:00:00:00:00:00"}
+	ipv6 := &NetworkInterface{IPAddress: "2604:3f08:1337:9411:cbe:814f:51a6:e4e3", MAC: "27:1b:aa:60:e8:0a"}
+	private := &NetworkInterface{IPAddress: "192.168.1.3", MAC: "f4:5d:79:93:58:5b"}
+	public := &NetworkInterface{IPAddress: "8.8.8.8", MAC: "f4:5d:79:93:58:5c"}
+	defaultRoute := &NetworkInterface{IPAddress: "10.0.0.2", MAC: "f4:5d:79:93:58:5d", DefaultRoute: true}
+	defaultRouteIPv6 := &NetworkInterface{IPAddress: "2604:3f08:1337:9411::1", MAC: "f4:5d:79:93:58:5d", DefaultRoute: true}
+	defaultRouteLoopback := &NetworkInterface{IPAddress: "127.0.0.1", MAC: "00:00:00:00:00:00", DefaultRoute: true}
+
+	testCases := []struct {
+		nics     []*NetworkInterface
+		expected *NetworkInterface
+	}{
+		{[]*NetworkInterface{loopback, linkLocal, zoned, private, ipv6}, private},
+		{[]*NetworkInterface{loopback, ipv6, private}, private},
+		{[]*NetworkInterface{public, private}, public},
+		{[]*NetworkInterface{private, public}, private},
+		{[]*NetworkInterface{loopback, zoned, ipv6}, ipv6},
+		// Falls back to the first interface
+		{[]*NetworkInterface{linkLocal, loopback}, linkLocal},
+		// The interfaces holding the default route win
+		{[]*NetworkInterface{private, defaultRouteIPv6, defaultRoute}, defaultRoute},
+		{[]*NetworkInterface{private, defaultRouteIPv6}, defaultRouteIPv6},
+		// Unless they are all skipped
+		{[]*NetworkInterface{defaultRouteLoopback, ipv6, private}, private},
+	}
+	for _, tt := range testCases {
+		assert.Same(t, tt.expected, SelectPrimaryNetworkInterface(tt.nics))
+	}
+}
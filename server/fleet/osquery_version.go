@@ -0,0 +1,113 @@
+package fleet
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// OsqueryVersionConstraint compares an osquery version against Version using
+// Op, one of "<", "<=", ">", ">=", "=" or "!=".
+//
+// Versions are compared by their major, minor and patch numbers. Version
+// strings that aren't valid semver (eg. "4.8.0.1" or "4.9.0-dev") are compared
+// by their leading numeric components, and missing or non-numeric components
+// are treated as 0.
+type OsqueryVersionConstraint struct {
+	Op    string
+	Major uint
+	Minor uint
+	Patch uint
+}
+
+var osqueryVersionOps = []string{"<=", ">=", "!=", "<", ">", "="}
+
+// ParseOsqueryVersionConstraints parses a comma separated list of version
+// constraints, such as "<5.0.0" or ">=4.6, <5". A version without an operator
+// is an exact match.
+func ParseOsqueryVersionConstraints(s string) ([]OsqueryVersionConstraint, error) {
+	var constraints []OsqueryVersionConstraint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, errors.Errorf("empty constraint in %q", s)
+		}
+
+		c := OsqueryVersionConstraint{Op: "="}
+		for _, op := range osqueryVersionOps {
+			if strings.HasPrefix(part, op) {
+				c.Op = op
+				part = strings.TrimSpace(strings.TrimPrefix(part, op))
+				break
+			}
+		}
+
+		version := strings.Split(strings.TrimPrefix(part, "v"), ".")
+		if len(version) > 3 {
+			return nil, errors.Errorf("invalid version %q", part)
+		}
+		nums := make([]uint, 3)
+		for i, v := range version {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, errors.Errorf("invalid version %q", part)
+			}
+			nums[i] = uint(n)
+		}
+		c.Major, c.Minor, c.Patch = nums[0], nums[1], nums[2]
+
+		constraints = append(constraints, c)
+	}
+	return constraints, nil
+}
+
+// Matches returns whether the version satisfies the constraint, using the same
+// fallback rules as the datastore for version strings that aren't semver.
+func (c OsqueryVersionConstraint) Matches(version string) bool {
+	cmp := compareVersionNumbers(parseVersionNumbers(version), [3]uint{c.Major, c.Minor, c.Patch})
+	switch c.Op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// parseVersionNumbers returns the leading numeric value of the first three
+// dot separated components of version.
+func parseVersionNumbers(version string) [3]uint {
+	var nums [3]uint
+	for i, part := range strings.SplitN(version, ".", 4) {
+		if i == 3 {
+			break
+		}
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		n, _ := strconv.ParseUint(part[:end], 10, 32)
+		nums[i] = uint(n)
+	}
+	return nums
+}
+
+func compareVersionNumbers(a, b [3]uint) int {
+	for i := range a {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+package fleet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOsqueryVersionConstraints(t *testing.T) {
+	constraints, err := ParseOsqueryVersionConstraints("<5.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, []OsqueryVersionConstraint{{Op: "<", Major: 5}}, constraints)
+
+	constraints, err = ParseOsqueryVersionConstraints(">= 4.6, <5")
+	require.NoError(t, err)
+	assert.Equal(t, []OsqueryVersionConstraint{{Op: ">=", Major: 4, Minor: 6}, {Op: "<", Major: 5}}, constraints)
+
+	constraints, err = ParseOsqueryVersionConstraints("v4.8.1")
+	require.NoError(t, err)
+	assert.Equal(t, []OsqueryVersionConstraint{{Op: "=", Major: 4, Minor: 8, Patch: 1}}, constraints)
+
+	for _, bad := range []string{"", "<", "<5.x", "1.2.3.4", ">=4.6,", "~4.6"} {
+		_, err := ParseOsqueryVersionConstraints(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestOsqueryVersionConstraintMatches(t *testing.T) {
+	testCases := []struct {
+		constraint string
+		version    string
+		matches    bool
+	}{
+		{"<5.0.0", "4.9.0", true},
+		{"<5.0.0", "5.0.1", false},
+		{"<5", "4.10.2", true},
+		{">=4.6", "4.6.0", true},
+		{">4.6", "4.6.0", false},
+		{"<=4.6.0", "4.6", true},
+		{"=4.8.0", "4.8.0.1", true},
+		{"!=4.8.0", "4.8.1", true},
+		{"=4.9.0", "4.9.0-dev", true},
+		{"<4.0.0", "garbage", true},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.constraint+" "+tt.version, func(t *testing.T) {
+			constraints, err := ParseOsqueryVersionConstraints(tt.constraint)
+			require.NoError(t, err)
+			require.Len(t, constraints, 1)
+			assert.Equal(t, tt.matches, constraints[0].Matches(tt.version))
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package fleet
+
+import (
+	"context"
+	"time"
+)
+
+type PolicyStore interface {
+	// NewGlobalPolicy creates a policy checked by all hosts with the query.
+	NewGlobalPolicy(queryID uint) (*Policy, error)
+	// Policy returns the policy, with its host counts.
+	Policy(id uint) (*Policy, error)
+	// ListGlobalPolicies returns the policies checked by all hosts, ordered
+	// by ID, with their host counts.
+	ListGlobalPolicies() ([]*Policy, error)
+	// DeleteGlobalPolicies deletes the policies and their results, returning
+	// the IDs of the policies that existed.
+	DeleteGlobalPolicies(ids []uint) ([]uint, error)
+
+	// PolicyQueriesForHost returns the queries of the policies the host
+	// should check, keyed by policy ID. These are the policies the host
+	// hasn't checked since cutoff.
+	PolicyQueriesForHost(host *Host, cutoff time.Time) (map[string]string, error)
+	// RecordPolicyQueryExecutions records whether the host passes the
+	// policies, keyed by policy ID. A nil result records that the query
+	// failed to run, the host then neither passes nor fails the policy.
+	RecordPolicyQueryExecutions(host *Host, results map[uint]*bool, updated time.Time) error
+}
+
+type GlobalPolicyService interface {
+	NewGlobalPolicy(ctx context.Context, queryID uint) (*Policy, error)
+	ListGlobalPolicies(ctx context.Context) ([]*Policy, error)
+	DeleteGlobalPolicies(ctx context.Context, ids []uint) ([]uint, error)
+}
+
+// Policy is a query that hosts pass when it returns results, and fail when it
+// doesn't.
+type Policy struct {
+	ID        uint   `json:"id"`
+	QueryID   uint   `json:"query_id" db:"query_id"`
+	QueryName string `json:"query_name" db:"query_name"`
+	// PassingHostCount is the count of hosts whose last check of the policy
+	// passed.
+	PassingHostCount uint `json:"passing_host_count" db:"passing_host_count"`
+	// FailingHostCount is the count of hosts whose last check of the policy
+	// failed.
+	FailingHostCount uint `json:"failing_host_count" db:"failing_host_count"`
+	UpdateCreateTimestamps
+}
+
+func (p Policy) AuthzType() string {
+	return "policy"
+}
@@ -74,4 +74,8 @@ type ScheduledQueryStats struct {
 	SystemTime   int       `json:"system_time" db:"system_time"`
 	UserTime     int       `json:"user_time" db:"user_time"`
 	WallTime     int       `json:"wall_time" db:"wall_time"`
+
+	// Found is set by HostScheduledQueryStats for the stats reported by the
+	// host, it is false for the zeroed stats of a query the host never ran.
+	Found bool `json:"-" db:"-"`
 }
@@ -21,5 +21,6 @@ type Service interface {
 	ActivitiesService
 	UserRolesService
 	GlobalScheduleService
+	GlobalPolicyService
 	TranslatorService
 }
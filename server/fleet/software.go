@@ -1,8 +1,114 @@
 package fleet
 
+import (
+	"sort"
+	"time"
+)
+
 type SoftwareStore interface {
+	// SaveHostSoftware saves the software of the host if it was modified,
+	// replacing either all the stored software or, with
+	// HostSoftware.PartialSources, the software of the reported sources.
+	// Software identical to the stored software is not written again, only
+	// the time it was collected is updated.
 	SaveHostSoftware(host *Host) error
+	// LoadHostSoftware loads the software installed on the host, including
+	// any matching SoftwareMetadata.
 	LoadHostSoftware(host *Host) error
+	// LoadHostsSoftware loads the software of all the hosts like
+	// LoadHostSoftware, with a fixed number of queries. Hosts without
+	// software get an empty Software slice.
+	LoadHostsSoftware(hosts []*Host) error
+	// UpsertSoftwareMetadata creates or replaces the metadata for software,
+	// keyed by name and version.
+	UpsertSoftwareMetadata(metadata []SoftwareMetadata) error
+	// CountSoftwareVersions returns the number of hosts allowed by the filter
+	// with each version of software installed.
+	CountSoftwareVersions(filter TeamFilter, opt SoftwareCountOptions) ([]SoftwareVersionCount, error)
+	// HostSoftwareCountsBySource returns the number of software items
+	// installed on the host for each source. Sources without software are
+	// not included.
+	HostSoftwareCountsBySource(hostID uint) (map[string]uint, error)
+	// HostHasSoftware returns whether the host has the version of the named
+	// software installed. An empty version matches any version.
+	HostHasSoftware(hostID uint, name string, version string) (bool, error)
+	// CountHostsMissingSoftware returns the number of hosts in the team,
+	// allowed by the filter, that don't have the named software installed at
+	// minVersion or later. Versions are compared with CompareVersions, and an
+	// empty minVersion accepts any version.
+	// A teamID of 0 counts the hosts that aren't in a team.
+	CountHostsMissingSoftware(filter TeamFilter, teamID uint, name string, minVersion string) (uint, error)
+	// HostSoftwareUpgradeCandidates returns the software installed on the
+	// host with a newer version installed on another host, by name and
+	// source, ordered by name. Versions are compared with CompareVersions.
+	HostSoftwareUpgradeCandidates(hostID uint) ([]SoftwareUpgradeCandidate, error)
+	// HostSoftwareDriftFromBaseline compares the software installed on the
+	// host with the baseline, see SoftwareDrift.
+	HostSoftwareDriftFromBaseline(hostID uint, baseline []Software, opt SoftwareDriftOptions) (missing, extra []Software, err error)
+}
+
+type SoftwareCountOptions struct {
+	// NormalizeVersions groups the counts by NormalizeVersion of the
+	// reported versions rather than by the raw versions. A host with several
+	// raw versions of the software that normalize to the same version is
+	// counted once.
+	NormalizeVersions bool
+}
+
+type SoftwareDriftOptions struct {
+	// IgnoreVersions compares the software by name and source only, so
+	// that any installed version matches the baseline.
+	IgnoreVersions bool
+}
+
+// SoftwareDrift compares the installed software with the baseline, by name,
+// version and source. It returns the baseline software that isn't installed
+// and the installed software that isn't in the baseline, without duplicates
+// and ordered by name, source and version.
+func SoftwareDrift(installed, baseline []Software, opt SoftwareDriftOptions) (missing, extra []Software) {
+	key := func(s Software) Software {
+		k := Software{Name: s.Name, Version: s.Version, Source: s.Source}
+		if opt.IgnoreVersions {
+			k.Version = ""
+		}
+		return k
+	}
+	diff := func(from, other []Software) []Software {
+		keys := make(map[Software]bool, len(other))
+		for _, s := range other {
+			keys[key(s)] = true
+		}
+		seen := make(map[Software]bool)
+		var result []Software
+		for _, s := range from {
+			unique := Software{Name: s.Name, Version: s.Version, Source: s.Source}
+			if keys[key(s)] || seen[unique] {
+				continue
+			}
+			seen[unique] = true
+			result = append(result, s)
+		}
+		sort.Slice(result, func(i, j int) bool {
+			if result[i].Name != result[j].Name {
+				return result[i].Name < result[j].Name
+			}
+			if result[i].Source != result[j].Source {
+				return result[i].Source < result[j].Source
+			}
+			return result[i].Version < result[j].Version
+		})
+		return result
+	}
+	return diff(baseline, installed), diff(installed, baseline)
+}
+
+// SoftwareVersionCount is the number of hosts with a version of software
+// installed.
+type SoftwareVersionCount struct {
+	Name       string `json:"name" db:"name"`
+	Version    string `json:"version" db:"version"`
+	Source     string `json:"source" db:"source"`
+	HostsCount uint   `json:"hosts_count" db:"hosts_count"`
 }
 
 // Software is a named and versioned piece of software installed on a device.
@@ -14,12 +120,44 @@ type Software struct {
 	Version string `json:"version" db:"version"`
 	// Source is the source of the data (osquery table name).
 	Source string `json:"source" db:"source"`
+
+	// License is the license of the software, from SoftwareMetadata.
+	License string `json:"license,omitempty" db:"license"`
+	// Vendor is the vendor of the software, from SoftwareMetadata.
+	Vendor string `json:"vendor,omitempty" db:"vendor"`
+}
+
+// SoftwareUpgradeCandidate is software installed on a host, along with the
+// newest version of the software installed anywhere in the fleet.
+type SoftwareUpgradeCandidate struct {
+	Current    Software `json:"current"`
+	LatestSeen string   `json:"latest_seen"`
+}
+
+// SoftwareMetadata is externally provided information about software. It
+// applies to software with the same name and version, or to all versions of
+// the software if Version is empty. Metadata for a specific version takes
+// precedence.
+type SoftwareMetadata struct {
+	Name    string `json:"name" db:"name"`
+	Version string `json:"version" db:"version"`
+	License string `json:"license" db:"license"`
+	Vendor  string `json:"vendor" db:"vendor"`
 }
 
 // HostSoftware is the set of software installed on a specific host
 type HostSoftware struct {
 	// Software is the software information.
 	Software []Software `json:"software,omitempty"`
+	// SoftwareUpdatedAt is when the software was last collected from the
+	// host, whether or not it changed. It is zero if the host never reported
+	// its software.
+	SoftwareUpdatedAt time.Time `json:"software_updated_at"`
+	// PartialSources limits saving to the sources present in Software. If
+	// it's true, the stored software of the other sources is left untouched,
+	// for hosts reporting some sources only. Otherwise all the software of
+	// the host is replaced.
+	PartialSources bool `json:"-"`
 	// Modified is a boolean indicating whether this has been modified since
 	// loading. If Modified is true, datastore implementations should save the
 	// data. We track this here because saving the software set is likely to be
@@ -0,0 +1,45 @@
+package fleet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftwareDrift(t *testing.T) {
+	installed := []Software{
+		{ID: 3, Name: "zsh", Version: "5.8", Source: "deb_packages"},
+		{ID: 1, Name: "curl", Version: "7.68.0", Source: "deb_packages"},
+		{ID: 2, Name: "curl", Version: "7.68.0", Source: "deb_packages"},
+		{ID: 4, Name: "vim", Version: "8.2", Source: "deb_packages"},
+	}
+	baseline := []Software{
+		{Name: "curl", Version: "7.68.0", Source: "deb_packages"},
+		{Name: "zsh", Version: "5.9", Source: "deb_packages"},
+		{Name: "osquery", Version: "4.9.0", Source: "deb_packages"},
+		{Name: "osquery", Version: "4.9.0", Source: "deb_packages"},
+	}
+
+	missing, extra := SoftwareDrift(installed, baseline, SoftwareDriftOptions{})
+	assert.Equal(t, []Software{
+		{Name: "osquery", Version: "4.9.0", Source: "deb_packages"},
+		{Name: "zsh", Version: "5.9", Source: "deb_packages"},
+	}, missing)
+	assert.Equal(t, []Software{
+		{ID: 4, Name: "vim", Version: "8.2", Source: "deb_packages"},
+		{ID: 3, Name: "zsh", Version: "5.8", Source: "deb_packages"},
+	}, extra)
+
+	missing, extra = SoftwareDrift(installed, baseline, SoftwareDriftOptions{IgnoreVersions: true})
+	assert.Equal(t, []Software{{Name: "osquery", Version: "4.9.0", Source: "deb_packages"}}, missing)
+	assert.Equal(t, []Software{{ID: 4, Name: "vim", Version: "8.2", Source: "deb_packages"}}, extra)
+
+	// The source is part of the key
+	missing, extra = SoftwareDrift(installed[:1], []Software{{Name: "zsh", Version: "5.8", Source: "apps"}}, SoftwareDriftOptions{IgnoreVersions: true})
+	assert.Len(t, missing, 1)
+	assert.Len(t, extra, 1)
+
+	missing, extra = SoftwareDrift(nil, nil, SoftwareDriftOptions{})
+	assert.Empty(t, missing)
+	assert.Empty(t, extra)
+}
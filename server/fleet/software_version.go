@@ -0,0 +1,125 @@
+package fleet
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NormalizeVersion returns the version to use when grouping software by
+// version. Package managers and vendors decorate versions in different ways,
+// so the raw version is reduced to the upstream version by applying, in
+// order:
+//
+//   - Surrounding whitespace is removed, and anything after the first
+//     whitespace is stripped, eg. "1.2.3 (build 4)" becomes "1.2.3".
+//   - Build metadata after a "+" is stripped, eg. "1.2.3+dfsg" becomes
+//     "1.2.3".
+//   - An epoch before a ":" is stripped, eg. "1:1.2.3" becomes "1.2.3".
+//   - A distro package revision (a "-" followed by a digit, as in dpkg and rpm
+//     versions) is stripped from dotted versions, eg. "1.2.3-1ubuntu2" and
+//     "1.2.3-4.el7" become "1.2.3". Pre-release suffixes such as
+//     "1.2.3-beta" and dates such as "2021-07-01" are kept.
+//   - A leading "v" before a digit is stripped, eg. "v1.2.3" becomes "1.2.3".
+//
+// If nothing is left, the trimmed raw version is returned.
+func NormalizeVersion(raw string) string {
+	raw = strings.TrimSpace(raw)
+	v := raw
+
+	if i := strings.IndexFunc(v, unicode.IsSpace); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.Index(v, ":"); i >= 0 && isDigits(v[:i]) {
+		v = v[i+1:]
+	}
+	if i := strings.LastIndex(v, "-"); i >= 0 && i+1 < len(v) && isDigit(v[i+1]) && strings.Contains(v[:i], ".") {
+		v = v[:i]
+	}
+	if len(v) > 1 && (v[0] == 'v' || v[0] == 'V') && isDigit(v[1]) {
+		v = v[1:]
+	}
+
+	if v == "" {
+		return raw
+	}
+	return v
+}
+
+// CompareVersions compares the NormalizeVersion of a and b, returning -1, 0 or
+// 1 if a is older, the same as or newer than b.
+//
+// The versions are compared component by component, splitting at "." and
+// "-". Numeric components compare as numbers, and are newer than other
+// components, which compare as strings. A missing component is the same as
+// "0", except that a version is newer than the same version followed by a
+// pre-release suffix, eg. "1.2" is newer than "1.2-beta".
+func CompareVersions(a, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(NormalizeVersion(v), func(r rune) bool { return r == '.' || r == '-' })
+	}
+	ac, bc := split(a), split(b)
+	for i := 0; i < len(ac) || i < len(bc); i++ {
+		switch {
+		case i >= len(ac):
+			if !isDigits(bc[i]) {
+				return 1
+			}
+			if cmp := compareVersionComponents("0", bc[i]); cmp != 0 {
+				return cmp
+			}
+		case i >= len(bc):
+			if !isDigits(ac[i]) {
+				return -1
+			}
+			if cmp := compareVersionComponents(ac[i], "0"); cmp != 0 {
+				return cmp
+			}
+		default:
+			if cmp := compareVersionComponents(ac[i], bc[i]); cmp != 0 {
+				return cmp
+			}
+		}
+	}
+	return 0
+}
+
+func compareVersionComponents(a, b string) int {
+	aNum, bNum := isDigits(a), isDigits(b)
+	switch {
+	case aNum && bNum:
+		// Compared without parsing so that long numbers can't overflow
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	case aNum:
+		return 1
+	case bNum:
+		return -1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
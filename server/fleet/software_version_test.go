@@ -0,0 +1,67 @@
+package fleet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeVersion(t *testing.T) {
+	testCases := []struct {
+		raw      string
+		expected string
+	}{
+		{"1.2.3", "1.2.3"},
+		{" 1.2.3 ", "1.2.3"},
+		{"1.2.3-1ubuntu2", "1.2.3"},
+		{"1.2.3-4.el7", "1.2.3"},
+		{"1:1.2.3-1", "1.2.3"},
+		{"1.2.3 (build 4)", "1.2.3"},
+		{"1.2.3+dfsg-1", "1.2.3"},
+		{"1.2.3+build.5", "1.2.3"},
+		{"v1.2.3", "1.2.3"},
+		{"1.2.3-beta", "1.2.3-beta"},
+		{"1.2.3-rc1", "1.2.3-rc1"},
+		{"2021-07-01", "2021-07-01"},
+		{"91.0.4472.114", "91.0.4472.114"},
+		{"version", "version"},
+		{"(unknown)", "(unknown)"},
+		{"", ""},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.raw, func(t *testing.T) {
+			assert.Equal(t, tt.expected, NormalizeVersion(tt.raw))
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.2", "1.2.0", 0},
+		{"1.2", "1.2.1", -1},
+		{"1.2.3-1ubuntu2", "1.2.3-4.el7", 0},
+		{"1:1.2.3", "1.2.4", -1},
+		{"1.2.3-beta", "1.2.3", -1},
+		{"1.2.3-beta", "1.2.3-rc1", -1},
+		{"1.2.3-rc1", "1.2.3-rc1", 0},
+		{"v2", "1.9", 1},
+		{"91.0.4472.114", "91.0.4472.77", 1},
+		{"1.2.3.4", "1.2.3-1ubuntu2", 1},
+		{"1.2.3.4", "1.2.4", -1},
+		{"2021-07-01", "2021-06-30", 1},
+		{"99999999999999999999", "100000000000000000000", -1},
+		{"", "1", -1},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.expected, CompareVersions(tt.a, tt.b))
+			assert.Equal(t, -tt.expected, CompareVersions(tt.b, tt.a))
+		})
+	}
+}
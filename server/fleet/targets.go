@@ -28,7 +28,7 @@ type TargetMetrics struct {
 	// within the last 30 days.
 	MissingInActionHosts uint `db:"mia"`
 	// NewHosts is the count of hosts that have enrolled in the last 24
-	// hours.
+	// hours, or in the new host hours of their team when it is set.
 	NewHosts uint `db:"new"`
 }
 
@@ -61,6 +61,12 @@ type TeamPayload struct {
 	Name        *string         `json:"name"`
 	Description *string         `json:"description"`
 	Secrets     []*EnrollSecret `json:"secrets"`
+	// CarveRetentionHours sets the team's carve retention, 0 resets it to
+	// the global retention.
+	CarveRetentionHours *uint `json:"carve_retention_hours"`
+	// NewHostHours sets how long the team's hosts are considered new, 0
+	// resets it to NewDuration.
+	NewHostHours *uint `json:"new_host_hours"`
 	// Note AgentOptions must be set by a separate endpoint.
 }
 
@@ -79,6 +85,12 @@ type Team struct {
 	Description string `json:"description" db:"description"`
 	// AgentOptions is the options for osquery and Orbit.
 	AgentOptions *json.RawMessage `json:"agent_options" db:"agent_options"`
+	// CarveRetentionHours is how long carves from the team's hosts are kept
+	// before they expire. If nil, DefaultCarveRetention applies.
+	CarveRetentionHours *uint `json:"carve_retention_hours,omitempty" db:"carve_retention_hours"`
+	// NewHostHours is how long after enrolling the team's hosts are
+	// considered new. If nil, NewDuration applies.
+	NewHostHours *uint `json:"new_host_hours,omitempty" db:"new_host_hours"`
 
 	// Derived from JOINs
 
@@ -17,6 +17,7 @@ import "github.com/fleetdm/fleet/v4/server/fleet"
 //go:generate mockimpl -o datastore_campaigns.go "s *CampaignStore" "fleet.CampaignStore"
 //go:generate mockimpl -o datastore_sessions.go "s *SessionStore" "fleet.SessionStore"
 //go:generate mockimpl -o datastore_activities.go "s *ActivitiesStore" "fleet.ActivitiesStore"
+//go:generate mockimpl -o datastore_policies.go "s *PolicyStore" "fleet.PolicyStore"
 
 var _ fleet.Datastore = (*Store)(nil)
 
@@ -37,8 +38,10 @@ type Store struct {
 	QueryResultStore
 	CarveStore
 	SoftwareStore
+	DiskStore
 	ActivitiesStore
 	StatisticsStore
+	PolicyStore
 }
 
 func (m *Store) Drop() error {
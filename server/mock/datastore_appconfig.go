@@ -18,6 +18,10 @@ type ApplyEnrollSecretsFunc func(treamID *uint, secrets []*fleet.EnrollSecret) e
 
 type GetEnrollSecretsFunc func(teamID *uint) ([]*fleet.EnrollSecret, error)
 
+type RecordHostEnrollSecretFunc func(hostID uint, secret string) error
+
+type EnrollmentSecretUsageFunc func() (map[string]uint, error)
+
 type AppConfigStore struct {
 	NewAppConfigFunc        NewAppConfigFunc
 	NewAppConfigFuncInvoked bool
@@ -36,6 +40,12 @@ type AppConfigStore struct {
 
 	GetEnrollSecretsFunc        GetEnrollSecretsFunc
 	GetEnrollSecretsFuncInvoked bool
+
+	RecordHostEnrollSecretFunc        RecordHostEnrollSecretFunc
+	RecordHostEnrollSecretFuncInvoked bool
+
+	EnrollmentSecretUsageFunc        EnrollmentSecretUsageFunc
+	EnrollmentSecretUsageFuncInvoked bool
 }
 
 func (s *AppConfigStore) NewAppConfig(info *fleet.AppConfig) (*fleet.AppConfig, error) {
@@ -67,3 +77,13 @@ func (s *AppConfigStore) GetEnrollSecrets(teamID *uint) ([]*fleet.EnrollSecret,
 	s.GetEnrollSecretsFuncInvoked = true
 	return s.GetEnrollSecretsFunc(teamID)
 }
+
+func (s *AppConfigStore) RecordHostEnrollSecret(hostID uint, secret string) error {
+	s.RecordHostEnrollSecretFuncInvoked = true
+	return s.RecordHostEnrollSecretFunc(hostID, secret)
+}
+
+func (s *AppConfigStore) EnrollmentSecretUsage() (map[string]uint, error) {
+	s.EnrollmentSecretUsageFuncInvoked = true
+	return s.EnrollmentSecretUsageFunc()
+}
@@ -3,6 +3,7 @@
 package mock
 
 import (
+	"io"
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
@@ -10,23 +11,39 @@ import (
 
 var _ fleet.CarveStore = (*CarveStore)(nil)
 
-type NewCarveFunc func(c *fleet.CarveMetadata) (*fleet.CarveMetadata, error)
+type NewCarveFunc func(c *fleet.CarveMetadata, maxActivePerHost int) (*fleet.CarveMetadata, error)
 
 type UpdateCarveFunc func(c *fleet.CarveMetadata) error
 
 type CarveFunc func(carveId int64) (*fleet.CarveMetadata, error)
 
-type ListCarvesFunc func(opt fleet.CarveListOptions) ([]*fleet.CarveMetadata, error)
+type ListCarvesFunc func(filter fleet.TeamFilter, opt fleet.CarveListOptions) ([]*fleet.CarveMetadata, error)
 
 type CarveBySessionIdFunc func(sessionId string) (*fleet.CarveMetadata, error)
 
-type CarveByNameFunc func(name string) (*fleet.CarveMetadata, error)
+type CarveByNameFunc func(filter fleet.TeamFilter, name string) (*fleet.CarveMetadata, error)
 
 type NewBlockFunc func(metadata *fleet.CarveMetadata, blockId int64, data []byte) error
 
 type GetBlockFunc func(metadata *fleet.CarveMetadata, blockId int64) ([]byte, error)
 
-type CleanupCarvesFunc func(now time.Time) (expired int, err error)
+type CleanupCarvesFunc func(now time.Time) (expired map[uint]int, err error)
+
+type CarveReaderAtFunc func(carve *fleet.CarveMetadata) (io.ReaderAt, int64, error)
+
+type CarveBlockStatusFunc func(carveId int64) ([]bool, error)
+
+type HostCarveStorageFunc func(hostID uint) (totalBytes int64, carveCount int, err error)
+
+type CarveByRequestIdFunc func(requestId string) (*fleet.CarveMetadata, error)
+
+type DeleteCarvesFunc func(ids []int64) (deleted int, err error)
+
+type ListCarvesExpiringBeforeFunc func(t time.Time) ([]*fleet.CarveMetadata, error)
+
+type WriteCarveFunc func(carve *fleet.CarveMetadata, w io.Writer) (int64, error)
+
+type UpdateCarveCaseFunc func(carveId int64, caseID string, notes string) error
 
 type CarveStore struct {
 	NewCarveFunc        NewCarveFunc
@@ -55,11 +72,35 @@ type CarveStore struct {
 
 	CleanupCarvesFunc        CleanupCarvesFunc
 	CleanupCarvesFuncInvoked bool
+
+	CarveReaderAtFunc        CarveReaderAtFunc
+	CarveReaderAtFuncInvoked bool
+
+	CarveBlockStatusFunc        CarveBlockStatusFunc
+	CarveBlockStatusFuncInvoked bool
+
+	HostCarveStorageFunc        HostCarveStorageFunc
+	HostCarveStorageFuncInvoked bool
+
+	CarveByRequestIdFunc        CarveByRequestIdFunc
+	CarveByRequestIdFuncInvoked bool
+
+	DeleteCarvesFunc        DeleteCarvesFunc
+	DeleteCarvesFuncInvoked bool
+
+	ListCarvesExpiringBeforeFunc        ListCarvesExpiringBeforeFunc
+	ListCarvesExpiringBeforeFuncInvoked bool
+
+	WriteCarveFunc        WriteCarveFunc
+	WriteCarveFuncInvoked bool
+
+	UpdateCarveCaseFunc        UpdateCarveCaseFunc
+	UpdateCarveCaseFuncInvoked bool
 }
 
-func (s *CarveStore) NewCarve(c *fleet.CarveMetadata) (*fleet.CarveMetadata, error) {
+func (s *CarveStore) NewCarve(c *fleet.CarveMetadata, maxActivePerHost int) (*fleet.CarveMetadata, error) {
 	s.NewCarveFuncInvoked = true
-	return s.NewCarveFunc(c)
+	return s.NewCarveFunc(c, maxActivePerHost)
 }
 
 func (s *CarveStore) UpdateCarve(c *fleet.CarveMetadata) error {
@@ -72,9 +113,9 @@ func (s *CarveStore) Carve(carveId int64) (*fleet.CarveMetadata, error) {
 	return s.CarveFunc(carveId)
 }
 
-func (s *CarveStore) ListCarves(opt fleet.CarveListOptions) ([]*fleet.CarveMetadata, error) {
+func (s *CarveStore) ListCarves(filter fleet.TeamFilter, opt fleet.CarveListOptions) ([]*fleet.CarveMetadata, error) {
 	s.ListCarvesFuncInvoked = true
-	return s.ListCarvesFunc(opt)
+	return s.ListCarvesFunc(filter, opt)
 }
 
 func (s *CarveStore) CarveBySessionId(sessionId string) (*fleet.CarveMetadata, error) {
@@ -82,9 +123,9 @@ func (s *CarveStore) CarveBySessionId(sessionId string) (*fleet.CarveMetadata, e
 	return s.CarveBySessionIdFunc(sessionId)
 }
 
-func (s *CarveStore) CarveByName(name string) (*fleet.CarveMetadata, error) {
+func (s *CarveStore) CarveByName(filter fleet.TeamFilter, name string) (*fleet.CarveMetadata, error) {
 	s.CarveByNameFuncInvoked = true
-	return s.CarveByNameFunc(name)
+	return s.CarveByNameFunc(filter, name)
 }
 
 func (s *CarveStore) NewBlock(metadata *fleet.CarveMetadata, blockId int64, data []byte) error {
@@ -97,7 +138,47 @@ func (s *CarveStore) GetBlock(metadata *fleet.CarveMetadata, blockId int64) ([]b
 	return s.GetBlockFunc(metadata, blockId)
 }
 
-func (s *CarveStore) CleanupCarves(now time.Time) (expired int, err error) {
+func (s *CarveStore) CleanupCarves(now time.Time) (expired map[uint]int, err error) {
 	s.CleanupCarvesFuncInvoked = true
 	return s.CleanupCarvesFunc(now)
 }
+
+func (s *CarveStore) CarveReaderAt(carve *fleet.CarveMetadata) (io.ReaderAt, int64, error) {
+	s.CarveReaderAtFuncInvoked = true
+	return s.CarveReaderAtFunc(carve)
+}
+
+func (s *CarveStore) CarveBlockStatus(carveId int64) ([]bool, error) {
+	s.CarveBlockStatusFuncInvoked = true
+	return s.CarveBlockStatusFunc(carveId)
+}
+
+func (s *CarveStore) HostCarveStorage(hostID uint) (totalBytes int64, carveCount int, err error) {
+	s.HostCarveStorageFuncInvoked = true
+	return s.HostCarveStorageFunc(hostID)
+}
+
+func (s *CarveStore) CarveByRequestId(requestId string) (*fleet.CarveMetadata, error) {
+	s.CarveByRequestIdFuncInvoked = true
+	return s.CarveByRequestIdFunc(requestId)
+}
+
+func (s *CarveStore) DeleteCarves(ids []int64) (deleted int, err error) {
+	s.DeleteCarvesFuncInvoked = true
+	return s.DeleteCarvesFunc(ids)
+}
+
+func (s *CarveStore) ListCarvesExpiringBefore(t time.Time) ([]*fleet.CarveMetadata, error) {
+	s.ListCarvesExpiringBeforeFuncInvoked = true
+	return s.ListCarvesExpiringBeforeFunc(t)
+}
+
+func (s *CarveStore) WriteCarve(carve *fleet.CarveMetadata, w io.Writer) (int64, error) {
+	s.WriteCarveFuncInvoked = true
+	return s.WriteCarveFunc(carve, w)
+}
+
+func (s *CarveStore) UpdateCarveCase(carveId int64, caseID string, notes string) error {
+	s.UpdateCarveCaseFuncInvoked = true
+	return s.UpdateCarveCaseFunc(carveId, caseID, notes)
+}
@@ -22,7 +22,7 @@ type HostByIdentifierFunc func(identifier string) (*fleet.Host, error)
 
 type ListHostsFunc func(filter fleet.TeamFilter, opt fleet.HostListOptions) ([]*fleet.Host, error)
 
-type EnrollHostFunc func(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration) (*fleet.Host, error)
+type EnrollHostFunc func(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration, opt fleet.EnrollHostOptions) (*fleet.Host, error)
 
 type AuthenticateHostFunc func(nodeKey string) (*fleet.Host, error)
 
@@ -32,18 +32,96 @@ type MarkHostsSeenFunc func(hostIDs []uint, t time.Time) error
 
 type CleanupIncomingHostsFunc func(t time.Time) error
 
-type SearchHostsFunc func(filter fleet.TeamFilter, query string, omit ...uint) ([]*fleet.Host, error)
+type SearchHostsFunc func(filter fleet.TeamFilter, query string, limit int, omit ...uint) ([]*fleet.Host, error)
 
-type GenerateHostStatusStatisticsFunc func(filter fleet.TeamFilter, now time.Time) (online uint, offline uint, mia uint, new uint, err error)
+type GenerateHostStatusStatisticsFunc func(filter fleet.TeamFilter, now time.Time) (*fleet.HostSummary, error)
 
 type DistributedQueriesForHostFunc func(host *fleet.Host) (map[uint]string, error)
 
 type HostIDsByNameFunc func(filter fleet.TeamFilter, hostnames []string) ([]uint, error)
 
-type AddHostsToTeamFunc func(teamID *uint, hostIDs []uint) error
+type AddHostsToTeamFunc func(teamID *uint, hostIDs []uint, refetchLabels bool) (fleet.HostTeamAssignment, error)
 
 type SaveHostAdditionalFunc func(host *fleet.Host) error
 
+type HostOrphanCheckFunc func(hostID uint) (map[string]int, error)
+
+type ClearStaleRefetchRequestsFunc func(olderThan time.Time) (int, error)
+
+type SetHostOwnerFunc func(hostID uint, email string) error
+
+type HostsByOwnerFunc func(filter fleet.TeamFilter, email string) ([]*fleet.Host, error)
+
+type ListHostUsersFunc func(hostID uint, opt fleet.ListOptions) ([]fleet.HostUser, uint, error)
+
+type SetHostTagsFunc func(hostIDs []uint, tags map[string]string) error
+
+type ListHostTagsFunc func(hostID uint) (map[string]string, error)
+
+type DeleteHostWithReasonFunc func(hid uint, reason string) error
+
+type ListHostDeletionsFunc func(since time.Time) ([]*fleet.HostDeletion, error)
+
+type SaveHostFieldsFunc func(host *fleet.Host, fields []string) error
+
+type TeamHostStatusStatisticsFunc func(filter fleet.TeamFilter, now time.Time) (map[uint]fleet.HostSummary, error)
+
+type DecommissionHostFunc func(hid uint) error
+
+type EnrollHostByHardwareFunc func(osqueryHostId, fingerprint, nodeKey string, teamID *uint, cooldown time.Duration) (*fleet.Host, error)
+
+type SnapshotHostSummaryFunc func(now time.Time) error
+
+type HostSummaryDeltaFunc func(from, to time.Time) (fleet.HostSummaryDelta, error)
+
+type HostBySerialFunc func(serial string) (*fleet.Host, error)
+
+type ListFailedEnrollmentsFunc func(since time.Time) ([]*fleet.EnrollmentAttempt, error)
+
+type StalestHostsByPlatformFunc func(filter fleet.TeamFilter, limit int) (map[string][]*fleet.Host, error)
+
+type RotateHostNodeKeyFunc func(hostID uint) (string, error)
+
+type RefetchStatusFunc func(hostID uint) (requested bool, completed bool, err error)
+
+type CountHostsBySubnetFunc func(filter fleet.TeamFilter, maskBits int) (map[string]uint, error)
+
+type SearchHostsWithMatchesFunc func(filter fleet.TeamFilter, query string, limit int, omit ...uint) ([]*fleet.HostSearchResult, error)
+
+type ScheduleHostRetirementFunc func(hostID uint, at time.Time) error
+
+type ProcessHostRetirementsFunc func(now time.Time) (int, error)
+
+type IterHostsFunc func(filter fleet.TeamFilter, opt fleet.HostListOptions, fn func(*fleet.Host) error) error
+
+type ListHostTeamChangesFunc func(since time.Time) ([]*fleet.HostTeamChange, error)
+
+type ApplyHostAnnotationsFunc func(annotations map[string]fleet.HostAnnotation) (applied int, unmatched []string, err error)
+
+type ListHostFieldChangesFunc func(hostID uint, fields []string) ([]*fleet.HostFieldChange, error)
+
+type SearchHostsFuzzyFunc func(filter fleet.TeamFilter, query string, limit int) ([]*fleet.Host, error)
+
+type MultiHostStatusStatisticsFunc func(filters []fleet.NamedTeamFilter, now time.Time) (map[string]fleet.HostSummary, error)
+
+type ListHostsWithPoorBatteryHealthFunc func(filter fleet.TeamFilter) ([]*fleet.Host, error)
+
+type HostDetailQueryStatusFunc func(hostID uint) (map[string]time.Time, error)
+
+type HostScheduledQueryStatsFunc func(hostID uint, queryName string) (fleet.ScheduledQueryStats, error)
+
+type ListEnrolledNeverSeenHostsFunc func(filter fleet.TeamFilter) ([]*fleet.Host, error)
+
+type PlatformCountHistoryFunc func(from, to time.Time) (map[string][]fleet.PlatformCountPoint, error)
+
+type ListHostsWithPendingActionsFunc func(filter fleet.TeamFilter) ([]*fleet.Host, error)
+
+type HostExistsFunc func(id uint) (bool, error)
+
+type HostResourceHistoryFunc func(hostID uint, from, to time.Time) ([]fleet.HostResourceSample, error)
+
+type LoadHostUsersFunc func(host *fleet.Host) error
+
 type HostStore struct {
 	NewHostFunc        NewHostFunc
 	NewHostFuncInvoked bool
@@ -95,6 +173,123 @@ type HostStore struct {
 
 	SaveHostAdditionalFunc        SaveHostAdditionalFunc
 	SaveHostAdditionalFuncInvoked bool
+
+	HostOrphanCheckFunc        HostOrphanCheckFunc
+	HostOrphanCheckFuncInvoked bool
+
+	ClearStaleRefetchRequestsFunc        ClearStaleRefetchRequestsFunc
+	ClearStaleRefetchRequestsFuncInvoked bool
+
+	SetHostOwnerFunc        SetHostOwnerFunc
+	SetHostOwnerFuncInvoked bool
+
+	HostsByOwnerFunc        HostsByOwnerFunc
+	HostsByOwnerFuncInvoked bool
+
+	ListHostUsersFunc        ListHostUsersFunc
+	ListHostUsersFuncInvoked bool
+
+	SetHostTagsFunc        SetHostTagsFunc
+	SetHostTagsFuncInvoked bool
+
+	ListHostTagsFunc        ListHostTagsFunc
+	ListHostTagsFuncInvoked bool
+
+	DeleteHostWithReasonFunc        DeleteHostWithReasonFunc
+	DeleteHostWithReasonFuncInvoked bool
+
+	ListHostDeletionsFunc        ListHostDeletionsFunc
+	ListHostDeletionsFuncInvoked bool
+
+	SaveHostFieldsFunc        SaveHostFieldsFunc
+	SaveHostFieldsFuncInvoked bool
+
+	TeamHostStatusStatisticsFunc        TeamHostStatusStatisticsFunc
+	TeamHostStatusStatisticsFuncInvoked bool
+
+	DecommissionHostFunc        DecommissionHostFunc
+	DecommissionHostFuncInvoked bool
+
+	EnrollHostByHardwareFunc        EnrollHostByHardwareFunc
+	EnrollHostByHardwareFuncInvoked bool
+
+	SnapshotHostSummaryFunc        SnapshotHostSummaryFunc
+	SnapshotHostSummaryFuncInvoked bool
+
+	HostSummaryDeltaFunc        HostSummaryDeltaFunc
+	HostSummaryDeltaFuncInvoked bool
+
+	HostBySerialFunc        HostBySerialFunc
+	HostBySerialFuncInvoked bool
+
+	ListFailedEnrollmentsFunc        ListFailedEnrollmentsFunc
+	ListFailedEnrollmentsFuncInvoked bool
+
+	StalestHostsByPlatformFunc        StalestHostsByPlatformFunc
+	StalestHostsByPlatformFuncInvoked bool
+
+	RotateHostNodeKeyFunc        RotateHostNodeKeyFunc
+	RotateHostNodeKeyFuncInvoked bool
+
+	RefetchStatusFunc        RefetchStatusFunc
+	RefetchStatusFuncInvoked bool
+
+	CountHostsBySubnetFunc        CountHostsBySubnetFunc
+	CountHostsBySubnetFuncInvoked bool
+
+	SearchHostsWithMatchesFunc        SearchHostsWithMatchesFunc
+	SearchHostsWithMatchesFuncInvoked bool
+
+	ScheduleHostRetirementFunc        ScheduleHostRetirementFunc
+	ScheduleHostRetirementFuncInvoked bool
+
+	ProcessHostRetirementsFunc        ProcessHostRetirementsFunc
+	ProcessHostRetirementsFuncInvoked bool
+
+	IterHostsFunc        IterHostsFunc
+	IterHostsFuncInvoked bool
+
+	ListHostTeamChangesFunc        ListHostTeamChangesFunc
+	ListHostTeamChangesFuncInvoked bool
+
+	ApplyHostAnnotationsFunc        ApplyHostAnnotationsFunc
+	ApplyHostAnnotationsFuncInvoked bool
+
+	ListHostFieldChangesFunc        ListHostFieldChangesFunc
+	ListHostFieldChangesFuncInvoked bool
+
+	SearchHostsFuzzyFunc        SearchHostsFuzzyFunc
+	SearchHostsFuzzyFuncInvoked bool
+
+	MultiHostStatusStatisticsFunc        MultiHostStatusStatisticsFunc
+	MultiHostStatusStatisticsFuncInvoked bool
+
+	ListHostsWithPoorBatteryHealthFunc        ListHostsWithPoorBatteryHealthFunc
+	ListHostsWithPoorBatteryHealthFuncInvoked bool
+
+	HostDetailQueryStatusFunc        HostDetailQueryStatusFunc
+	HostDetailQueryStatusFuncInvoked bool
+
+	HostScheduledQueryStatsFunc        HostScheduledQueryStatsFunc
+	HostScheduledQueryStatsFuncInvoked bool
+
+	ListEnrolledNeverSeenHostsFunc        ListEnrolledNeverSeenHostsFunc
+	ListEnrolledNeverSeenHostsFuncInvoked bool
+
+	PlatformCountHistoryFunc        PlatformCountHistoryFunc
+	PlatformCountHistoryFuncInvoked bool
+
+	ListHostsWithPendingActionsFunc        ListHostsWithPendingActionsFunc
+	ListHostsWithPendingActionsFuncInvoked bool
+
+	HostExistsFunc        HostExistsFunc
+	HostExistsFuncInvoked bool
+
+	HostResourceHistoryFunc        HostResourceHistoryFunc
+	HostResourceHistoryFuncInvoked bool
+
+	LoadHostUsersFunc        LoadHostUsersFunc
+	LoadHostUsersFuncInvoked bool
 }
 
 func (s *HostStore) NewHost(host *fleet.Host) (*fleet.Host, error) {
@@ -127,9 +322,9 @@ func (s *HostStore) ListHosts(filter fleet.TeamFilter, opt fleet.HostListOptions
 	return s.ListHostsFunc(filter, opt)
 }
 
-func (s *HostStore) EnrollHost(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration) (*fleet.Host, error) {
+func (s *HostStore) EnrollHost(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration, opt fleet.EnrollHostOptions) (*fleet.Host, error) {
 	s.EnrollHostFuncInvoked = true
-	return s.EnrollHostFunc(osqueryHostId, nodeKey, teamID, cooldown)
+	return s.EnrollHostFunc(osqueryHostId, nodeKey, teamID, cooldown, opt)
 }
 
 func (s *HostStore) AuthenticateHost(nodeKey string) (*fleet.Host, error) {
@@ -152,12 +347,12 @@ func (s *HostStore) CleanupIncomingHosts(t time.Time) error {
 	return s.CleanupIncomingHostsFunc(t)
 }
 
-func (s *HostStore) SearchHosts(filter fleet.TeamFilter, query string, omit ...uint) ([]*fleet.Host, error) {
+func (s *HostStore) SearchHosts(filter fleet.TeamFilter, query string, limit int, omit ...uint) ([]*fleet.Host, error) {
 	s.SearchHostsFuncInvoked = true
-	return s.SearchHostsFunc(filter, query, omit...)
+	return s.SearchHostsFunc(filter, query, limit, omit...)
 }
 
-func (s *HostStore) GenerateHostStatusStatistics(filter fleet.TeamFilter, now time.Time) (online uint, offline uint, mia uint, new uint, err error) {
+func (s *HostStore) GenerateHostStatusStatistics(filter fleet.TeamFilter, now time.Time) (*fleet.HostSummary, error) {
 	s.GenerateHostStatusStatisticsFuncInvoked = true
 	return s.GenerateHostStatusStatisticsFunc(filter, now)
 }
@@ -172,12 +367,207 @@ func (s *HostStore) HostIDsByName(filter fleet.TeamFilter, hostnames []string) (
 	return s.HostIDsByNameFunc(filter, hostnames)
 }
 
-func (s *HostStore) AddHostsToTeam(teamID *uint, hostIDs []uint) error {
+func (s *HostStore) AddHostsToTeam(teamID *uint, hostIDs []uint, refetchLabels bool) (fleet.HostTeamAssignment, error) {
 	s.AddHostsToTeamFuncInvoked = true
-	return s.AddHostsToTeamFunc(teamID, hostIDs)
+	return s.AddHostsToTeamFunc(teamID, hostIDs, refetchLabels)
 }
 
 func (s *HostStore) SaveHostAdditional(host *fleet.Host) error {
 	s.SaveHostAdditionalFuncInvoked = true
 	return s.SaveHostAdditionalFunc(host)
 }
+
+func (s *HostStore) HostOrphanCheck(hostID uint) (map[string]int, error) {
+	s.HostOrphanCheckFuncInvoked = true
+	return s.HostOrphanCheckFunc(hostID)
+}
+
+func (s *HostStore) ClearStaleRefetchRequests(olderThan time.Time) (int, error) {
+	s.ClearStaleRefetchRequestsFuncInvoked = true
+	return s.ClearStaleRefetchRequestsFunc(olderThan)
+}
+
+func (s *HostStore) SetHostOwner(hostID uint, email string) error {
+	s.SetHostOwnerFuncInvoked = true
+	return s.SetHostOwnerFunc(hostID, email)
+}
+
+func (s *HostStore) HostsByOwner(filter fleet.TeamFilter, email string) ([]*fleet.Host, error) {
+	s.HostsByOwnerFuncInvoked = true
+	return s.HostsByOwnerFunc(filter, email)
+}
+
+func (s *HostStore) ListHostUsers(hostID uint, opt fleet.ListOptions) ([]fleet.HostUser, uint, error) {
+	s.ListHostUsersFuncInvoked = true
+	return s.ListHostUsersFunc(hostID, opt)
+}
+
+func (s *HostStore) SetHostTags(hostIDs []uint, tags map[string]string) error {
+	s.SetHostTagsFuncInvoked = true
+	return s.SetHostTagsFunc(hostIDs, tags)
+}
+
+func (s *HostStore) ListHostTags(hostID uint) (map[string]string, error) {
+	s.ListHostTagsFuncInvoked = true
+	return s.ListHostTagsFunc(hostID)
+}
+
+func (s *HostStore) DeleteHostWithReason(hid uint, reason string) error {
+	s.DeleteHostWithReasonFuncInvoked = true
+	return s.DeleteHostWithReasonFunc(hid, reason)
+}
+
+func (s *HostStore) ListHostDeletions(since time.Time) ([]*fleet.HostDeletion, error) {
+	s.ListHostDeletionsFuncInvoked = true
+	return s.ListHostDeletionsFunc(since)
+}
+
+func (s *HostStore) SaveHostFields(host *fleet.Host, fields []string) error {
+	s.SaveHostFieldsFuncInvoked = true
+	return s.SaveHostFieldsFunc(host, fields)
+}
+
+func (s *HostStore) TeamHostStatusStatistics(filter fleet.TeamFilter, now time.Time) (map[uint]fleet.HostSummary, error) {
+	s.TeamHostStatusStatisticsFuncInvoked = true
+	return s.TeamHostStatusStatisticsFunc(filter, now)
+}
+
+func (s *HostStore) DecommissionHost(hid uint) error {
+	s.DecommissionHostFuncInvoked = true
+	return s.DecommissionHostFunc(hid)
+}
+
+func (s *HostStore) EnrollHostByHardware(osqueryHostId, fingerprint, nodeKey string, teamID *uint, cooldown time.Duration) (*fleet.Host, error) {
+	s.EnrollHostByHardwareFuncInvoked = true
+	return s.EnrollHostByHardwareFunc(osqueryHostId, fingerprint, nodeKey, teamID, cooldown)
+}
+
+func (s *HostStore) SnapshotHostSummary(now time.Time) error {
+	s.SnapshotHostSummaryFuncInvoked = true
+	return s.SnapshotHostSummaryFunc(now)
+}
+
+func (s *HostStore) HostSummaryDelta(from, to time.Time) (fleet.HostSummaryDelta, error) {
+	s.HostSummaryDeltaFuncInvoked = true
+	return s.HostSummaryDeltaFunc(from, to)
+}
+
+func (s *HostStore) HostBySerial(serial string) (*fleet.Host, error) {
+	s.HostBySerialFuncInvoked = true
+	return s.HostBySerialFunc(serial)
+}
+
+func (s *HostStore) ListFailedEnrollments(since time.Time) ([]*fleet.EnrollmentAttempt, error) {
+	s.ListFailedEnrollmentsFuncInvoked = true
+	return s.ListFailedEnrollmentsFunc(since)
+}
+
+func (s *HostStore) StalestHostsByPlatform(filter fleet.TeamFilter, limit int) (map[string][]*fleet.Host, error) {
+	s.StalestHostsByPlatformFuncInvoked = true
+	return s.StalestHostsByPlatformFunc(filter, limit)
+}
+
+func (s *HostStore) RotateHostNodeKey(hostID uint) (string, error) {
+	s.RotateHostNodeKeyFuncInvoked = true
+	return s.RotateHostNodeKeyFunc(hostID)
+}
+
+func (s *HostStore) RefetchStatus(hostID uint) (requested bool, completed bool, err error) {
+	s.RefetchStatusFuncInvoked = true
+	return s.RefetchStatusFunc(hostID)
+}
+
+func (s *HostStore) CountHostsBySubnet(filter fleet.TeamFilter, maskBits int) (map[string]uint, error) {
+	s.CountHostsBySubnetFuncInvoked = true
+	return s.CountHostsBySubnetFunc(filter, maskBits)
+}
+
+func (s *HostStore) SearchHostsWithMatches(filter fleet.TeamFilter, query string, limit int, omit ...uint) ([]*fleet.HostSearchResult, error) {
+	s.SearchHostsWithMatchesFuncInvoked = true
+	return s.SearchHostsWithMatchesFunc(filter, query, limit, omit...)
+}
+
+func (s *HostStore) ScheduleHostRetirement(hostID uint, at time.Time) error {
+	s.ScheduleHostRetirementFuncInvoked = true
+	return s.ScheduleHostRetirementFunc(hostID, at)
+}
+
+func (s *HostStore) ProcessHostRetirements(now time.Time) (int, error) {
+	s.ProcessHostRetirementsFuncInvoked = true
+	return s.ProcessHostRetirementsFunc(now)
+}
+
+func (s *HostStore) IterHosts(filter fleet.TeamFilter, opt fleet.HostListOptions, fn func(*fleet.Host) error) error {
+	s.IterHostsFuncInvoked = true
+	return s.IterHostsFunc(filter, opt, fn)
+}
+
+func (s *HostStore) ListHostTeamChanges(since time.Time) ([]*fleet.HostTeamChange, error) {
+	s.ListHostTeamChangesFuncInvoked = true
+	return s.ListHostTeamChangesFunc(since)
+}
+
+func (s *HostStore) ApplyHostAnnotations(annotations map[string]fleet.HostAnnotation) (applied int, unmatched []string, err error) {
+	s.ApplyHostAnnotationsFuncInvoked = true
+	return s.ApplyHostAnnotationsFunc(annotations)
+}
+
+func (s *HostStore) ListHostFieldChanges(hostID uint, fields []string) ([]*fleet.HostFieldChange, error) {
+	s.ListHostFieldChangesFuncInvoked = true
+	return s.ListHostFieldChangesFunc(hostID, fields)
+}
+
+func (s *HostStore) SearchHostsFuzzy(filter fleet.TeamFilter, query string, limit int) ([]*fleet.Host, error) {
+	s.SearchHostsFuzzyFuncInvoked = true
+	return s.SearchHostsFuzzyFunc(filter, query, limit)
+}
+
+func (s *HostStore) MultiHostStatusStatistics(filters []fleet.NamedTeamFilter, now time.Time) (map[string]fleet.HostSummary, error) {
+	s.MultiHostStatusStatisticsFuncInvoked = true
+	return s.MultiHostStatusStatisticsFunc(filters, now)
+}
+
+func (s *HostStore) ListHostsWithPoorBatteryHealth(filter fleet.TeamFilter) ([]*fleet.Host, error) {
+	s.ListHostsWithPoorBatteryHealthFuncInvoked = true
+	return s.ListHostsWithPoorBatteryHealthFunc(filter)
+}
+
+func (s *HostStore) HostDetailQueryStatus(hostID uint) (map[string]time.Time, error) {
+	s.HostDetailQueryStatusFuncInvoked = true
+	return s.HostDetailQueryStatusFunc(hostID)
+}
+
+func (s *HostStore) HostScheduledQueryStats(hostID uint, queryName string) (fleet.ScheduledQueryStats, error) {
+	s.HostScheduledQueryStatsFuncInvoked = true
+	return s.HostScheduledQueryStatsFunc(hostID, queryName)
+}
+
+func (s *HostStore) ListEnrolledNeverSeenHosts(filter fleet.TeamFilter) ([]*fleet.Host, error) {
+	s.ListEnrolledNeverSeenHostsFuncInvoked = true
+	return s.ListEnrolledNeverSeenHostsFunc(filter)
+}
+
+func (s *HostStore) PlatformCountHistory(from, to time.Time) (map[string][]fleet.PlatformCountPoint, error) {
+	s.PlatformCountHistoryFuncInvoked = true
+	return s.PlatformCountHistoryFunc(from, to)
+}
+
+func (s *HostStore) ListHostsWithPendingActions(filter fleet.TeamFilter) ([]*fleet.Host, error) {
+	s.ListHostsWithPendingActionsFuncInvoked = true
+	return s.ListHostsWithPendingActionsFunc(filter)
+}
+
+func (s *HostStore) HostExists(id uint) (bool, error) {
+	s.HostExistsFuncInvoked = true
+	return s.HostExistsFunc(id)
+}
+
+func (s *HostStore) HostResourceHistory(hostID uint, from, to time.Time) ([]fleet.HostResourceSample, error) {
+	s.HostResourceHistoryFuncInvoked = true
+	return s.HostResourceHistoryFunc(hostID, from, to)
+}
+
+func (s *HostStore) LoadHostUsers(host *fleet.Host) error {
+	s.LoadHostUsersFuncInvoked = true
+	return s.LoadHostUsersFunc(host)
+}
@@ -40,6 +40,14 @@ type SearchLabelsFunc func(filter fleet.TeamFilter, query string, omit ...uint)
 
 type LabelIDsByNameFunc func(labels []string) ([]uint, error)
 
+type AddHostsToLabelFunc func(labelID uint, hostIDs []uint) error
+
+type RemoveHostsFromLabelFunc func(labelID uint, hostIDs []uint) error
+
+type SnapshotLabelCountsFunc func(now time.Time) error
+
+type LabelCountHistoryFunc func(labelID uint, from, to time.Time) ([]fleet.LabelCountPoint, error)
+
 type LabelStore struct {
 	ApplyLabelSpecsFunc        ApplyLabelSpecsFunc
 	ApplyLabelSpecsFuncInvoked bool
@@ -85,6 +93,18 @@ type LabelStore struct {
 
 	LabelIDsByNameFunc        LabelIDsByNameFunc
 	LabelIDsByNameFuncInvoked bool
+
+	AddHostsToLabelFunc        AddHostsToLabelFunc
+	AddHostsToLabelFuncInvoked bool
+
+	RemoveHostsFromLabelFunc        RemoveHostsFromLabelFunc
+	RemoveHostsFromLabelFuncInvoked bool
+
+	SnapshotLabelCountsFunc        SnapshotLabelCountsFunc
+	SnapshotLabelCountsFuncInvoked bool
+
+	LabelCountHistoryFunc        LabelCountHistoryFunc
+	LabelCountHistoryFuncInvoked bool
 }
 
 func (s *LabelStore) ApplyLabelSpecs(specs []*fleet.LabelSpec) error {
@@ -161,3 +181,23 @@ func (s *LabelStore) LabelIDsByName(labels []string) ([]uint, error) {
 	s.LabelIDsByNameFuncInvoked = true
 	return s.LabelIDsByNameFunc(labels)
 }
+
+func (s *LabelStore) AddHostsToLabel(labelID uint, hostIDs []uint) error {
+	s.AddHostsToLabelFuncInvoked = true
+	return s.AddHostsToLabelFunc(labelID, hostIDs)
+}
+
+func (s *LabelStore) RemoveHostsFromLabel(labelID uint, hostIDs []uint) error {
+	s.RemoveHostsFromLabelFuncInvoked = true
+	return s.RemoveHostsFromLabelFunc(labelID, hostIDs)
+}
+
+func (s *LabelStore) SnapshotLabelCounts(now time.Time) error {
+	s.SnapshotLabelCountsFuncInvoked = true
+	return s.SnapshotLabelCountsFunc(now)
+}
+
+func (s *LabelStore) LabelCountHistory(labelID uint, from, to time.Time) ([]fleet.LabelCountPoint, error) {
+	s.LabelCountHistoryFuncInvoked = true
+	return s.LabelCountHistoryFunc(labelID, from, to)
+}
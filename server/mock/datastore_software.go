@@ -10,12 +10,52 @@ type SaveHostSoftwareFunc func(host *fleet.Host) error
 
 type LoadHostSoftwareFunc func(host *fleet.Host) error
 
+type UpsertSoftwareMetadataFunc func(metadata []fleet.SoftwareMetadata) error
+
+type CountSoftwareVersionsFunc func(filter fleet.TeamFilter, opt fleet.SoftwareCountOptions) ([]fleet.SoftwareVersionCount, error)
+
+type HostSoftwareCountsBySourceFunc func(hostID uint) (map[string]uint, error)
+
+type LoadHostsSoftwareFunc func(hosts []*fleet.Host) error
+
+type HostHasSoftwareFunc func(hostID uint, name string, version string) (bool, error)
+
+type CountHostsMissingSoftwareFunc func(filter fleet.TeamFilter, teamID uint, name string, minVersion string) (uint, error)
+
+type HostSoftwareUpgradeCandidatesFunc func(hostID uint) ([]fleet.SoftwareUpgradeCandidate, error)
+
+type HostSoftwareDriftFromBaselineFunc func(hostID uint, baseline []fleet.Software, opt fleet.SoftwareDriftOptions) (missing, extra []fleet.Software, err error)
+
 type SoftwareStore struct {
 	SaveHostSoftwareFunc        SaveHostSoftwareFunc
 	SaveHostSoftwareFuncInvoked bool
 
 	LoadHostSoftwareFunc        LoadHostSoftwareFunc
 	LoadHostSoftwareFuncInvoked bool
+
+	UpsertSoftwareMetadataFunc        UpsertSoftwareMetadataFunc
+	UpsertSoftwareMetadataFuncInvoked bool
+
+	CountSoftwareVersionsFunc        CountSoftwareVersionsFunc
+	CountSoftwareVersionsFuncInvoked bool
+
+	HostSoftwareCountsBySourceFunc        HostSoftwareCountsBySourceFunc
+	HostSoftwareCountsBySourceFuncInvoked bool
+
+	LoadHostsSoftwareFunc        LoadHostsSoftwareFunc
+	LoadHostsSoftwareFuncInvoked bool
+
+	HostHasSoftwareFunc        HostHasSoftwareFunc
+	HostHasSoftwareFuncInvoked bool
+
+	CountHostsMissingSoftwareFunc        CountHostsMissingSoftwareFunc
+	CountHostsMissingSoftwareFuncInvoked bool
+
+	HostSoftwareUpgradeCandidatesFunc        HostSoftwareUpgradeCandidatesFunc
+	HostSoftwareUpgradeCandidatesFuncInvoked bool
+
+	HostSoftwareDriftFromBaselineFunc        HostSoftwareDriftFromBaselineFunc
+	HostSoftwareDriftFromBaselineFuncInvoked bool
 }
 
 func (s *SoftwareStore) SaveHostSoftware(host *fleet.Host) error {
@@ -27,3 +67,43 @@ func (s *SoftwareStore) LoadHostSoftware(host *fleet.Host) error {
 	s.LoadHostSoftwareFuncInvoked = true
 	return s.LoadHostSoftwareFunc(host)
 }
+
+func (s *SoftwareStore) UpsertSoftwareMetadata(metadata []fleet.SoftwareMetadata) error {
+	s.UpsertSoftwareMetadataFuncInvoked = true
+	return s.UpsertSoftwareMetadataFunc(metadata)
+}
+
+func (s *SoftwareStore) CountSoftwareVersions(filter fleet.TeamFilter, opt fleet.SoftwareCountOptions) ([]fleet.SoftwareVersionCount, error) {
+	s.CountSoftwareVersionsFuncInvoked = true
+	return s.CountSoftwareVersionsFunc(filter, opt)
+}
+
+func (s *SoftwareStore) HostSoftwareCountsBySource(hostID uint) (map[string]uint, error) {
+	s.HostSoftwareCountsBySourceFuncInvoked = true
+	return s.HostSoftwareCountsBySourceFunc(hostID)
+}
+
+func (s *SoftwareStore) LoadHostsSoftware(hosts []*fleet.Host) error {
+	s.LoadHostsSoftwareFuncInvoked = true
+	return s.LoadHostsSoftwareFunc(hosts)
+}
+
+func (s *SoftwareStore) HostHasSoftware(hostID uint, name string, version string) (bool, error) {
+	s.HostHasSoftwareFuncInvoked = true
+	return s.HostHasSoftwareFunc(hostID, name, version)
+}
+
+func (s *SoftwareStore) CountHostsMissingSoftware(filter fleet.TeamFilter, teamID uint, name string, minVersion string) (uint, error) {
+	s.CountHostsMissingSoftwareFuncInvoked = true
+	return s.CountHostsMissingSoftwareFunc(filter, teamID, name, minVersion)
+}
+
+func (s *SoftwareStore) HostSoftwareUpgradeCandidates(hostID uint) ([]fleet.SoftwareUpgradeCandidate, error) {
+	s.HostSoftwareUpgradeCandidatesFuncInvoked = true
+	return s.HostSoftwareUpgradeCandidatesFunc(hostID)
+}
+
+func (s *SoftwareStore) HostSoftwareDriftFromBaseline(hostID uint, baseline []fleet.Software, opt fleet.SoftwareDriftOptions) (missing, extra []fleet.Software, err error) {
+	s.HostSoftwareDriftFromBaselineFuncInvoked = true
+	return s.HostSoftwareDriftFromBaselineFunc(hostID, baseline, opt)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/go-kit/kit/endpoint"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// Add Global Policy
+////////////////////////////////////////////////////////////////////////////////
+
+type globalPolicyRequest struct {
+	QueryID uint `json:"query_id"`
+}
+
+type globalPolicyResponse struct {
+	Policy *fleet.Policy `json:"policy,omitempty"`
+	Err    error         `json:"error,omitempty"`
+}
+
+func (r globalPolicyResponse) error() error { return r.Err }
+
+func makeGlobalPolicyEndpoint(svc fleet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(globalPolicyRequest)
+		policy, err := svc.NewGlobalPolicy(ctx, req.QueryID)
+		if err != nil {
+			return globalPolicyResponse{Err: err}, nil
+		}
+		return globalPolicyResponse{Policy: policy}, nil
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// List Global Policies
+////////////////////////////////////////////////////////////////////////////////
+
+type listGlobalPoliciesResponse struct {
+	Policies []*fleet.Policy `json:"policies"`
+	Err      error           `json:"error,omitempty"`
+}
+
+func (r listGlobalPoliciesResponse) error() error { return r.Err }
+
+func makeListGlobalPoliciesEndpoint(svc fleet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		policies, err := svc.ListGlobalPolicies(ctx)
+		if err != nil {
+			return listGlobalPoliciesResponse{Err: err}, nil
+		}
+		return listGlobalPoliciesResponse{Policies: policies}, nil
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Delete Global Policies
+////////////////////////////////////////////////////////////////////////////////
+
+type deleteGlobalPoliciesRequest struct {
+	IDs []uint `json:"ids"`
+}
+
+type deleteGlobalPoliciesResponse struct {
+	Deleted []uint `json:"deleted,omitempty"`
+	Err     error  `json:"error,omitempty"`
+}
+
+func (r deleteGlobalPoliciesResponse) error() error { return r.Err }
+
+func makeDeleteGlobalPoliciesEndpoint(svc fleet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deleteGlobalPoliciesRequest)
+		deleted, err := svc.DeleteGlobalPolicies(ctx, req.IDs)
+		if err != nil {
+			return deleteGlobalPoliciesResponse{Err: err}, nil
+		}
+		return deleteGlobalPoliciesResponse{Deleted: deleted}, nil
+	}
+}
@@ -13,32 +13,48 @@ import (
 // rendering in the UI.
 type HostResponse struct {
 	*fleet.Host
-	Status      fleet.HostStatus `json:"status"`
-	DisplayText string           `json:"display_text"`
-	Labels      []fleet.Label    `json:"labels,omitempty"`
+	Status            fleet.HostStatus `json:"status"`
+	DisplayText       string           `json:"display_text"`
+	DaysSinceLastSeen *float64         `json:"days_since_last_seen"`
+	Labels            []fleet.Label    `json:"labels,omitempty"`
 }
 
 func hostResponseForHost(ctx context.Context, svc fleet.Service, host *fleet.Host) (*HostResponse, error) {
+	now := time.Now()
 	return &HostResponse{
-		Host:        host,
-		Status:      host.Status(time.Now()),
-		DisplayText: host.Hostname,
+		Host:              host,
+		Status:            host.Status(now),
+		DisplayText:       host.Hostname,
+		DaysSinceLastSeen: daysSinceLastSeen(host, now),
 	}, nil
 }
 
+// daysSinceLastSeen returns the days since the host was last seen, or nil if
+// it was never seen.
+func daysSinceLastSeen(host *fleet.Host, now time.Time) *float64 {
+	days := host.DaysSinceLastSeen(now)
+	if days == fleet.HostNeverSeen {
+		return nil
+	}
+	return &days
+}
+
 // HostDetailresponse is the response struct that contains the full host information
 // with the HostDetail details.
 type HostDetailResponse struct {
 	fleet.HostDetail
-	Status      fleet.HostStatus `json:"status"`
-	DisplayText string           `json:"display_text"`
+	Status            fleet.HostStatus `json:"status"`
+	DisplayText       string           `json:"display_text"`
+	DaysSinceLastSeen *float64         `json:"days_since_last_seen"`
 }
 
 func hostDetailResponseForHost(ctx context.Context, svc fleet.Service, host *fleet.HostDetail) (*HostDetailResponse, error) {
+	now := time.Now()
 	return &HostDetailResponse{
-		HostDetail:  *host,
-		Status:      host.Status(time.Now()),
-		DisplayText: host.Hostname,
+		HostDetail:        *host,
+		Status:            host.Status(now),
+		DisplayText:       host.Hostname,
+		DaysSinceLastSeen: daysSinceLastSeen(&host.Host, now),
 	}, nil
 }
 
@@ -47,7 +63,8 @@ func hostDetailResponseForHost(ctx context.Context, svc fleet.Service, host *fle
 ////////////////////////////////////////////////////////////////////////////////
 
 type getHostRequest struct {
-	ID uint `json:"id"`
+	ID           uint `json:"id"`
+	IncludeUsers bool `json:"include_users"`
 }
 
 type getHostResponse struct {
@@ -60,7 +77,7 @@ func (r getHostResponse) error() error { return r.Err }
 func makeGetHostEndpoint(svc fleet.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(getHostRequest)
-		host, err := svc.GetHost(ctx, req.ID)
+		host, err := svc.GetHost(ctx, req.ID, fleet.HostDetailOptions{IncludeUsers: req.IncludeUsers})
 		if err != nil {
 			return getHostResponse{Err: err}, nil
 		}
@@ -76,6 +93,60 @@ func makeGetHostEndpoint(svc fleet.Service) endpoint.Endpoint {
 	}
 }
 
+////////////////////////////////////////////////////////////////////////////////
+// List Host Users
+////////////////////////////////////////////////////////////////////////////////
+
+type listHostUsersRequest struct {
+	ID          uint
+	ListOptions fleet.ListOptions
+}
+
+type listHostUsersResponse struct {
+	Users []fleet.HostUser `json:"users"`
+	Count uint             `json:"count"`
+	Err   error            `json:"error,omitempty"`
+}
+
+func (r listHostUsersResponse) error() error { return r.Err }
+
+func makeListHostUsersEndpoint(svc fleet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listHostUsersRequest)
+		users, count, err := svc.ListHostUsers(ctx, req.ID, req.ListOptions)
+		if err != nil {
+			return listHostUsersResponse{Err: err}, nil
+		}
+		return listHostUsersResponse{Users: users, Count: count}, nil
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Host Software Upgrade Candidates
+////////////////////////////////////////////////////////////////////////////////
+
+type hostSoftwareUpgradeCandidatesRequest struct {
+	ID uint
+}
+
+type hostSoftwareUpgradeCandidatesResponse struct {
+	Software []fleet.SoftwareUpgradeCandidate `json:"software"`
+	Err      error                            `json:"error,omitempty"`
+}
+
+func (r hostSoftwareUpgradeCandidatesResponse) error() error { return r.Err }
+
+func makeHostSoftwareUpgradeCandidatesEndpoint(svc fleet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(hostSoftwareUpgradeCandidatesRequest)
+		software, err := svc.HostSoftwareUpgradeCandidates(ctx, req.ID)
+		if err != nil {
+			return hostSoftwareUpgradeCandidatesResponse{Err: err}, nil
+		}
+		return hostSoftwareUpgradeCandidatesResponse{Software: software}, nil
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // Get Host By Identifier
 ////////////////////////////////////////////////////////////////////////////////
@@ -143,15 +214,21 @@ func makeListHostsEndpoint(svc fleet.Service) endpoint.Endpoint {
 // Get Host Summary
 ////////////////////////////////////////////////////////////////////////////////
 
+type getHostSummaryRequest struct {
+	Cumulative bool
+}
+
 type getHostSummaryResponse struct {
 	fleet.HostSummary
-	Err error `json:"error,omitempty"`
+	Cumulative *fleet.CumulativeHostSummary `json:"cumulative,omitempty"`
+	Err        error                        `json:"error,omitempty"`
 }
 
 func (r getHostSummaryResponse) error() error { return r.Err }
 
 func makeGetHostSummaryEndpoint(svc fleet.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getHostSummaryRequest)
 		summary, err := svc.GetHostSummary(ctx)
 		if err != nil {
 			return getHostSummaryResponse{Err: err}, nil
@@ -160,6 +237,10 @@ func makeGetHostSummaryEndpoint(svc fleet.Service) endpoint.Endpoint {
 		resp := getHostSummaryResponse{
 			HostSummary: *summary,
 		}
+		if req.Cumulative {
+			cumulative := summary.Cumulative()
+			resp.Cumulative = &cumulative
+		}
 		return resp, nil
 	}
 }
@@ -169,7 +250,8 @@ func makeGetHostSummaryEndpoint(svc fleet.Service) endpoint.Endpoint {
 ////////////////////////////////////////////////////////////////////////////////
 
 type deleteHostRequest struct {
-	ID uint `json:"id"`
+	ID     uint   `json:"id"`
+	Reason string `json:"reason"`
 }
 
 type deleteHostResponse struct {
@@ -181,7 +263,7 @@ func (r deleteHostResponse) error() error { return r.Err }
 func makeDeleteHostEndpoint(svc fleet.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(deleteHostRequest)
-		err := svc.DeleteHost(ctx, req.ID)
+		err := svc.DeleteHost(ctx, req.ID, req.Reason)
 		if err != nil {
 			return deleteHostResponse{Err: err}, nil
 		}
@@ -199,6 +281,7 @@ type addHostsToTeamRequest struct {
 }
 
 type addHostsToTeamResponse struct {
+	fleet.HostTeamAssignment
 	Err error `json:"error,omitempty"`
 }
 
@@ -207,12 +290,12 @@ func (r addHostsToTeamResponse) error() error { return r.Err }
 func makeAddHostsToTeamEndpoint(svc fleet.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(addHostsToTeamRequest)
-		err := svc.AddHostsToTeam(ctx, req.TeamID, req.HostIDs)
+		assignment, err := svc.AddHostsToTeam(ctx, req.TeamID, req.HostIDs)
 		if err != nil {
 			return addHostsToTeamResponse{Err: err}, nil
 		}
 
-		return addHostsToTeamResponse{}, err
+		return addHostsToTeamResponse{HostTeamAssignment: assignment}, nil
 	}
 }
 
@@ -230,6 +313,7 @@ type addHostsToTeamByFilterRequest struct {
 }
 
 type addHostsToTeamByFilterResponse struct {
+	fleet.HostTeamAssignment
 	Err error `json:"error,omitempty"`
 }
 
@@ -244,12 +328,76 @@ func makeAddHostsToTeamByFilterEndpoint(svc fleet.Service) endpoint.Endpoint {
 			},
 			StatusFilter: req.Filters.Status,
 		}
-		err := svc.AddHostsToTeamByFilter(ctx, req.TeamID, listOpt, req.Filters.LabelID)
+		assignment, err := svc.AddHostsToTeamByFilter(ctx, req.TeamID, listOpt, req.Filters.LabelID)
 		if err != nil {
 			return addHostsToTeamByFilterResponse{Err: err}, nil
 		}
 
-		return addHostsToTeamByFilterResponse{}, err
+		return addHostsToTeamByFilterResponse{HostTeamAssignment: assignment}, nil
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Set Host Tags by Filter
+////////////////////////////////////////////////////////////////////////////////
+
+type setHostTagsByFilterRequest struct {
+	Tags    map[string]string `json:"tags"`
+	Filters struct {
+		MatchQuery string           `json:"query"`
+		Status     fleet.HostStatus `json:"status"`
+		LabelID    *uint            `json:"label_id"`
+	} `json:"filters"`
+}
+
+type setHostTagsByFilterResponse struct {
+	Count int   `json:"count"`
+	Err   error `json:"error,omitempty"`
+}
+
+func (r setHostTagsByFilterResponse) error() error { return r.Err }
+
+func makeSetHostTagsByFilterEndpoint(svc fleet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(setHostTagsByFilterRequest)
+		listOpt := fleet.HostListOptions{
+			ListOptions: fleet.ListOptions{
+				MatchQuery: req.Filters.MatchQuery,
+			},
+			StatusFilter: req.Filters.Status,
+		}
+		count, err := svc.SetHostTagsByFilter(ctx, listOpt, req.Filters.LabelID, req.Tags)
+		if err != nil {
+			return setHostTagsByFilterResponse{Err: err}, nil
+		}
+
+		return setHostTagsByFilterResponse{Count: count}, nil
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Set Host Owner
+////////////////////////////////////////////////////////////////////////////////
+
+type setHostOwnerRequest struct {
+	ID    uint   `json:"-"`
+	Email string `json:"email"`
+}
+
+type setHostOwnerResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r setHostOwnerResponse) error() error { return r.Err }
+
+func makeSetHostOwnerEndpoint(svc fleet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(setHostOwnerRequest)
+		err := svc.SetHostOwner(ctx, req.ID, req.Email)
+		if err != nil {
+			return setHostOwnerResponse{Err: err}, nil
+		}
+		return setHostOwnerResponse{}, nil
 	}
 }
 
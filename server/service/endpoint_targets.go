@@ -70,12 +70,15 @@ func makeSearchTargetsEndpoint(svc fleet.Service) endpoint.Endpoint {
 			Teams:  []teamSearchResult{},
 		}
 
+		now := time.Now()
+		statuses := fleet.ComputeStatuses(results.Hosts, now)
 		for _, host := range results.Hosts {
 			targets.Hosts = append(targets.Hosts,
 				hostSearchResult{
 					HostResponse{
-						Host:   host,
-						Status: host.Status(time.Now()),
+						Host:              host,
+						Status:            statuses[host.ID],
+						DaysSinceLastSeen: daysSinceLastSeen(host, now),
 					},
 					host.Hostname,
 				},
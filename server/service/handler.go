@@ -76,6 +76,9 @@ type FleetEndpoints struct {
 	GetGlobalSchedule                     endpoint.Endpoint
 	ModifyGlobalSchedule                  endpoint.Endpoint
 	DeleteGlobalSchedule                  endpoint.Endpoint
+	GlobalPolicy                          endpoint.Endpoint
+	ListGlobalPolicies                    endpoint.Endpoint
+	DeleteGlobalPolicies                  endpoint.Endpoint
 	EnrollAgent                           endpoint.Endpoint
 	GetClientConfig                       endpoint.Endpoint
 	GetDistributedQueries                 endpoint.Endpoint
@@ -97,10 +100,14 @@ type FleetEndpoints struct {
 	HostByIdentifier                      endpoint.Endpoint
 	DeleteHost                            endpoint.Endpoint
 	RefetchHost                           endpoint.Endpoint
+	ListHostUsers                         endpoint.Endpoint
+	HostSoftwareUpgradeCandidates         endpoint.Endpoint
+	SetHostOwner                          endpoint.Endpoint
 	ListHosts                             endpoint.Endpoint
 	GetHostSummary                        endpoint.Endpoint
 	AddHostsToTeam                        endpoint.Endpoint
 	AddHostsToTeamByFilter                endpoint.Endpoint
+	SetHostTagsByFilter                   endpoint.Endpoint
 	SearchTargets                         endpoint.Endpoint
 	GetCertificate                        endpoint.Endpoint
 	ChangeEmail                           endpoint.Endpoint
@@ -200,6 +207,9 @@ func MakeFleetServerEndpoints(svc fleet.Service, urlPrefix string, limitStore th
 		GetGlobalSchedule:                     authenticatedUser(svc, makeGetGlobalScheduleEndpoint(svc)),
 		ModifyGlobalSchedule:                  authenticatedUser(svc, makeModifyGlobalScheduleEndpoint(svc)),
 		DeleteGlobalSchedule:                  authenticatedUser(svc, makeDeleteGlobalScheduleEndpoint(svc)),
+		GlobalPolicy:                          authenticatedUser(svc, makeGlobalPolicyEndpoint(svc)),
+		ListGlobalPolicies:                    authenticatedUser(svc, makeListGlobalPoliciesEndpoint(svc)),
+		DeleteGlobalPolicies:                  authenticatedUser(svc, makeDeleteGlobalPoliciesEndpoint(svc)),
 		GetHost:                               authenticatedUser(svc, makeGetHostEndpoint(svc)),
 		HostByIdentifier:                      authenticatedUser(svc, makeHostByIdentifierEndpoint(svc)),
 		ListHosts:                             authenticatedUser(svc, makeListHostsEndpoint(svc)),
@@ -207,7 +217,11 @@ func MakeFleetServerEndpoints(svc fleet.Service, urlPrefix string, limitStore th
 		DeleteHost:                            authenticatedUser(svc, makeDeleteHostEndpoint(svc)),
 		AddHostsToTeam:                        authenticatedUser(svc, makeAddHostsToTeamEndpoint(svc)),
 		AddHostsToTeamByFilter:                authenticatedUser(svc, makeAddHostsToTeamByFilterEndpoint(svc)),
+		SetHostTagsByFilter:                   authenticatedUser(svc, makeSetHostTagsByFilterEndpoint(svc)),
 		RefetchHost:                           authenticatedUser(svc, makeRefetchHostEndpoint(svc)),
+		ListHostUsers:                         authenticatedUser(svc, makeListHostUsersEndpoint(svc)),
+		HostSoftwareUpgradeCandidates:         authenticatedUser(svc, makeHostSoftwareUpgradeCandidatesEndpoint(svc)),
+		SetHostOwner:                          authenticatedUser(svc, makeSetHostOwnerEndpoint(svc)),
 		CreateLabel:                           authenticatedUser(svc, makeCreateLabelEndpoint(svc)),
 		ModifyLabel:                           authenticatedUser(svc, makeModifyLabelEndpoint(svc)),
 		GetLabel:                              authenticatedUser(svc, makeGetLabelEndpoint(svc)),
@@ -312,6 +326,9 @@ type fleetHandlers struct {
 	GetGlobalSchedule                     http.Handler
 	ModifyGlobalSchedule                  http.Handler
 	DeleteGlobalSchedule                  http.Handler
+	GlobalPolicy                          http.Handler
+	ListGlobalPolicies                    http.Handler
+	DeleteGlobalPolicies                  http.Handler
 	EnrollAgent                           http.Handler
 	GetClientConfig                       http.Handler
 	GetDistributedQueries                 http.Handler
@@ -333,10 +350,14 @@ type fleetHandlers struct {
 	HostByIdentifier                      http.Handler
 	DeleteHost                            http.Handler
 	RefetchHost                           http.Handler
+	ListHostUsers                         http.Handler
+	HostSoftwareUpgradeCandidates         http.Handler
+	SetHostOwner                          http.Handler
 	ListHosts                             http.Handler
 	GetHostSummary                        http.Handler
 	AddHostsToTeam                        http.Handler
 	AddHostsToTeamByFilter                http.Handler
+	SetHostTagsByFilter                   http.Handler
 	SearchTargets                         http.Handler
 	GetCertificate                        http.Handler
 	ChangeEmail                           http.Handler
@@ -423,6 +444,9 @@ func makeKitHandlers(e FleetEndpoints, opts []kithttp.ServerOption) *fleetHandle
 		GetGlobalSchedule:                     newServer(e.GetGlobalSchedule, decodeGetGlobalScheduleRequest),
 		ModifyGlobalSchedule:                  newServer(e.ModifyGlobalSchedule, decodeModifyGlobalScheduleRequest),
 		DeleteGlobalSchedule:                  newServer(e.DeleteGlobalSchedule, decodeDeleteGlobalScheduleRequest),
+		GlobalPolicy:                          newServer(e.GlobalPolicy, decodeGlobalPolicyRequest),
+		ListGlobalPolicies:                    newServer(e.ListGlobalPolicies, decodeNoParamsRequest),
+		DeleteGlobalPolicies:                  newServer(e.DeleteGlobalPolicies, decodeDeleteGlobalPoliciesRequest),
 		EnrollAgent:                           newServer(e.EnrollAgent, decodeEnrollAgentRequest),
 		GetClientConfig:                       newServer(e.GetClientConfig, decodeGetClientConfigRequest),
 		GetDistributedQueries:                 newServer(e.GetDistributedQueries, decodeGetDistributedQueriesRequest),
@@ -444,10 +468,14 @@ func makeKitHandlers(e FleetEndpoints, opts []kithttp.ServerOption) *fleetHandle
 		HostByIdentifier:                      newServer(e.HostByIdentifier, decodeHostByIdentifierRequest),
 		DeleteHost:                            newServer(e.DeleteHost, decodeDeleteHostRequest),
 		RefetchHost:                           newServer(e.RefetchHost, decodeRefetchHostRequest),
+		ListHostUsers:                         newServer(e.ListHostUsers, decodeListHostUsersRequest),
+		HostSoftwareUpgradeCandidates:         newServer(e.HostSoftwareUpgradeCandidates, decodeHostSoftwareUpgradeCandidatesRequest),
+		SetHostOwner:                          newServer(e.SetHostOwner, decodeSetHostOwnerRequest),
 		ListHosts:                             newServer(e.ListHosts, decodeListHostsRequest),
-		GetHostSummary:                        newServer(e.GetHostSummary, decodeNoParamsRequest),
+		GetHostSummary:                        newServer(e.GetHostSummary, decodeGetHostSummaryRequest),
 		AddHostsToTeam:                        newServer(e.AddHostsToTeam, decodeAddHostsToTeamRequest),
 		AddHostsToTeamByFilter:                newServer(e.AddHostsToTeamByFilter, decodeAddHostsToTeamByFilterRequest),
+		SetHostTagsByFilter:                   newServer(e.SetHostTagsByFilter, decodeSetHostTagsByFilterRequest),
 		SearchTargets:                         newServer(e.SearchTargets, decodeSearchTargetsRequest),
 		GetCertificate:                        newServer(e.GetCertificate, decodeNoParamsRequest),
 		ChangeEmail:                           newServer(e.ChangeEmail, decodeChangeEmailRequest),
@@ -614,6 +642,10 @@ func attachFleetAPIRoutes(r *mux.Router, h *fleetHandlers) {
 	r.Handle("/api/v1/fleet/global/schedule/{id}", h.ModifyGlobalSchedule).Methods("PATCH").Name("modify_global_schedule")
 	r.Handle("/api/v1/fleet/global/schedule/{id}", h.DeleteGlobalSchedule).Methods("DELETE").Name("delete_global_schedule")
 
+	r.Handle("/api/v1/fleet/global/policies", h.GlobalPolicy).Methods("POST").Name("add_global_policy")
+	r.Handle("/api/v1/fleet/global/policies", h.ListGlobalPolicies).Methods("GET").Name("list_global_policies")
+	r.Handle("/api/v1/fleet/global/policies/delete", h.DeleteGlobalPolicies).Methods("POST").Name("delete_global_policies")
+
 	r.Handle("/api/v1/fleet/labels", h.CreateLabel).Methods("POST").Name("create_label")
 	r.Handle("/api/v1/fleet/labels/{id}", h.ModifyLabel).Methods("PATCH").Name("modify_label")
 	r.Handle("/api/v1/fleet/labels/{id}", h.GetLabel).Methods("GET").Name("get_label")
@@ -632,7 +664,11 @@ func attachFleetAPIRoutes(r *mux.Router, h *fleetHandlers) {
 	r.Handle("/api/v1/fleet/hosts/{id}", h.DeleteHost).Methods("DELETE").Name("delete_host")
 	r.Handle("/api/v1/fleet/hosts/transfer", h.AddHostsToTeam).Methods("POST").Name("add_hosts_to_team")
 	r.Handle("/api/v1/fleet/hosts/transfer/filter", h.AddHostsToTeamByFilter).Methods("POST").Name("add_hosts_to_team_by_filter")
+	r.Handle("/api/v1/fleet/hosts/tags/filter", h.SetHostTagsByFilter).Methods("POST").Name("set_host_tags_by_filter")
 	r.Handle("/api/v1/fleet/hosts/{id}/refetch", h.RefetchHost).Methods("POST").Name("refetch_host")
+	r.Handle("/api/v1/fleet/hosts/{id}/users", h.ListHostUsers).Methods("GET").Name("list_host_users")
+	r.Handle("/api/v1/fleet/hosts/{id}/software/upgrade_candidates", h.HostSoftwareUpgradeCandidates).Methods("GET").Name("host_software_upgrade_candidates")
+	r.Handle("/api/v1/fleet/hosts/{id}/owner", h.SetHostOwner).Methods("PATCH").Name("set_host_owner")
 
 	r.Handle("/api/v1/fleet/targets", h.SearchTargets).Methods("POST").Name("search_targets")
 
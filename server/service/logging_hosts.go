@@ -25,7 +25,7 @@ func (mw loggingMiddleware) ListHosts(ctx context.Context, opt fleet.HostListOpt
 	return hosts, err
 }
 
-func (mw loggingMiddleware) GetHost(ctx context.Context, id uint) (*fleet.HostDetail, error) {
+func (mw loggingMiddleware) GetHost(ctx context.Context, id uint, opt fleet.HostDetailOptions) (*fleet.HostDetail, error) {
 	var (
 		host *fleet.HostDetail
 		err  error
@@ -39,7 +39,7 @@ func (mw loggingMiddleware) GetHost(ctx context.Context, id uint) (*fleet.HostDe
 		)
 	}(time.Now())
 
-	host, err = mw.Service.GetHost(ctx, id)
+	host, err = mw.Service.GetHost(ctx, id, opt)
 	return host, err
 }
 
@@ -61,7 +61,7 @@ func (mw loggingMiddleware) GetHostSummary(ctx context.Context) (*fleet.HostSumm
 	return summary, err
 }
 
-func (mw loggingMiddleware) DeleteHost(ctx context.Context, id uint) error {
+func (mw loggingMiddleware) DeleteHost(ctx context.Context, id uint, reason string) error {
 	var (
 		err error
 	)
@@ -69,11 +69,12 @@ func (mw loggingMiddleware) DeleteHost(ctx context.Context, id uint) error {
 	defer func(begin time.Time) {
 		_ = mw.loggerInfo(err).Log(
 			"method", "DeleteHost",
+			"reason", reason,
 			"err", err,
 			"took", time.Since(begin),
 		)
 	}(time.Now())
 
-	err = mw.Service.DeleteHost(ctx, id)
+	err = mw.Service.DeleteHost(ctx, id, reason)
 	return err
 }
@@ -36,6 +36,8 @@ type Service struct {
 
 	seenHostSet *seenHostSet
 
+	enrollTeamRules []fleet.EnrollTeamRule
+
 	authz *authz.Authorizer
 }
 
@@ -56,6 +58,11 @@ func NewService(ds fleet.Datastore, resultStore fleet.QueryResultStore,
 		return nil, errors.Wrap(err, "new authorizer")
 	}
 
+	enrollTeamRules, err := fleet.ParseEnrollTeamRules(config.Osquery.EnrollTeamRules)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing enroll team rules")
+	}
+
 	svc = &Service{
 		ds:               ds,
 		carveStore:       carveStore,
@@ -68,6 +75,7 @@ func NewService(ds fleet.Datastore, resultStore fleet.QueryResultStore,
 		mailService:      mailService,
 		ssoSessionStore:  sso,
 		seenHostSet:      newSeenHostSet(),
+		enrollTeamRules:  enrollTeamRules,
 		license:          license,
 		authz:            authorizer,
 	}
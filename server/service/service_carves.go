@@ -6,6 +6,7 @@ import (
 	"time"
 
 	hostctx "github.com/fleetdm/fleet/v4/server/contexts/host"
+	"github.com/fleetdm/fleet/v4/server/contexts/viewer"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -61,7 +62,14 @@ func (svc *Service) CarveBegin(ctx context.Context, payload fleet.CarveBeginPayl
 		CreatedAt:  now,
 	}
 
-	carve, err = svc.carveStore.NewCarve(carve)
+	name := carve.Name
+	carve, err = svc.carveStore.NewCarve(carve, svc.config.Osquery.MaxActiveCarvesPerHost)
+	switch err {
+	case fleet.ErrDuplicateCarveName:
+		return nil, osqueryError{message: "carve name already in use: " + name}
+	case fleet.ErrCarveQuotaExceeded:
+		return nil, osqueryError{message: "too many carves in progress for host"}
+	}
 	if err != nil {
 		return nil, osqueryError{message: "internal error: new carve: " + err.Error()}
 	}
@@ -106,23 +114,56 @@ func (svc *Service) CarveBlock(ctx context.Context, payload fleet.CarveBlockPayl
 }
 
 func (svc *Service) GetCarve(ctx context.Context, id int64) (*fleet.CarveMetadata, error) {
-	if err := svc.authz.Authorize(ctx, &fleet.CarveMetadata{}, fleet.ActionRead); err != nil {
+	// First ensure the user has access to list carves, then check the
+	// specific carve once team_id is loaded.
+	if err := svc.authz.Authorize(ctx, &fleet.CarveMetadata{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	metadata, err := svc.carveStore.Carve(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "get carve")
+	}
+
+	if err := svc.authz.Authorize(ctx, metadata, fleet.ActionRead); err != nil {
 		return nil, err
 	}
 
-	return svc.carveStore.Carve(id)
+	return metadata, nil
 }
 
 func (svc *Service) ListCarves(ctx context.Context, opt fleet.CarveListOptions) ([]*fleet.CarveMetadata, error) {
-	if err := svc.authz.Authorize(ctx, &fleet.CarveMetadata{}, fleet.ActionRead); err != nil {
+	if err := svc.authz.Authorize(ctx, &fleet.CarveMetadata{}, fleet.ActionList); err != nil {
 		return nil, err
 	}
 
-	return svc.carveStore.ListCarves(opt)
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return nil, fleet.ErrNoContext
+	}
+
+	return svc.carveStore.ListCarves(carveTeamFilter(vc.User), opt)
+}
+
+// carveTeamFilter returns a filter matching the teams on which the user may
+// read carves. Only admins may read carves, so the teams where the user has
+// another role are left out of the filter.
+func carveTeamFilter(user *fleet.User) fleet.TeamFilter {
+	if user == nil || user.GlobalRole != nil {
+		return fleet.TeamFilter{User: user}
+	}
+	filtered := *user
+	filtered.Teams = nil
+	for _, team := range user.Teams {
+		if team.Role == fleet.RoleAdmin {
+			filtered.Teams = append(filtered.Teams, team)
+		}
+	}
+	return fleet.TeamFilter{User: &filtered}
 }
 
 func (svc *Service) GetBlock(ctx context.Context, carveId, blockId int64) ([]byte, error) {
-	if err := svc.authz.Authorize(ctx, &fleet.CarveMetadata{}, fleet.ActionRead); err != nil {
+	if err := svc.authz.Authorize(ctx, &fleet.CarveMetadata{}, fleet.ActionList); err != nil {
 		return nil, err
 	}
 
@@ -131,6 +172,10 @@ func (svc *Service) GetBlock(ctx context.Context, carveId, blockId int64) ([]byt
 		return nil, errors.Wrap(err, "get carve by name")
 	}
 
+	if err := svc.authz.Authorize(ctx, metadata, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
 	if metadata.Expired {
 		return nil, fmt.Errorf("cannot get block for expired carve")
 	}
@@ -7,10 +7,12 @@ import (
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/authz"
+	"github.com/fleetdm/fleet/v4/server/config"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 
 	hostctx "github.com/fleetdm/fleet/v4/server/contexts/host"
 	"github.com/fleetdm/fleet/v4/server/mock"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -34,7 +36,7 @@ func TestCarveBegin(t *testing.T) {
 		CarveSize:  23 * 64,
 		RequestId:  "carve_request",
 	}
-	ms.NewCarveFunc = func(metadata *fleet.CarveMetadata) (*fleet.CarveMetadata, error) {
+	ms.NewCarveFunc = func(metadata *fleet.CarveMetadata, maxActivePerHost int) (*fleet.CarveMetadata, error) {
 		metadata.ID = 7
 		return metadata, nil
 	}
@@ -60,7 +62,7 @@ func TestCarveBeginNewCarveError(t *testing.T) {
 	}
 	ms := new(mock.Store)
 	svc := &Service{carveStore: ms}
-	ms.NewCarveFunc = func(metadata *fleet.CarveMetadata) (*fleet.CarveMetadata, error) {
+	ms.NewCarveFunc = func(metadata *fleet.CarveMetadata, maxActivePerHost int) (*fleet.CarveMetadata, error) {
 		return nil, fmt.Errorf("ouch!")
 	}
 
@@ -71,6 +73,51 @@ func TestCarveBeginNewCarveError(t *testing.T) {
 	assert.Contains(t, err.Error(), "ouch!")
 }
 
+func TestCarveBeginDuplicateNameError(t *testing.T) {
+	host := fleet.Host{ID: 3, Hostname: "foo"}
+	payload := fleet.CarveBeginPayload{
+		BlockCount: 23,
+		BlockSize:  64,
+		CarveSize:  23 * 64,
+		RequestId:  "carve_request",
+	}
+	ms := new(mock.Store)
+	svc := &Service{carveStore: ms}
+	ms.NewCarveFunc = func(metadata *fleet.CarveMetadata, maxActivePerHost int) (*fleet.CarveMetadata, error) {
+		return nil, fleet.ErrDuplicateCarveName
+	}
+
+	ctx := hostctx.NewContext(context.Background(), host)
+
+	_, err := svc.CarveBegin(ctx, payload)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "carve name already in use")
+	assert.NotContains(t, err.Error(), "internal error")
+}
+
+func TestCarveBeginQuotaExceededError(t *testing.T) {
+	host := fleet.Host{ID: 3, Hostname: "foo"}
+	payload := fleet.CarveBeginPayload{
+		BlockCount: 23,
+		BlockSize:  64,
+		CarveSize:  23 * 64,
+		RequestId:  "carve_request",
+	}
+	ms := new(mock.Store)
+	svc := &Service{carveStore: ms, config: config.FleetConfig{Osquery: config.OsqueryConfig{MaxActiveCarvesPerHost: 4}}}
+	ms.NewCarveFunc = func(metadata *fleet.CarveMetadata, maxActivePerHost int) (*fleet.CarveMetadata, error) {
+		assert.Equal(t, 4, maxActivePerHost)
+		return nil, fleet.ErrCarveQuotaExceeded
+	}
+
+	ctx := hostctx.NewContext(context.Background(), host)
+
+	_, err := svc.CarveBegin(ctx, payload)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "too many carves in progress")
+	assert.NotContains(t, err.Error(), "internal error")
+}
+
 func TestCarveBeginEmptyError(t *testing.T) {
 	ms := new(mock.Store)
 	svc := &Service{carveStore: ms}
@@ -442,3 +489,54 @@ func TestCarveGetBlockGetBlockError(t *testing.T) {
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "yow!!")
 }
+
+func TestCarveListCarvesTeamFilter(t *testing.T) {
+	ms := new(mock.Store)
+	svc := &Service{carveStore: ms, authz: authz.Must()}
+	ms.ListCarvesFunc = func(filter fleet.TeamFilter, opt fleet.CarveListOptions) ([]*fleet.CarveMetadata, error) {
+		require.NotNil(t, filter.User)
+		require.Len(t, filter.User.Teams, 1)
+		assert.Equal(t, uint(1), filter.User.Teams[0].ID)
+		return []*fleet.CarveMetadata{}, nil
+	}
+
+	user := &fleet.User{
+		Teams: []fleet.UserTeam{
+			{Team: fleet.Team{ID: 1}, Role: fleet.RoleAdmin},
+			{Team: fleet.Team{ID: 2}, Role: fleet.RoleMaintainer},
+		},
+	}
+	_, err := svc.ListCarves(test.UserContext(user), fleet.CarveListOptions{})
+	require.NoError(t, err)
+	assert.True(t, ms.ListCarvesFuncInvoked)
+
+	// Team maintainers may not list carves at all
+	ms.ListCarvesFuncInvoked = false
+	maintainer := &fleet.User{
+		Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleMaintainer}},
+	}
+	_, err = svc.ListCarves(test.UserContext(maintainer), fleet.CarveListOptions{})
+	require.Error(t, err)
+	assert.False(t, ms.ListCarvesFuncInvoked)
+}
+
+func TestCarveGetCarveTeamAdmin(t *testing.T) {
+	ms := new(mock.Store)
+	svc := &Service{carveStore: ms, authz: authz.Must()}
+	ms.CarveFunc = func(carveId int64) (*fleet.CarveMetadata, error) {
+		return &fleet.CarveMetadata{ID: carveId, TeamID: ptr.Uint(uint(carveId))}, nil
+	}
+
+	user := &fleet.User{
+		Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 1}, Role: fleet.RoleAdmin}},
+	}
+	carve, err := svc.GetCarve(test.UserContext(user), 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), carve.ID)
+
+	_, err = svc.GetCarve(test.UserContext(user), 2)
+	require.Error(t, err)
+
+	_, err = svc.GetCarve(test.UserContext(test.UserAdmin), 2)
+	require.NoError(t, err)
+}
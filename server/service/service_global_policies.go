@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+)
+
+func (svc *Service) NewGlobalPolicy(ctx context.Context, queryID uint) (*fleet.Policy, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Policy{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	return svc.ds.NewGlobalPolicy(queryID)
+}
+
+func (svc *Service) ListGlobalPolicies(ctx context.Context) ([]*fleet.Policy, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Policy{}, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	return svc.ds.ListGlobalPolicies()
+}
+
+func (svc *Service) DeleteGlobalPolicies(ctx context.Context, ids []uint) ([]uint, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Policy{}, fleet.ActionWrite); err != nil {
+		return nil, err
+	}
+
+	return svc.ds.DeleteGlobalPolicies(ids)
+}
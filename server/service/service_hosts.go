@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"net/mail"
+	"strings"
 
 	"github.com/fleetdm/fleet/v4/server/contexts/viewer"
 	"github.com/fleetdm/fleet/v4/server/fleet"
@@ -19,10 +21,14 @@ func (svc Service) ListHosts(ctx context.Context, opt fleet.HostListOptions) ([]
 	}
 	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: true}
 
+	if err := opt.ValidateFields(); err != nil {
+		return nil, err
+	}
+
 	return svc.ds.ListHosts(filter, opt)
 }
 
-func (svc Service) GetHost(ctx context.Context, id uint) (*fleet.HostDetail, error) {
+func (svc Service) GetHost(ctx context.Context, id uint, opt fleet.HostDetailOptions) (*fleet.HostDetail, error) {
 	// First ensure the user has access to list hosts, then check the specific
 	// host once team_id is loaded.
 	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
@@ -39,9 +45,51 @@ func (svc Service) GetHost(ctx context.Context, id uint) (*fleet.HostDetail, err
 		return nil, err
 	}
 
+	if opt.IncludeUsers {
+		if err := svc.ds.LoadHostUsers(host); err != nil {
+			return nil, errors.Wrap(err, "load host users")
+		}
+	}
+
 	return svc.getHostDetails(ctx, host)
 }
 
+func (svc Service) ListHostUsers(ctx context.Context, id uint, opt fleet.ListOptions) ([]fleet.HostUser, uint, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, 0, err
+	}
+
+	host, err := svc.ds.Host(id)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "get host")
+	}
+
+	// Authorize again with team loaded now that we have team_id
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionRead); err != nil {
+		return nil, 0, err
+	}
+
+	return svc.ds.ListHostUsers(id, opt)
+}
+
+func (svc Service) HostSoftwareUpgradeCandidates(ctx context.Context, id uint) ([]fleet.SoftwareUpgradeCandidate, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionList); err != nil {
+		return nil, err
+	}
+
+	host, err := svc.ds.Host(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "get host")
+	}
+
+	// Authorize again with team loaded now that we have team_id
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionRead); err != nil {
+		return nil, err
+	}
+
+	return svc.ds.HostSoftwareUpgradeCandidates(id)
+}
+
 func (svc Service) HostByIdentifier(ctx context.Context, identifier string) (*fleet.HostDetail, error) {
 	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionRead); err != nil {
 		return nil, err
@@ -64,6 +112,9 @@ func (svc Service) getHostDetails(ctx context.Context, host *fleet.Host) (*fleet
 	if err := svc.ds.LoadHostSoftware(host); err != nil {
 		return nil, errors.Wrap(err, "load host software")
 	}
+	if err := svc.ds.LoadHostDisks(host); err != nil {
+		return nil, errors.Wrap(err, "load host disks")
+	}
 
 	labels, err := svc.ds.ListLabelsForHost(host.ID)
 	if err != nil {
@@ -88,19 +139,10 @@ func (svc Service) GetHostSummary(ctx context.Context) (*fleet.HostSummary, erro
 	}
 	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: true}
 
-	online, offline, mia, new, err := svc.ds.GenerateHostStatusStatistics(filter, svc.clock.Now())
-	if err != nil {
-		return nil, err
-	}
-	return &fleet.HostSummary{
-		OnlineCount:  online,
-		OfflineCount: offline,
-		MIACount:     mia,
-		NewCount:     new,
-	}, nil
+	return svc.ds.GenerateHostStatusStatistics(filter, svc.clock.Now())
 }
 
-func (svc Service) DeleteHost(ctx context.Context, id uint) error {
+func (svc Service) DeleteHost(ctx context.Context, id uint, reason string) error {
 	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionWrite); err != nil {
 		return err
 	}
@@ -115,7 +157,7 @@ func (svc Service) DeleteHost(ctx context.Context, id uint) error {
 		return err
 	}
 
-	return svc.ds.DeleteHost(id)
+	return svc.ds.DeleteHostWithReason(id, reason)
 }
 
 func (svc *Service) FlushSeenHosts(ctx context.Context) error {
@@ -125,34 +167,34 @@ func (svc *Service) FlushSeenHosts(ctx context.Context) error {
 	return svc.ds.MarkHostsSeen(hostIDs, svc.clock.Now())
 }
 
-func (svc Service) AddHostsToTeam(ctx context.Context, teamID *uint, hostIDs []uint) error {
+func (svc Service) AddHostsToTeam(ctx context.Context, teamID *uint, hostIDs []uint) (fleet.HostTeamAssignment, error) {
 	// This is currently treated as a "team write". If we ever give users
 	// besides global admins permissions to modify team hosts, we will need to
 	// check that the user has permissions for both the source and destination
 	// teams.
 	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionWrite); err != nil {
-		return err
+		return fleet.HostTeamAssignment{}, err
 	}
 
-	return svc.ds.AddHostsToTeam(teamID, hostIDs)
+	return svc.ds.AddHostsToTeam(teamID, hostIDs, true)
 }
 
-func (svc Service) AddHostsToTeamByFilter(ctx context.Context, teamID *uint, opt fleet.HostListOptions, lid *uint) error {
+func (svc Service) AddHostsToTeamByFilter(ctx context.Context, teamID *uint, opt fleet.HostListOptions, lid *uint) (fleet.HostTeamAssignment, error) {
 	// This is currently treated as a "team write". If we ever give users
 	// besides global admins permissions to modify team hosts, we will need to
 	// check that the user has permissions for both the source and destination
 	// teams.
 	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionWrite); err != nil {
-		return err
+		return fleet.HostTeamAssignment{}, err
 	}
 	vc, ok := viewer.FromContext(ctx)
 	if !ok {
-		return fleet.ErrNoContext
+		return fleet.HostTeamAssignment{}, fleet.ErrNoContext
 	}
 	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: true}
 
 	if opt.StatusFilter != "" && lid != nil {
-		return fleet.NewInvalidArgumentError("status", "may not be provided with label_id")
+		return fleet.HostTeamAssignment{}, fleet.NewInvalidArgumentError("status", "may not be provided with label_id")
 	}
 
 	opt.PerPage = fleet.PerPageUnlimited
@@ -166,11 +208,11 @@ func (svc Service) AddHostsToTeamByFilter(ctx context.Context, teamID *uint, opt
 		hosts, err = svc.ds.ListHosts(filter, opt)
 	}
 	if err != nil {
-		return err
+		return fleet.HostTeamAssignment{}, err
 	}
 
 	if len(hosts) == 0 {
-		return nil
+		return fleet.HostTeamAssignment{}, nil
 	}
 
 	hostIDs := make([]uint, 0, len(hosts))
@@ -179,7 +221,82 @@ func (svc Service) AddHostsToTeamByFilter(ctx context.Context, teamID *uint, opt
 	}
 
 	// Apply the team to the selected hosts.
-	return svc.ds.AddHostsToTeam(teamID, hostIDs)
+	return svc.ds.AddHostsToTeam(teamID, hostIDs, true)
+}
+
+func (svc Service) SetHostTagsByFilter(ctx context.Context, opt fleet.HostListOptions, lid *uint, tags map[string]string) (int, error) {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionWrite); err != nil {
+		return 0, err
+	}
+	vc, ok := viewer.FromContext(ctx)
+	if !ok {
+		return 0, fleet.ErrNoContext
+	}
+	filter := fleet.TeamFilter{User: vc.User, IncludeObserver: true}
+
+	if opt.StatusFilter != "" && lid != nil {
+		return 0, fleet.NewInvalidArgumentError("status", "may not be provided with label_id")
+	}
+	if len(tags) == 0 {
+		return 0, fleet.NewInvalidArgumentError("tags", "at least one tag must be provided")
+	}
+	for name := range tags {
+		if strings.TrimSpace(name) == "" {
+			return 0, fleet.NewInvalidArgumentError("tags", "tag names may not be empty")
+		}
+	}
+
+	opt.PerPage = fleet.PerPageUnlimited
+
+	// Load hosts, either from label if provided or from all hosts.
+	var hosts []*fleet.Host
+	var err error
+	if lid != nil {
+		hosts, err = svc.ds.ListHostsInLabel(filter, *lid, opt)
+	} else {
+		hosts, err = svc.ds.ListHosts(filter, opt)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(hosts) == 0 {
+		return 0, nil
+	}
+
+	hostIDs := make([]uint, 0, len(hosts))
+	for _, h := range hosts {
+		hostIDs = append(hostIDs, h.ID)
+	}
+
+	if err := svc.ds.SetHostTags(hostIDs, tags); err != nil {
+		return 0, errors.Wrap(err, "set host tags")
+	}
+	return len(hostIDs), nil
+}
+
+func (svc *Service) SetHostOwner(ctx context.Context, id uint, email string) error {
+	if err := svc.authz.Authorize(ctx, &fleet.Host{}, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	email = strings.TrimSpace(email)
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return fleet.NewInvalidArgumentError("email", "must be a valid email address")
+		}
+	}
+
+	host, err := svc.ds.Host(id)
+	if err != nil {
+		return errors.Wrap(err, "find host for owner")
+	}
+
+	if err := svc.authz.Authorize(ctx, host, fleet.ActionWrite); err != nil {
+		return err
+	}
+
+	return svc.ds.SetHostOwner(id, email)
 }
 
 func (svc *Service) RefetchHost(ctx context.Context, id uint) error {
@@ -27,7 +27,7 @@ func TestListHosts(t *testing.T) {
 	storedTime := time.Now()
 
 	_, err = ds.NewHost(&fleet.Host{
-		Hostname: "foo",
+		Hostname:       "foo",
 		LastEnrolledAt: storedTime,
 	})
 	assert.Nil(t, err)
@@ -38,6 +38,16 @@ func TestListHosts(t *testing.T) {
 	assert.Equal(t, storedTime, hosts[0].LastEnrolledAt)
 }
 
+func TestListHostsInvalidFields(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	_, err := svc.ListHosts(test.UserContext(test.UserAdmin), fleet.HostListOptions{Fields: []string{"hostname", "node_key"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "node_key")
+	assert.False(t, ds.ListHostsFuncInvoked)
+}
+
 func TestDeleteHost(t *testing.T) {
 	ds, err := inmem.New(config.TestConfig())
 	assert.Nil(t, err)
@@ -50,7 +60,7 @@ func TestDeleteHost(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotZero(t, host.ID)
 
-	err = svc.DeleteHost(test.UserContext(test.UserAdmin), host.ID)
+	err = svc.DeleteHost(test.UserContext(test.UserAdmin), host.ID, "")
 	assert.Nil(t, err)
 
 	filter := fleet.TeamFilter{User: test.UserAdmin}
@@ -60,6 +70,31 @@ func TestDeleteHost(t *testing.T) {
 
 }
 
+func TestDeleteHostReason(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	ds.HostFunc = func(id uint) (*fleet.Host, error) {
+		return &fleet.Host{ID: id}, nil
+	}
+	var deletedID uint
+	var deletedReason string
+	ds.DeleteHostWithReasonFunc = func(hid uint, reason string) error {
+		deletedID, deletedReason = hid, reason
+		return nil
+	}
+
+	err := svc.DeleteHost(test.UserContext(test.UserObserver), 3, "decommissioned")
+	require.Error(t, err)
+	assert.False(t, ds.DeleteHostWithReasonFuncInvoked)
+
+	err = svc.DeleteHost(test.UserContext(test.UserAdmin), 3, "decommissioned")
+	require.NoError(t, err)
+	assert.True(t, ds.DeleteHostWithReasonFuncInvoked)
+	assert.Equal(t, uint(3), deletedID)
+	assert.Equal(t, "decommissioned", deletedReason)
+}
+
 func TestHostDetails(t *testing.T) {
 	ds := new(mock.Store)
 	svc := &Service{ds: ds}
@@ -88,11 +123,32 @@ func TestHostDetails(t *testing.T) {
 	ds.LoadHostSoftwareFunc = func(host *fleet.Host) error {
 		return nil
 	}
+	expectedDisks := []fleet.HostDisk{{Name: "/dev/sda", Size: 512110190592, Type: "disk"}}
+	ds.LoadHostDisksFunc = func(host *fleet.Host) error {
+		host.Disks = expectedDisks
+		return nil
+	}
 
 	hostDetail, err := svc.getHostDetails(test.UserContext(test.UserAdmin), host)
 	require.NoError(t, err)
 	assert.Equal(t, expectedLabels, hostDetail.Labels)
 	assert.Equal(t, expectedPacks, hostDetail.Packs)
+	assert.Equal(t, expectedDisks, hostDetail.Disks)
+}
+
+func TestGetHostSummary(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	expected := &fleet.HostSummary{OnlineCount: 1, OfflineCount: 2, MIACount: 3, NewCount: 4, TotalCount: 6}
+	ds.GenerateHostStatusStatisticsFunc = func(filter fleet.TeamFilter, now time.Time) (*fleet.HostSummary, error) {
+		assert.Equal(t, test.UserAdmin, filter.User)
+		return expected, nil
+	}
+
+	summary, err := svc.GetHostSummary(test.UserContext(test.UserAdmin))
+	require.NoError(t, err)
+	assert.Equal(t, expected, summary)
 }
 
 func TestRefetchHost(t *testing.T) {
@@ -112,6 +168,129 @@ func TestRefetchHost(t *testing.T) {
 	require.NoError(t, svc.RefetchHost(test.UserContext(test.UserAdmin), host.ID))
 }
 
+func TestGetHostIncludeUsers(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	ds.HostFunc = func(hid uint) (*fleet.Host, error) {
+		return &fleet.Host{ID: hid}, nil
+	}
+	ds.LoadHostSoftwareFunc = func(host *fleet.Host) error {
+		return nil
+	}
+	ds.LoadHostDisksFunc = func(host *fleet.Host) error {
+		return nil
+	}
+	ds.ListLabelsForHostFunc = func(hid uint) ([]*fleet.Label, error) {
+		return nil, nil
+	}
+	ds.ListPacksForHostFunc = func(hid uint) ([]*fleet.Pack, error) {
+		return nil, nil
+	}
+	expectedUsers := []fleet.HostUser{{Uid: 42, Username: "user"}}
+	ds.LoadHostUsersFunc = func(host *fleet.Host) error {
+		host.Users = expectedUsers
+		return nil
+	}
+
+	host, err := svc.GetHost(test.UserContext(test.UserAdmin), 3, fleet.HostDetailOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, host.Users)
+	assert.False(t, ds.LoadHostUsersFuncInvoked)
+
+	host, err = svc.GetHost(test.UserContext(test.UserAdmin), 3, fleet.HostDetailOptions{IncludeUsers: true})
+	require.NoError(t, err)
+	assert.Equal(t, expectedUsers, host.Users)
+	assert.True(t, ds.LoadHostUsersFuncInvoked)
+}
+
+func TestListHostUsers(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	teamID := uint(1)
+	ds.HostFunc = func(hid uint) (*fleet.Host, error) {
+		return &fleet.Host{ID: hid, TeamID: &teamID}, nil
+	}
+	ds.ListHostUsersFunc = func(hostID uint, opt fleet.ListOptions) ([]fleet.HostUser, uint, error) {
+		assert.Equal(t, uint(3), hostID)
+		assert.Equal(t, uint(10), opt.PerPage)
+		return []fleet.HostUser{{Uid: 42, Username: "user"}}, 12, nil
+	}
+
+	users, count, err := svc.ListHostUsers(test.UserContext(test.UserAdmin), 3, fleet.ListOptions{PerPage: 10})
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, uint(12), count)
+
+	// A team observer of another team can't list the users
+	otherTeam := &fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleObserver}}}
+	ds.ListHostUsersFuncInvoked = false
+	_, _, err = svc.ListHostUsers(test.UserContext(otherTeam), 3, fleet.ListOptions{PerPage: 10})
+	require.Error(t, err)
+	assert.False(t, ds.ListHostUsersFuncInvoked)
+}
+
+func TestHostSoftwareUpgradeCandidates(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	teamID := uint(1)
+	ds.HostFunc = func(hid uint) (*fleet.Host, error) {
+		return &fleet.Host{ID: hid, TeamID: &teamID}, nil
+	}
+	ds.HostSoftwareUpgradeCandidatesFunc = func(hostID uint) ([]fleet.SoftwareUpgradeCandidate, error) {
+		assert.Equal(t, uint(3), hostID)
+		return []fleet.SoftwareUpgradeCandidate{
+			{Current: fleet.Software{Name: "curl", Version: "7.68.0", Source: "deb_packages"}, LatestSeen: "7.74.0"},
+		}, nil
+	}
+
+	software, err := svc.HostSoftwareUpgradeCandidates(test.UserContext(test.UserAdmin), 3)
+	require.NoError(t, err)
+	require.Len(t, software, 1)
+	assert.Equal(t, "7.74.0", software[0].LatestSeen)
+
+	// A team observer of another team can't list the software
+	otherTeam := &fleet.User{Teams: []fleet.UserTeam{{Team: fleet.Team{ID: 2}, Role: fleet.RoleObserver}}}
+	ds.HostSoftwareUpgradeCandidatesFuncInvoked = false
+	_, err = svc.HostSoftwareUpgradeCandidates(test.UserContext(otherTeam), 3)
+	require.Error(t, err)
+	assert.False(t, ds.HostSoftwareUpgradeCandidatesFuncInvoked)
+}
+
+func TestSetHostOwner(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	host := &fleet.Host{ID: 3}
+	ds.HostFunc = func(hid uint) (*fleet.Host, error) {
+		return host, nil
+	}
+	var owner string
+	ds.SetHostOwnerFunc = func(hostID uint, email string) error {
+		assert.Equal(t, host.ID, hostID)
+		owner = email
+		return nil
+	}
+
+	require.NoError(t, svc.SetHostOwner(test.UserContext(test.UserAdmin), host.ID, " jane@example.com "))
+	assert.Equal(t, "jane@example.com", owner)
+
+	require.NoError(t, svc.SetHostOwner(test.UserContext(test.UserAdmin), host.ID, ""))
+	assert.Equal(t, "", owner)
+	assert.True(t, ds.SetHostOwnerFuncInvoked)
+
+	ds.SetHostOwnerFuncInvoked = false
+	err := svc.SetHostOwner(test.UserContext(test.UserAdmin), host.ID, "not an email")
+	require.Error(t, err)
+	assert.False(t, ds.SetHostOwnerFuncInvoked)
+
+	err = svc.SetHostOwner(test.UserContext(test.UserObserver), host.ID, "jane@example.com")
+	require.Error(t, err)
+	assert.False(t, ds.SetHostOwnerFuncInvoked)
+}
+
 func TestAddHostsToTeamByFilter(t *testing.T) {
 	ds := new(mock.Store)
 	svc := newTestService(ds, nil, nil)
@@ -126,13 +305,16 @@ func TestAddHostsToTeamByFilter(t *testing.T) {
 		}
 		return hosts, nil
 	}
-	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint) error {
+	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint, refetchLabels bool) (fleet.HostTeamAssignment, error) {
 		assert.Equal(t, expectedTeam, teamID)
 		assert.Equal(t, expectedHostIDs, hostIDs)
-		return nil
+		assert.True(t, refetchLabels)
+		return fleet.HostTeamAssignment{Changed: 2, AlreadyAssigned: 1}, nil
 	}
 
-	require.NoError(t, svc.AddHostsToTeamByFilter(test.UserContext(test.UserAdmin), expectedTeam, fleet.HostListOptions{}, nil))
+	assignment, err := svc.AddHostsToTeamByFilter(test.UserContext(test.UserAdmin), expectedTeam, fleet.HostListOptions{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, fleet.HostTeamAssignment{Changed: 2, AlreadyAssigned: 1}, assignment)
 }
 
 func TestAddHostsToTeamByFilterLabel(t *testing.T) {
@@ -151,12 +333,13 @@ func TestAddHostsToTeamByFilterLabel(t *testing.T) {
 		}
 		return hosts, nil
 	}
-	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint) error {
+	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint, refetchLabels bool) (fleet.HostTeamAssignment, error) {
 		assert.Equal(t, expectedHostIDs, hostIDs)
-		return nil
+		return fleet.HostTeamAssignment{}, nil
 	}
 
-	require.NoError(t, svc.AddHostsToTeamByFilter(test.UserContext(test.UserAdmin), expectedTeam, fleet.HostListOptions{}, expectedLabel))
+	_, err := svc.AddHostsToTeamByFilter(test.UserContext(test.UserAdmin), expectedTeam, fleet.HostListOptions{}, expectedLabel)
+	require.NoError(t, err)
 }
 
 func TestAddHostsToTeamByFilterEmptyHosts(t *testing.T) {
@@ -166,10 +349,93 @@ func TestAddHostsToTeamByFilterEmptyHosts(t *testing.T) {
 	ds.ListHostsFunc = func(filter fleet.TeamFilter, opt fleet.HostListOptions) ([]*fleet.Host, error) {
 		return []*fleet.Host{}, nil
 	}
-	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint) error {
+	ds.AddHostsToTeamFunc = func(teamID *uint, hostIDs []uint, refetchLabels bool) (fleet.HostTeamAssignment, error) {
 		t.Error("add hosts func should not have been called")
+		return fleet.HostTeamAssignment{}, nil
+	}
+
+	_, err := svc.AddHostsToTeamByFilter(test.UserContext(test.UserAdmin), nil, fleet.HostListOptions{}, nil)
+	require.NoError(t, err)
+}
+
+func TestSetHostTagsByFilter(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	expectedHostIDs := []uint{1, 2, 4}
+	expectedTags := map[string]string{"env": "prod"}
+
+	ds.ListHostsFunc = func(filter fleet.TeamFilter, opt fleet.HostListOptions) ([]*fleet.Host, error) {
+		assert.Equal(t, "foo", opt.MatchQuery)
+		var hosts []*fleet.Host
+		for _, id := range expectedHostIDs {
+			hosts = append(hosts, &fleet.Host{ID: id})
+		}
+		return hosts, nil
+	}
+	ds.SetHostTagsFunc = func(hostIDs []uint, tags map[string]string) error {
+		assert.Equal(t, expectedHostIDs, hostIDs)
+		assert.Equal(t, expectedTags, tags)
+		return nil
+	}
+
+	opt := fleet.HostListOptions{ListOptions: fleet.ListOptions{MatchQuery: "foo"}}
+	count, err := svc.SetHostTagsByFilter(test.UserContext(test.UserAdmin), opt, nil, expectedTags)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.True(t, ds.SetHostTagsFuncInvoked)
+}
+
+func TestSetHostTagsByFilterLabel(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	expectedLabel := ptr.Uint(2)
+	ds.ListHostsInLabelFunc = func(filter fleet.TeamFilter, lid uint, opt fleet.HostListOptions) ([]*fleet.Host, error) {
+		assert.Equal(t, *expectedLabel, lid)
+		return []*fleet.Host{{ID: 6}}, nil
+	}
+	ds.SetHostTagsFunc = func(hostIDs []uint, tags map[string]string) error {
+		assert.Equal(t, []uint{6}, hostIDs)
+		return nil
+	}
+
+	count, err := svc.SetHostTagsByFilter(test.UserContext(test.UserAdmin), fleet.HostListOptions{}, expectedLabel, map[string]string{"env": "prod"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestSetHostTagsByFilterEmptyHosts(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	ds.ListHostsFunc = func(filter fleet.TeamFilter, opt fleet.HostListOptions) ([]*fleet.Host, error) {
+		return []*fleet.Host{}, nil
+	}
+	ds.SetHostTagsFunc = func(hostIDs []uint, tags map[string]string) error {
+		t.Error("set host tags func should not have been called")
 		return nil
 	}
 
-	require.NoError(t, svc.AddHostsToTeamByFilter(test.UserContext(test.UserAdmin), nil, fleet.HostListOptions{}, nil))
+	count, err := svc.SetHostTagsByFilter(test.UserContext(test.UserAdmin), fleet.HostListOptions{}, nil, map[string]string{"env": "prod"})
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestSetHostTagsByFilterInvalid(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	ctx := test.UserContext(test.UserAdmin)
+	_, err := svc.SetHostTagsByFilter(ctx, fleet.HostListOptions{}, nil, nil)
+	require.Error(t, err)
+	_, err = svc.SetHostTagsByFilter(ctx, fleet.HostListOptions{}, nil, map[string]string{" ": "prod"})
+	require.Error(t, err)
+	_, err = svc.SetHostTagsByFilter(ctx, fleet.HostListOptions{StatusFilter: fleet.StatusOnline}, ptr.Uint(1), map[string]string{"env": "prod"})
+	require.Error(t, err)
+	assert.False(t, ds.ListHostsFuncInvoked)
+	assert.False(t, ds.ListHostsInLabelFuncInvoked)
+
+	_, err = svc.SetHostTagsByFilter(test.UserContext(test.UserObserver), fleet.HostListOptions{}, nil, map[string]string{"env": "prod"})
+	require.Error(t, err)
 }
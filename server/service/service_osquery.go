@@ -14,9 +14,11 @@ import (
 	"github.com/fleetdm/fleet/v4/server/fleet"
 
 	hostctx "github.com/fleetdm/fleet/v4/server/contexts/host"
+	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/pubsub"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/pkg/errors"
 	"github.com/spf13/cast"
 )
@@ -57,6 +59,13 @@ func (svc Service) AuthenticateHost(ctx context.Context, nodeKey string) (*fleet
 
 	host, err := svc.ds.AuthenticateHost(nodeKey)
 	if err != nil {
+		if err == fleet.ErrHostDecommissioned {
+			// The node key is not invalid, so that osquery doesn't retry
+			// enrolling a host that will be refused anyway.
+			return nil, osqueryError{
+				message: "authentication error: host decommissioned",
+			}
+		}
 		switch err.(type) {
 		case fleet.NotFoundError:
 			return nil, osqueryError{
@@ -82,6 +91,16 @@ func (svc Service) AuthenticateHost(ctx context.Context, nodeKey string) (*fleet
 	return host, nil
 }
 
+// remoteIP returns the IP of the client that made the request, without the
+// port. It returns an empty string if the request address is unknown.
+func remoteIP(ctx context.Context) string {
+	addr, _ := ctx.Value(kithttp.ContextKeyRequestRemoteAddr).(string)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
 func (svc Service) EnrollAgent(ctx context.Context, enrollSecret, hostIdentifier string, hostDetails map[string](map[string]string)) (string, error) {
 	// skipauth: Authorization is currently for user endpoints only.
 	svc.authz.SkipAuthorization(ctx)
@@ -104,10 +123,39 @@ func (svc Service) EnrollAgent(ctx context.Context, enrollSecret, hostIdentifier
 
 	hostIdentifier = getHostIdentifier(svc.logger, svc.config.Osquery.HostIdentifier, hostIdentifier, hostDetails)
 
-	host, err := svc.ds.EnrollHost(hostIdentifier, nodeKey, secret.TeamID, svc.config.Osquery.EnrollCooldown)
+	teamID := secret.TeamID
+	if teamID == nil {
+		teamID = svc.enrollTeamFromRules(hostIdentifier, hostDetails)
+	}
+
+	host, err := svc.ds.EnrollHost(hostIdentifier, nodeKey, teamID, svc.config.Osquery.EnrollCooldown, fleet.EnrollHostOptions{
+		Strategy:       fleet.EnrollStrategy(svc.config.Osquery.EnrollStrategy),
+		TeamChange:     fleet.EnrollTeamChange(svc.config.Osquery.EnrollTeamChange),
+		EnrolledFromIP: remoteIP(ctx),
+		UUID:           hostDetails["system_info"]["uuid"],
+		HardwareSerial: hostDetails["system_info"]["hardware_serial"],
+	})
 	if err != nil {
 		return "", osqueryError{message: "save enroll failed: " + err.Error(), nodeInvalid: true}
 	}
+	if !fleet.SameTeam(host.TeamID, teamID) {
+		// The host was kept on its team, the change is applied by
+		// transferring the host once confirmed.
+		level.Info(svc.logger).Log(
+			"msg", "host team change from enroll secret requires confirmation",
+			"host", host.ID,
+			"osquery_host_id", hostIdentifier,
+		)
+	}
+	if err := svc.ds.RecordHostEnrollSecret(host.ID, enrollSecret); err != nil {
+		// Enroll secret usage is informational only, the enrollment
+		// proceeds regardless.
+		level.Info(svc.logger).Log(
+			"msg", "could not record host enroll secret",
+			"host", host.ID,
+			"err", err,
+		)
+	}
 
 	// Save enrollment details if provided
 	save := false
@@ -132,6 +180,28 @@ func (svc Service) EnrollAgent(ctx context.Context, enrollSecret, hostIdentifier
 	return host.NodeKey, nil
 }
 
+// enrollTeamFromRules returns the team of the first enroll team rule
+// matching the details of a host enrolling without a team. It returns nil if
+// no rule matches or the team of the matching rule doesn't exist, so that the
+// host enrolls without a team.
+func (svc Service) enrollTeamFromRules(hostIdentifier string, hostDetails map[string](map[string]string)) *uint {
+	name, ok := fleet.MatchEnrollTeamRules(svc.enrollTeamRules, fleet.EnrollAttributes(hostDetails))
+	if !ok {
+		return nil
+	}
+	team, err := svc.ds.TeamByName(name)
+	if err != nil {
+		level.Info(svc.logger).Log(
+			"msg", "could not get team of enroll team rule",
+			"team", name,
+			"osquery_host_id", hostIdentifier,
+			"err", err,
+		)
+		return nil
+	}
+	return &team.ID
+}
+
 func getHostIdentifier(logger log.Logger, identifierOption, providedIdentifier string, details map[string](map[string]string)) string {
 	switch identifierOption {
 	case "provided":
@@ -336,6 +406,10 @@ func (svc *Service) SubmitResultLogs(ctx context.Context, logs []json.RawMessage
 // osqueryd writes the distributed query results.
 const hostLabelQueryPrefix = "fleet_label_query_"
 
+// hostPolicyQueryPrefix is appended before the policy ID when a query is
+// provided as a policy query.
+const hostPolicyQueryPrefix = "fleet_policy_query_"
+
 // hostDetailQueryPrefix is appended before the query name when a query is
 // provided as a detail query.
 const hostDetailQueryPrefix = "fleet_detail_query_"
@@ -374,7 +448,13 @@ func (q *detailQuery) runForPlatform(platform string) bool {
 // fleet.Host data model. This map should not be modified at runtime.
 var detailQueries = map[string]detailQuery{
 	"network_interface": {
-		Query: `select address, mac
+		Query: `with default_routes as (
+                          select interface from routes
+                          where destination in ('0.0.0.0', '::') and netmask = 0
+                        )
+                        select address, mac,
+                          (ia.interface in (select interface from default_routes)
+                           or ia.address in (select interface from default_routes)) as default_route
                         from interface_details id join interface_addresses ia
                                on ia.interface = id.interface where length(mac) > 0
                                order by (ibytes + obytes) desc`,
@@ -385,49 +465,18 @@ var detailQueries = map[string]detailQuery{
 				return nil
 			}
 
-			// Rows are ordered by traffic, so we will get the most active
-			// interface by iterating in order
-			var firstIPv4, firstIPv6 map[string]string
+			// Rows are ordered by traffic, which the primary interface
+			// selection relies on. The primary interface is picked when
+			// the host is saved.
+			nics := make([]*fleet.NetworkInterface, 0, len(rows))
 			for _, row := range rows {
-				ip := net.ParseIP(row["address"])
-				if ip == nil {
-					continue
-				}
-
-				// Skip link-local and loopback interfaces
-				if ip.IsLinkLocalUnicast() || ip.IsLoopback() {
-					continue
-				}
-
-				if strings.Contains(row["address"], ":") {
-					//IPv6
-					if firstIPv6 == nil {
-						firstIPv6 = row
-					}
-				} else {
-					// IPv4
-					if firstIPv4 == nil {
-						firstIPv4 = row
-					}
-				}
+				nics = append(nics, &fleet.NetworkInterface{
+					IPAddress:    row["address"],
+					MAC:          row["mac"],
+					DefaultRoute: row["default_route"] == "1",
+				})
 			}
-
-			var selected map[string]string
-			switch {
-			// Prefer IPv4
-			case firstIPv4 != nil:
-				selected = firstIPv4
-			// Otherwise IPv6
-			case firstIPv6 != nil:
-				selected = firstIPv6
-			// If only link-local and loopback found, still use the first
-			// interface so that we don't get an empty value.
-			default:
-				selected = rows[0]
-			}
-
-			host.PrimaryIP = selected["address"]
-			host.PrimaryMac = selected["mac"]
+			host.NetworkInterfaces = nics
 			return nil
 		},
 	},
@@ -595,6 +644,179 @@ var detailQueries = map[string]detailQuery{
 			return nil
 		},
 	},
+	"timezone": {
+		Query: "select local_timezone from time limit 1",
+		IngestFunc: func(logger log.Logger, host *fleet.Host, rows []map[string]string) error {
+			if len(rows) != 1 {
+				logger.Log("component", "service", "method", "IngestFunc", "err",
+					fmt.Sprintf("detail_query_timezone expected single result got %d", len(rows)))
+				return nil
+			}
+
+			host.Timezone = rows[0]["local_timezone"]
+			return nil
+		},
+	},
+	"kernel_info": {
+		Query: "select version from kernel_info limit 1",
+		IngestFunc: func(logger log.Logger, host *fleet.Host, rows []map[string]string) error {
+			if len(rows) != 1 {
+				logger.Log("component", "service", "method", "IngestFunc", "err",
+					fmt.Sprintf("detail_query_kernel_info expected single result got %d", len(rows)))
+				return nil
+			}
+
+			host.KernelVersion = rows[0]["version"]
+			return nil
+		},
+	},
+	"cloud_instance": {
+		// The instance metadata tables return no rows on hosts that aren't
+		// running in their cloud.
+		Query: `select 'aws' as provider, instance_id from ec2_instance_metadata
+			union all
+			select 'azure' as provider, vm_id as instance_id from azure_instance_metadata`,
+		IngestFunc: func(logger log.Logger, host *fleet.Host, rows []map[string]string) error {
+			host.CloudProvider = ""
+			host.CloudInstanceID = ""
+			for _, row := range rows {
+				if row["instance_id"] != "" {
+					host.CloudProvider = row["provider"]
+					host.CloudInstanceID = row["instance_id"]
+					break
+				}
+			}
+			return nil
+		},
+	},
+	"mdm": {
+		Query:     `select enrolled, server_url from mdm`,
+		Platforms: []string{"darwin"},
+		IngestFunc: func(logger log.Logger, host *fleet.Host, rows []map[string]string) error {
+			if len(rows) != 1 {
+				logger.Log("component", "service", "method", "IngestFunc", "err",
+					fmt.Sprintf("detail_query_mdm expected single result got %d", len(rows)))
+				return nil
+			}
+
+			enrolled := rows[0]["enrolled"] == "1"
+			host.MDMEnrolled = &enrolled
+			host.MDMServerURL = ""
+			if enrolled {
+				host.MDMServerURL = rows[0]["server_url"]
+			}
+			return nil
+		},
+	},
+	"mdm_windows": {
+		// Windows keeps a registry key for each enrollment, only MDM
+		// enrollments have a discovery service URL.
+		Query: `select data as server_url from registry
+			where path like 'HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft\Enrollments\%\DiscoveryServiceFullURL'
+			and data != '' limit 1`,
+		Platforms: []string{"windows"},
+		IngestFunc: func(logger log.Logger, host *fleet.Host, rows []map[string]string) error {
+			enrolled := len(rows) == 1
+			host.MDMEnrolled = &enrolled
+			host.MDMServerURL = ""
+			if enrolled {
+				host.MDMServerURL = rows[0]["server_url"]
+			}
+			return nil
+		},
+	},
+	"battery": {
+		// The battery table has no rows on hosts without a battery, such as
+		// desktops and servers. It is only available on Windows from osquery
+		// 5.12.
+		Query:     `select cycle_count, health from battery`,
+		Platforms: []string{"darwin", "windows"},
+		IngestFunc: func(logger log.Logger, host *fleet.Host, rows []map[string]string) error {
+			host.BatteryCycleCount = nil
+			host.BatteryHealth = nil
+			if len(rows) == 0 {
+				return nil
+			}
+
+			if cycles, err := strconv.Atoi(rows[0]["cycle_count"]); err == nil {
+				host.BatteryCycleCount = &cycles
+			}
+			if health := rows[0]["health"]; health != "" {
+				host.BatteryHealth = &health
+			}
+			return nil
+		},
+	},
+	"disks": {
+		// Devices without a parent are whole disks rather than partitions.
+		Query:      `select name, size * block_size as size, type from block_devices where parent = ''`,
+		Platforms:  []string{"darwin", "linux", "rhel", "ubuntu", "centos"},
+		IngestFunc: ingestDisks,
+	},
+	"disks_windows": {
+		Query:      `select name, disk_size as size, type from disk_info`,
+		Platforms:  []string{"windows"},
+		IngestFunc: ingestDisks,
+	},
+	"orbit_info": {
+		// The orbit_info table is only available on hosts running orbit, the
+		// query fails and returns no rows on other hosts.
+		Query: "select version from orbit_info limit 1",
+		IngestFunc: func(logger log.Logger, host *fleet.Host, rows []map[string]string) error {
+			if len(rows) != 1 {
+				host.OrbitVersion = ""
+				return nil
+			}
+
+			host.OrbitVersion = rows[0]["version"]
+			return nil
+		},
+	},
+	"disk_encryption": {
+		// The encryption of the volume mounted as root, FileVault on macOS
+		// and LUKS on Linux.
+		Query: `
+SELECT de.encrypted
+FROM disk_encryption de
+JOIN mounts m ON (m.device_alias = de.name)
+WHERE m.path = '/'
+LIMIT 1
+`,
+		Platforms: []string{"darwin", "linux", "rhel", "ubuntu", "centos"},
+		IngestFunc: func(logger log.Logger, host *fleet.Host, rows []map[string]string) error {
+			if len(rows) != 1 {
+				logger.Log("component", "service", "method", "IngestFunc", "err",
+					fmt.Sprintf("detail_query_disk_encryption expected single result got %d", len(rows)))
+				return nil
+			}
+
+			encrypted := rows[0]["encrypted"] == "1"
+			host.DiskEncryptionEnabled = &encrypted
+			return nil
+		},
+	},
+	"disk_encryption_windows": {
+		Query:     `SELECT protection_status FROM bitlocker_info WHERE drive_letter = 'C:' LIMIT 1`,
+		Platforms: []string{"windows"},
+		IngestFunc: func(logger log.Logger, host *fleet.Host, rows []map[string]string) error {
+			if len(rows) != 1 {
+				logger.Log("component", "service", "method", "IngestFunc", "err",
+					fmt.Sprintf("detail_query_disk_encryption_windows expected single result got %d", len(rows)))
+				return nil
+			}
+
+			// Protection status is 0 (off), 1 (on) or 2 (unknown).
+			switch rows[0]["protection_status"] {
+			case "0":
+				host.DiskEncryptionEnabled = ptr.Bool(false)
+			case "1":
+				host.DiskEncryptionEnabled = ptr.Bool(true)
+			default:
+				host.DiskEncryptionEnabled = nil
+			}
+			return nil
+		},
+	},
 	"software_macos": {
 		Query: `
 SELECT
@@ -885,6 +1107,30 @@ func ingestSoftware(logger log.Logger, host *fleet.Host, rows []map[string]strin
 	return nil
 }
 
+func ingestDisks(logger log.Logger, host *fleet.Host, rows []map[string]string) error {
+	disks := fleet.HostDisks{Modified: true}
+
+	for _, row := range rows {
+		name := row["name"]
+		if name == "" {
+			level.Debug(logger).Log(
+				"msg", "host reported disk with empty name",
+				"host", host.Hostname,
+			)
+			continue
+		}
+		size, err := strconv.ParseInt(emptyToZero(row["size"]), 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "parsing size of disk %s", name)
+		}
+		disks.Disks = append(disks.Disks, fleet.HostDisk{Name: name, Size: size, Type: row["type"]})
+	}
+
+	host.HostDisks = disks
+
+	return nil
+}
+
 // hostDetailQueries returns the map of queries that should be executed by
 // osqueryd to fill in the host details
 func (svc *Service) hostDetailQueries(host fleet.Host) (map[string]string, error) {
@@ -954,6 +1200,16 @@ func (svc *Service) GetDistributedQueries(ctx context.Context) (map[string]strin
 		queries[hostLabelQueryPrefix+name] = query
 	}
 
+	// Policies are checked as often as labels are updated
+	policyQueries, err := svc.ds.PolicyQueriesForHost(&host, cutoff)
+	if err != nil {
+		return nil, 0, osqueryError{message: "retrieving policy queries: " + err.Error()}
+	}
+
+	for name, query := range policyQueries {
+		queries[hostPolicyQueryPrefix+name] = query
+	}
+
 	liveQueries, err := svc.liveQueryStore.QueriesForHost(host.ID)
 	if err != nil {
 		return nil, 0, osqueryError{message: "retrieve live queries: " + err.Error()}
@@ -1009,6 +1265,24 @@ func (svc *Service) ingestLabelQuery(host fleet.Host, query string, rows []map[s
 	return nil
 }
 
+// ingestPolicyQuery records the results of policy queries run by a host
+func (svc *Service) ingestPolicyQuery(query string, rows []map[string]string, failed bool, results map[uint]*bool) error {
+	trimmedQuery := strings.TrimPrefix(query, hostPolicyQueryPrefix)
+	policyID, err := strconv.Atoi(emptyToZero(trimmedQuery))
+	if err != nil {
+		return errors.Wrap(err, "converting policy ID from string to int")
+	}
+	// A host passes a policy if there is at least one result for its query.
+	// A query that failed to run neither passes nor fails.
+	if failed {
+		results[uint(policyID)] = nil
+		return nil
+	}
+	passes := len(rows) > 0
+	results[uint(policyID)] = &passes
+	return nil
+}
+
 // ingestDistributedQuery takes the results of a distributed query and modifies the
 // provided fleet.Host appropriately.
 func (svc *Service) ingestDistributedQuery(host fleet.Host, name string, rows []map[string]string, failed bool, errMsg string) error {
@@ -1104,17 +1378,25 @@ func (svc *Service) SubmitDistributedQueryResults(ctx context.Context, results f
 	detailUpdated := false // Whether detail or additional was updated
 	additionalResults := make(fleet.OsqueryDistributedQueryResults)
 	labelResults := map[uint]bool{}
+	policyResults := map[uint]*bool{}
 	for query, rows := range results {
 		switch {
 		case strings.HasPrefix(query, hostDetailQueryPrefix):
 			err = svc.ingestDetailQuery(&host, query, rows)
 			detailUpdated = true
+			if status, ok := statuses[query]; err == nil && (!ok || status == fleet.StatusOK) {
+				host.DetailQueriesSucceeded = append(host.DetailQueriesSucceeded, strings.TrimPrefix(query, hostDetailQueryPrefix))
+			}
 		case strings.HasPrefix(query, hostAdditionalQueryPrefix):
 			name := strings.TrimPrefix(query, hostAdditionalQueryPrefix)
 			additionalResults[name] = rows
 			detailUpdated = true
 		case strings.HasPrefix(query, hostLabelQueryPrefix):
 			err = svc.ingestLabelQuery(host, query, rows, labelResults)
+		case strings.HasPrefix(query, hostPolicyQueryPrefix):
+			status, ok := statuses[query]
+			failed := (ok && status != fleet.StatusOK)
+			err = svc.ingestPolicyQuery(query, rows, failed, policyResults)
 		case strings.HasPrefix(query, hostDistributedQueryPrefix):
 			// osquery docs say any nonzero (string) value for
 			// status indicates a query error
@@ -1139,6 +1421,13 @@ func (svc *Service) SubmitDistributedQueryResults(ctx context.Context, results f
 		}
 	}
 
+	if len(policyResults) > 0 {
+		err = svc.ds.RecordPolicyQueryExecutions(&host, policyResults, svc.clock.Now())
+		if err != nil {
+			return osqueryError{message: "failed to save policy results: " + err.Error()}
+		}
+	}
+
 	if detailUpdated {
 		host.Modified = true
 		host.DetailUpdatedAt = svc.clock.Now()
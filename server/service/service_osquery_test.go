@@ -23,12 +23,19 @@ import (
 	"github.com/fleetdm/fleet/v4/server/ptr"
 	"github.com/fleetdm/fleet/v4/server/pubsub"
 	"github.com/go-kit/kit/log"
+	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-// 3 detail queries are currently feature flagged off by default.
-var expectedDetailQueries = len(detailQueries) - 3
+// 3 detail queries are currently feature flagged off by default, and only one
+// of the 2 disk encryption queries, of the 2 MDM queries and of the 2 disks
+// queries runs on each platform.
+var expectedDetailQueries = len(detailQueries) - 6
+
+// expectedLinuxDetailQueries is the number of detail queries run on Linux,
+// where neither of the MDM queries nor the battery query runs.
+var expectedLinuxDetailQueries = expectedDetailQueries - 2
 
 func TestEnrollAgent(t *testing.T) {
 	ds := new(mock.Store)
@@ -40,18 +47,51 @@ func TestEnrollAgent(t *testing.T) {
 			return nil, errors.New("not found")
 		}
 	}
-	ds.EnrollHostFunc = func(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration) (*fleet.Host, error) {
+	ds.EnrollHostFunc = func(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration, opt fleet.EnrollHostOptions) (*fleet.Host, error) {
 		assert.Equal(t, ptr.Uint(3), teamID)
 		return &fleet.Host{
 			OsqueryHostID: osqueryHostId, NodeKey: nodeKey,
 		}, nil
 	}
 
+	var gotSecret string
+	ds.RecordHostEnrollSecretFunc = func(hostID uint, secret string) error {
+		gotSecret = secret
+		return nil
+	}
+
 	svc := newTestService(ds, nil, nil)
 
 	nodeKey, err := svc.EnrollAgent(context.Background(), "valid_secret", "host123", nil)
 	require.Nil(t, err)
 	assert.NotEmpty(t, nodeKey)
+	assert.True(t, ds.RecordHostEnrollSecretFuncInvoked)
+	assert.Equal(t, "valid_secret", gotSecret)
+}
+
+func TestEnrollAgentEnrolledFromIP(t *testing.T) {
+	ds := new(mock.Store)
+	ds.VerifyEnrollSecretFunc = func(secret string) (*fleet.EnrollSecret, error) {
+		return &fleet.EnrollSecret{}, nil
+	}
+	var gotIP string
+	ds.EnrollHostFunc = func(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration, opt fleet.EnrollHostOptions) (*fleet.Host, error) {
+		gotIP = opt.EnrolledFromIP
+		return &fleet.Host{
+			OsqueryHostID: osqueryHostId, NodeKey: nodeKey,
+		}, nil
+	}
+
+	ds.RecordHostEnrollSecretFunc = func(hostID uint, secret string) error {
+		return nil
+	}
+
+	svc := newTestService(ds, nil, nil)
+
+	ctx := context.WithValue(context.Background(), kithttp.ContextKeyRequestRemoteAddr, "10.1.2.3:54321")
+	_, err := svc.EnrollAgent(ctx, "valid_secret", "host123", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "10.1.2.3", gotIP)
 }
 
 func TestEnrollAgentIncorrectEnrollSecret(t *testing.T) {
@@ -77,11 +117,16 @@ func TestEnrollAgentDetails(t *testing.T) {
 	ds.VerifyEnrollSecretFunc = func(secret string) (*fleet.EnrollSecret, error) {
 		return &fleet.EnrollSecret{}, nil
 	}
-	ds.EnrollHostFunc = func(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration) (*fleet.Host, error) {
+	var gotOpt fleet.EnrollHostOptions
+	ds.EnrollHostFunc = func(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration, opt fleet.EnrollHostOptions) (*fleet.Host, error) {
+		gotOpt = opt
 		return &fleet.Host{
 			OsqueryHostID: osqueryHostId, NodeKey: nodeKey,
 		}, nil
 	}
+	ds.RecordHostEnrollSecretFunc = func(hostID uint, secret string) error {
+		return nil
+	}
 	var gotHost *fleet.Host
 	ds.SaveHostFunc = func(host *fleet.Host) error {
 		gotHost = host
@@ -92,7 +137,7 @@ func TestEnrollAgentDetails(t *testing.T) {
 
 	details := map[string](map[string]string){
 		"osquery_info": {"version": "2.12.0"},
-		"system_info":  {"hostname": "zwass.local", "uuid": "froobling_uuid"},
+		"system_info":  {"hostname": "zwass.local", "uuid": "froobling_uuid", "hardware_serial": "froobling_serial"},
 		"os_version": {
 			"name":     "Mac OS X",
 			"major":    "10",
@@ -106,6 +151,10 @@ func TestEnrollAgentDetails(t *testing.T) {
 	require.Nil(t, err)
 	assert.NotEmpty(t, nodeKey)
 
+	// The system_info values are passed on for enrollment dedup
+	assert.Equal(t, "froobling_uuid", gotOpt.UUID)
+	assert.Equal(t, "froobling_serial", gotOpt.HardwareSerial)
+
 	assert.Equal(t, "Mac OS X 10.14.5", gotHost.OSVersion)
 	assert.Equal(t, "darwin", gotHost.Platform)
 	assert.Equal(t, "2.12.0", gotHost.OsqueryVersion)
@@ -113,6 +162,64 @@ func TestEnrollAgentDetails(t *testing.T) {
 	assert.Equal(t, "froobling_uuid", gotHost.UUID)
 }
 
+func TestEnrollAgentTeamRules(t *testing.T) {
+	ds := new(mock.Store)
+	ds.VerifyEnrollSecretFunc = func(secret string) (*fleet.EnrollSecret, error) {
+		if secret == "team_secret" {
+			return &fleet.EnrollSecret{Secret: secret, TeamID: ptr.Uint(3)}, nil
+		}
+		return &fleet.EnrollSecret{Secret: secret}, nil
+	}
+	ds.TeamByNameFunc = func(name string) (*fleet.Team, error) {
+		switch name {
+		case "Workstations":
+			return &fleet.Team{ID: 1, Name: name}, nil
+		case "Servers":
+			return &fleet.Team{ID: 2, Name: name}, nil
+		}
+		return nil, errors.New("not found")
+	}
+	var gotTeamID *uint
+	ds.EnrollHostFunc = func(osqueryHostId, nodeKey string, teamID *uint, cooldown time.Duration, opt fleet.EnrollHostOptions) (*fleet.Host, error) {
+		gotTeamID = teamID
+		return &fleet.Host{OsqueryHostID: osqueryHostId, NodeKey: nodeKey, TeamID: teamID}, nil
+	}
+	ds.RecordHostEnrollSecretFunc = func(hostID uint, secret string) error {
+		return nil
+	}
+	ds.SaveHostFunc = func(host *fleet.Host) error { return nil }
+
+	cfg := config.TestConfig()
+	cfg.Osquery.EnrollTeamRules = "platform=darwin:Workstations;platform_family=linux:Servers;platform=windows:Desktops"
+	svc, err := NewService(ds, nil, log.NewNopLogger(), cfg, nil, clock.C, nil, nil, ds, fleet.LicenseInfo{Tier: "core"})
+	require.NoError(t, err)
+
+	details := func(platform string) map[string](map[string]string) {
+		return map[string](map[string]string){"os_version": {"platform": platform}}
+	}
+	for _, tc := range []struct {
+		secret   string
+		platform string
+		teamID   *uint
+	}{
+		{"", "darwin", ptr.Uint(1)},
+		{"", "ubuntu", ptr.Uint(2)},
+		{"", "freebsd", nil},
+		// The team of the rule doesn't exist
+		{"", "windows", nil},
+		// The team of the enroll secret takes precedence
+		{"team_secret", "darwin", ptr.Uint(3)},
+	} {
+		_, err := svc.EnrollAgent(context.Background(), tc.secret, "host123", details(tc.platform))
+		require.NoError(t, err)
+		assert.Equal(t, tc.teamID, gotTeamID, tc.platform)
+	}
+
+	cfg.Osquery.EnrollTeamRules = "platform=darwin"
+	_, err = NewService(ds, nil, log.NewNopLogger(), cfg, nil, clock.C, nil, nil, ds, fleet.LicenseInfo{Tier: "core"})
+	assert.Error(t, err)
+}
+
 func TestAuthenticateHost(t *testing.T) {
 	ds := new(mock.Store)
 	svc := newTestService(ds, nil, nil)
@@ -169,6 +276,22 @@ func TestAuthenticateHostFailure(t *testing.T) {
 	require.NotNil(t, err)
 }
 
+func TestAuthenticateHostDecommissioned(t *testing.T) {
+	ds := new(mock.Store)
+	svc := newTestService(ds, nil, nil)
+
+	ds.AuthenticateHostFunc = func(key string) (*fleet.Host, error) {
+		return nil, fleet.ErrHostDecommissioned
+	}
+
+	_, err := svc.AuthenticateHost(context.Background(), "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "decommissioned")
+	// osquery shouldn't attempt to enroll again
+	assert.False(t, err.(osqueryError).NodeInvalid())
+	assert.False(t, ds.MarkHostsSeenFuncInvoked)
+}
+
 type testJSONLogger struct {
 	logs []json.RawMessage
 }
@@ -282,7 +405,7 @@ func TestHostDetailQueries(t *testing.T) {
 
 	queries, err = svc.hostDetailQueries(host)
 	assert.Nil(t, err)
-	assert.Len(t, queries, expectedDetailQueries+2)
+	assert.Len(t, queries, expectedLinuxDetailQueries+2)
 	for name := range queries {
 		assert.True(t,
 			strings.HasPrefix(name, hostDetailQueryPrefix) || strings.HasPrefix(name, hostAdditionalQueryPrefix),
@@ -313,6 +436,9 @@ func TestLabelQueries(t *testing.T) {
 	ds.LabelQueriesForHostFunc = func(host *fleet.Host, cutoff time.Time) (map[string]string, error) {
 		return map[string]string{}, nil
 	}
+	ds.PolicyQueriesForHostFunc = func(*fleet.Host, time.Time) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
 	ds.DistributedQueriesForHostFunc = func(host *fleet.Host) (map[uint]string, error) {
 		return map[uint]string{}, nil
 	}
@@ -411,6 +537,69 @@ func TestLabelQueries(t *testing.T) {
 	}
 }
 
+func TestPolicyQueries(t *testing.T) {
+	mockClock := clock.NewMockClock()
+	ds := new(mock.Store)
+	lq := new(live_query.MockLiveQuery)
+	svc := newTestServiceWithClock(ds, nil, lq, mockClock)
+
+	host := &fleet.Host{
+		ID:              1,
+		Platform:        "darwin",
+		Hostname:        "zwass.local",
+		DetailUpdatedAt: mockClock.Now(),
+	}
+
+	ds.LabelQueriesForHostFunc = func(host *fleet.Host, cutoff time.Time) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	var gotCutoff time.Time
+	ds.PolicyQueriesForHostFunc = func(host *fleet.Host, cutoff time.Time) (map[string]string, error) {
+		gotCutoff = cutoff
+		return map[string]string{"1": "select 1", "2": "select 2"}, nil
+	}
+	ds.AppConfigFunc = func() (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	lq.On("QueriesForHost", uint(1)).Return(map[string]string{}, nil)
+
+	ctx := hostctx.NewContext(context.Background(), *host)
+
+	queries, _, err := svc.GetDistributedQueries(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		hostPolicyQueryPrefix + "1": "select 1",
+		hostPolicyQueryPrefix + "2": "select 2",
+	}, queries)
+	assert.Equal(t, mockClock.Now().Add(-config.TestConfig().Osquery.LabelUpdateInterval), gotCutoff)
+
+	var gotResults map[uint]*bool
+	var gotTime time.Time
+	ds.RecordPolicyQueryExecutionsFunc = func(host *fleet.Host, results map[uint]*bool, t time.Time) error {
+		gotResults = results
+		gotTime = t
+		return nil
+	}
+
+	err = svc.SubmitDistributedQueryResults(
+		ctx,
+		map[string][]map[string]string{
+			hostPolicyQueryPrefix + "1": {{"col1": "val1"}},
+			hostPolicyQueryPrefix + "2": {},
+			hostPolicyQueryPrefix + "3": {},
+		},
+		map[string]fleet.OsqueryStatus{hostPolicyQueryPrefix + "3": 1},
+		map[string]string{},
+	)
+	require.NoError(t, err)
+	assert.True(t, ds.RecordPolicyQueryExecutionsFuncInvoked)
+	assert.Equal(t, mockClock.Now(), gotTime)
+	require.Len(t, gotResults, 3)
+	assert.Equal(t, ptr.Bool(true), gotResults[1])
+	assert.Equal(t, ptr.Bool(false), gotResults[2])
+	assert.Nil(t, gotResults[3])
+}
+
 func TestGetClientConfig(t *testing.T) {
 	ds := new(mock.Store)
 	ds.ListPacksForHostFunc = func(hid uint) ([]*fleet.Pack, error) {
@@ -528,6 +717,9 @@ func TestDetailQueriesWithEmptyStrings(t *testing.T) {
 	ds.LabelQueriesForHostFunc = func(*fleet.Host, time.Time) (map[string]string, error) {
 		return map[string]string{}, nil
 	}
+	ds.PolicyQueriesForHostFunc = func(*fleet.Host, time.Time) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
 
 	lq.On("QueriesForHost", host.ID).Return(map[string]string{}, nil)
 
@@ -701,12 +893,15 @@ func TestDetailQueries(t *testing.T) {
 	ds.LabelQueriesForHostFunc = func(*fleet.Host, time.Time) (map[string]string, error) {
 		return map[string]string{}, nil
 	}
+	ds.PolicyQueriesForHostFunc = func(*fleet.Host, time.Time) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
 
 	// With a new host, we should get the detail queries (and accelerated
 	// queries)
 	queries, acc, err := svc.GetDistributedQueries(ctx)
 	assert.Nil(t, err)
-	assert.Len(t, queries, expectedDetailQueries)
+	assert.Len(t, queries, expectedLinuxDetailQueries)
 	assert.NotZero(t, acc)
 
 	resultJSON := `
@@ -877,6 +1072,220 @@ func TestDetailQueries(t *testing.T) {
 	assert.Zero(t, acc)
 }
 
+func TestDetailQueriesSucceeded(t *testing.T) {
+	ds := new(mock.Store)
+	mockClock := clock.NewMockClock()
+	svc := newTestServiceWithClock(ds, nil, nil, mockClock)
+
+	host := fleet.Host{ID: 1, Platform: "darwin"}
+	ctx := hostctx.NewContext(context.Background(), host)
+
+	var gotHost *fleet.Host
+	ds.SaveHostFunc = func(host *fleet.Host) error {
+		gotHost = host
+		return nil
+	}
+
+	results := fleet.OsqueryDistributedQueryResults{
+		hostDetailQueryPrefix + "timezone":   {{"local_timezone": "PDT"}},
+		hostDetailQueryPrefix + "orbit_info": {},
+		hostDetailQueryPrefix + "uptime":     {{"total_seconds": "1000"}},
+	}
+	statuses := map[string]fleet.OsqueryStatus{
+		hostDetailQueryPrefix + "timezone":   fleet.StatusOK,
+		hostDetailQueryPrefix + "orbit_info": 1,
+	}
+	require.NoError(t, svc.SubmitDistributedQueryResults(ctx, results, statuses, map[string]string{}))
+	require.NotNil(t, gotHost)
+	// Queries without a status succeeded
+	assert.ElementsMatch(t, []string{"timezone", "uptime"}, gotHost.DetailQueriesSucceeded)
+	assert.Equal(t, mockClock.Now(), gotHost.DetailUpdatedAt)
+}
+
+func TestDetailQueryTimezone(t *testing.T) {
+	var host fleet.Host
+
+	ingest := detailQueries["timezone"].IngestFunc
+
+	// Unreported
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, nil))
+	assert.Empty(t, host.Timezone)
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{{"local_timezone": "PDT"}}))
+	assert.Equal(t, "PDT", host.Timezone)
+}
+
+func TestDetailQueryKernelInfo(t *testing.T) {
+	var host fleet.Host
+
+	ingest := detailQueries["kernel_info"].IngestFunc
+
+	// Unreported
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, nil))
+	assert.Empty(t, host.KernelVersion)
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{{"version": "5.4.0-80-generic"}}))
+	assert.Equal(t, "5.4.0-80-generic", host.KernelVersion)
+}
+
+func TestDetailQueryDiskEncryption(t *testing.T) {
+	var host fleet.Host
+
+	ingest := detailQueries["disk_encryption"].IngestFunc
+
+	// Unreported
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, nil))
+	assert.Nil(t, host.DiskEncryptionEnabled)
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{{"encrypted": "0"}}))
+	require.NotNil(t, host.DiskEncryptionEnabled)
+	assert.False(t, *host.DiskEncryptionEnabled)
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{{"encrypted": "1"}}))
+	require.NotNil(t, host.DiskEncryptionEnabled)
+	assert.True(t, *host.DiskEncryptionEnabled)
+
+	ingest = detailQueries["disk_encryption_windows"].IngestFunc
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{{"protection_status": "0"}}))
+	require.NotNil(t, host.DiskEncryptionEnabled)
+	assert.False(t, *host.DiskEncryptionEnabled)
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{{"protection_status": "1"}}))
+	require.NotNil(t, host.DiskEncryptionEnabled)
+	assert.True(t, *host.DiskEncryptionEnabled)
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{{"protection_status": "2"}}))
+	assert.Nil(t, host.DiskEncryptionEnabled)
+
+	// Exactly one of the queries runs on each platform
+	for _, platform := range []string{"darwin", "ubuntu", "windows"} {
+		linux := detailQueries["disk_encryption"]
+		windows := detailQueries["disk_encryption_windows"]
+		assert.NotEqual(t, linux.runForPlatform(platform), windows.runForPlatform(platform), platform)
+	}
+}
+
+func TestDetailQueryMDM(t *testing.T) {
+	var host fleet.Host
+
+	ingest := detailQueries["mdm"].IngestFunc
+
+	// Unreported
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, nil))
+	assert.Nil(t, host.MDMEnrolled)
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{
+		{"enrolled": "1", "server_url": "https://mdm.example.com/mdm"},
+	}))
+	require.NotNil(t, host.MDMEnrolled)
+	assert.True(t, *host.MDMEnrolled)
+	assert.Equal(t, "https://mdm.example.com/mdm", host.MDMServerURL)
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{{"enrolled": "0", "server_url": ""}}))
+	require.NotNil(t, host.MDMEnrolled)
+	assert.False(t, *host.MDMEnrolled)
+	assert.Empty(t, host.MDMServerURL)
+
+	ingest = detailQueries["mdm_windows"].IngestFunc
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{
+		{"server_url": "https://mdm.example.com/EnrollmentServer/Discovery.svc"},
+	}))
+	require.NotNil(t, host.MDMEnrolled)
+	assert.True(t, *host.MDMEnrolled)
+	assert.Equal(t, "https://mdm.example.com/EnrollmentServer/Discovery.svc", host.MDMServerURL)
+	// Hosts without an MDM enrollment have no discovery URL
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, nil))
+	require.NotNil(t, host.MDMEnrolled)
+	assert.False(t, *host.MDMEnrolled)
+	assert.Empty(t, host.MDMServerURL)
+}
+
+func TestDetailQueryBattery(t *testing.T) {
+	var host fleet.Host
+
+	ingest := detailQueries["battery"].IngestFunc
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{
+		{"cycle_count": "1042", "health": "Fair"},
+	}))
+	require.NotNil(t, host.BatteryCycleCount)
+	assert.Equal(t, 1042, *host.BatteryCycleCount)
+	require.NotNil(t, host.BatteryHealth)
+	assert.Equal(t, fleet.BatteryHealthFair, *host.BatteryHealth)
+
+	// Hosts without a battery have no rows
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, nil))
+	assert.Nil(t, host.BatteryCycleCount)
+	assert.Nil(t, host.BatteryHealth)
+
+	// Windows doesn't report the health of some batteries
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{
+		{"cycle_count": "12", "health": ""},
+	}))
+	require.NotNil(t, host.BatteryCycleCount)
+	assert.Equal(t, 12, *host.BatteryCycleCount)
+	assert.Nil(t, host.BatteryHealth)
+}
+
+func TestDetailQueryDisks(t *testing.T) {
+	var host fleet.Host
+
+	ingest := detailQueries["disks"].IngestFunc
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{
+		{"name": "/dev/sda", "size": "512110190592", "type": "disk"},
+		{"name": "", "size": "1024", "type": "disk"},
+		{"name": "/dev/sdb", "size": "", "type": ""},
+	}))
+	assert.True(t, host.HostDisks.Modified)
+	assert.Equal(t, []fleet.HostDisk{
+		{Name: "/dev/sda", Size: 512110190592, Type: "disk"},
+		{Name: "/dev/sdb"},
+	}, host.Disks)
+
+	// Hosts without disks still replace their disks
+	host.HostDisks.Modified = false
+	assert.NoError(t, detailQueries["disks_windows"].IngestFunc(log.NewNopLogger(), &host, nil))
+	assert.True(t, host.HostDisks.Modified)
+	assert.Empty(t, host.Disks)
+
+	assert.Error(t, ingest(log.NewNopLogger(), &host, []map[string]string{{"name": "/dev/sda", "size": "big"}}))
+}
+
+func TestDetailQueryOrbitInfo(t *testing.T) {
+	var host fleet.Host
+
+	ingest := detailQueries["orbit_info"].IngestFunc
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{{"version": "0.0.3"}}))
+	assert.Equal(t, "0.0.3", host.OrbitVersion)
+
+	// Hosts that stop running orbit don't report the table
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, nil))
+	assert.Empty(t, host.OrbitVersion)
+}
+
+func TestDetailQueryCloudInstance(t *testing.T) {
+	var host fleet.Host
+
+	ingest := detailQueries["cloud_instance"].IngestFunc
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{
+		{"provider": "aws", "instance_id": "i-0123456789abcdef0"},
+	}))
+	assert.Equal(t, "aws", host.CloudProvider)
+	assert.Equal(t, "i-0123456789abcdef0", host.CloudInstanceID)
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, []map[string]string{
+		{"provider": "aws", "instance_id": ""},
+		{"provider": "azure", "instance_id": "8d10da9d-2b2d-4b7e-9e5a-1a0a3c8b6f11"},
+	}))
+	assert.Equal(t, "azure", host.CloudProvider)
+	assert.Equal(t, "8d10da9d-2b2d-4b7e-9e5a-1a0a3c8b6f11", host.CloudInstanceID)
+
+	// Hosts that aren't running in a cloud don't return rows
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, nil))
+	assert.Empty(t, host.CloudProvider)
+	assert.Empty(t, host.CloudInstanceID)
+}
+
 func TestDetailQueryNetworkInterfaces(t *testing.T) {
 	var initialHost fleet.Host
 	host := initialHost
@@ -901,8 +1310,9 @@ func TestDetailQueryNetworkInterfaces(t *testing.T) {
 	))
 
 	assert.NoError(t, ingest(log.NewNopLogger(), &host, rows))
-	assert.Equal(t, "192.168.1.3", host.PrimaryIP)
-	assert.Equal(t, "f4:5d:79:93:58:5b", host.PrimaryMac)
+	primary := fleet.SelectPrimaryNetworkInterface(host.NetworkInterfaces)
+	assert.Equal(t, "192.168.1.3", primary.IPAddress)
+	assert.Equal(t, "f4:5d:79:93:58:5b", primary.MAC)
 
 	// Only IPv6
 	require.NoError(t, json.Unmarshal([]byte(`
@@ -919,8 +1329,9 @@ func TestDetailQueryNetworkInterfaces(t *testing.T) {
 	))
 
 	assert.NoError(t, ingest(log.NewNopLogger(), &host, rows))
-	assert.Equal(t, "2604:3f08:1337:9411:cbe:814f:51a6:e4e3", host.PrimaryIP)
-	assert.Equal(t, "27:1b:aa:60:e8:0a", host.PrimaryMac)
+	primary = fleet.SelectPrimaryNetworkInterface(host.NetworkInterfaces)
+	assert.Equal(t, "2604:3f08:1337:9411:cbe:814f:51a6:e4e3", primary.IPAddress)
+	assert.Equal(t, "27:1b:aa:60:e8:0a", primary.MAC)
 
 	// IPv6 appears before IPv4 (v4 should be prioritized)
 	require.NoError(t, json.Unmarshal([]byte(`
@@ -938,8 +1349,9 @@ func TestDetailQueryNetworkInterfaces(t *testing.T) {
 	))
 
 	assert.NoError(t, ingest(log.NewNopLogger(), &host, rows))
-	assert.Equal(t, "205.111.43.79", host.PrimaryIP)
-	assert.Equal(t, "ab:1b:aa:60:e8:0a", host.PrimaryMac)
+	primary = fleet.SelectPrimaryNetworkInterface(host.NetworkInterfaces)
+	assert.Equal(t, "205.111.43.79", primary.IPAddress)
+	assert.Equal(t, "ab:1b:aa:60:e8:0a", primary.MAC)
 
 	// Only link-local/loopback
 	require.NoError(t, json.Unmarshal([]byte(`
@@ -955,8 +1367,27 @@ func TestDetailQueryNetworkInterfaces(t *testing.T) {
 	))
 
 	assert.NoError(t, ingest(log.NewNopLogger(), &host, rows))
-	assert.Equal(t, "127.0.0.1", host.PrimaryIP)
-	assert.Equal(t, "00:00:00:00:00:00", host.PrimaryMac)
+	primary = fleet.SelectPrimaryNetworkInterface(host.NetworkInterfaces)
+	assert.Equal(t, "127.0.0.1", primary.IPAddress)
+	assert.Equal(t, "00:00:00:00:00:00", primary.MAC)
+
+	// The interface holding the default route is the primary interface
+	require.NoError(t, json.Unmarshal([]byte(`
+[
+  {"address":"127.0.0.1","mac":"00:00:00:00:00:00","default_route":"0"},
+  {"address":"192.168.1.3","mac":"f4:5d:79:93:58:5b","default_route":"0"},
+  {"address":"fe80::1%en1","mac":"f4:5d:79:93:58:5c","default_route":"1"},
+  {"address":"10.8.0.2","mac":"f4:5d:79:93:58:5c","default_route":"1"}
+]`),
+		&rows,
+	))
+
+	assert.NoError(t, ingest(log.NewNopLogger(), &host, rows))
+	require.Len(t, host.NetworkInterfaces, 4)
+	assert.True(t, host.NetworkInterfaces[3].DefaultRoute)
+	primary = fleet.SelectPrimaryNetworkInterface(host.NetworkInterfaces)
+	assert.Equal(t, "10.8.0.2", primary.IPAddress)
+	assert.Equal(t, "f4:5d:79:93:58:5c", primary.MAC)
 }
 
 func TestDetailQueryScheduledQueryStats(t *testing.T) {
@@ -1151,6 +1582,9 @@ func TestNewDistributedQueryCampaign(t *testing.T) {
 	ds.LabelQueriesForHostFunc = func(host *fleet.Host, cutoff time.Time) (map[string]string, error) {
 		return map[string]string{}, nil
 	}
+	ds.PolicyQueriesForHostFunc = func(*fleet.Host, time.Time) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
 	ds.DistributedQueriesForHostFunc = func(host *fleet.Host) (map[uint]string, error) {
 		return map[uint]string{}, nil
 	}
@@ -1222,6 +1656,9 @@ func TestDistributedQueryResults(t *testing.T) {
 	ds.LabelQueriesForHostFunc = func(host *fleet.Host, cutoff time.Time) (map[string]string, error) {
 		return map[string]string{}, nil
 	}
+	ds.PolicyQueriesForHostFunc = func(*fleet.Host, time.Time) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
 	ds.SaveHostFunc = func(host *fleet.Host) error {
 		return nil
 	}
@@ -32,7 +32,7 @@ func TestSearchTargets(t *testing.T) {
 		{Name: "team1"},
 	}
 
-	ds.SearchHostsFunc = func(filter fleet.TeamFilter, query string, omit ...uint) ([]*fleet.Host, error) {
+	ds.SearchHostsFunc = func(filter fleet.TeamFilter, query string, limit int, omit ...uint) ([]*fleet.Host, error) {
 		assert.Equal(t, user, filter.User)
 		return hosts, nil
 	}
@@ -59,7 +59,7 @@ func TestSearchWithOmit(t *testing.T) {
 	user := &fleet.User{GlobalRole: ptr.String(fleet.RoleAdmin)}
 	ctx := viewer.NewContext(context.Background(), viewer.Viewer{User: user})
 
-	ds.SearchHostsFunc = func(filter fleet.TeamFilter, query string, omit ...uint) ([]*fleet.Host, error) {
+	ds.SearchHostsFunc = func(filter fleet.TeamFilter, query string, limit int, omit ...uint) ([]*fleet.Host, error) {
 		assert.Equal(t, user, filter.User)
 		assert.Equal(t, []uint{1, 2}, omit)
 		return nil, nil
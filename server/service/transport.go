@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/gorilla/mux"
@@ -178,6 +179,147 @@ func hostListOptionsFromRequest(r *http.Request) (fleet.HostListOptions, error)
 		return hopt, err
 	}
 
+	if minUptime := r.URL.Query().Get("min_uptime"); minUptime != "" {
+		d, err := time.ParseDuration(minUptime)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse min_uptime as duration")
+		}
+		hopt.MinUptime = d
+	}
+	if maxUptime := r.URL.Query().Get("max_uptime"); maxUptime != "" {
+		d, err := time.ParseDuration(maxUptime)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse max_uptime as duration")
+		}
+		hopt.MaxUptime = d
+	}
+
+	hopt.EnrolledFromIP = r.URL.Query().Get("enrolled_from_ip")
+	hopt.OwnerFilter = r.URL.Query().Get("owner")
+	hopt.HasUser = r.URL.Query().Get("has_user")
+	if active := r.URL.Query().Get("has_active_carves"); active != "" {
+		b, err := strconv.ParseBool(active)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse has_active_carves as bool")
+		}
+		hopt.HasActiveCarves = b
+	}
+	if decommissioned := r.URL.Query().Get("include_decommissioned"); decommissioned != "" {
+		b, err := strconv.ParseBool(decommissioned)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse include_decommissioned as bool")
+		}
+		hopt.IncludeDecommissioned = b
+	}
+	if failing := r.URL.Query().Get("has_failing_policies"); failing != "" {
+		b, err := strconv.ParseBool(failing)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse has_failing_policies as bool")
+		}
+		hopt.HasFailingPolicies = b
+	}
+	hopt.TimezoneFilter = r.URL.Query().Get("timezone")
+	hopt.KernelVersionFilter = r.URL.Query().Get("kernel_version")
+	hopt.OrbitVersionFilter = r.URL.Query().Get("orbit_version")
+	hopt.CloudProviderFilter = r.URL.Query().Get("cloud_provider")
+	hopt.CloudInstanceIDFilter = r.URL.Query().Get("cloud_instance_id")
+	if encrypted := r.URL.Query().Get("disk_encryption_enabled"); encrypted != "" {
+		b, err := strconv.ParseBool(encrypted)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse disk_encryption_enabled as bool")
+		}
+		hopt.DiskEncryptionFilter = &b
+	}
+	if enrolled := r.URL.Query().Get("mdm_enrolled"); enrolled != "" {
+		b, err := strconv.ParseBool(enrolled)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse mdm_enrolled as bool")
+		}
+		hopt.MDMEnrolledFilter = &b
+	}
+	hopt.MDMServerURLFilter = r.URL.Query().Get("mdm_server_url")
+	hopt.BatteryHealthFilter = r.URL.Query().Get("battery_health")
+	if poor := r.URL.Query().Get("poor_battery_health"); poor != "" {
+		b, err := strconv.ParseBool(poor)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse poor_battery_health as bool")
+		}
+		hopt.PoorBatteryHealth = b
+	}
+	if refetch := r.URL.Query().Get("refetch_requested"); refetch != "" {
+		b, err := strconv.ParseBool(refetch)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse refetch_requested as bool")
+		}
+		hopt.RefetchRequestedFilter = &b
+	}
+
+	hopt.AdditionalKey = r.URL.Query().Get("additional_key")
+	if missing := r.URL.Query().Get("additional_key_missing"); missing != "" {
+		b, err := strconv.ParseBool(missing)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse additional_key_missing as bool")
+		}
+		hopt.AdditionalKeyMissing = b
+	}
+
+	if before := r.URL.Query().Get("label_updated_before"); before != "" {
+		t, err := time.Parse(time.RFC3339, before)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse label_updated_before as RFC3339 time")
+		}
+		hopt.LabelUpdatedBefore = &t
+	}
+
+	if since := r.URL.Query().Get("modified_since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse modified_since as RFC3339 time")
+		}
+		hopt.ModifiedSince = &t
+	}
+
+	if osqueryVersion := r.URL.Query().Get("osquery_version"); osqueryVersion != "" {
+		constraints, err := fleet.ParseOsqueryVersionConstraints(osqueryVersion)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse osquery_version")
+		}
+		hopt.OsqueryVersionConstraints = constraints
+	}
+	if empty := r.URL.Query().Get("osquery_version_empty"); empty != "" {
+		b, err := strconv.ParseBool(empty)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse osquery_version_empty as bool")
+		}
+		hopt.OsqueryVersionEmpty = b
+	}
+
+	if noTeam := r.URL.Query().Get("no_team"); noTeam != "" {
+		b, err := strconv.ParseBool(noTeam)
+		if err != nil {
+			return hopt, errors.Wrap(err, "parse no_team as bool")
+		}
+		hopt.NoTeam = b
+	}
+
+	if labelIDs := r.URL.Query().Get("label_ids"); labelIDs != "" {
+		for _, s := range strings.Split(labelIDs, ",") {
+			id, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return hopt, errors.Wrap(err, "parse label_ids as list of ids")
+			}
+			hopt.LabelIDs = append(hopt.LabelIDs, uint(id))
+		}
+	}
+	hopt.LabelMatchMode = fleet.LabelMatchMode(r.URL.Query().Get("label_match"))
+	if !hopt.LabelMatchMode.Valid() {
+		return hopt, errors.Errorf("invalid label_match %s", hopt.LabelMatchMode)
+	}
+
+	if fields := r.URL.Query().Get("fields"); fields != "" {
+		hopt.Fields = strings.Split(fields, ",")
+	}
+
 	additionalInfoFiltersString := r.URL.Query().Get("additional_info_filters")
 	if additionalInfoFiltersString != "" {
 		hopt.AdditionalFilters = strings.Split(additionalInfoFiltersString, ",")
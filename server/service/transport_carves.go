@@ -54,6 +54,8 @@ func decodeListCarvesRequest(ctx context.Context, r *http.Request) (interface{},
 	default:
 		return nil, errors.Errorf("invalid expired value %s", expired)
 	}
+	copt.NamePrefix = r.URL.Query().Get("name_prefix")
+	copt.CaseID = r.URL.Query().Get("case_id")
 	return listCarvesRequest{ListOptions: copt}, nil
 }
 
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+func decodeGlobalPolicyRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req globalPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeDeleteGlobalPoliciesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req deleteGlobalPoliciesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
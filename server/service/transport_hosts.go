@@ -4,6 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
 )
 
 func decodeGetHostRequest(ctx context.Context, r *http.Request) (interface{}, error) {
@@ -11,7 +14,34 @@ func decodeGetHostRequest(ctx context.Context, r *http.Request) (interface{}, er
 	if err != nil {
 		return nil, err
 	}
-	return getHostRequest{ID: id}, nil
+	req := getHostRequest{ID: id}
+	if includeUsers := r.URL.Query().Get("include_users"); includeUsers != "" {
+		req.IncludeUsers, err = strconv.ParseBool(includeUsers)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse include_users as bool")
+		}
+	}
+	return req, nil
+}
+
+func decodeListHostUsersRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := idFromRequest(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	opt, err := listOptionsFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return listHostUsersRequest{ID: id, ListOptions: opt}, nil
+}
+
+func decodeHostSoftwareUpgradeCandidatesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := idFromRequest(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	return hostSoftwareUpgradeCandidatesRequest{ID: id}, nil
 }
 
 func decodeHostByIdentifierRequest(ctx context.Context, r *http.Request) (interface{}, error) {
@@ -27,7 +57,7 @@ func decodeDeleteHostRequest(ctx context.Context, r *http.Request) (interface{},
 	if err != nil {
 		return nil, err
 	}
-	return deleteHostRequest{ID: id}, nil
+	return deleteHostRequest{ID: id, Reason: r.URL.Query().Get("reason")}, nil
 }
 
 func decodeRefetchHostRequest(ctx context.Context, r *http.Request) (interface{}, error) {
@@ -38,6 +68,31 @@ func decodeRefetchHostRequest(ctx context.Context, r *http.Request) (interface{}
 	return refetchHostRequest{ID: id}, nil
 }
 
+func decodeSetHostOwnerRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := idFromRequest(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	var req setHostOwnerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	req.ID = id
+	return req, nil
+}
+
+func decodeGetHostSummaryRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req getHostSummaryRequest
+	if cumulative := r.URL.Query().Get("cumulative"); cumulative != "" {
+		c, err := strconv.ParseBool(cumulative)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse cumulative as bool")
+		}
+		req.Cumulative = c
+	}
+	return req, nil
+}
+
 func decodeListHostsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	hopt, err := hostListOptionsFromRequest(r)
 	if err != nil {
@@ -64,3 +119,12 @@ func decodeAddHostsToTeamByFilterRequest(ctx context.Context, r *http.Request) (
 	}
 	return req, nil
 }
+
+func decodeSetHostTagsByFilterRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req setHostTagsByFilterRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}